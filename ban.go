@@ -0,0 +1,502 @@
+package lav7
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/L7-MCPE/lav7/config"
+)
+
+// BanEntry is an entry of ban lists or whitelist.
+type BanEntry struct {
+	Name    string     `json:"name"` // Lowercase username, or IP address
+	Reason  string     `json:"reason,omitempty"`
+	Source  string     `json:"source,omitempty"` // Name of command sender who added the entry
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"` // If nil, the entry is permanent
+}
+
+// Expired returns if the entry is expired at given time.
+func (e *BanEntry) Expired(now time.Time) bool {
+	return e.Expires != nil && !now.Before(*e.Expires)
+}
+
+// String returns description of the entry, e.g. "Steve (by CONSOLE until 2016-01-02 15:04): griefing"
+func (e *BanEntry) String() string {
+	s := e.Name
+	if e.Source != "" {
+		s += " (by " + e.Source
+		if e.Expires != nil {
+			s += " until " + e.Expires.Format("2006-01-02 15:04")
+		}
+		s += ")"
+	}
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// BanList is a list of names or IP addresses persisted on JSON file.
+// Expired entries are ignored, and removed when the list is saved.
+type BanList struct {
+	Path    string // If empty, the list is not persisted
+	lock    sync.RWMutex
+	entries map[string]*BanEntry
+}
+
+// Player lists and IP ban list
+var (
+	Whitelist     = &BanList{Path: "whitelist.json"}
+	BannedPlayers = &BanList{Path: "banned-players.json"}
+	BannedIPs     = &BanList{Path: "banned-ips.json"}
+)
+
+var whitelistEnabled int32
+
+// SetWhitelistEnabled turns whitelist on or off, and saves it as white-list property on config.File.
+func SetWhitelistEnabled(enabled bool) error {
+	setWhitelistEnabled(enabled)
+	return config.SetProperty("white-list", strconv.FormatBool(enabled))
+}
+
+func setWhitelistEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&whitelistEnabled, v)
+}
+
+// WhitelistEnabled returns if whitelist is turned on.
+func WhitelistEnabled() bool {
+	return atomic.LoadInt32(&whitelistEnabled) == 1
+}
+
+// Load reloads the list from the file. Missing file is treated as an empty list.
+func (l *BanList) Load() error {
+	var entries []*BanEntry
+	if err := readJSON(l.Path, &entries); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = make(map[string]*BanEntry)
+	for _, e := range entries {
+		e.Name = strings.ToLower(e.Name)
+		if ip := net.ParseIP(e.Name); ip != nil { // Normalize IPv6 addresses
+			e.Name = ip.String()
+		}
+		l.entries[e.Name] = e
+	}
+	return nil
+}
+
+// save writes unexpired entries to the file. Callers should hold the lock.
+func (l *BanList) save() error {
+	entries := l.list(time.Now())
+	for _, e := range l.entries {
+		if e.Expired(time.Now()) {
+			delete(l.entries, e.Name)
+		}
+	}
+	return writeJSON(l.Path, entries)
+}
+
+// list returns unexpired entries sorted by name. Callers should hold the lock.
+func (l *BanList) list(now time.Time) []*BanEntry {
+	names := make([]string, 0, len(l.entries))
+	for name, e := range l.entries {
+		if !e.Expired(now) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	entries := make([]*BanEntry, len(names))
+	for i, name := range names {
+		entries[i] = l.entries[name]
+	}
+	return entries
+}
+
+// Add adds the entry to the list, replacing the entry with same name, and saves the list.
+func (l *BanList) Add(e BanEntry) error {
+	e.Name = strings.ToLower(e.Name)
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.entries == nil {
+		l.entries = make(map[string]*BanEntry)
+	}
+	l.entries[e.Name] = &e
+	return l.save()
+}
+
+// Remove removes the entry with given name, and saves the list.
+// It returns false if the entry does not exist.
+func (l *BanList) Remove(name string) (bool, error) {
+	name = strings.ToLower(name)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	e, ok := l.entries[name]
+	if !ok || e.Expired(time.Now()) {
+		return false, nil
+	}
+	delete(l.entries, name)
+	return true, l.save()
+}
+
+// Get returns the entry with given name, or nil if it does not exist or expired.
+func (l *BanList) Get(name string) *BanEntry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	e, ok := l.entries[strings.ToLower(name)]
+	if !ok || e.Expired(time.Now()) {
+		return nil
+	}
+	c := *e
+	return &c
+}
+
+// Entries returns every unexpired entries, sorted by name.
+func (l *BanList) Entries() []BanEntry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	list := l.list(time.Now())
+	entries := make([]BanEntry, len(list))
+	for i, e := range list {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// LoadBanLists loads whitelist and ban lists from files, and turns whitelist on if white-list property is set.
+func LoadBanLists() error {
+	setWhitelistEnabled(config.Whitelist)
+	for _, l := range []*BanList{Whitelist, BannedPlayers, BannedIPs} {
+		if err := l.Load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowAddress returns false if given IP address is banned. It is used for raknet.AddressFilter.
+func AllowAddress(ip net.IP) bool {
+	return BannedIPs.Get(ip.String()) == nil
+}
+
+// checkLogin checks if the player with given name and address can join the server.
+// It returns disconnect message if the player cannot join, or empty string.
+func checkLogin(name string, ip net.IP) string {
+	if msg := checkBanned(name, ip); msg != "" {
+		return msg
+	}
+	if WhitelistEnabled() && Whitelist.Get(name) == nil && !IsOp(name) {
+		return "You are not whitelisted on this server"
+	}
+	return ""
+}
+
+// checkBanned returns disconnect message if the player with given name or address is banned, or empty string.
+func checkBanned(name string, ip net.IP) string {
+	if e := BannedPlayers.Get(name); e != nil {
+		return banMessage("You are banned", e)
+	}
+	if ip != nil {
+		if e := BannedIPs.Get(ip.String()); e != nil {
+			return banMessage("Your IP address is banned", e)
+		}
+	}
+	return ""
+}
+
+// kickBanned disconnects online players banned by name or address, e.g. after reloading ban lists.
+func kickBanned() {
+	AsPlayers(func(p *Player) {
+		var ip net.IP
+		if p.Address != nil {
+			ip = p.Address.IP
+		}
+		if msg := checkBanned(p.Username, ip); msg != "" {
+			p.disconnect(msg)
+		}
+	})
+}
+
+func banMessage(msg string, e *BanEntry) string {
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Expires != nil {
+		msg += " (until " + e.Expires.Format("2006-01-02 15:04") + ")"
+	}
+	return msg
+}
+
+// parseDuration parses ban duration like "30m", "12h" or "7d". Days are not supported by time.ParseDuration.
+func parseDuration(s string) (time.Duration, bool) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * time.Hour * 24, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// banArgs parses optional duration and reason arguments to a BanEntry.
+func banArgs(sender CommandSender, name string, args *CommandArgs) BanEntry {
+	e := BanEntry{
+		Name:    name,
+		Source:  sender.Name(),
+		Created: time.Now(),
+	}
+	if d, ok := parseDuration(args.Peek()); ok {
+		args.String()
+		expires := e.Created.Add(d)
+		e.Expires = &expires
+	}
+	e.Reason = args.Rest()
+	return e
+}
+
+// kickAddress kicks every players from given IP address, and closes their sessions.
+// Sessions without players, e.g. connecting clients, are closed too if DefaultServer has its router.
+func kickAddress(ip net.IP, reason string) {
+	AsPlayers(func(p *Player) {
+		if p.Address != nil && p.Address.IP.Equal(ip) {
+			p.disconnect(reason)
+		}
+	})
+	if r := DefaultServer.Router; r != nil {
+		r.CloseAddress(ip, reason) // Skips sessions closed by disconnect
+	}
+}
+
+func init() {
+	for _, cmd := range []*Command{
+		{
+			Name:        "kick",
+			Usage:       "<player> [reason]",
+			Description: "Kicks the player from the server.",
+			Permission:  "lav7.command.kick",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				p, err := args.Player()
+				if err != nil {
+					return err
+				}
+				reason := args.Rest()
+				if reason == "" {
+					reason = "Kicked by " + sender.Name()
+				}
+				p.Kick(reason)
+				commandMessage(sender, fmt.Sprintf("Kicked %s: %s", p.Username, reason))
+				return nil
+			},
+		},
+		{
+			Name:        "ban",
+			Usage:       "<player> [duration, e.g. 30m, 12h, 7d] [reason]",
+			Description: "Bans the player by name.",
+			Permission:  "lav7.command.ban",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				name, err := args.String()
+				if err != nil {
+					return err
+				}
+				e := banArgs(sender, name, args)
+				if err := BannedPlayers.Add(e); err != nil {
+					return err
+				}
+				AsPlayers(func(p *Player) {
+					if strings.EqualFold(p.Username, name) {
+						p.disconnect(banMessage("You are banned", &e))
+					}
+				})
+				sender.SendMessage("Banned " + e.String())
+				return nil
+			},
+		},
+		{
+			Name:        "ban-ip",
+			Usage:       "<address|player> [duration, e.g. 30m, 12h, 7d] [reason]",
+			Description: "Bans the IP address, or address of the online player.",
+			Permission:  "lav7.command.ban",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				target, err := args.String()
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(target)
+				if ip == nil {
+					p := FindPlayer(target)
+					if p == nil || p.Address == nil {
+						return usageError("Invalid address or player not found: " + target)
+					}
+					ip = p.Address.IP
+				}
+				e := banArgs(sender, ip.String(), args)
+				if err := BannedIPs.Add(e); err != nil {
+					return err
+				}
+				kickAddress(ip, banMessage("Your IP address is banned", &e))
+				sender.SendMessage("Banned " + e.String())
+				return nil
+			},
+		},
+		{
+			Name:        "pardon",
+			Aliases:     []string{"unban"},
+			Usage:       "<player>",
+			Description: "Removes the player from ban list.",
+			Permission:  "lav7.command.ban",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				name, err := args.String()
+				if err != nil {
+					return err
+				}
+				return commandPardon(sender, name, BannedPlayers)
+			},
+		},
+		{
+			Name:        "pardon-ip",
+			Aliases:     []string{"unban-ip"},
+			Usage:       "<address>",
+			Description: "Removes the IP address from ban list.",
+			Permission:  "lav7.command.ban",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				target, err := args.String()
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(target)
+				if ip == nil {
+					return usageError("Invalid address: " + target)
+				}
+				return commandPardon(sender, ip.String(), BannedIPs) // Same form as ban-ip
+			},
+		},
+		{
+			Name:        "banlist",
+			Usage:       "[players|ips|reload]",
+			Description: "Shows ban lists, or reloads them from files.",
+			Permission:  "lav7.command.ban",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				switch strings.ToLower(args.Peek()) {
+				case "", "players":
+					listEntries(sender, "Banned players", BannedPlayers)
+				case "ips":
+					listEntries(sender, "Banned IP addresses", BannedIPs)
+				case "reload":
+					if err := BannedPlayers.Load(); err != nil {
+						return err
+					}
+					if err := BannedIPs.Load(); err != nil {
+						return err
+					}
+					kickBanned()
+					sender.SendMessage("Reloaded ban lists.")
+				default:
+					return ErrUsage
+				}
+				return nil
+			},
+		},
+		{
+			Name:        "whitelist",
+			Usage:       "on | off | add <player> | remove <player> | list | reload",
+			Description: "Manages the whitelist.",
+			Permission:  "lav7.command.whitelist",
+			Execute:     commandWhitelist,
+		},
+	} {
+		if err := RegisterCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func commandPardon(sender CommandSender, name string, list *BanList) error {
+	ok, err := list.Remove(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return usageError(name + " is not banned")
+	}
+	sender.SendMessage("Unbanned " + name)
+	return nil
+}
+
+func listEntries(sender CommandSender, title string, list *BanList) {
+	entries := list.Entries()
+	sender.SendMessage(fmt.Sprintf("%s (%d):", title, len(entries)))
+	for _, e := range entries {
+		sender.SendMessage(" - " + e.String())
+	}
+}
+
+func commandWhitelist(sender CommandSender, args *CommandArgs) error {
+	sub, err := args.String()
+	if err != nil {
+		return err
+	}
+	sub = strings.ToLower(sub)
+	switch sub {
+	case "on", "off":
+		if err := SetWhitelistEnabled(sub == "on"); err != nil {
+			return err
+		}
+		sender.SendMessage("Whitelist is turned " + sub)
+	case "add":
+		name, err := args.String()
+		if err != nil {
+			return err
+		}
+		if err := Whitelist.Add(BanEntry{Name: name, Source: sender.Name()}); err != nil {
+			return err
+		}
+		sender.SendMessage("Added " + name + " to whitelist")
+	case "remove":
+		name, err := args.String()
+		if err != nil {
+			return err
+		}
+		ok, err := Whitelist.Remove(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return usageError(name + " is not whitelisted")
+		}
+		sender.SendMessage("Removed " + name + " from whitelist")
+	case "list":
+		state := "off"
+		if WhitelistEnabled() {
+			state = "on"
+		}
+		listEntries(sender, "Whitelist is "+state+". Whitelisted players", Whitelist)
+	case "reload":
+		if err := Whitelist.Load(); err != nil {
+			return err
+		}
+		sender.SendMessage("Reloaded whitelist.")
+	default:
+		return ErrUsage
+	}
+	return nil
+}
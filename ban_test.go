@@ -0,0 +1,196 @@
+package lav7
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/config"
+	"github.com/L7-MCPE/lav7/raknet"
+)
+
+func TestBanList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lav7-ban")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := &BanList{Path: filepath.Join(dir, "banned-players.json")}
+	if err := l.Load(); err != nil {
+		t.Fatal("Missing file should be loaded as empty list:", err)
+	}
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	for _, e := range []BanEntry{
+		{Name: "Steve", Reason: "griefing", Source: "CONSOLE"},
+		{Name: "alex", Expires: &future},
+		{Name: "notch", Expires: &past},
+	} {
+		if err := l.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := l.Get("STEVE"); e == nil || e.Reason != "griefing" || e.Source != "CONSOLE" || e.Created.IsZero() {
+		t.Errorf("Entry mismatch: %+v", e)
+	}
+	if l.Get("notch") != nil {
+		t.Error("Expired entry should be ignored")
+	}
+	if ok, _ := l.Remove("notch"); ok {
+		t.Error("Expired entry should not be removed")
+	}
+
+	reloaded := &BanList{Path: l.Path}
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	entries := reloaded.Entries()
+	if len(entries) != 2 || entries[0].Name != "alex" || entries[1].Name != "steve" {
+		t.Fatalf("Reloaded entries mismatch: %+v", entries)
+	}
+	if entries[0].Expires == nil || !entries[0].Expires.Equal(future) {
+		t.Error("Expiry should be persisted")
+	}
+	if ok, err := reloaded.Remove("Steve"); !ok || err != nil {
+		t.Error("Entry should be removed:", err)
+	}
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Get("steve") != nil {
+		t.Error("Removed entry should not exist after reload")
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	defer withPermissionFiles(t)()
+	defer func(file string) { config.File = file }(config.File)
+	config.File = ""
+	lists := []*BanList{Whitelist, BannedPlayers, BannedIPs}
+	for _, l := range lists {
+		l.Path = ""
+		l.Load()
+	}
+	defer func() {
+		Whitelist.Path, BannedPlayers.Path, BannedIPs.Path = "whitelist.json", "banned-players.json", "banned-ips.json"
+		for _, l := range lists {
+			l.entries = nil
+		}
+		SetWhitelistEnabled(false)
+	}()
+
+	ip, other := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	BannedPlayers.Add(BanEntry{Name: "griefer", Reason: "griefing"})
+	BannedIPs.Add(BanEntry{Name: ip.String()})
+	if msg := checkLogin("Griefer", other); msg != "You are banned: griefing" {
+		t.Errorf("Banned player message mismatch: %q", msg)
+	}
+	if msg := checkLogin("steve", ip); msg == "" || AllowAddress(ip) {
+		t.Error("Banned address should be refused")
+	}
+	if msg := checkLogin("steve", other); msg != "" || !AllowAddress(other) {
+		t.Error("Player should be allowed:", msg)
+	}
+
+	SetWhitelistEnabled(true)
+	Whitelist.Add(BanEntry{Name: "Alex"})
+	SetOp("admin", true)
+	for name, allowed := range map[string]bool{"steve": false, "alex": true, "admin": true} {
+		if got := checkLogin(name, other) == ""; got != allowed {
+			t.Errorf("Whitelist check for %s: got %v, want %v", name, got, allowed)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"30m": time.Minute * 30,
+		"12h": time.Hour * 12,
+		"7d":  time.Hour * 24 * 7,
+		"d":   0,
+		"-1h": 0,
+		"foo": 0,
+	} {
+		d, ok := parseDuration(s)
+		if d != want || ok != (want != 0) {
+			t.Errorf("parseDuration(%q): got %v %v, want %v", s, d, ok, want)
+		}
+	}
+}
+
+func TestWhitelistPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lav7-ban")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) { config.File = file }(config.File)
+	defer setWhitelistEnabled(false)
+	config.File = filepath.Join(dir, "lav7.properties")
+	ioutil.WriteFile(config.File, []byte("server-port=19132\nwhite-list=false\nmax-players=20\n"), 0644)
+
+	if err := SetWhitelistEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(config.File)
+	if string(b) != "server-port=19132\nwhite-list=true\nmax-players=20\n" || !WhitelistEnabled() {
+		t.Errorf("Whitelist state is not saved: %q", b)
+	}
+
+	ioutil.WriteFile(config.File, []byte("server-port=19132\n"), 0644)
+	if err := SetWhitelistEnabled(false); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadFile(config.File)
+	if string(b) != "server-port=19132\nwhite-list=false\n" || WhitelistEnabled() {
+		t.Errorf("Missing property should be appended: %q", b)
+	}
+}
+
+func TestBanCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lav7-ban")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BannedPlayers.Path, BannedIPs.Path = filepath.Join(dir, "banned-players.json"), filepath.Join(dir, "banned-ips.json")
+	BannedPlayers.Load()
+	BannedIPs.Load()
+	defer func() {
+		BannedPlayers.Path, BannedIPs.Path = "banned-players.json", "banned-ips.json"
+		BannedPlayers.entries, BannedIPs.entries = nil, nil
+	}()
+
+	for _, c := range []struct{ ban, pardon string }{
+		{"10.0.0.3", "::ffff:10.0.0.3"},
+		{"2001:db8::1", "2001:0db8:0000::0001"},
+	} {
+		RunCommand("ban-ip " + c.ban)
+		if AllowAddress(net.ParseIP(c.ban)) {
+			t.Fatal("Address is not banned:", c.ban)
+		}
+		if out := RunCommand("pardon-ip " + c.pardon); !strings.HasPrefix(out, "Unbanned") {
+			t.Errorf("pardon-ip %s: %q", c.pardon, out)
+		}
+		if !AllowAddress(net.ParseIP(c.ban)) {
+			t.Error("Address is not unbanned:", c.ban)
+		}
+	}
+
+	network := raknet.NewMemoryNetwork(1)
+	r := startTestServer(t, network)
+	defer r.Close()
+	c, _ := spawnTestClient(t, network, "mallory")
+	defer c.Close()
+	(&BanList{Path: BannedPlayers.Path}).Add(BanEntry{Name: "mallory", Reason: "edited file"})
+	RunCommand("banlist reload")
+	select {
+	case <-c.Closed():
+	case <-time.After(time.Second * 10):
+		t.Error("Player banned on reloaded list is not kicked")
+	}
+}
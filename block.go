@@ -0,0 +1,287 @@
+package lav7
+
+import (
+	"math"
+	"time"
+
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+// MaxLight is the maximum light level, for both block light and sky light.
+const MaxLight = 15
+
+// BlockType describes properties and behaviors of a block ID.
+type BlockType struct {
+	ID          types.ID
+	Solid       bool    // Blocks movement, and supports blocks attached to it, e.g. torches
+	Hardness    float32 // Breaking time factor. Negative values mean the block is unbreakable.
+	Emission    byte    // Light level emitted by the block
+	Opacity     byte    // Light level reduced by the block in addition to 1 per block. MaxLight blocks light completely.
+	Replaceable bool    // Placing blocks, falling blocks and liquids replace the block, e.g. tall grass and water
+	Tile        string  // ID of the tile entity created on placement, e.g. "Sign"
+
+	// Drops returns items dropped when the block is broken. If nil, the block itself is dropped.
+	Drops func(b types.Block) []types.Item
+	// Place decides the block to place from clicked face and player rotation, e.g. orientation of stairs.
+	// Returning false cancels the placement.
+	Place func(ctx *BlockPlace) bool
+	// OnPlace is called after a player placed the block.
+	OnPlace func(lv *Level, p *Player, x, y, z int32, b types.Block)
+	// OnBreak is called after a player broke the block. b is the block before breaking.
+	OnBreak func(lv *Level, p *Player, x, y, z int32, b types.Block)
+	// OnInteract is called when a player uses an item on the block. Returning true prevents placing the item.
+	OnInteract func(lv *Level, p *Player, x, y, z int32, b types.Block, item *types.Item) bool
+}
+
+// Breakable returns if the block can be broken.
+func (bt *BlockType) Breakable() bool {
+	return bt.Hardness >= 0
+}
+
+// BreakTime returns time to break the block by hand.
+func (bt *BlockType) BreakTime() time.Duration {
+	if !bt.Breakable() {
+		return 0
+	}
+	return time.Duration(float64(bt.Hardness) * 1.5 * float64(time.Second))
+}
+
+// GetDrops returns items dropped when the block is broken.
+func (bt *BlockType) GetDrops(b types.Block) []types.Item {
+	if bt.Drops != nil {
+		return bt.Drops(b)
+	}
+	return []types.Item{{ID: types.ID(b.ID), Meta: uint16(b.Meta), Amount: 1}}
+}
+
+// BlockPlace is a block placement by a player. Placement rules may change the block and its position.
+type BlockPlace struct {
+	Player     *Player
+	Level      *Level
+	X, Y, Z    int32    // Position to place the block
+	Clicked    [3]int32 // Position of the clicked block
+	Face       byte     // Clicked face of the clicked block
+	FX, FY, FZ float32  // Clicked position on the face, 0 ~ 1
+	Block      types.Block
+	Replace    bool // Set by placement rules to replace a non-replaceable block, e.g. merging slabs
+}
+
+// Direction returns horizontal direction the player is facing: 0 for south(+z), 1 for west(-x),
+// 2 for north(-z), and 3 for east(+x).
+func (ctx *BlockPlace) Direction() byte {
+	if ctx.Player == nil {
+		return 0
+	}
+	return byte(int(math.Floor(float64(ctx.Player.Yaw)/90+0.5)) & 3)
+}
+
+// ClickedBlock returns the clicked block.
+func (ctx *BlockPlace) ClickedBlock() types.Block {
+	b, _ := ctx.Level.LoadedBlock(ctx.Clicked[0], ctx.Clicked[1], ctx.Clicked[2])
+	return b
+}
+
+var blockTypes [256]*BlockType
+
+// Light properties of each block ID, copied from blockTypes for the light engine
+var (
+	lightEmission [256]byte
+	lightOpacity  [256]byte
+)
+
+// itemBlocks maps items to blocks placed by them, for items with IDs above 255, e.g. seeds and signs.
+var itemBlocks = map[types.ID]types.ID{}
+
+// RegisterBlock sets the block type for its ID, overriding the previous one.
+// Blocks should be registered before levels are loaded.
+func RegisterBlock(bt *BlockType) {
+	id := bt.ID.Block()
+	blockTypes[id] = bt
+	lightEmission[id] = bt.Emission
+	lightOpacity[id] = bt.Opacity
+}
+
+// GetBlockType returns the block type of given block ID.
+// Unregistered IDs are solid and opaque blocks without behaviors.
+func GetBlockType(id byte) *BlockType {
+	return blockTypes[id]
+}
+
+// RegisterItemBlock sets the block placed by given item. Zero block ID removes the mapping.
+func RegisterItemBlock(item, block types.ID) {
+	if block == 0 {
+		delete(itemBlocks, item)
+		return
+	}
+	itemBlocks[item] = block
+}
+
+// ItemBlock returns the block placed by given item, and false if the item is not placeable.
+func ItemBlock(item types.ID) (types.ID, bool) {
+	if id, ok := itemBlocks[item]; ok {
+		return id, true
+	}
+	if item > 0 && item < 256 {
+		return item, true
+	}
+	return 0, false
+}
+
+// placeOn returns a placement rule requiring one of given blocks below.
+func placeOn(ids ...types.ID) func(ctx *BlockPlace) bool {
+	return func(ctx *BlockPlace) bool {
+		below, ok := ctx.Level.LoadedBlock(ctx.X, ctx.Y-1, ctx.Z)
+		if !ok {
+			return false
+		}
+		for _, id := range ids {
+			if below.ID == byte(id) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// placeStairs sets direction of stairs to the player's direction, and flips them when placed on upper half.
+func placeStairs(ctx *BlockPlace) bool {
+	ctx.Block.Meta = [4]byte{2, 1, 3, 0}[ctx.Direction()]
+	if ctx.Face == vector.SideDown || ctx.Face != vector.SideUp && ctx.FY > 0.5 {
+		ctx.Block.Meta |= 4
+	}
+	return true
+}
+
+// placeAxis sets axis bits of logs and hay bales from the clicked face, keeping the wood type.
+func placeAxis(ctx *BlockPlace) bool {
+	ctx.Block.Meta &= 3
+	switch ctx.Face {
+	case vector.SideNorth, vector.SideSouth:
+		ctx.Block.Meta |= 8
+	case vector.SideWest, vector.SideEast:
+		ctx.Block.Meta |= 4
+	}
+	return true
+}
+
+// placeSlab returns a placement rule putting slabs on upper or lower half, and merging two slabs of the same type
+// into the double slab.
+func placeSlab(double types.ID) func(ctx *BlockPlace) bool {
+	return func(ctx *BlockPlace) bool {
+		kind := ctx.Block.Meta & 7
+		ctx.Block.Meta = kind
+		merge := func(x, y, z int32, top bool) bool {
+			b, ok := ctx.Level.LoadedBlock(x, y, z)
+			if !ok || b.ID != ctx.Block.ID || b.Meta&7 != kind || (b.Meta&8 != 0) != top {
+				return false
+			}
+			ctx.X, ctx.Y, ctx.Z = x, y, z
+			ctx.Block = types.Block{ID: byte(double), Meta: kind}
+			ctx.Replace = true
+			return true
+		}
+		c := ctx.Clicked
+		if ctx.Face == vector.SideUp && merge(c[0], c[1], c[2], false) ||
+			ctx.Face == vector.SideDown && merge(c[0], c[1], c[2], true) ||
+			merge(ctx.X, ctx.Y, ctx.Z, ctx.Face == vector.SideDown || ctx.Face != vector.SideUp && ctx.FY > 0.5) {
+			return true
+		}
+		if ctx.Face == vector.SideDown || ctx.Face != vector.SideUp && ctx.FY > 0.5 {
+			ctx.Block.Meta |= 8
+		}
+		return true
+	}
+}
+
+// placeTorch attaches torches to the clicked face of a solid block. Torches can't hang from ceilings.
+func placeTorch(ctx *BlockPlace) bool {
+	if ctx.Face > vector.SideEast || ctx.Face == vector.SideDown || !GetBlockType(ctx.ClickedBlock().ID).Solid {
+		return false
+	}
+	ctx.Block.Meta = [6]byte{0, 5, 4, 3, 2, 1}[ctx.Face]
+	return true
+}
+
+// placeFacing turns blocks like chests and furnaces toward the player.
+func placeFacing(ctx *BlockPlace) bool {
+	ctx.Block.Meta = [4]byte{2, 5, 3, 4}[ctx.Direction()]
+	return true
+}
+
+// placePumpkin turns pumpkins toward the player.
+func placePumpkin(ctx *BlockPlace) bool {
+	ctx.Block.Meta = (ctx.Direction() + 2) & 3
+	return true
+}
+
+// placeSign places sign posts on top of blocks, rotated toward the player, and wall signs on sides of blocks.
+func placeSign(ctx *BlockPlace) bool {
+	switch ctx.Face {
+	case vector.SideDown:
+		return false
+	case vector.SideUp:
+		ctx.Block = types.Block{ID: byte(types.SignPost)}
+		if ctx.Player != nil {
+			ctx.Block.Meta = byte(int(math.Floor(float64(ctx.Player.Yaw+180)*16/360+0.5)) & 0xf)
+		}
+	default:
+		ctx.Block = types.Block{ID: byte(types.WallSign), Meta: ctx.Face}
+	}
+	return true
+}
+
+// openWindow is an interaction hook opening the container of the block, e.g. chests.
+func openWindow(lv *Level, p *Player, x, y, z int32, b types.Block, item *types.Item) bool {
+	return lv.OpenContainer(p, x, y, z)
+}
+
+// toggleMeta returns an interaction hook flipping given metadata bits, e.g. opening trapdoors.
+func toggleMeta(bits byte) func(lv *Level, p *Player, x, y, z int32, b types.Block, item *types.Item) bool {
+	return func(lv *Level, p *Player, x, y, z int32, b types.Block, item *types.Item) bool {
+		b.Meta ^= bits
+		lv.ChangeBlock(x, y, z, b)
+		return true
+	}
+}
+
+// dropItem returns a drop rule dropping given item.
+func dropItem(id types.ID, meta uint16, amount byte) func(types.Block) []types.Item {
+	return func(types.Block) []types.Item {
+		return []types.Item{{ID: id, Meta: meta, Amount: amount}}
+	}
+}
+
+// dropMasked returns a drop rule dropping the block itself, with metadata bits other than mask cleared,
+// e.g. orientation of logs.
+func dropMasked(mask byte) func(types.Block) []types.Item {
+	return func(b types.Block) []types.Item {
+		return []types.Item{{ID: types.ID(b.ID), Meta: uint16(b.Meta & mask), Amount: 1}}
+	}
+}
+
+func dropNothing(types.Block) []types.Item {
+	return nil
+}
+
+// dropCrop returns a drop rule of crops, dropping the product when fully grown and seeds otherwise.
+func dropCrop(product, seeds types.ID) func(types.Block) []types.Item {
+	return func(b types.Block) []types.Item {
+		if b.Meta < 7 {
+			return []types.Item{{ID: seeds, Amount: 1}}
+		}
+		if product == seeds {
+			return []types.Item{{ID: product, Amount: 2}}
+		}
+		return []types.Item{{ID: product, Amount: 1}, {ID: seeds, Amount: 1}}
+	}
+}
+
+// placeWall attaches blocks like ladders to the clicked side of a solid block.
+func placeWall(ctx *BlockPlace) bool {
+	if ctx.Face < vector.SideNorth || ctx.Face > vector.SideEast || !GetBlockType(ctx.ClickedBlock().ID).Solid {
+		return false
+	}
+	ctx.Block.Meta = ctx.Face
+	return true
+}
@@ -0,0 +1,170 @@
+package lav7
+
+import (
+	"testing"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+func TestBlockRegistry(t *testing.T) {
+	if bt := GetBlockType(byte(types.TallGrass)); bt.Solid || !bt.Replaceable || lightOpacity[types.TallGrass] != 0 {
+		t.Error("Tall grass should be a replaceable transparent block:", bt)
+	}
+	if bt := GetBlockType(23); !bt.Solid || bt.Replaceable || lightOpacity[23] != MaxLight {
+		t.Error("Unregistered blocks should be solid and opaque:", bt)
+	}
+	if !GetBlockType(byte(types.Stone)).Breakable() || GetBlockType(byte(types.Bedrock)).Breakable() {
+		t.Error("Only bedrock should be unbreakable")
+	}
+	for _, c := range []struct {
+		block types.Block
+		drops []types.Item
+	}{
+		{types.Block{ID: byte(types.Stone)}, []types.Item{{ID: types.Cobblestone, Amount: 1}}},
+		{types.Block{ID: byte(types.Wool), Meta: 14}, []types.Item{{ID: types.Wool, Meta: 14, Amount: 1}}},
+		{types.Block{ID: byte(types.Log), Meta: 2 | 8}, []types.Item{{ID: types.Log, Meta: 2, Amount: 1}}},
+		{types.Block{ID: byte(types.WheatBlock), Meta: 3}, []types.Item{{ID: types.Seeds, Amount: 1}}},
+		{types.Block{ID: byte(types.WheatBlock), Meta: 7}, []types.Item{{ID: types.Wheat, Amount: 1}, {ID: types.Seeds, Amount: 1}}},
+		{types.Block{ID: byte(types.Glass)}, nil},
+	} {
+		drops := GetBlockType(c.block.ID).GetDrops(c.block)
+		if len(drops) != len(c.drops) {
+			t.Errorf("Drops of %v: expected %v, got %v", c.block, c.drops, drops)
+			continue
+		}
+		for i := range drops {
+			if drops[i].ID != c.drops[i].ID || drops[i].Meta != c.drops[i].Meta || drops[i].Amount != c.drops[i].Amount {
+				t.Errorf("Drops of %v: expected %v, got %v", c.block, c.drops, drops)
+			}
+		}
+	}
+
+	if id, ok := ItemBlock(types.Seeds); !ok || id != types.WheatBlock {
+		t.Error("Seeds should place wheat:", id)
+	}
+	if _, ok := ItemBlock(types.Diamond); ok {
+		t.Error("Diamonds should not be placeable")
+	}
+
+	old := GetBlockType(byte(types.Sponge))
+	defer RegisterBlock(old)
+	RegisterBlock(&BlockType{ID: types.Sponge, Emission: 7})
+	if lightEmission[types.Sponge] != 7 || lightOpacity[types.Sponge] != 0 {
+		t.Error("Light properties are not updated by RegisterBlock")
+	}
+}
+
+func TestBlockPlace(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.Set(0, 6, 0, types.Block{ID: byte(types.Slab), Meta: 3})
+	lv.Set(2, 6, 0, types.Block{ID: byte(types.TallGrass)})
+	lv.Set(4, 5, 0, types.Block{ID: byte(types.Farmland)})
+
+	for _, c := range []struct {
+		name    string
+		block   types.ID
+		meta    byte
+		x, y, z int32 // Clicked block
+		face    byte
+		fy      float32
+		yaw     float32
+		ok      bool
+		expect  types.Block
+		at      [3]int32
+	}{
+		{"Stairs facing south", types.WoodStairs, 0, 5, 5, 5, vector.SideUp, 1, 0, true, types.Block{ID: byte(types.WoodStairs), Meta: 2}, [3]int32{5, 6, 5}},
+		{"Upside-down stairs facing east", types.WoodStairs, 0, 5, 5, 5, vector.SideWest, 0.7, 270, true, types.Block{ID: byte(types.WoodStairs), Meta: 4}, [3]int32{4, 5, 5}},
+		{"Log on side", types.Log, 1, 5, 5, 5, vector.SideEast, 0.5, 0, true, types.Block{ID: byte(types.Log), Meta: 1 | 4}, [3]int32{6, 5, 5}},
+		{"Torch on top", types.Torch, 0, 5, 5, 5, vector.SideUp, 1, 0, true, types.Block{ID: byte(types.Torch), Meta: 5}, [3]int32{5, 6, 5}},
+		{"Torch on north side", types.Torch, 0, 5, 5, 5, vector.SideNorth, 0.5, 0, true, types.Block{ID: byte(types.Torch), Meta: 4}, [3]int32{5, 5, 4}},
+		{"Torch on ceiling", types.Torch, 0, 5, 5, 5, vector.SideDown, 0, 0, false, types.Block{}, [3]int32{}},
+		{"Torch on tall grass", types.Torch, 0, 2, 6, 0, vector.SideEast, 0.5, 0, false, types.Block{}, [3]int32{}},
+		{"Chest facing the player", types.Chest, 0, 5, 5, 5, vector.SideUp, 1, 90, true, types.Block{ID: byte(types.Chest), Meta: 5}, [3]int32{5, 6, 5}},
+		{"Upper slab", types.Slab, 3, 5, 5, 5, vector.SideWest, 0.8, 0, true, types.Block{ID: byte(types.Slab), Meta: 3 | 8}, [3]int32{4, 5, 5}},
+		{"Merged slab", types.Slab, 3, 0, 6, 0, vector.SideUp, 0.5, 0, true, types.Block{ID: byte(types.DoubleSlab), Meta: 3}, [3]int32{0, 6, 0}},
+		{"Slab of another type", types.Slab, 1, 0, 6, 0, vector.SideUp, 0.5, 0, true, types.Block{ID: byte(types.Slab), Meta: 1}, [3]int32{0, 7, 0}},
+		{"Sign post", types.SignPost, 0, 5, 5, 5, vector.SideUp, 1, 0, true, types.Block{ID: byte(types.SignPost), Meta: 8}, [3]int32{5, 6, 5}},
+		{"Wall sign", types.SignPost, 0, 5, 5, 5, vector.SideSouth, 0.5, 0, true, types.Block{ID: byte(types.WallSign), Meta: vector.SideSouth}, [3]int32{5, 5, 6}},
+		{"Wheat on grass", types.WheatBlock, 0, 5, 5, 5, vector.SideUp, 1, 0, false, types.Block{}, [3]int32{}},
+		{"Wheat on farmland", types.WheatBlock, 0, 4, 5, 0, vector.SideUp, 1, 0, true, types.Block{ID: byte(types.WheatBlock)}, [3]int32{4, 6, 0}},
+		{"Leaves", types.Leaves, 1, 5, 5, 5, vector.SideUp, 1, 0, true, types.Block{ID: byte(types.Leaves), Meta: 1 | leavesNoDecay}, [3]int32{5, 6, 5}},
+	} {
+		ctx := &BlockPlace{
+			Player:  &Player{Yaw: c.yaw},
+			Level:   lv,
+			X:       c.x,
+			Y:       c.y,
+			Z:       c.z,
+			Clicked: [3]int32{c.x, c.y, c.z},
+			Face:    c.face,
+			FY:      c.fy,
+			Block:   types.Block{ID: byte(c.block), Meta: c.meta},
+		}
+		switch c.face {
+		case vector.SideDown:
+			ctx.Y--
+		case vector.SideUp:
+			ctx.Y++
+		case vector.SideNorth:
+			ctx.Z--
+		case vector.SideSouth:
+			ctx.Z++
+		case vector.SideWest:
+			ctx.X--
+		case vector.SideEast:
+			ctx.X++
+		}
+		bt := GetBlockType(byte(c.block))
+		ok := bt.Place == nil || bt.Place(ctx)
+		if ok != c.ok {
+			t.Errorf("%s: expected placement %v, got %v", c.name, c.ok, ok)
+			continue
+		}
+		if ok && (ctx.Block != c.expect || [3]int32{ctx.X, ctx.Y, ctx.Z} != c.at) {
+			t.Errorf("%s: expected %v on %v, got %v on %v", c.name, c.expect, c.at, ctx.Block, [3]int32{ctx.X, ctx.Y, ctx.Z})
+		}
+	}
+}
+
+func TestPlaceOnReplaceable(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	r := startTestServer(t, network)
+	defer r.Close()
+	c, updates := spawnTestClient(t, network, "placer")
+	defer c.Close()
+
+	lv := GetDefaultLevel()
+	lv.Set(7, 6, 7, types.Block{ID: byte(types.TallGrass)})
+	c.SendPacket(&proto.UseItem{
+		X: 7, Y: 6, Z: 7,
+		Face: vector.SideUp,
+		Item: &types.Item{ID: types.Stone, Amount: 1},
+	})
+	if !waitUpdateBlock(updates, 7, 6, 7, byte(types.Stone)) {
+		t.Error("Block placed on tall grass should replace it")
+	}
+	if b := lv.GetBlock(7, 7, 7); b != 0 {
+		t.Error("Block should not be placed above tall grass:", b)
+	}
+}
+
+func TestBreakUnbreakable(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	r := startTestServer(t, network)
+	defer r.Close()
+	c, updates := spawnTestClient(t, network, "breaker")
+	defer c.Close()
+
+	lv := GetDefaultLevel()
+	lv.Set(8, 6, 8, types.Block{ID: byte(types.Bedrock)})
+	c.SendPacket(&proto.RemoveBlock{X: 8, Y: 6, Z: 8})
+	if !waitUpdateBlock(updates, 8, 6, 8, byte(types.Bedrock)) {
+		t.Error("Breaking bedrock should be reverted")
+	}
+	if b := lv.GetBlock(8, 6, 8); b != byte(types.Bedrock) {
+		t.Error("Bedrock should not be broken:", b)
+	}
+}
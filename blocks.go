@@ -0,0 +1,175 @@
+package lav7
+
+import "github.com/L7-MCPE/lav7/types"
+
+func init() {
+	for i := range blockTypes {
+		RegisterBlock(&BlockType{ID: types.ID(i), Solid: true, Hardness: 1, Opacity: MaxLight})
+	}
+	stairs := func(id types.ID, hardness float32) *BlockType {
+		return &BlockType{ID: id, Solid: true, Hardness: hardness, Opacity: MaxLight, Place: placeStairs, Drops: dropMasked(0)}
+	}
+	for _, bt := range []*BlockType{
+		{ID: types.Air, Hardness: -1, Replaceable: true, Drops: dropNothing},
+		{ID: types.Stone, Solid: true, Hardness: 1.5, Opacity: MaxLight, Drops: dropItem(types.Cobblestone, 0, 1)},
+		{ID: types.Grass, Solid: true, Hardness: 0.6, Opacity: MaxLight, Drops: dropItem(types.Dirt, 0, 1)},
+		{ID: types.Dirt, Solid: true, Hardness: 0.5, Opacity: MaxLight},
+		{ID: types.Cobblestone, Solid: true, Hardness: 2, Opacity: MaxLight},
+		{ID: types.Plank, Solid: true, Hardness: 2, Opacity: MaxLight},
+		{ID: types.Sapling, Place: placeOn(types.Grass, types.Dirt, types.Farmland, types.Podzol), Drops: dropMasked(7)},
+		{ID: types.Bedrock, Solid: true, Hardness: -1, Opacity: MaxLight},
+		{ID: types.Water, Hardness: -1, Opacity: 2, Replaceable: true, Drops: dropNothing},
+		{ID: types.StillWater, Hardness: -1, Opacity: 2, Replaceable: true, Drops: dropNothing},
+		{ID: types.Lava, Hardness: -1, Emission: 15, Opacity: MaxLight, Replaceable: true, Drops: dropNothing},
+		{ID: types.StillLava, Hardness: -1, Emission: 15, Opacity: MaxLight, Replaceable: true, Drops: dropNothing},
+		{ID: types.Sand, Solid: true, Hardness: 0.5, Opacity: MaxLight},
+		{ID: types.Gravel, Solid: true, Hardness: 0.6, Opacity: MaxLight},
+		{ID: types.GoldOre, Solid: true, Hardness: 3, Opacity: MaxLight},
+		{ID: types.IronOre, Solid: true, Hardness: 3, Opacity: MaxLight},
+		{ID: types.CoalOre, Solid: true, Hardness: 3, Opacity: MaxLight, Drops: dropItem(types.Coal, 0, 1)},
+		{ID: types.Log, Solid: true, Hardness: 2, Opacity: MaxLight, Place: placeAxis, Drops: dropMasked(3)},
+		{ID: types.Leaves, Solid: true, Hardness: 0.2, Opacity: 1, Place: placeLeaves, Drops: dropNothing},
+		{ID: types.Sponge, Solid: true, Hardness: 0.6, Opacity: MaxLight},
+		{ID: types.Glass, Solid: true, Hardness: 0.3, Drops: dropNothing},
+		{ID: types.LapisOre, Solid: true, Hardness: 3, Opacity: MaxLight, Drops: dropItem(types.Dye, 4, 5)},
+		{ID: types.LapisBlock, Solid: true, Hardness: 3, Opacity: MaxLight},
+		{ID: types.Sandstone, Solid: true, Hardness: 0.8, Opacity: MaxLight},
+		{ID: types.BedBlock, Solid: true, Hardness: 0.2, Drops: dropItem(types.Bed, 0, 1)},
+		{ID: types.Cobweb, Hardness: 4, Opacity: 1, Drops: dropNothing},
+		{ID: types.TallGrass, Replaceable: true, Drops: dropNothing},
+		{ID: types.Bush, Replaceable: true, Drops: dropNothing},
+		{ID: types.Wool, Solid: true, Hardness: 0.8, Opacity: MaxLight},
+		{ID: types.Dandelion, Place: placeOn(types.Grass, types.Dirt, types.Farmland, types.Podzol)},
+		{ID: types.Poppy, Place: placeOn(types.Grass, types.Dirt, types.Farmland, types.Podzol)},
+		{ID: types.BrownMushroom, Emission: 1},
+		{ID: types.RedMushroom},
+		{ID: types.GoldBlock, Solid: true, Hardness: 3, Opacity: MaxLight},
+		{ID: types.IronBlock, Solid: true, Hardness: 5, Opacity: MaxLight},
+		{ID: types.DoubleSlab, Solid: true, Hardness: 2, Opacity: MaxLight, Drops: func(b types.Block) []types.Item {
+			return []types.Item{{ID: types.Slab, Meta: uint16(b.Meta & 7), Amount: 2}}
+		}},
+		{ID: types.Slab, Solid: true, Hardness: 2, Opacity: MaxLight, Place: placeSlab(types.DoubleSlab), Drops: dropMasked(7)},
+		{ID: types.Bricks, Solid: true, Hardness: 2, Opacity: MaxLight},
+		{ID: types.Tnt, Solid: true, Opacity: MaxLight},
+		{ID: types.Bookshelf, Solid: true, Hardness: 1.5, Opacity: MaxLight},
+		{ID: types.MossStone, Solid: true, Hardness: 2, Opacity: MaxLight},
+		{ID: types.Obsidian, Solid: true, Hardness: 50, Opacity: MaxLight},
+		{ID: types.Torch, Emission: 14, Place: placeTorch, Drops: dropMasked(0)},
+		{ID: types.Fire, Emission: 15, Replaceable: true, Drops: dropNothing},
+		{ID: types.MonsterSpawner, Solid: true, Hardness: 5, Drops: dropNothing},
+		stairs(types.WoodStairs, 2),
+		{ID: types.Chest, Solid: true, Hardness: 2.5, Place: placeFacing, Drops: dropMasked(0), Tile: "Chest", OnInteract: openWindow},
+		{ID: types.DiamondOre, Solid: true, Hardness: 3, Opacity: MaxLight, Drops: dropItem(types.Diamond, 0, 1)},
+		{ID: types.DiamondBlock, Solid: true, Hardness: 5, Opacity: MaxLight},
+		{ID: types.CraftingTable, Solid: true, Hardness: 2.5, Opacity: MaxLight},
+		{ID: types.WheatBlock, Place: placeOn(types.Farmland), Drops: dropCrop(types.Wheat, types.Seeds)},
+		{ID: types.Farmland, Solid: true, Hardness: 0.6, Opacity: MaxLight, Drops: dropItem(types.Dirt, 0, 1)},
+		{ID: types.Furnace, Solid: true, Hardness: 3.5, Opacity: MaxLight, Place: placeFacing, Drops: dropItem(types.Furnace, 0, 1), Tile: "Furnace", OnInteract: openWindow},
+		{ID: types.BurningFurnace, Solid: true, Hardness: 3.5, Emission: 13, Opacity: MaxLight, Place: placeFacing, Drops: dropItem(types.Furnace, 0, 1), Tile: "Furnace", OnInteract: openWindow},
+		{ID: types.SignPost, Hardness: 1, Place: placeSign, Drops: dropItem(types.Sign, 0, 1), Tile: "Sign"},
+		{ID: types.DoorBlock, Solid: true, Hardness: 3, Drops: dropItem(types.WoodenDoor, 0, 1)},
+		{ID: types.Ladder, Hardness: 0.4, Place: placeWall, Drops: dropMasked(0)},
+		stairs(types.CobbleStairs, 2),
+		{ID: types.WallSign, Hardness: 1, Place: placeSign, Drops: dropItem(types.Sign, 0, 1), Tile: "Sign"},
+		{ID: types.IronDoorBlock, Solid: true, Hardness: 5, Drops: dropItem(types.IronDoor, 0, 1)},
+		{ID: types.RedstoneOre, Solid: true, Hardness: 3, Opacity: MaxLight, Drops: dropItem(types.Redstone, 0, 4)},
+		{ID: types.GlowingRedstoneOre, Solid: true, Hardness: 3, Emission: 9, Opacity: MaxLight, Drops: dropItem(types.Redstone, 0, 4)},
+		{ID: types.Snow, Hardness: 0.1, Replaceable: true, Drops: dropItem(types.Snowball, 0, 1)},
+		{ID: types.Ice, Solid: true, Hardness: 0.5, Opacity: 2, Drops: dropNothing},
+		{ID: types.SnowBlock, Solid: true, Hardness: 0.2, Opacity: MaxLight, Drops: dropItem(types.Snowball, 0, 4)},
+		{ID: types.Cactus, Solid: true, Hardness: 0.4, Place: placeOn(types.Sand, types.Cactus)},
+		{ID: types.ClayBlock, Solid: true, Hardness: 0.6, Opacity: MaxLight, Drops: dropItem(types.Clay, 0, 4)},
+		{ID: types.Reeds, Place: placeOn(types.Grass, types.Dirt, types.Sand, types.Podzol, types.Reeds), Drops: dropItem(types.Sugarcane, 0, 1)},
+		{ID: types.Fence, Solid: true, Hardness: 2},
+		{ID: types.Pumpkin, Solid: true, Hardness: 1, Opacity: MaxLight, Place: placePumpkin, Drops: dropMasked(0)},
+		{ID: types.Netherrack, Solid: true, Hardness: 0.4, Opacity: MaxLight},
+		{ID: types.SoulSand, Solid: true, Hardness: 0.5, Opacity: MaxLight},
+		{ID: types.Glowstone, Solid: true, Hardness: 0.3, Emission: 15, Opacity: MaxLight, Drops: dropItem(types.GlowstoneDust, 0, 3)},
+		{ID: types.LitPumpkin, Solid: true, Hardness: 1, Emission: 15, Opacity: MaxLight, Place: placePumpkin, Drops: dropMasked(0)},
+		{ID: types.CakeBlock, Solid: true, Hardness: 0.5, Drops: dropNothing},
+		{ID: types.Trapdoor, Solid: true, Hardness: 3, OnInteract: toggleMeta(8), Drops: dropMasked(0)},
+		{ID: types.StoneBricks, Solid: true, Hardness: 1.5, Opacity: MaxLight},
+		{ID: types.IronBar, Solid: true, Hardness: 5},
+		{ID: types.GlassPane, Solid: true, Hardness: 0.3, Drops: dropNothing},
+		{ID: types.MelonBlock, Solid: true, Hardness: 1, Opacity: MaxLight, Drops: dropItem(types.Melon, 0, 5)},
+		{ID: types.PumpkinStem, Place: placeOn(types.Farmland), Drops: dropItem(types.PumpkinSeeds, 0, 1)},
+		{ID: types.MelonStem, Place: placeOn(types.Farmland), Drops: dropItem(types.MelonSeeds, 0, 1)},
+		{ID: types.Vine, Hardness: 0.2, Replaceable: true, Drops: dropNothing},
+		{ID: types.FenceGate, Solid: true, Hardness: 2, Place: placePumpkin, OnInteract: toggleMeta(4), Drops: dropMasked(0)},
+		stairs(types.BrickStairs, 2),
+		stairs(types.StoneBrickStairs, 1.5),
+		{ID: types.Mycelium, Solid: true, Hardness: 0.6, Opacity: MaxLight, Drops: dropItem(types.Dirt, 0, 1)},
+		{ID: types.WaterLily, Place: placeOn(types.StillWater)},
+		{ID: types.NetherBricks, Solid: true, Hardness: 2, Opacity: MaxLight},
+		{ID: types.NetherBrickFence, Solid: true, Hardness: 2},
+		stairs(types.NetherBricksStairs, 2),
+		{ID: types.EnchantingTable, Solid: true, Hardness: 5},
+		{ID: types.BrewingStand, Solid: true, Hardness: 0.5, Emission: 1},
+		{ID: types.EndPortal, Hardness: -1, Emission: 15, Drops: dropNothing},
+		{ID: types.EndStone, Solid: true, Hardness: 3, Opacity: MaxLight},
+		stairs(types.SandstoneStairs, 0.8),
+		{ID: types.EmeraldOre, Solid: true, Hardness: 3, Opacity: MaxLight, Drops: dropItem(types.Emerald, 0, 1)},
+		{ID: types.EmeraldBlock, Solid: true, Hardness: 5, Opacity: MaxLight},
+		stairs(types.SpruceWoodStairs, 2),
+		stairs(types.BirchWoodStairs, 2),
+		stairs(types.JungleWoodStairs, 2),
+		{ID: types.CobbleWall, Solid: true, Hardness: 2},
+		{ID: types.FlowerPotBlock, Drops: dropItem(types.FlowerPot, 0, 1)},
+		{ID: types.CarrotBlock, Place: placeOn(types.Farmland), Drops: dropCrop(types.Carrot, types.Carrot)},
+		{ID: types.PotatoBlock, Place: placeOn(types.Farmland), Drops: dropCrop(types.Potato, types.Potato)},
+		{ID: types.Anvil, Solid: true, Hardness: 5, Place: placePumpkin, Drops: dropMasked(0xc)},
+		{ID: types.TrappedChest, Solid: true, Hardness: 2.5, Place: placeFacing, Drops: dropMasked(0), Tile: "Chest", OnInteract: openWindow},
+		{ID: types.RedstoneBlock, Solid: true, Hardness: 5, Opacity: MaxLight},
+		{ID: types.QuartzBlock, Solid: true, Hardness: 0.8, Opacity: MaxLight},
+		stairs(types.QuartzStairs, 0.8),
+		{ID: types.DoubleWoodSlab, Solid: true, Hardness: 2, Opacity: MaxLight, Drops: func(b types.Block) []types.Item {
+			return []types.Item{{ID: types.WoodSlab, Meta: uint16(b.Meta & 7), Amount: 2}}
+		}},
+		{ID: types.WoodSlab, Solid: true, Hardness: 2, Opacity: MaxLight, Place: placeSlab(types.DoubleWoodSlab), Drops: dropMasked(7)},
+		{ID: types.StainedClay, Solid: true, Hardness: 1.25, Opacity: MaxLight},
+		{ID: types.Leaves2, Solid: true, Hardness: 0.2, Opacity: 1, Place: placeLeaves, Drops: dropNothing},
+		{ID: types.Wood2, Solid: true, Hardness: 2, Opacity: MaxLight, Place: placeAxis, Drops: dropMasked(3)},
+		stairs(types.AcaciaWoodStairs, 2),
+		stairs(types.DarkOakWoodStairs, 2),
+		{ID: types.IronTrapdoor, Solid: true, Hardness: 5, Drops: dropMasked(0)},
+		{ID: types.HayBale, Solid: true, Hardness: 0.5, Opacity: MaxLight, Place: placeAxis, Drops: dropMasked(0)},
+		{ID: types.Carpet, Solid: true, Hardness: 0.1},
+		{ID: types.HardenedClay, Solid: true, Hardness: 1.25, Opacity: MaxLight},
+		{ID: types.CoalBlock, Solid: true, Hardness: 5, Opacity: MaxLight},
+		{ID: types.PackedIce, Solid: true, Hardness: 0.5, Opacity: MaxLight},
+		{ID: types.DoublePlant, Replaceable: true, Drops: dropNothing},
+		{ID: types.FenceGateSpruce, Solid: true, Hardness: 2, Place: placePumpkin, OnInteract: toggleMeta(4), Drops: dropMasked(0)},
+		{ID: types.FenceGateBirch, Solid: true, Hardness: 2, Place: placePumpkin, OnInteract: toggleMeta(4), Drops: dropMasked(0)},
+		{ID: types.FenceGateJungle, Solid: true, Hardness: 2, Place: placePumpkin, OnInteract: toggleMeta(4), Drops: dropMasked(0)},
+		{ID: types.FenceGateDarkOak, Solid: true, Hardness: 2, Place: placePumpkin, OnInteract: toggleMeta(4), Drops: dropMasked(0)},
+		{ID: types.FenceGateAcacia, Solid: true, Hardness: 2, Place: placePumpkin, OnInteract: toggleMeta(4), Drops: dropMasked(0)},
+		{ID: types.GrassPath, Solid: true, Hardness: 0.6, Opacity: MaxLight, Drops: dropItem(types.Dirt, 0, 1)},
+		{ID: types.Podzol, Solid: true, Hardness: 0.5, Opacity: MaxLight, Drops: dropItem(types.Dirt, 0, 1)},
+		{ID: types.BeetrootBlock, Place: placeOn(types.Farmland), Drops: dropCrop(types.Beetroot, types.BeetrootSeeds)},
+		{ID: types.Stonecutter, Solid: true, Hardness: 3.5, Opacity: MaxLight},
+		{ID: types.GlowingObsidian, Solid: true, Hardness: 50, Emission: 12, Opacity: MaxLight},
+	} {
+		RegisterBlock(bt)
+	}
+
+	for item, block := range map[types.ID]types.ID{
+		types.Seeds:         types.WheatBlock,
+		types.Carrot:        types.CarrotBlock,
+		types.Potato:        types.PotatoBlock,
+		types.BeetrootSeeds: types.BeetrootBlock,
+		types.PumpkinSeeds:  types.PumpkinStem,
+		types.MelonSeeds:    types.MelonStem,
+		types.Sugarcane:     types.Reeds,
+		types.Sign:          types.SignPost,
+		types.Cake:          types.CakeBlock,
+		types.FlowerPot:     types.FlowerPotBlock,
+	} {
+		RegisterItemBlock(item, block)
+	}
+}
+
+// placeLeaves marks leaves placed by players, so they never decay.
+func placeLeaves(ctx *BlockPlace) bool {
+	ctx.Block.Meta |= leavesNoDecay
+	return true
+}
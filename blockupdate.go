@@ -0,0 +1,227 @@
+package lav7
+
+import (
+	"container/heap"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// maxBlockUpdates is a limit of scheduled block updates run on each tick. Remaining updates run on next ticks.
+const maxBlockUpdates = 1024
+
+// BlockUpdater handles scheduled updates of a block type, e.g. falling sand or flowing water.
+// Updates are scheduled when the block or its neighbors change, and run on the level goroutine.
+type BlockUpdater interface {
+	UpdateDelay() int                // Ticks to wait after a change, before Update is called
+	Update(lv *Level, x, y, z int32) // Updates the block on given coordinates
+}
+
+var blockUpdaters = map[byte]BlockUpdater{}
+
+// RegisterBlockUpdater sets the updater for given block ID. A nil updater removes the updater.
+func RegisterBlockUpdater(id types.ID, u BlockUpdater) {
+	if u == nil {
+		delete(blockUpdaters, id.Block())
+		return
+	}
+	blockUpdaters[id.Block()] = u
+}
+
+// scheduledUpdate is a block update queued for a tick.
+type scheduledUpdate struct {
+	pos  [3]int32
+	tick uint64
+	seq  uint64
+}
+
+type updateQueue []scheduledUpdate
+
+func (q updateQueue) Len() int { return len(q) }
+func (q updateQueue) Less(i, j int) bool {
+	if q[i].tick != q[j].tick {
+		return q[i].tick < q[j].tick
+	}
+	return q[i].seq < q[j].seq
+}
+func (q updateQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *updateQueue) Push(x interface{}) { *q = append(*q, x.(scheduledUpdate)) }
+func (q *updateQueue) Pop() interface{} {
+	old := *q
+	u := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return u
+}
+
+// blockUpdates holds scheduled block updates, and block changes not sent to players yet.
+type blockUpdates struct {
+	sync.Mutex
+	due     map[[3]int32]uint64 // Position -> tick of the earliest update
+	queue   updateQueue
+	seq     uint64
+	changes map[[3]int32]struct{}
+}
+
+func newBlockUpdates() *blockUpdates {
+	return &blockUpdates{
+		due:     make(map[[3]int32]uint64),
+		changes: make(map[[3]int32]struct{}),
+	}
+}
+
+// loadedChunk returns the chunk if it is loaded, without loading or generating it.
+func (lv *Level) loadedChunk(cx, cz int32) *types.Chunk {
+	lv.ChunkMutex.Lock()
+	defer lv.ChunkMutex.Unlock()
+	return lv.ChunkMap[[2]int32{cx, cz}]
+}
+
+// LoadedBlock returns the block on given coordinates, and false if the chunk is not loaded or y is out of range.
+func (lv *Level) LoadedBlock(x, y, z int32) (types.Block, bool) {
+	if y < 0 || y > 127 {
+		return types.Block{}, false
+	}
+	c := lv.loadedChunk(x>>4, z>>4)
+	if c == nil {
+		return types.Block{}, false
+	}
+	c.Mutex().RLock()
+	defer c.Mutex().RUnlock()
+	return types.Block{
+		ID:   c.GetBlock(byte(x&0xf), byte(y), byte(z&0xf)),
+		Meta: c.GetBlockMeta(byte(x&0xf), byte(y), byte(z&0xf)),
+	}, true
+}
+
+// ScheduleUpdate schedules update of the block on given coordinates after delay ticks.
+// If an earlier update is already scheduled on the position, this does nothing.
+func (lv *Level) ScheduleUpdate(x, y, z int32, delay int) {
+	if delay < 1 {
+		delay = 1
+	}
+	pos := [3]int32{x, y, z}
+	tick := lv.CurrentTick() + uint64(delay)
+	u := lv.updates
+	u.Lock()
+	defer u.Unlock()
+	if due, ok := u.due[pos]; ok && due <= tick {
+		return
+	}
+	u.due[pos] = tick
+	u.seq++
+	heap.Push(&u.queue, scheduledUpdate{pos: pos, tick: tick, seq: u.seq})
+}
+
+// NotifyNeighbors schedules updates of the block on given coordinates and its neighbors,
+// after the block is changed.
+func (lv *Level) NotifyNeighbors(x, y, z int32) {
+	for _, d := range [7][3]int32{{0, 0, 0}, {0, -1, 0}, {0, 1, 0}, {-1, 0, 0}, {1, 0, 0}, {0, 0, -1}, {0, 0, 1}} {
+		nx, ny, nz := x+d[0], y+d[1], z+d[2]
+		b, ok := lv.LoadedBlock(nx, ny, nz)
+		if !ok {
+			continue
+		}
+		if u := blockUpdaters[b.ID]; u != nil {
+			lv.ScheduleUpdate(nx, ny, nz, u.UpdateDelay())
+		}
+	}
+}
+
+// ChangeBlock sets the block, notifies neighbors, and sends the change to players on next tick.
+// Block updaters should use this to change blocks.
+func (lv *Level) ChangeBlock(x, y, z int32, block types.Block) {
+	if y < 0 || y > 127 || lv.loadedChunk(x>>4, z>>4) == nil {
+		return
+	}
+	lv.Set(x, y, z, block)
+	lv.updates.Lock()
+	lv.updates.changes[[3]int32{x, y, z}] = struct{}{}
+	lv.updates.Unlock()
+	lv.NotifyNeighbors(x, y, z)
+}
+
+// runBlockUpdates runs scheduled block updates due on given tick.
+func (lv *Level) runBlockUpdates(tick uint64) {
+	u := lv.updates
+	for i := 0; i < maxBlockUpdates; i++ {
+		u.Lock()
+		if len(u.queue) == 0 || u.queue[0].tick > tick {
+			u.Unlock()
+			return
+		}
+		s := heap.Pop(&u.queue).(scheduledUpdate)
+		if u.due[s.pos] != s.tick { // Rescheduled to another tick
+			u.Unlock()
+			continue
+		}
+		delete(u.due, s.pos)
+		u.Unlock()
+
+		b, ok := lv.LoadedBlock(s.pos[0], s.pos[1], s.pos[2])
+		if !ok {
+			continue
+		}
+		if updater := blockUpdaters[b.ID]; updater != nil {
+			runBlockUpdate(lv, updater, s.pos)
+		}
+	}
+}
+
+func runBlockUpdate(lv *Level, updater BlockUpdater, pos [3]int32) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while updating block on %v: %v\n%s", pos, r, debug.Stack())
+		}
+	}()
+	updater.Update(lv, pos[0], pos[1], pos[2])
+}
+
+// PendingUpdates returns count of scheduled block updates.
+func (lv *Level) PendingUpdates() int {
+	lv.updates.Lock()
+	defer lv.updates.Unlock()
+	return len(lv.updates.due)
+}
+
+// flushBlockChanges sends block changes to players who have the chunks, batched in an UpdateBlock packet for each player.
+func (lv *Level) flushBlockChanges() {
+	u := lv.updates
+	u.Lock()
+	if len(u.changes) == 0 {
+		u.Unlock()
+		return
+	}
+	changes := u.changes
+	u.changes = make(map[[3]int32]struct{})
+	u.Unlock()
+
+	records := make(map[[2]int32][]proto.BlockRecord)
+	for pos := range changes {
+		b, ok := lv.LoadedBlock(pos[0], pos[1], pos[2])
+		if !ok {
+			continue
+		}
+		cc := [2]int32{pos[0] >> 4, pos[2] >> 4}
+		records[cc] = append(records[cc], proto.BlockRecord{
+			X:     uint32(pos[0]),
+			Y:     byte(pos[1]),
+			Z:     uint32(pos[2]),
+			Block: b,
+			Flags: proto.UpdateAllPriority,
+		})
+	}
+	lv.AsPlayers(func(p *Player) {
+		var list []proto.BlockRecord
+		for cc, r := range records {
+			if p.HasChunk(cc[0], cc[1]) {
+				list = append(list, r...)
+			}
+		}
+		if len(list) > 0 {
+			p.SendPacket(&proto.UpdateBlock{BlockRecords: list})
+		}
+	})
+}
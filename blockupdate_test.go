@@ -0,0 +1,132 @@
+package lav7
+
+import (
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// runTicks runs level ticks without waiting for the ticker.
+func runTicks(lv *Level, n int) {
+	for i := 0; i < n; i++ {
+		lv.tick(time.Now())
+	}
+}
+
+func TestScheduleUpdate(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.ScheduleUpdate(0, 6, 0, 10)
+	lv.ScheduleUpdate(0, 6, 0, 3) // Earlier update replaces the later one
+	lv.ScheduleUpdate(0, 6, 0, 5)
+	if n := lv.PendingUpdates(); n != 1 {
+		t.Error("Updates on a position should be merged:", n)
+	}
+	runTicks(lv, 3)
+	if n := lv.PendingUpdates(); n != 0 {
+		t.Error("Update is not run:", n)
+	}
+}
+
+func TestFallingBlock(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.ChangeBlock(0, 12, 0, types.Block{ID: byte(types.Sand)})
+	lv.ChangeBlock(1, 12, 0, types.Block{ID: byte(types.Stone)})
+	lv.ChangeBlock(1, 13, 0, types.Block{ID: byte(types.Gravel)})
+	runTicks(lv, 20)
+	if b := lv.GetBlock(0, 6, 0); b != byte(types.Sand) {
+		t.Error("Sand should fall on the ground:", b)
+	}
+	for y := int32(7); y <= 12; y++ {
+		if b := lv.GetBlock(0, y, 0); b != 0 {
+			t.Errorf("Block on y %d should be air: %d", y, b)
+		}
+	}
+	if b := lv.GetBlock(1, 13, 0); b != byte(types.Gravel) {
+		t.Error("Gravel on stone should not fall:", b)
+	}
+}
+
+func TestLiquidFlow(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.ChangeBlock(0, 6, 0, types.Block{ID: byte(types.StillWater)})
+	runTicks(lv, 100)
+	for x := int32(1); x <= 7; x++ {
+		if b := lv.Get(x, 6, 0); b.ID != byte(types.Water) || b.Meta != byte(x) {
+			t.Errorf("Water on x %d: got %v, expected flow level %d", x, b, x)
+		}
+	}
+	if b := lv.Get(8, 6, 0); b.ID != 0 {
+		t.Error("Water should not flow farther than 7 blocks:", b)
+	}
+	if b := lv.Get(3, 6, 3); b.ID != byte(types.Water) || b.Meta != 6 {
+		t.Error("Water should spread on every direction:", b)
+	}
+
+	// Water falls down from ledges
+	lv.ChangeBlock(20, 10, 0, types.Block{ID: byte(types.Stone)})
+	lv.ChangeBlock(20, 11, 0, types.Block{ID: byte(types.StillWater)})
+	runTicks(lv, 100)
+	if b := lv.Get(21, 10, 0); b.ID != byte(types.Water) || b.Meta&8 == 0 {
+		t.Error("Water should fall beside the ledge:", b)
+	}
+	if b := lv.Get(21, 6, 0); b.ID != byte(types.Water) {
+		t.Error("Falling water should reach the ground:", b)
+	}
+
+	// Flowing water dries up without a source
+	lv.ChangeBlock(0, 6, 0, types.Block{})
+	runTicks(lv, 200)
+	for x := int32(0); x <= 8; x++ {
+		if b := lv.Get(x, 6, 0); b.ID != 0 {
+			t.Errorf("Water on x %d should dry up: %v", x, b)
+		}
+	}
+}
+
+func TestLavaHarden(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.ChangeBlock(-8, 6, -8, types.Block{ID: byte(types.StillLava)})
+	runTicks(lv, 100)
+	if b := lv.Get(-5, 6, -8); b.ID != byte(types.Lava) || b.Meta != 6 {
+		t.Error("Lava should flow 3 blocks:", b)
+	}
+	if b := lv.Get(-4, 6, -8); b.ID != 0 {
+		t.Error("Lava flows too far:", b)
+	}
+	lv.ChangeBlock(-8, 7, -8, types.Block{ID: byte(types.StillWater)})
+	runTicks(lv, 100)
+	if b := lv.Get(-8, 6, -8); b.ID != byte(types.Obsidian) {
+		t.Error("Lava source under water should be obsidian:", b)
+	}
+	if lv.GetBlockLight(-8, 7, -7) >= 14 {
+		t.Error("Light should be updated after lava is gone")
+	}
+}
+
+func TestBlockChangesSent(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	r := startTestServer(t, network)
+	defer r.Close()
+	c, updates := spawnTestClient(t, network, "physics")
+	defer c.Close()
+
+	lv := GetDefaultLevel()
+	lv.ChangeBlock(5, 9, 5, types.Block{ID: byte(types.Sand)})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			runTicks(lv, 1)
+			time.Sleep(time.Millisecond * 10)
+		}
+		close(done)
+	}()
+	if !waitUpdateBlock(updates, 5, 6, 5, byte(types.Sand)) {
+		t.Error("Falling block is not sent to the player")
+	}
+	<-done
+	if b := lv.GetBlock(5, 6, 5); b != byte(types.Sand) {
+		t.Error("Sand should fall on the ground:", b)
+	}
+}
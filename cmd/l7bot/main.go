@@ -0,0 +1,129 @@
+// Command l7bot starts simulated MCPE players against a server, for load tests.
+//
+// Every bot connects from the same IP address if the server runs on localhost,
+// so server-side rate limits(rate-limit-*) may need to be raised for large bot counts.
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/L7-MCPE/lav7/raknet/client"
+)
+
+var (
+	address  = flag.String("addr", "127.0.0.1:19132", "server address")
+	count    = flag.Int("n", 15, "number of bots")
+	prefix   = flag.String("prefix", "l7bot", "username prefix")
+	interval = flag.Duration("interval", time.Millisecond*500, "interval between bot joins")
+	move     = flag.Duration("move", time.Millisecond*100, "interval between MovePlayer packets")
+	radius   = flag.Float64("radius", 16, "radius of the circle bots walk along")
+	duration = flag.Duration("duration", 0, "stop after given duration(0: until interrupted)")
+	chat     = flag.Duration("chat", 0, "interval between chat messages(0: no chat)")
+)
+
+var online, failed int32
+
+func main() {
+	flag.Parse()
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		if *duration > 0 {
+			select {
+			case <-sig:
+			case <-time.After(*duration):
+			}
+		} else {
+			<-sig
+		}
+		close(stop)
+	}()
+
+	wg := new(sync.WaitGroup)
+	go report(stop)
+	for i := 0; i < *count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bot(*prefix+strconv.Itoa(i), float64(i)/float64(*count)*2*math.Pi, stop)
+		}(i)
+		select {
+		case <-time.After(*interval):
+		case <-stop:
+		}
+	}
+	wg.Wait()
+	log.Printf("Done: %d bots failed", atomic.LoadInt32(&failed))
+}
+
+// bot connects to the server, and walks along a circle until stop is closed.
+func bot(name string, phase float64, stop <-chan struct{}) {
+	start := time.Now()
+	c, err := client.Dial(*address)
+	if err == nil {
+		if err = c.Login(name); err == nil {
+			err = c.WaitSpawn(time.Second * 30)
+		}
+		if err != nil {
+			c.Close()
+		}
+	}
+	if err != nil {
+		log.Println(name+": connection failed:", err)
+		atomic.AddInt32(&failed, 1)
+		return
+	}
+	defer c.Close()
+	log.Printf("%s: spawned in %v, %d chunks received", name, time.Since(start), c.Chunks())
+	atomic.AddInt32(&online, 1)
+	defer atomic.AddInt32(&online, -1)
+
+	center := c.Position()
+	moveTicker := time.NewTicker(*move)
+	defer moveTicker.Stop()
+	var chatTicker <-chan time.Time
+	if *chat > 0 {
+		t := time.NewTicker(*chat)
+		defer t.Stop()
+		chatTicker = t.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.Closed():
+			log.Println(name+":", c.Err())
+			atomic.AddInt32(&failed, 1)
+			return
+		case <-moveTicker.C:
+			phase += 0.05
+			c.Move(center.X+float32(*radius*math.Cos(phase)), center.Y,
+				center.Z+float32(*radius*math.Sin(phase)), float32(phase*180/math.Pi), 0)
+		case <-chatTicker:
+			c.Chat("Hello from " + name)
+		}
+	}
+}
+
+func report(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			log.Printf("Online bots: %d/%d, failed: %d",
+				atomic.LoadInt32(&online), *count, atomic.LoadInt32(&failed))
+		}
+	}
+}
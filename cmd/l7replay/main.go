@@ -0,0 +1,127 @@
+// Command l7replay decodes session capture files written by lav7(capture-dir option),
+// and prints MCPE packets in the capture.
+//
+// Inbound datagrams are fed through raknet session code like live server, so ordering, split
+// and duplication issues are reproduced offline. Usage:
+//
+//	l7replay [flags] capture.pcapng
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
+
+var (
+	direction = flag.String("dir", "both", "packet direction to print: in, out or both")
+	filter    = flag.String("filter", "", "comma-separated packet names to print, e.g. UseItem,UpdateBlock")
+	width     = flag.Int("width", 200, "maximum length of printed packet fields(0: unlimited)")
+	batch     = flag.Bool("batch", false, "print Batch packets themselves, besides packets in them")
+)
+
+var start time.Time
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: l7replay [flags] capture.pcapng")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalln("Error while opening capture:", err)
+	}
+	defer f.Close()
+	rd, err := raknet.NewCaptureReader(f)
+	if err != nil {
+		log.Fatalln("Error while reading capture:", err)
+	}
+
+	r := raknet.NewReplayer(func(inbound bool, t time.Time, buf *bytes.Buffer) {
+		if (inbound && *direction == "out") || (!inbound && *direction == "in") {
+			return
+		}
+		printPacket(inbound, t, buf)
+	})
+	count := 0
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Println("Error while reading capture:", err)
+			break
+		}
+		if start.IsZero() {
+			start = rec.Time
+			log.Printf("Capture of %v starts at %v", rec.Client, rec.Time.Format(time.RFC3339Nano))
+		}
+		r.Feed(rec)
+		count++
+	}
+	r.Close()
+	log.Println(count, "datagrams replayed")
+}
+
+// printPacket decodes MCPE packet and prints it. Packets in Batch are printed one by one.
+func printPacket(inbound bool, t time.Time, buf *bytes.Buffer) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("  decode error:", r)
+		}
+	}()
+	pid := buffer.ReadByte(buf)
+	pk := proto.NewPacket(pid)
+	arrow := "S->C"
+	if inbound {
+		arrow = "C->S"
+	}
+	prefix := fmt.Sprintf("%10.3f %s", t.Sub(start).Seconds(), arrow)
+	if pk == nil {
+		fmt.Printf("%s unknown packet 0x%02x (%d bytes)\n", prefix, pid, buf.Len())
+		return
+	}
+	pk.Read(buf)
+	if b, ok := pk.(*proto.Batch); ok {
+		if *batch {
+			fmt.Printf("%s Batch (%d packets)\n", prefix, len(b.Payloads))
+		}
+		for _, payload := range b.Payloads {
+			printPacket(inbound, t, bytes.NewBuffer(payload))
+		}
+		return
+	}
+	name := reflect.TypeOf(pk).Elem().Name()
+	if *filter != "" && !contains(strings.Split(*filter, ","), name) {
+		return
+	}
+	fields := fmt.Sprintf("%+v", pk)
+	if *width > 0 && len(fields) > *width {
+		fields = fields[:*width] + "..."
+	}
+	fmt.Printf("%s %s %s\n", prefix, name, fields)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if strings.TrimSpace(l) == s {
+			return true
+		}
+	}
+	return false
+}
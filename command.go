@@ -2,98 +2,303 @@ package lav7
 
 import (
 	"bufio"
-	"encoding/base64"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"runtime"
-	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 
-	"github.com/L7-MCPE/lav7/proto"
-	"github.com/L7-MCPE/lav7/raknet"
-	"github.com/L7-MCPE/lav7/types"
-	"github.com/L7-MCPE/lav7/util"
 	"github.com/L7-MCPE/lav7/util/vector"
 )
 
-const skin = "eJzsmm9wVNUZxv1gNSVqqJU2hJANCRt2l91kd8Pmvwkx7ZA4BCISKQiLpSkUTAUCKTVaMIJAOkjCHzGgiAIZXBkkRAfGWmxKO40dO7UwYzvTL47YmU6HdjrtB/qtr/u8m/dy7s3N3uwkzq7knpln7rnnvudwf++5e3OH573DohXOTCfINf0uUvvQrKlfp/YFRXFltX4y298//73l/QlvKPdelsoPgXFlmdtUqc4/lgZeYXdnpY3Kn5+fr9Ptwi97b/wNyNjtzq+yYv8h9bcwGfjN2CcTv3D7sqdwH+99I//t/P4TblXyXHyV//6hzc1OIwhMnugRrGXO+yiQF1MoL52KHLF3wNyZafwORCzGwC9xmIO5nuG1ZF2r74dU4of8OfcwB/gq5mTwUYTzhb4MWuC9n+OQD4nBHIzJOip/vO+HZPPzPWfG7pm5wRW9b1dmmpaH62e76MZAD/3rvRN041xPtH+APjm0iSrnTOUYxGJO0XDuOAeZsVxafT8km5/3aXo6BaP36h9mxzOMfmh2Bl07tp0+O9XFx7+c6KLfdLfRP85205Wdq1mIQaxneL/Rx1pYk/No8f2QEvzyG5g+hQoy76Z9yx+k0+sfpofc32D2mzdvUktjhK4ffY7Z0ccYcoIYxGIO5mINdU2r74eU4Y/uV+yZzaA9j86j4y0L6MXwg3SlYzl9erSDPn+jm3YuqmbdiHTRh7sep+Nr6zgGsZiDuVhD9l59/432/ZBsfnnm8cyunl9IZzY/RifXN9DPm0ujf7/8dKG9mS62N9GhR7+rU/+WRnptbQPHIBZzMBdrBIfXlN9/vO+HZPPj3uX+cexrXcT72bOqmtn2Li2nPUtKmRV66Yk6ej36vEPoIwaxkacW89yTG5pix+H1JI9Z992hk+Qt2fzG5sxZSqo8Hg+53W5NVvM7O9dSdc69psK1cHhBXFmt/59/DhJ0/bMLNHC+izbM99MjAad2nAj+3BkLWcLvdDpZifCHstJ1Uvnn1/hNNRb+//9viCDkYOi3r0w6fqKPSXJw9U+ndew4TgS/8flXNVZ+NQcT+fyDX3IwEfyFc1pJVcC9RSfj81AwayW58lbT3NlrWT3dG3U6d3Y39Z1+npYX59CSomxWuCSXx3DNGN/70mY6eLCVDuxvpX1dP2J1v7iBx3pfbuPnHKzCbeRft+4RWrG8noX+W5FOfjdAmGvFH/T8hPyuTTTP+wyVFnZSQ82b9J3yk6yQ92em/OAumrORZeS5drWPPvzdq/TB5SN04fx+FvoYwzVj/MCFPfT2W5105swzdOr1p1no95/fSe++s1fjV3OgvgOM/L8ePEQff3RCy4EVfyjKHXBvZfaq4v3UWHuRHq7q1/gLHMtu/R4czeTK/77GHozO6wj6Rmh3aYD6SvTCmFnspmCAtgX9tCNYqBPGcE3iDlSGqL+0mIU+hPEV0dgmfxEL/TU+Hz0ZiAnXx8If9LRTuX9vQvxgh+6c4tDpnmkhyvMtIU9olab//vvPPIZrxnhoyv2B6LVKTUcObuMxWQ+a6VqorYc+lDbVN3K99DxKy3DTm327ed54+V2zVmn8BbkrRvBv+vFjpvxgVnMg/MZ44QezmoN4/H/7dJDP757q1a2zdEmtxg/98tLBhPjV33916LDGX+D4Xkwm/Ph3Vab0B+bR+5depixnvZYDsM9wNtDhnpHxcm7MAc4xDnZwSA5EOL/0zoFR9x9KdP/BD24IzwL4587+ocbvzl8Td//Rx54gB7+4eJi5Zd8vv3eMrxnjZf9jrLfYZf/XzPgWa6Njhk4yXvPtaTrVT/smNWU+wML1sfDj/RePH3/vIPB7oufq+8+YfzBC8tyKZNzs9y85UCXjV/8QYf31k36dMPar93vpifBDOp04/iz1v93FGvyg15Iff+P9rs1aDoQdKvFtN+UX+ZxPjtgX0U9zs2lH3kwW+hjbkDN9hJZlZbLC2Zm0LrpfEPoyDgZjDuQcz9hTrQt1Av+7A/t43lj46+vrafHixcyPXFQEXtA0z9uh8Xu9XiouLqaysjIqKSlh1dTU8L93LrJL0+DlY/TR0Cm69seIThjDNTUWc6HImV18z6owhmsqv6qhK28w//PPrdDY0U+Uv66ujiQH4XCY2traWC0tLXwOZrALf3l5OVVVVTG78Ks5AKMxB8Ku8ss8NQeqZPy1V7eZ6pWj7XSsdyt17lip0/5963VxVvzV1dUE1dbWch6amppYjY2N1NDQwLyqKisrddqxPaxp9ws/iH7TtfJ9md0zriFG4jueflwns/Ejq2tH1Z7mStazi0pY6Mu4HK347WY3u9nNbnazm93sZje72c1udrOb3RJt460fgKcv3h000f7ul93G65/D01c9v8nGD09X9Tsnur7hy27jrR8QfvF6U53fuN/G+gFjfYHUDeD/1OGlz6/xUqEvl4W+kV/1vNXaHwhef6rxwzOS+gF4ifCUUFuAGgPUGoh3hByY8Vv5/cIOjx9ef9L5Hc23/GHHMo0fHjK8ZPhIyAH8FfYao0fJAbxET9rXaNZdd7LQF6/f6PerNQPi78Pr/yrww1eDxyr8kgPwG31AePri8ap+v+olwtuHvwuvN9n88MSFH155ovzs2Rv8U/DC4zfjhxcu/naq8Is/LvzwUNXffyL7D0/fzOuXGgAolfjhCQs/vFLwY8/FR7fiN/r3Vn4/JP4+vP5U4Bd/PB6/2fsPHrrRv4enP5rfD8HTFX8f/miq8aNmQOoHwC/ssRqDLVwzoNYQGP17eNqj+f3iaUPwuFOBX/xx4UfNgFpDEPsO2sr+OvxleOZSP1BRUTHCvwc/vH0zzz+V+aU+ADUDqB2QOgKcCztqDaRuADUE4Df69/DO4e2P5vurgtefbH5jfQBqBlA7IHUE4EZtgdQZGOsHjH6+Wd8sRo7jvf8vAgAA//+g4HAb"
+// CommandSender is an interface for command executors: console, players and remote consoles.
+type CommandSender interface {
+	Name() string
+	SendMessage(msg string)
+	HasPermission(node string) bool
+}
+
+// Command is a server command, run with "/name args..." in game or "name args..." on console.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Usage       string // Argument usage, e.g. "<player> [reason]"
+	Description string
+	Permission  string // Permission node to run the command. If empty, everyone can run it.
+
+	// Execute runs the command. Returning ErrUsage prints the usage to the sender.
+	Execute func(sender CommandSender, args *CommandArgs) error
+}
+
+// ErrUsage is returned from commands or argument parsers if the command is used incorrectly.
+var ErrUsage = errors.New("invalid usage")
+
+// usageError is an ErrUsage with detailed message.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+var commands = make(map[string]*Command) // Includes aliases
+var commandLock = new(sync.RWMutex)
+
+// RegisterCommand adds command to the server.
+// It returns error if the name or aliases are used by other commands.
+func RegisterCommand(cmd *Command) error {
+	commandLock.Lock()
+	defer commandLock.Unlock()
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if _, ok := commands[strings.ToLower(name)]; ok {
+			return fmt.Errorf("command %s is already registered", name)
+		}
+	}
+	for _, name := range names {
+		commands[strings.ToLower(name)] = cmd
+	}
+	return nil
+}
+
+// UnregisterCommand removes command with given name, including its aliases.
+func UnregisterCommand(name string) {
+	commandLock.Lock()
+	defer commandLock.Unlock()
+	cmd, ok := commands[strings.ToLower(name)]
+	if !ok {
+		return
+	}
+	for k, c := range commands {
+		if c == cmd {
+			delete(commands, k)
+		}
+	}
+}
+
+// GetCommand finds the command with given name or alias. If it doesn't present, returns nil.
+func GetCommand(name string) *Command {
+	commandLock.RLock()
+	defer commandLock.RUnlock()
+	return commands[strings.ToLower(name)]
+}
+
+// GetCommands returns every registered commands, sorted by name.
+func GetCommands() []*Command {
+	commandLock.RLock()
+	defer commandLock.RUnlock()
+	var names []string
+	for name, cmd := range commands {
+		if name == strings.ToLower(cmd.Name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	list := make([]*Command, len(names))
+	for i, name := range names {
+		list[i] = commands[name]
+	}
+	return list
+}
+
+// ExecuteCommand parses given command line and runs it as given sender.
+// Leading "/" is optional. Errors are reported to the sender.
+func ExecuteCommand(sender CommandSender, line string) {
+	texts := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "/"))
+	if len(texts) == 0 {
+		return
+	}
+	cmd := GetCommand(texts[0])
+	if cmd == nil {
+		sender.SendMessage("Unknown command: " + texts[0] + ". Type 'help' for the command list.")
+		return
+	}
+	if cmd.Permission != "" && !sender.HasPermission(cmd.Permission) {
+		sender.SendMessage("You don't have permission to use this command.")
+		return
+	}
+	args := &CommandArgs{args: texts[1:]}
+	if err := cmd.Execute(sender, args); err != nil {
+		if _, ok := err.(usageError); ok {
+			sender.SendMessage(err.Error())
+			err = ErrUsage
+		}
+		if err == ErrUsage {
+			sender.SendMessage("Usage: /" + cmd.Name + " " + cmd.Usage)
+			return
+		}
+		sender.SendMessage("Error: " + err.Error())
+	}
+}
+
+// CommandArgs is a parser for command arguments.
+// Each parser method consumes an argument, and returns ErrUsage-like error if it is missing or invalid.
+type CommandArgs struct {
+	args []string
+	pos  int
+}
+
+// Len returns count of remaining arguments.
+func (a *CommandArgs) Len() int {
+	return len(a.args) - a.pos
+}
+
+// Peek returns next argument without consuming it, or empty string if there are no more arguments.
+func (a *CommandArgs) Peek() string {
+	if a.Len() == 0 {
+		return ""
+	}
+	return a.args[a.pos]
+}
+
+// String returns next argument.
+func (a *CommandArgs) String() (string, error) {
+	if a.Len() == 0 {
+		return "", ErrUsage
+	}
+	a.pos++
+	return a.args[a.pos-1], nil
+}
+
+// Rest returns every remaining arguments joined with spaces.
+func (a *CommandArgs) Rest() string {
+	s := strings.Join(a.args[a.pos:], " ")
+	a.pos = len(a.args)
+	return s
+}
+
+// Int returns next argument as an integer.
+func (a *CommandArgs) Int() (int, error) {
+	s, err := a.String()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, usageError("Invalid number: " + s)
+	}
+	return n, nil
+}
+
+// Player returns online player with next argument as the name.
+// Names are case-insensitive, and unique prefixes are also accepted.
+func (a *CommandArgs) Player() (*Player, error) {
+	s, err := a.String()
+	if err != nil {
+		return nil, err
+	}
+	if p := FindPlayer(s); p != nil {
+		return p, nil
+	}
+	return nil, usageError("Player not found: " + s)
+}
+
+// Coordinate returns next argument as a coordinate.
+// "~" prefixed values are relative to given base, e.g. "~", "~5", "~-1.5".
+func (a *CommandArgs) Coordinate(base float32) (float32, error) {
+	s, err := a.String()
+	if err != nil {
+		return 0, err
+	}
+	relative := strings.HasPrefix(s, "~")
+	if relative {
+		s = s[1:]
+		if s == "" {
+			return base, nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, usageError("Invalid coordinate: " + s)
+	}
+	if relative {
+		return base + float32(f), nil
+	}
+	return float32(f), nil
+}
+
+// Position returns next three arguments as x, y, z coordinates, relative to given base.
+func (a *CommandArgs) Position(base vector.Vector3) (pos vector.Vector3, err error) {
+	if pos.X, err = a.Coordinate(base.X); err != nil {
+		return
+	}
+	if pos.Y, err = a.Coordinate(base.Y); err != nil {
+		return
+	}
+	pos.Z, err = a.Coordinate(base.Z)
+	return
+}
+
+// FindPlayer finds online player with given name, case-insensitively.
+// If no player has exact name, a player whose name starts with given name is returned if the player is unique.
+func FindPlayer(name string) *Player {
+	name = strings.ToLower(name)
+	var found *Player
+	matches := 0
+	AsPlayers(func(p *Player) {
+		username := strings.ToLower(p.Username)
+		if username == name {
+			found, matches = p, -1
+		} else if matches >= 0 && strings.HasPrefix(username, name) {
+			found = p
+			matches++
+		}
+	})
+	if matches > 1 || name == "" {
+		return nil
+	}
+	return found
+}
+
+// Name returns username of the player. It implements CommandSender interface.
+func (p *Player) Name() string {
+	return p.Username
+}
+
+// HasPermission checks if the player has given permission node. See HasPermission function for details.
+func (p *Player) HasPermission(node string) bool {
+	return node == "" || HasPermission(p.Username, node)
+}
+
+// writerSender is a CommandSender which writes messages to the writer, with every permissions.
+type writerSender struct {
+	name string
+	out  io.Writer
+}
+
+func (s writerSender) Name() string              { return s.name }
+func (s writerSender) SendMessage(msg string)    { fmt.Fprintln(s.out, msg) }
+func (s writerSender) HasPermission(string) bool { return true }
+
+// consoleOutput writes command output to server log.
+type consoleOutput struct{}
+
+func (consoleOutput) Write(p []byte) (int, error) {
+	log.Print(string(p))
+	return len(p), nil
+}
+
+// ConsoleSender is a CommandSender for server console. Messages are written to server log.
+var ConsoleSender CommandSender = writerSender{name: "CONSOLE", out: consoleOutput{}}
 
 // HandleCommand handles command input from stdin.
 func HandleCommand() {
+	r := bufio.NewReader(os.Stdin)
 	for {
-		r := bufio.NewReader(os.Stdin)
-		text, _ := r.ReadString('\n')
-		texts := strings.Split(strings.Replace(text[:len(text)-1], "\r", "", -1), " ")
-		switch texts[0] {
-		case "stop", "exit":
-			go Stop(strings.Join(texts[1:], " "))
-		case "spawn":
-			b, _ := base64.StdEncoding.DecodeString(skin)
-			b, _ = util.DecodeDeflate(b)
-			BroadcastPacket(&proto.PlayerList{
-				Type: proto.PlayerListAdd,
-				PlayerEntries: []proto.PlayerListEntry{
-					{
-						RawUUID:  [16]byte{0, 1, 2, 3, 4, 0, 1, 2, 3, 4, 1, 2, 3, 4, 5, 6},
-						EntityID: 99,
-						Username: "Test",
-						Skinname: "Festive_FestiveSweaterSteve",
-						Skin:     b,
-					},
-				},
-			})
-			SpawnPlayer(&Player{
-				UUID:     [16]byte{0, 1, 2, 3, 4, 0, 1, 2, 3, 4, 1, 2, 3, 4, 5, 6},
-				Username: "Test",
-				EntityID: 99,
-				Position: vector.Vector3{X: 0, Y: 70, Z: 0},
-			})
-
-		case "move":
-			BroadcastPacket(&proto.MovePlayer{
-				EntityID: 199,
-				X:        1,
-				Y:        64,
-				Z:        3,
-			})
-		case "block":
-			br := make([]proto.BlockRecord, 20)
-			for i := 0; i < 20; i++ {
-				br[i] = proto.BlockRecord{
-					X:     0,
-					Y:     byte(i) + 55,
-					Z:     0,
-					Block: types.Block{ID: 4},
-				}
-			}
-			BroadcastPacket(&proto.UpdateBlock{
-				BlockRecords: br,
-			})
-		case "trace":
-			fmt.Print(util.GetTrace())
-		case "gc":
-			Message("[system] Cleaning server memory...")
-			c := GetDefaultLevel().Clean()
-			runtime.GC()
-			debug.FreeOSMemory()
-			Message(fmt.Sprintf("[system] Done. %d chunks saved/unloaded.", c))
-		case "netbytes":
-			bs := atomic.LoadUint64(&raknet.GotBytes)
-			log.Printf("%dKBs", bs>>10)
-		case "dump":
-			f, _ := os.Create("heapdump")
-			debug.WriteHeapDump(f.Fd())
-			log.Printf("Done")
-		case "sendchunk":
-			BroadcastCallback(PlayerCallback{
-				Call: func(p *Player, args interface{}) {
-					p.SendNearChunk(nil)
-				},
-			})
-		default:
-			log.Println("?")
+		text, err := r.ReadString('\n')
+		if err != nil && text == "" { // No console, e.g. running as a daemon: keep running
+			select {}
 		}
+		ExecuteCommand(ConsoleSender, text)
 	}
 }
+
+// RunCommand executes given command line with console permissions, and returns the output.
+// This is used for remote consoles like RCON.
+func RunCommand(line string) string {
+	buf := new(bytes.Buffer)
+	ExecuteCommand(writerSender{name: "RCON", out: buf}, line)
+	return buf.String()
+}
@@ -0,0 +1,87 @@
+package lav7
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+// testSender is a CommandSender which records messages.
+type testSender struct {
+	messages []string
+	perms    map[string]bool
+}
+
+func (s *testSender) Name() string                   { return "tester" }
+func (s *testSender) SendMessage(msg string)         { s.messages = append(s.messages, msg) }
+func (s *testSender) HasPermission(node string) bool { return s.perms[node] }
+
+func TestRunCommand(t *testing.T) {
+	if out := RunCommand("netbytes"); !strings.HasSuffix(out, "KBs\n") {
+		t.Errorf("netbytes output mismatch: %q", out)
+	}
+	if out := RunCommand("nonexistent"); !strings.HasPrefix(out, "Unknown command") {
+		t.Errorf("Unknown command output mismatch: %q", out)
+	}
+}
+
+func TestCommandDispatch(t *testing.T) {
+	var got []string
+	cmd := &Command{
+		Name:       "testcmd",
+		Aliases:    []string{"tc"},
+		Usage:      "<n> <x> <y> <z>",
+		Permission: "lav7.command.testcmd",
+		Execute: func(sender CommandSender, args *CommandArgs) error {
+			n, err := args.Int()
+			if err != nil {
+				return err
+			}
+			pos, err := args.Position(vector.Vector3{X: 10, Y: 20, Z: 30})
+			if err != nil {
+				return err
+			}
+			got = append(got, strings.Repeat("*", n))
+			sender.SendMessage(fmt.Sprint(pos.X, ",", pos.Y, ",", pos.Z))
+			return nil
+		},
+	}
+	if err := RegisterCommand(cmd); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterCommand("testcmd")
+	if err := RegisterCommand(&Command{Name: "other", Aliases: []string{"TC"}}); err == nil {
+		t.Error("Duplicated alias should not be registered")
+	}
+
+	s := &testSender{}
+	ExecuteCommand(s, "/testcmd 1 0 0 0")
+	if len(got) != 0 || len(s.messages) != 1 || !strings.Contains(s.messages[0], "permission") {
+		t.Error("Command without permission should be denied:", s.messages)
+	}
+
+	s = &testSender{perms: map[string]bool{"lav7.command.testcmd": true}}
+	ExecuteCommand(s, "TC 2 ~ ~-5 1.5")
+	if len(got) != 1 || got[0] != "**" || s.messages[0] != "10,15,1.5" {
+		t.Error("Command result mismatch:", got, s.messages)
+	}
+
+	s.messages = nil
+	ExecuteCommand(s, "tc x 1 2 3")
+	if len(s.messages) != 2 || s.messages[0] != "Invalid number: x" || s.messages[1] != "Usage: /testcmd <n> <x> <y> <z>" {
+		t.Error("Usage error mismatch:", s.messages)
+	}
+
+	s.messages = nil
+	ExecuteCommand(s, "tc 1 2 3")
+	if len(s.messages) != 1 || !strings.HasPrefix(s.messages[0], "Usage:") {
+		t.Error("Missing argument should print usage:", s.messages)
+	}
+
+	UnregisterCommand("tc")
+	if GetCommand("testcmd") != nil || GetCommand("tc") != nil {
+		t.Error("Command should be unregistered with aliases")
+	}
+}
@@ -9,7 +9,9 @@ package config
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -17,17 +19,51 @@ import (
 // DefaultConfig is a default config string.
 var DefaultConfig = `# Default lav7 properties
 server-port=19132
+# Comma-separated listen addresses, e.g. 0.0.0.0:19132,[::]:19133. If empty, 0.0.0.0:server-port is used.
+server-addresses=
 server-name=lav7 - lightweight MCPE server
 max-players=20
 generator-name=flat
 generator-args=
 level-format=vilan
 chunk-radius=6
+# Random block ticks per 16*16*16 chunk section per tick, for crops, grass and leaves. 0 disables random ticks.
+random-tick-speed=3
+# Per-IP rate limits per second. 0 disables the limit.
+rate-limit-packets=500
+rate-limit-bytes=1048576
+rate-limit-handshakes=10
+# Maximum count of connecting sessions
+max-half-open=32
+# Temporary ban duration for rate limit offenders, doubled on each offense
+ban-seconds=10
+# Datagram size limits for MTU negotiation, excluding IP/UDP headers
+mtu-max=1464
+mtu-min=400
+# Only allow players on whitelist.json and operators to join
+white-list=false
+# Answer GameSpy4 query requests on the game port
+enable-query=true
+# Source RCON remote console on TCP. rcon-password should be set to enable it.
+enable-rcon=false
+rcon-port=25575
+rcon-password=
+# Directory of Lua scripts, reloaded on changes. If empty, scripts are disabled.
+scripts-dir=scripts
+# Directory to write per-session packet captures(pcapng) on. If empty, sessions are not captured.
+capture-dir=
 `
 
+// File is a path of the properties file. Properties changed at runtime, e.g. with whitelist command, are saved on it.
+// If empty, changed properties are not saved.
+var File = "lav7.properties"
+
 // Port is a port number of the server.
 var Port uint16
 
+// Addresses is a list of addresses for the server to listen on.
+var Addresses []string
+
 // ServerName is a server name displayed on lists.
 var ServerName string
 
@@ -46,6 +82,42 @@ var Format string
 // ChunkRadius is a default chunk send radius for client.
 var ChunkRadius int32
 
+// PacketRate, ByteRate and HandshakeRate are per-IP rate limits per second.
+var PacketRate, ByteRate, HandshakeRate int
+
+// MaxHalfOpen is a maximum count of sessions which are not connected yet.
+var MaxHalfOpen int32
+
+// BanSeconds is a temporary ban duration for rate limit offenders.
+var BanSeconds int
+
+// MaxMTU and MinMTU are datagram size limits for MTU negotiation.
+var MaxMTU, MinMTU uint16
+
+// Whitelist only allows whitelisted players and operators to join.
+var Whitelist bool
+
+// EnableQuery enables GameSpy4 query protocol on the game port.
+var EnableQuery bool
+
+// EnableRcon enables RCON remote console on RconPort, authenticated with RconPassword.
+var EnableRcon bool
+
+// RconPort is a TCP port number of RCON server.
+var RconPort uint16
+
+// RconPassword is a password for RCON clients.
+var RconPassword string
+
+// RandomTickSpeed is a count of random block ticks per chunk section per tick.
+var RandomTickSpeed int
+
+// ScriptsDir is a directory of server scripts.
+var ScriptsDir string
+
+// CaptureDir is a directory to write per-session packet captures on.
+var CaptureDir string
+
 // Parse parses the config with given reader interface.
 func Parse(rd io.Reader) {
 	scanner := bufio.NewScanner(rd)
@@ -73,6 +145,16 @@ func Parse(rd io.Reader) {
 	}
 	Port = uint16(port)
 
+	Addresses = nil
+	for _, addr := range strings.Split(getString(cfg, "server-addresses", ""), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			Addresses = append(Addresses, addr)
+		}
+	}
+	if len(Addresses) == 0 {
+		Addresses = []string{"0.0.0.0:" + strconv.Itoa(port)}
+	}
+
 	ServerName = getString(cfg, "server-name", "lav7 - lightweight MCPE server")
 	m, err := strconv.Atoi(getString(cfg, "max-players", "20"))
 	if err != nil {
@@ -89,6 +171,57 @@ func Parse(rd io.Reader) {
 		log.Fatalln("Invalid chunk radius")
 	}
 	ChunkRadius = int32(chunkRadius)
+
+	PacketRate = getInt(cfg, "rate-limit-packets", 500)
+	ByteRate = getInt(cfg, "rate-limit-bytes", 1024*1024)
+	HandshakeRate = getInt(cfg, "rate-limit-handshakes", 10)
+	MaxHalfOpen = int32(getInt(cfg, "max-half-open", 32))
+	BanSeconds = getInt(cfg, "ban-seconds", 10)
+
+	maxMTU, minMTU := getInt(cfg, "mtu-max", 1464), getInt(cfg, "mtu-min", 400)
+	if maxMTU > 65535 || minMTU > maxMTU {
+		log.Fatalln("Invalid MTU limits")
+	}
+	MaxMTU, MinMTU = uint16(maxMTU), uint16(minMTU)
+
+	Whitelist = getString(cfg, "white-list", "false") == "true"
+	EnableQuery = getString(cfg, "enable-query", "true") == "true"
+	EnableRcon = getString(cfg, "enable-rcon", "false") == "true"
+	rconPort := getInt(cfg, "rcon-port", 25575)
+	if rconPort > 65535 {
+		log.Fatalln("Invalid RCON port")
+	}
+	RconPort = uint16(rconPort)
+	RconPassword = getString(cfg, "rcon-password", "")
+	CaptureDir = getString(cfg, "capture-dir", "")
+	ScriptsDir = getString(cfg, "scripts-dir", "scripts")
+	RandomTickSpeed = getInt(cfg, "random-tick-speed", 3)
+}
+
+// SetProperty saves the property on File, keeping other lines. If the key does not exist, it is appended.
+func SetProperty(key, value string) error {
+	if File == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	found := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, key+"=") {
+			lines[i] = key + "=" + value
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, key+"="+value)
+	}
+	return ioutil.WriteFile(File, []byte(strings.Join(lines, "\n")+"\n"), 0644)
 }
 
 func getString(m map[string]string, key string, def string) string {
@@ -100,3 +233,11 @@ func getString(m map[string]string, key string, def string) string {
 	}
 	return val
 }
+
+func getInt(m map[string]string, key string, def int) int {
+	val, err := strconv.Atoi(getString(m, key, strconv.Itoa(def)))
+	if err != nil || val < 0 {
+		log.Fatalln("Invalid config value:", key)
+	}
+	return val
+}
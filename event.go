@@ -0,0 +1,210 @@
+package lav7
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+// EventPriority decides the order of event handlers. Handlers with lower priority run first,
+// so handlers with higher priority have the final say on the event.
+type EventPriority int
+
+// Event priorities
+const (
+	PriorityLowest EventPriority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+	PriorityHighest
+	PriorityMonitor // Only for observing results. Handlers should not modify events.
+)
+
+// Cancellable is embedded in events which can be cancelled.
+type Cancellable struct {
+	cancelled bool
+}
+
+// Cancelled returns if the event is cancelled.
+func (c *Cancellable) Cancelled() bool { return c.cancelled }
+
+// SetCancelled cancels or uncancels the event.
+func (c *Cancellable) SetCancelled(cancel bool) { c.cancelled = cancel }
+
+type cancellable interface {
+	Cancelled() bool
+}
+
+// PlayerJoinEvent is fired when the player logs in, before spawning.
+// Cancelling the event disconnects the player with KickMessage.
+type PlayerJoinEvent struct {
+	Cancellable
+	Player      *Player
+	Message     string // Broadcasted on spawn. If empty, nothing is broadcasted.
+	KickMessage string
+}
+
+// PlayerChatEvent is fired when the player sends a chat message.
+type PlayerChatEvent struct {
+	Cancellable
+	Player  *Player
+	Message string
+	Format  string // Format for the broadcast, with username and message, e.g. "<%s> %s"
+}
+
+// BlockPlaceEvent is fired when the player places a block. Handlers may change the block,
+// but the position is read-only: changes to X, Y and Z are ignored.
+type BlockPlaceEvent struct {
+	Cancellable
+	Player  *Player
+	Level   *Level
+	X, Y, Z int32
+	Block   types.Block // Block to be placed
+}
+
+// BlockBreakEvent is fired when the player breaks a block.
+type BlockBreakEvent struct {
+	Cancellable
+	Player  *Player
+	Level   *Level
+	X, Y, Z int32
+	Block   types.Block // Block being broken
+}
+
+// BlockInteractEvent is fired when the player uses an item on a block which reacts to it, e.g. opening a chest
+// or toggling a trapdoor. Cancelling the event prevents the interaction, and the item is not placed.
+type BlockInteractEvent struct {
+	Cancellable
+	Player  *Player
+	Level   *Level
+	X, Y, Z int32
+	Block   types.Block // Clicked block
+	Item    types.Item  // Item in hand
+}
+
+// SignChangeEvent is fired when the player edits text of a sign.
+type SignChangeEvent struct {
+	Cancellable
+	Player  *Player
+	Level   *Level
+	X, Y, Z int32
+	Lines   [4]string // New text of the sign
+}
+
+// ContainerChangeEvent is fired when the player changes a slot of an opened container, e.g. a chest.
+// Cancelling the event reverts the slot on the client.
+type ContainerChangeEvent struct {
+	Cancellable
+	Player  *Player
+	Level   *Level
+	X, Y, Z int32
+	Slot    int
+	Item    types.Item // New item of the slot
+}
+
+// PlayerMoveEvent is fired when the player moves. Cancelling the event moves the player back,
+// and changing To or rotation moves the player to the position and rotation.
+type PlayerMoveEvent struct {
+	Cancellable
+	Player              *Player
+	From, To            vector.Vector3
+	Yaw, BodyYaw, Pitch float32
+}
+
+// PlayerQuitEvent is fired when the player disconnects.
+type PlayerQuitEvent struct {
+	Player  *Player
+	Message string // Broadcasted to other players. If empty, nothing is broadcasted.
+}
+
+// Subscription is a registered event handler.
+type Subscription struct {
+	typ      reflect.Type
+	priority EventPriority
+	handler  reflect.Value
+}
+
+var eventHandlers = make(map[reflect.Type][]*Subscription)
+var eventLock = new(sync.RWMutex)
+
+// Subscribe registers event handler with given priority. The handler should be a function with
+// a single event pointer argument, e.g. func(ev *lav7.BlockPlaceEvent).
+//
+// Handlers run on the goroutine which fired the event, usually the player goroutine of the event.
+func Subscribe(handler interface{}, priority EventPriority) (*Subscription, error) {
+	fn := reflect.ValueOf(handler)
+	typ := fn.Type()
+	if typ.Kind() != reflect.Func || typ.NumIn() != 1 || typ.NumOut() != 0 ||
+		typ.In(0).Kind() != reflect.Ptr || typ.In(0).Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("invalid event handler type: %v", typ)
+	}
+	s := &Subscription{
+		typ:      typ.In(0),
+		priority: priority,
+		handler:  fn,
+	}
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	// Copy on write, so FireEvent can iterate without lock
+	list := append([]*Subscription(nil), eventHandlers[s.typ]...)
+	list = append(list, s)
+	sort.Stable(byPriority(list))
+	eventHandlers[s.typ] = list
+	return s, nil
+}
+
+// Unsubscribe removes the handler.
+func (s *Subscription) Unsubscribe() {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	var list []*Subscription
+	for _, h := range eventHandlers[s.typ] {
+		if h != s {
+			list = append(list, h)
+		}
+	}
+	if len(list) == 0 {
+		delete(eventHandlers, s.typ)
+	} else {
+		eventHandlers[s.typ] = list
+	}
+}
+
+type byPriority []*Subscription
+
+func (b byPriority) Len() int           { return len(b) }
+func (b byPriority) Less(i, j int) bool { return b[i].priority < b[j].priority }
+func (b byPriority) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// FireEvent calls every handlers of the event, in order of priority.
+// It returns false if the event is cancelled. Panics in handlers are logged, and do not stop other handlers.
+func FireEvent(ev interface{}) bool {
+	eventLock.RLock()
+	list := eventHandlers[reflect.TypeOf(ev)]
+	eventLock.RUnlock()
+	if len(list) > 0 {
+		arg := []reflect.Value{reflect.ValueOf(ev)}
+		for _, s := range list {
+			callHandler(s, arg)
+		}
+	}
+	if c, ok := ev.(cancellable); ok {
+		return !c.Cancelled()
+	}
+	return true
+}
+
+func callHandler(s *Subscription, arg []reflect.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while handling %v: %v\n%s", s.typ.Elem(), r, debug.Stack())
+		}
+	}()
+	s.handler.Call(arg)
+}
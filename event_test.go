@@ -0,0 +1,174 @@
+package lav7
+
+import (
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+func TestFireEvent(t *testing.T) {
+	if _, err := Subscribe(func(ev PlayerChatEvent) {}, PriorityNormal); err == nil {
+		t.Error("Handler with non-pointer argument should be rejected")
+	}
+	if _, err := Subscribe("not a function", PriorityNormal); err == nil {
+		t.Error("Non-function handler should be rejected")
+	}
+
+	var order []string
+	subscribe := func(handler interface{}, priority EventPriority) *Subscription {
+		s, err := Subscribe(handler, priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	monitor := subscribe(func(ev *PlayerChatEvent) {
+		order = append(order, "monitor")
+		if !ev.Cancelled() || ev.Message != "HELLO" {
+			t.Error("Monitor should see the final event:", ev.Message, ev.Cancelled())
+		}
+	}, PriorityMonitor)
+	defer monitor.Unsubscribe()
+	high := subscribe(func(ev *PlayerChatEvent) {
+		order = append(order, "high")
+		ev.SetCancelled(true)
+	}, PriorityHigh)
+	defer high.Unsubscribe()
+	low := subscribe(func(ev *PlayerChatEvent) {
+		order = append(order, "low")
+		ev.Message = "HELLO"
+		panic("handler panic should be recovered")
+	}, PriorityLow)
+	defer low.Unsubscribe()
+
+	if FireEvent(&PlayerChatEvent{Message: "hello"}) {
+		t.Error("Cancelled event should return false")
+	}
+	if len(order) != 3 || order[0] != "low" || order[1] != "high" || order[2] != "monitor" {
+		t.Error("Handlers should run in order of priority:", order)
+	}
+
+	high.Unsubscribe()
+	monitor.Unsubscribe()
+	order = nil
+	if !FireEvent(&PlayerChatEvent{Message: "hello"}) || len(order) != 1 {
+		t.Error("Unsubscribed handlers should not run:", order)
+	}
+	if !FireEvent(&PlayerQuitEvent{}) {
+		t.Error("Events without handlers should not be cancelled")
+	}
+}
+
+func TestBlockPlaceEvent(t *testing.T) {
+	s, err := Subscribe(func(ev *BlockPlaceEvent) {
+		switch ev.X {
+		case 2:
+			ev.Block = types.Block{ID: byte(types.Cobblestone)}
+		case 3:
+			ev.SetCancelled(true)
+		case 4:
+			ev.Y = 200
+		}
+	}, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Unsubscribe()
+
+	network := raknet.NewMemoryNetwork(2)
+	r := startTestServer(t, network)
+	defer r.Close()
+	alice, updates := spawnTestClient(t, network, "alice")
+	defer alice.Close()
+
+	place := func(x, z uint32) {
+		alice.SendPacket(&proto.UseItem{
+			X: x, Y: 5, Z: z,
+			Face: vector.SideUp,
+			Item: &types.Item{ID: types.Stone, Amount: 1},
+		})
+	}
+	place(2, 2)
+	if !waitUpdateBlock(updates, 2, 6, 2, byte(types.Cobblestone)) {
+		t.Error("Block modified by event handler should be placed")
+	}
+	place(3, 3)
+	if !waitUpdateBlock(updates, 3, 6, 3, 0) {
+		t.Error("Cancelled block place should be reverted")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if b := GetDefaultLevel().GetBlock(3, 6, 3); b != 0 {
+		t.Error("Cancelled block should not be set on level: got", b)
+	}
+	place(4, 4)
+	if !waitUpdateBlock(updates, 4, 6, 4, byte(types.Stone)) {
+		t.Error("Changed position of the event should be ignored")
+	}
+}
+
+func TestBlockInteractEvent(t *testing.T) {
+	s, err := Subscribe(func(ev *BlockInteractEvent) {
+		if ev.X == 3 {
+			ev.SetCancelled(true)
+		}
+	}, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Unsubscribe()
+
+	network := raknet.NewMemoryNetwork(2)
+	r := startTestServer(t, network)
+	defer r.Close()
+	alice, updates := spawnTestClient(t, network, "alice")
+	defer alice.Close()
+
+	lv := GetDefaultLevel()
+	lv.Set(2, 6, 2, types.Block{ID: byte(types.Trapdoor)})
+	lv.Set(3, 6, 3, types.Block{ID: byte(types.Trapdoor)})
+	use := func(x, z uint32) {
+		alice.SendPacket(&proto.UseItem{
+			X: x, Y: 6, Z: z,
+			Face: vector.SideUp,
+			Item: &types.Item{ID: types.Stone, Amount: 1},
+		})
+	}
+	use(2, 2)
+	use(3, 3) // Handled after the first one, so the trapdoor is toggled when this is reverted.
+	if !waitUpdateBlock(updates, 3, 6, 3, byte(types.Trapdoor)) {
+		t.Fatal("Cancelled interaction should be reverted")
+	}
+	if b := lv.Get(2, 6, 2); b.Meta != 8 {
+		t.Error("Trapdoor should be toggled:", b)
+	}
+	if b := lv.Get(3, 6, 3); b.Meta != 0 {
+		t.Error("Cancelled interaction should not toggle trapdoor:", b)
+	}
+	if b := lv.GetBlock(3, 7, 3); b != 0 {
+		t.Error("Item should not be placed on cancelled interaction:", b)
+	}
+}
+
+func TestPlayerMoveEvent(t *testing.T) {
+	s, err := Subscribe(func(ev *PlayerMoveEvent) {
+		ev.Yaw, ev.Pitch = 90, 30
+	}, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Unsubscribe()
+
+	p, sent := windowTestPlayer(nil)
+	p.server = NewServer()
+	p.updateMove(&proto.MovePlayer{X: 1, Y: 2, Z: 3, Yaw: 10, BodyYaw: 10, Pitch: 0})
+	if p.Yaw != 90 || p.BodyYaw != 10 || p.Pitch != 30 || p.Position != (vector.Vector3{X: 1, Y: 2, Z: 3}) {
+		t.Error("Changed rotation is not applied:", p.Position, p.Yaw, p.BodyYaw, p.Pitch)
+	}
+	if pids := sentPids(sent); len(pids) != 1 || pids[0] != proto.MovePlayerHead {
+		t.Errorf("Changed rotation is not sent to the player: %x", pids)
+	}
+}
@@ -3,6 +3,7 @@ package format
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -56,11 +57,14 @@ func (dm *Dummy) LoadChunk(cx, cz int32, path string) (chunk *types.Chunk, err e
 	if err != nil {
 		return
 	}
-	b := make([]byte, 83200)
-	_, err = f.Read(b)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
 	if err != nil {
 		return
 	}
+	if len(b) < 83200 {
+		return nil, io.ErrUnexpectedEOF
+	}
 	chunk = new(types.Chunk)
 	buf := bytes.NewBuffer(b)
 	chunk.Mutex().Lock()
@@ -70,6 +74,7 @@ func (dm *Dummy) LoadChunk(cx, cz int32, path string) (chunk *types.Chunk, err e
 	copy(chunk.SkyLightData[:], buf.Next(16*16*64))
 	copy(chunk.HeightMap[:], buf.Next(16*16))
 	copy(chunk.BiomeData[:], buf.Next(16*16*4))
+	err = chunk.ReadTileData(buf.Bytes()) // Tile entities follow chunk data
 	chunk.Mutex().Unlock()
 	return
 
@@ -84,7 +89,7 @@ func (dm *Dummy) WriteChunk(cx, cz int32, c *types.Chunk) error {
 	buf := new(bytes.Buffer)
 	c.Mutex().Lock()
 	defer c.Mutex().Unlock()
-	buffer.BatchWrite(buf, c.BlockData[:], c.MetaData[:], c.LightData[:], c.SkyLightData[:], c.HeightMap[:], c.BiomeData[:])
+	buffer.BatchWrite(buf, c.BlockData[:], c.MetaData[:], c.LightData[:], c.SkyLightData[:], c.HeightMap[:], c.BiomeData[:], c.TileData())
 	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
 		return err
 	}
@@ -0,0 +1,57 @@
+package format
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/nbt"
+)
+
+func TestTilePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lav7-format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"dummy", "vilan"} {
+		pv := GetProvider(name)
+		pv.Init("test")
+		c := new(types.Chunk)
+		c.SetBlock(1, 2, 3, byte(types.SignPost))
+		c.SetTile(1, 2, 3, nbt.Compound{"id": "Sign", "x": int32(-15), "y": int32(2), "z": int32(19), "Text1": "lav7"})
+		if err := pv.WriteChunk(-1, 1, c); err != nil {
+			t.Errorf("%s: error while writing chunk: %v", name, err)
+			continue
+		}
+		path, ok := pv.Loadable(-1, 1)
+		if !ok {
+			t.Errorf("%s: written chunk is not loadable", name)
+			continue
+		}
+		loaded, err := pv.LoadChunk(-1, 1, path)
+		if err != nil {
+			t.Errorf("%s: error while loading chunk: %v", name, err)
+			continue
+		}
+		if loaded.GetBlock(1, 2, 3) != byte(types.SignPost) {
+			t.Errorf("%s: block data mismatch", name)
+		}
+		if tile := loaded.GetTile(1, 2, 3); tile.String("Text1") != "lav7" {
+			t.Errorf("%s: tile entity is not loaded: %v", name, tile)
+		}
+
+		c.SetTile(1, 2, 3, nil)
+		pv.WriteChunk(-1, 1, c)
+		if loaded, err := pv.LoadChunk(-1, 1, path); err != nil || len(loaded.TileEntities) != 0 {
+			t.Errorf("%s: removed tile entity is loaded: %v %v", name, loaded.TileEntities, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package format
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -79,8 +80,20 @@ func (v *Vilan) LoadChunk(cx, cz int32, path string) (chunk *types.Chunk, err er
 	copy(chunk.SkyLightData[:], buf.Next(16*16*64))
 	copy(chunk.HeightMap[:], buf.Next(16*16))
 	copy(chunk.BiomeData[:], buf.Next(16*16*4))
-	chunk.Mutex().Unlock()
-	return
+	defer chunk.Mutex().Unlock()
+	tiles, err := ioutil.ReadFile(v.tilePath(cx, cz))
+	if os.IsNotExist(err) {
+		return chunk, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return chunk, chunk.ReadTileData(tiles)
+}
+
+// tilePath returns path to the file containing tile entities of the chunk.
+// Sections have fixed size for each chunk, so tile entities are saved on separate files.
+func (v *Vilan) tilePath(cx, cz int32) string {
+	return fmt.Sprintf("levels/%s/tiles.%d.%d.nbt", v.name, cx, cz)
 }
 
 // WriteChunk implements format.Provider interface.
@@ -124,8 +137,16 @@ func (v *Vilan) WriteChunk(cx, cz int32, chunk *types.Chunk) error {
 	buffer.BatchWrite(buf, chunk.BlockData[:], chunk.MetaData[:], chunk.LightData[:], chunk.SkyLightData[:], chunk.HeightMap[:], chunk.BiomeData[:])
 
 	pos := 2 + int64(byte(cx&3)<<2|byte(cz&3))*83200
-	_, err = file.WriteAt(buf.Bytes(), pos)
-	return err
+	if _, err = file.WriteAt(buf.Bytes(), pos); err != nil {
+		return err
+	}
+	if tiles := chunk.TileData(); len(tiles) > 0 {
+		return ioutil.WriteFile(v.tilePath(cx, cz), tiles, 0644)
+	}
+	if err := os.Remove(v.tilePath(cx, cz)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 // SaveAll implements format.Provider interface.
@@ -0,0 +1,165 @@
+package lav7
+
+import (
+	"math/rand"
+
+	"github.com/L7-MCPE/lav7/types"
+)
+
+func init() {
+	RegisterRandomTicker(types.Grass, grassBlock{})
+	for _, id := range []types.ID{types.WheatBlock, types.CarrotBlock, types.PotatoBlock, types.BeetrootBlock} {
+		RegisterRandomTicker(id, crop{maxStage: 7})
+	}
+	RegisterRandomTicker(types.Sapling, sapling{})
+	RegisterRandomTicker(types.Leaves, leaves{})
+	RegisterRandomTicker(types.Leaves2, leaves{})
+}
+
+// Metadata flags of leaves
+const (
+	leavesNoDecay    = 0x4 // Set on leaves placed by players
+	leavesDecayRange = 4   // Leaves farther than this from logs decay
+)
+
+// grassBlock spreads onto nearby lit dirt, and dies into dirt under opaque blocks.
+type grassBlock struct{}
+
+func (grassBlock) RandomTick(lv *Level, x, y, z int32, r *rand.Rand) {
+	if above, ok := lv.LoadedBlock(x, y+1, z); ok && lightOpacity[above.ID] > 2 {
+		lv.ChangeBlock(x, y, z, types.Block{ID: byte(types.Dirt)})
+		return
+	}
+	if lv.brightness(x, y+1, z) < 9 {
+		return
+	}
+	for i := 0; i < 4; i++ {
+		tx, ty, tz := x+r.Int31n(3)-1, y+r.Int31n(5)-3, z+r.Int31n(3)-1
+		if b, ok := lv.LoadedBlock(tx, ty, tz); !ok || b.ID != byte(types.Dirt) || b.Meta != 0 {
+			continue
+		}
+		if above, ok := lv.LoadedBlock(tx, ty+1, tz); !ok || lightOpacity[above.ID] > 2 || lv.brightness(tx, ty+1, tz) < 4 {
+			continue
+		}
+		lv.ChangeBlock(tx, ty, tz, types.Block{ID: byte(types.Grass)})
+	}
+}
+
+// crop grows through metadata stages on farmland, with enough light.
+type crop struct {
+	maxStage byte
+}
+
+func (c crop) RandomTick(lv *Level, x, y, z int32, r *rand.Rand) {
+	b, _ := lv.LoadedBlock(x, y, z)
+	if b.Meta >= c.maxStage || lv.brightness(x, y+1, z) < 9 {
+		return
+	}
+	if below, ok := lv.LoadedBlock(x, y-1, z); !ok || below.ID != byte(types.Farmland) {
+		return
+	}
+	if r.Intn(3) == 0 {
+		b.Meta++
+		lv.ChangeBlock(x, y, z, b)
+	}
+}
+
+// sapling grows in two stages: the first sets bit 8 of metadata, and the second grows a tree.
+// Lower 3 bits of metadata are the wood type.
+type sapling struct{}
+
+func (sapling) RandomTick(lv *Level, x, y, z int32, r *rand.Rand) {
+	if lv.brightness(x, y+1, z) < 9 || r.Intn(7) != 0 {
+		return
+	}
+	b, _ := lv.LoadedBlock(x, y, z)
+	if b.Meta&8 == 0 {
+		b.Meta |= 8
+		lv.ChangeBlock(x, y, z, b)
+		return
+	}
+	growTree(lv, x, y, z, b.Meta&7, r)
+}
+
+// growTree grows a simple tree of given wood type on the position. It returns false if there is no space.
+func growTree(lv *Level, x, y, z int32, wood byte, r *rand.Rand) bool {
+	trunk, leaf := types.Block{ID: byte(types.Log), Meta: wood}, types.Block{ID: byte(types.Leaves), Meta: wood}
+	if wood >= 4 {
+		trunk, leaf = types.Block{ID: byte(types.Wood2), Meta: wood - 4}, types.Block{ID: byte(types.Leaves2), Meta: wood - 4}
+	}
+	height := 4 + r.Int31n(3)
+	if y+height+1 > 127 {
+		return false
+	}
+	for dy := int32(1); dy <= height; dy++ {
+		if b, ok := lv.LoadedBlock(x, y+dy, z); !ok || !GetBlockType(b.ID).Replaceable {
+			return false
+		}
+	}
+	for ly := y + height - 3; ly <= y+height; ly++ {
+		radius := int32(2)
+		if ly >= y+height-1 {
+			radius = 1
+		}
+		for dx := -radius; dx <= radius; dx++ {
+			for dz := -radius; dz <= radius; dz++ {
+				corner := (dx == radius || dx == -radius) && (dz == radius || dz == -radius)
+				if corner && (ly == y+height || r.Intn(2) == 0) {
+					continue
+				}
+				if b, ok := lv.LoadedBlock(x+dx, ly, z+dz); ok && GetBlockType(b.ID).Replaceable {
+					lv.ChangeBlock(x+dx, ly, z+dz, leaf)
+				}
+			}
+		}
+	}
+	for dy := int32(0); dy < height; dy++ {
+		lv.ChangeBlock(x, y+dy, z, trunk)
+	}
+	return true
+}
+
+// leaves decay when they are not connected to logs through leaves, unless placed by players.
+type leaves struct{}
+
+func (leaves) RandomTick(lv *Level, x, y, z int32, r *rand.Rand) {
+	b, _ := lv.LoadedBlock(x, y, z)
+	if b.Meta&leavesNoDecay != 0 || nearLog(lv, x, y, z) {
+		return
+	}
+	lv.ChangeBlock(x, y, z, types.Block{})
+}
+
+// nearLog searches logs connected to the leaves through leaves, within leavesDecayRange.
+// Unloaded chunks are treated as logs, so leaves on chunk borders do not decay.
+func nearLog(lv *Level, x, y, z int32) bool {
+	type node struct {
+		pos  [3]int32
+		dist int
+	}
+	visited := map[[3]int32]bool{{x, y, z}: true}
+	queue := []node{{pos: [3]int32{x, y, z}}}
+	for i := 0; i < len(queue); i++ {
+		n := queue[i]
+		for _, d := range lightSides {
+			pos := [3]int32{n.pos[0] + d[0], n.pos[1] + d[1], n.pos[2] + d[2]}
+			if visited[pos] {
+				continue
+			}
+			visited[pos] = true
+			b, ok := lv.LoadedBlock(pos[0], pos[1], pos[2])
+			if !ok && pos[1] >= 0 && pos[1] <= 127 {
+				return true
+			}
+			switch types.ID(b.ID) {
+			case types.Log, types.Wood2:
+				return true
+			case types.Leaves, types.Leaves2:
+				if n.dist+1 < leavesDecayRange {
+					queue = append(queue, node{pos: pos, dist: n.dist + 1})
+				}
+			}
+		}
+	}
+	return false
+}
@@ -15,6 +15,7 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -24,14 +25,16 @@ import (
 	"github.com/L7-MCPE/lav7/format"
 	"github.com/L7-MCPE/lav7/gen"
 	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/rcon"
+	"github.com/L7-MCPE/lav7/script"
 	"github.com/L7-MCPE/lav7/util"
 )
 
 func main() {
 
-	cfg, err := os.Open("lav7.properties")
+	cfg, err := os.Open(config.File)
 	if os.IsNotExist(err) {
-		err := ioutil.WriteFile("lav7.properties", []byte(config.DefaultConfig), 0644)
+		err := ioutil.WriteFile(config.File, []byte(config.DefaultConfig), 0644)
 		if err != nil {
 			panic(err)
 		}
@@ -68,10 +71,24 @@ func main() {
 	} else {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
+	if err := lav7.LoadPermissions(); err != nil {
+		log.Fatalln("Error while loading permissions:", err)
+	}
+	if err := lav7.LoadBanLists(); err != nil {
+		log.Fatalln("Error while loading ban lists:", err)
+	}
+	lav7.RandomTickSpeed = config.RandomTickSpeed
 	initLevel(config.Generator, config.GeneratorArgs, config.Format)
 	initRaknet()
 	startLevel()
-	startRouter(config.Port)
+	lav7.EnablePlugins()
+	if config.ScriptsDir != "" {
+		startScripts(config.ScriptsDir)
+	}
+	startRouter(config.Addresses)
+	if config.EnableRcon {
+		startRcon(config.RconPort, config.RconPassword)
+	}
 
 	log.Println("All done! Elapsed time:", time.Since(start).Seconds(), "seconds")
 	log.Println("Server is ready. Type 'stop' to stop server.")
@@ -129,18 +146,59 @@ func initLevel(genname string, arg string, lvformat string) {
 func initRaknet() {
 	raknet.ServerName = config.ServerName
 	atomic.StoreInt32(&raknet.MaxPlayers, config.MaxPlayers)
+	raknet.PacketRate = config.PacketRate
+	raknet.ByteRate = config.ByteRate
+	raknet.HandshakeRate = config.HandshakeRate
+	raknet.MaxHalfOpen = config.MaxHalfOpen
+	raknet.BanDuration = time.Duration(config.BanSeconds) * time.Second
+	raknet.MaxMTU, raknet.MinMTU = config.MaxMTU, config.MinMTU
+	raknet.AddressFilter = lav7.AllowAddress
+	if config.EnableQuery {
+		raknet.QueryHandler = lav7.QueryInfo
+	}
+	if config.CaptureDir != "" {
+		if err := os.MkdirAll(config.CaptureDir, 0755); err != nil {
+			log.Fatalln("Error while creating capture directory:", err)
+		}
+		raknet.CaptureDir = config.CaptureDir
+	}
 }
 
 func startLevel() {
 	go lav7.GetDefaultLevel().Process()
 }
 
-func startRouter(port uint16) {
+func startRouter(addresses []string) {
 	log.Println("Starting raknet router, version", raknet.Version)
 	var r *raknet.Router
 	var err error
-	if r, err = raknet.CreateRouter(lav7.RegisterPlayer, lav7.UnregisterPlayer, port); err != nil {
+	if r, err = raknet.CreateRouter(lav7.RegisterPlayer, lav7.UnregisterPlayer, addresses...); err != nil {
 		log.Fatalln("Error while creating router:", err)
 	}
+	lav7.DefaultServer.Router = r
+	for _, addr := range r.Addresses() {
+		log.Println("Listening on", addr)
+	}
 	r.Start()
 }
+
+func startScripts(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalln("Error while creating scripts directory:", err)
+	}
+	m := script.NewManager(dir)
+	if err := m.Register(); err != nil {
+		log.Fatalln("Error while registering scripts command:", err)
+	}
+	m.Poll()
+	go m.Watch(time.Second)
+}
+
+func startRcon(port uint16, password string) {
+	s, err := rcon.Listen(":"+strconv.Itoa(int(port)), password, lav7.RunCommand)
+	if err != nil {
+		log.Fatalln("Error while starting RCON server:", err)
+	}
+	log.Println("RCON server is listening on", s.Addr())
+	go s.Serve()
+}
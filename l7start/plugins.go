@@ -0,0 +1,6 @@
+package main
+
+// Plugins are built in by importing their packages here. Each plugin package registers itself
+// with lav7.RegisterPlugin on init, e.g.
+//
+//	import _ "github.com/example/lav7-plugin"
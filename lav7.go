@@ -1,8 +1,6 @@
 // Package lav7 is not only a lightweight Minecraft:PE server, but provides Minecraft:PE protocol/gameplay mechanics.
 package lav7
 
-import "sync"
-
 const (
 	// Version is a version of this server.
 	Version = "1.1.0 alpha-dev"
@@ -15,11 +13,6 @@ var GitCommit = "unknown"
 // BuildTime is a timestamp when the program is built.
 var BuildTime = "unknown"
 
-// Players is a map containing Player structs.
-var Players = make(map[string]*Player)
-
-var iteratorLock = new(sync.Mutex)
-
 var lastEntityID uint64
 
 var levels = map[string]*Level{
@@ -10,6 +10,8 @@
 #      - If you are compiling it for linux-arm/android-arm, each ARMv5, ARMv6, ARMv7 binary will be created.
 #   lav7-build-all: Build for all possible platforms to ~/builds
 #   lav7-build-publish: Execute lav7-build-all and move to ~/share/lav7/builds/{current date}
+#   lav7-bench-arm: Build test binaries for each ARM version to ~/builds/bench, for running benchmarks on ARM devices.
+#      - Run on the device: ./lav7-ARMv7.test -test.run NONE -test.bench Light -test.benchmem
 
 type setopt >/dev/null 2>&1 && setopt shwordsplit
 PLATFORMS="darwin/386 darwin/amd64 freebsd/386 freebsd/amd64 freebsd/arm linux/386 linux/amd64 linux/arm windows/386 windows/amd64 openbsd/386 openbsd/amd64 android/arm"
@@ -56,6 +58,16 @@ function lav7-crosscompile {
     fi
 }
 
+function lav7-bench-arm {
+    cd ${GOPATH}/src/github.com/L7-MCPE/lav7
+    mkdir -p ~/builds/bench
+    for ARM in $ARMS; do
+        ACMD="GOARM=${ARM} go-linux-arm test -c -o ~/builds/bench/lav7-ARMv${ARM}.test ."
+        echo $ACMD
+        eval $ACMD || return 1
+    done
+}
+
 function lav7-build-all {
     local FAILS=""
     for PLATFORM in $PLATFORMS; do
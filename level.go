@@ -3,7 +3,10 @@ package lav7
 import (
 	"fmt"
 	"log"
+	"math/rand"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/L7-MCPE/lav7/format"
@@ -13,7 +16,16 @@ import (
 	"github.com/L7-MCPE/lav7/util/vector"
 )
 
-const tickDuration = time.Millisecond * 50
+// TicksPerSecond is a target tick rate of levels.
+const TicksPerSecond = 20
+
+const tickDuration = time.Second / TicksPerSecond
+
+// tickSamples is a count of recent ticks used for tick statistics.
+const tickSamples = 100
+
+// lagWarning is a threshold of tick lag to warn about overloaded server.
+const lagWarning = time.Second * 2
 
 var numWorkers = runtime.NumCPU()
 
@@ -24,11 +36,14 @@ type genRequest struct {
 
 // Level is a struct for processing MCPE worlds.
 type Level struct {
+	ticks uint64 // Accessed atomically: first field for 64-bit alignment on 32-bit platforms
+
 	format.Provider
 	Name string
 
 	ChunkMap   map[[2]int32]*types.Chunk
 	ChunkMutex util.Locker
+	lightLock  util.Locker // Serializes light updates, which lock several chunks
 	genTask    chan genRequest
 	Gen        func(int32, int32) *types.Chunk
 
@@ -36,6 +51,33 @@ type Level struct {
 
 	Ticker *time.Ticker
 	Stop   chan struct{}
+
+	Scheduler  *Scheduler // Tasks running on level ticks
+	stats      *tickStats
+	env        *levelEnv
+	updates    *blockUpdates
+	containers *openContainers
+	random     *rand.Rand // Used only on the level goroutine
+
+	players    map[*Player]struct{} // Players on the level, of every servers
+	playerLock util.Locker
+}
+
+// tickStats records start times and durations of recent ticks.
+type tickStats struct {
+	sync.Mutex
+	starts    [tickSamples]time.Time
+	durations [tickSamples]time.Duration
+	count     int // Recorded ticks, up to tickSamples
+	pos       int // Next index to record
+}
+
+// TickStats is tick timing statistics of a level.
+type TickStats struct {
+	Tick uint64        // Current tick
+	TPS  float64       // Ticks per second, averaged over recent ticks
+	MSPT float64       // Average milliseconds spent on each tick
+	Max  time.Duration // Longest tick duration among recent ticks
 }
 
 // Init initializes the level.
@@ -43,37 +85,105 @@ func (lv *Level) Init(pv format.Provider) {
 	lv.Provider = pv
 	lv.ChunkMap = make(map[[2]int32]*types.Chunk)
 	lv.ChunkMutex = util.NewMutex()
+	lv.lightLock = util.NewMutex()
 	lv.Ticker = time.NewTicker(tickDuration)
 	lv.Stop = make(chan struct{}, 1)
+	lv.Scheduler = new(Scheduler)
+	lv.stats = new(tickStats)
+	lv.env = newLevelEnv()
+	lv.updates = newBlockUpdates()
+	lv.containers = newOpenContainers()
+	lv.players = make(map[*Player]struct{})
+	lv.playerLock = util.NewMutex()
+	lv.random = rand.New(rand.NewSource(time.Now().UnixNano()))
 	lv.genTask = make(chan genRequest, 512)
 	lv.CleanQueue = make(map[[2]int32]struct{})
 	pv.Init(lv.Name)
+	lv.loadState()
 	log.Printf("* level: generating %d workers for chunk gen", numWorkers)
 	for i := 0; i < numWorkers; i++ {
 		go lv.genWorker()
 	}
 }
 
-// Process receives signals from two channels, Ticker.C and Stop.
+// Process runs level ticks on Ticker until Stop receives a signal. Scheduled tasks run on this goroutine.
 func (lv *Level) Process() {
+	last := time.Now()
 	for {
 		select {
 		case <-lv.Ticker.C:
-			return
-			//lv.tick()
+			now := time.Now()
+			if lag := now.Sub(last) - tickDuration; lag > lagWarning {
+				log.Printf("Level %s can't keep up! Running %v behind", lv.Name, lag)
+			}
+			last = now
+			lv.tick(now)
 		case <-lv.Stop:
 			return
 		}
 	}
 }
 
-func (lv *Level) tick() {
+// tick advances the level by a tick.
+func (lv *Level) tick(start time.Time) {
+	tick := atomic.AddUint64(&lv.ticks, 1)
+	lv.tickEnv(tick)
+	lv.Scheduler.Run(tick)
+	lv.runBlockUpdates(tick)
+	lv.randomTick()
+	lv.flushBlockChanges()
+	lv.stats.record(start, time.Since(start))
+}
 
+// CurrentTick returns count of ticks passed on the level.
+func (lv *Level) CurrentTick() uint64 {
+	return atomic.LoadUint64(&lv.ticks)
+}
+
+func (s *tickStats) record(start time.Time, d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.starts[s.pos] = start
+	s.durations[s.pos] = d
+	s.pos = (s.pos + 1) % tickSamples
+	if s.count < tickSamples {
+		s.count++
+	}
+}
+
+// TickStats returns tick statistics of recent ticks.
+func (lv *Level) TickStats() TickStats {
+	s := lv.stats
+	s.Lock()
+	defer s.Unlock()
+	stats := TickStats{Tick: lv.CurrentTick()}
+	if s.count == 0 {
+		return stats
+	}
+	oldest := (s.pos - s.count + tickSamples) % tickSamples
+	newest := (s.pos - 1 + tickSamples) % tickSamples
+	var total time.Duration
+	for i := 0; i < s.count; i++ {
+		d := s.durations[(oldest+i)%tickSamples]
+		total += d
+		if d > stats.Max {
+			stats.Max = d
+		}
+	}
+	stats.MSPT = total.Seconds() * 1000 / float64(s.count)
+	if elapsed := s.starts[newest].Sub(s.starts[oldest]); s.count > 1 && elapsed > 0 {
+		stats.TPS = float64(s.count-1) / elapsed.Seconds()
+	}
+	if stats.TPS > TicksPerSecond || s.count == 1 {
+		stats.TPS = TicksPerSecond
+	}
+	return stats
 }
 
 func (lv *Level) genWorker() {
 	for task := range lv.genTask {
 		c := lv.Gen(task.cx, task.cz)
+		populateLight(c)
 		lv.ChunkMutex.Lock()
 		if _, ok := lv.ChunkMap[[2]int32{task.cx, task.cz}]; ok {
 			lv.ChunkMutex.Unlock()
@@ -82,71 +192,154 @@ func (lv *Level) genWorker() {
 		}
 		lv.SetChunk(task.cx, task.cz, c)
 		lv.ChunkMutex.Unlock()
+		lv.spreadLight(task.cx, task.cz)
 		task.done <- struct{}{}
 	}
 }
 
-// OnUseItem handles UseItemPacket and determines position to update block position.
-func (lv *Level) OnUseItem(p *Player, x, y, z int32, face byte, item *types.Item) {
-	if !item.IsBlock() {
+// OnUseItem handles UseItemPacket: interacts with the clicked block, or places the block of the item.
+func (lv *Level) OnUseItem(p *Player, pk *proto.UseItem) {
+	x, y, z := int32(pk.X), int32(pk.Y), int32(pk.Z)
+	if pk.Face > vector.SideEast || pk.Item == nil {
 		return
 	}
-	switch face {
-	case vector.SideDown:
-		y--
-	case vector.SideUp:
-		y++
-	case vector.SideNorth:
-		z--
-	case vector.SideSouth:
-		z++
-	case vector.SideWest:
-		x--
-	case vector.SideEast:
-		x++
-	case 255:
+	clicked := lv.Get(x, y, z)
+	if bt := GetBlockType(clicked.ID); bt.OnInteract != nil {
+		// Interactions change the level or containers, so they need the same permission as placing blocks.
+		if !p.HasPermission(PermissionPlace) {
+			p.SendMessage("You don't have permission to use blocks.")
+			lv.SendBlock(p, x, y, z)
+			return
+		}
+		if !FireEvent(&BlockInteractEvent{Player: p, Level: lv, X: x, Y: y, Z: z, Block: clicked, Item: *pk.Item}) {
+			lv.SendBlock(p, x, y, z)
+			return
+		}
+		if bt.OnInteract(lv, p, x, y, z, clicked, pk.Item) {
+			return
+		}
+	}
+	id, ok := ItemBlock(pk.Item.ID)
+	if !ok {
+		return
+	}
+	ctx := &BlockPlace{
+		Player:  p,
+		Level:   lv,
+		X:       x,
+		Y:       y,
+		Z:       z,
+		Clicked: [3]int32{x, y, z},
+		Face:    pk.Face,
+		FX:      pk.FloatX,
+		FY:      pk.FloatY,
+		FZ:      pk.FloatZ,
+		Block:   types.Block{ID: id.Block(), Meta: byte(pk.Item.Meta)},
+	}
+	if !GetBlockType(clicked.ID).Replaceable { // Blocks like tall grass are replaced by the placed block
+		switch pk.Face {
+		case vector.SideDown:
+			ctx.Y--
+		case vector.SideUp:
+			ctx.Y++
+		case vector.SideNorth:
+			ctx.Z--
+		case vector.SideSouth:
+			ctx.Z++
+		case vector.SideWest:
+			ctx.X--
+		case vector.SideEast:
+			ctx.X++
+		}
+	}
+	if ctx.Y < 0 || ctx.Y > 127 {
 		return
 	}
-	if y > 127 {
+	if !p.HasPermission(PermissionPlace) {
+		p.SendMessage("You don't have permission to place blocks.")
+		lv.SendBlock(p, ctx.X, ctx.Y, ctx.Z)
 		return
 	}
-	if f := lv.GetBlock(x, y, z); f == 0 {
-		lv.Set(x, y, z, item.Block())
-		records := []proto.BlockRecord{
+	if bt := GetBlockType(ctx.Block.ID); bt.Place != nil && !bt.Place(ctx) {
+		lv.SendBlock(p, ctx.X, ctx.Y, ctx.Z)
+		return
+	}
+	if f := lv.Get(ctx.X, ctx.Y, ctx.Z); !ctx.Replace && !GetBlockType(f.ID).Replaceable {
+		p.SendMessage(fmt.Sprintf("Block %d(%s) already exists on x:%d, y:%d, z: %d", f.ID, types.ID(f.ID), ctx.X, ctx.Y, ctx.Z))
+		lv.SendBlock(p, ctx.X, ctx.Y, ctx.Z)
+		return
+	}
+	ev := &BlockPlaceEvent{Player: p, Level: lv, X: ctx.X, Y: ctx.Y, Z: ctx.Z, Block: ctx.Block}
+	if !FireEvent(ev) {
+		lv.SendBlock(p, ctx.X, ctx.Y, ctx.Z)
+		return
+	}
+	// Position of the event is read-only, as it is checked above.
+	lv.Set(ctx.X, ctx.Y, ctx.Z, ev.Block)
+	lv.BroadcastPacket(&proto.UpdateBlock{
+		BlockRecords: []proto.BlockRecord{
 			{
-				X: uint32(x),
-				Y: byte(y),
-				Z: uint32(z),
-				Block: types.Block{
-					ID:   byte(item.ID),
-					Meta: byte(0),
-				},
+				X:     uint32(ctx.X),
+				Y:     byte(ctx.Y),
+				Z:     uint32(ctx.Z),
+				Block: ev.Block,
 				Flags: proto.UpdateAllPriority,
 			},
+		},
+	})
+	bt := GetBlockType(ev.Block.ID)
+	if bt.Tile != "" {
+		if te := NewTileEntity(bt.Tile); te != nil {
+			lv.SetTile(ctx.X, ctx.Y, ctx.Z, te)
 		}
-		lv.updateSides(x, y, z, &records)
-		BroadcastPacket(&proto.UpdateBlock{
-			BlockRecords: records,
-		})
-		p.SendMessage(fmt.Sprintf("Face: %d", face))
-	} else {
-		p.SendMessage(fmt.Sprintf("Block %d(%s) already exists on x:%d, y:%d, z: %d", f, types.ID(f), x, y, z))
-		p.SendPacket(&proto.UpdateBlock{
-			BlockRecords: []proto.BlockRecord{
-				{
-					X:     uint32(x),
-					Y:     byte(y),
-					Z:     uint32(z),
-					Block: lv.Get(x, y, z),
-					Flags: proto.UpdateAllPriority,
-				},
-			},
-		})
 	}
+	if bt.OnPlace != nil {
+		bt.OnPlace(lv, p, ctx.X, ctx.Y, ctx.Z, ev.Block)
+	}
+	lv.NotifyNeighbors(ctx.X, ctx.Y, ctx.Z)
 }
 
-func (lv *Level) updateSides(x, y, z int32, record *[]proto.BlockRecord) {
-	// TODO
+// addPlayer adds the player to players on the level.
+func (lv *Level) addPlayer(p *Player) {
+	lv.playerLock.Lock()
+	defer lv.playerLock.Unlock()
+	lv.players[p] = struct{}{}
+}
+
+// removePlayer removes the player from players on the level.
+func (lv *Level) removePlayer(p *Player) {
+	lv.playerLock.Lock()
+	defer lv.playerLock.Unlock()
+	delete(lv.players, p)
+}
+
+// AsPlayers executes given callback with every players on the level, of every servers.
+// Like lav7.AsPlayers, callbacks can run with disconnected player.
+func (lv *Level) AsPlayers(callback func(*Player)) {
+	lv.playerLock.Lock()
+	players := make([]*Player, 0, len(lv.players))
+	for p := range lv.players {
+		players = append(players, p)
+	}
+	lv.playerLock.Unlock()
+	for _, p := range players {
+		callback(p)
+	}
+}
+
+// SendBlock sends current block on given position to the player, to revert client-side block changes.
+func (lv *Level) SendBlock(p *Player, x, y, z int32) {
+	p.SendPacket(&proto.UpdateBlock{
+		BlockRecords: []proto.BlockRecord{
+			{
+				X:     uint32(x),
+				Y:     byte(y),
+				Z:     uint32(z),
+				Block: lv.Get(x, y, z),
+				Flags: proto.UpdateAllPriority,
+			},
+		},
+	})
 }
 
 // ChunkExists returns if the chunk is loaded on the given chunk coordinates.
@@ -189,6 +382,7 @@ fallback:
 	log.Println("Using empty chunk anyway.")
 	c := new(types.Chunk)
 	*c = types.FallbackChunk
+	populateLight(c)
 	lv.SetChunk(cx, cz, c)
 	return c
 }
@@ -243,8 +437,11 @@ func (lv *Level) Clean() (cnt int) {
 	return
 }
 
-// Save saves all loaded chunks on memory.
+// Save saves all loaded chunks on memory, and time and weather of the level.
 func (lv *Level) Save() {
+	if err := lv.saveState(); err != nil {
+		log.Println("Error while saving level state:", err)
+	}
 	lv.ChunkMutex.Lock()
 	defer lv.ChunkMutex.Unlock()
 	if err := lv.SaveAll(lv.ChunkMap); err != nil {
@@ -253,23 +450,30 @@ func (lv *Level) Save() {
 }
 
 // GetBlock returns block ID on given coordinates.
-func (lv Level) GetBlock(x, y, z int32) byte {
+func (lv *Level) GetBlock(x, y, z int32) byte {
 	c := lv.GetChunk(x>>4, z>>4)
 	c.Mutex().RLock()
 	defer c.Mutex().RUnlock()
 	return c.GetBlock(byte(x&0xf), byte(y), byte(z&0xf))
 }
 
-// SetBlock sets block ID on given coordinates.
+// SetBlock sets block ID on given coordinates, and updates light.
 func (lv *Level) SetBlock(x, y, z int32, b byte) {
 	c := lv.GetChunk(x>>4, z>>4)
 	c.Mutex().Lock()
-	defer c.Mutex().Unlock()
+	old := c.GetBlock(byte(x&0xf), byte(y), byte(z&0xf))
 	c.SetBlock(byte(x&0xf), byte(y), byte(z&0xf), b)
+	c.UpdateHeight(byte(x&0xf), byte(y), byte(z&0xf))
+	removed := removeTile(c, x, y, z, old, b)
+	c.Mutex().Unlock()
+	if removed {
+		lv.closeContainer(x, y, z)
+	}
+	lv.blockChanged(x, y, z, old, b)
 }
 
 // GetBlockMeta returns block meta on given coordinates.
-func (lv Level) GetBlockMeta(x, y, z int32) byte {
+func (lv *Level) GetBlockMeta(x, y, z int32) byte {
 	c := lv.GetChunk(x>>4, z>>4)
 	c.Mutex().RLock()
 	defer c.Mutex().RUnlock()
@@ -286,7 +490,7 @@ func (lv *Level) SetBlockMeta(x, y, z int32, b byte) {
 
 // Get returns types.Block struct on given coordinates.
 // The struct will contain block ID/meta.
-func (lv Level) Get(x, y, z int32) types.Block {
+func (lv *Level) Get(x, y, z int32) types.Block {
 	c := lv.GetChunk(x>>4, z>>4)
 	c.Mutex().Lock()
 	defer c.Mutex().Unlock()
@@ -296,11 +500,34 @@ func (lv Level) Get(x, y, z int32) types.Block {
 	}
 }
 
-// Set sets block to given types.Block struct on given coordinates.
-func (lv Level) Set(x, y, z int32, block types.Block) {
+// Set sets block to given types.Block struct on given coordinates, and updates light.
+func (lv *Level) Set(x, y, z int32, block types.Block) {
 	c := lv.GetChunk(x>>4, z>>4)
 	c.Mutex().Lock()
-	defer c.Mutex().Unlock()
+	old := c.GetBlock(byte(x&0xf), byte(y), byte(z&0xf))
 	c.SetBlock(byte(x&0xf), byte(y), byte(z&0xf), block.ID)
 	c.SetBlockMeta(byte(x&0xf), byte(y), byte(z&0xf), block.Meta)
+	c.UpdateHeight(byte(x&0xf), byte(y), byte(z&0xf))
+	removed := removeTile(c, x, y, z, old, block.ID)
+	c.Mutex().Unlock()
+	if removed {
+		lv.closeContainer(x, y, z)
+	}
+	lv.blockChanged(x, y, z, old, block.ID)
+}
+
+// GetBlockLight returns block light level on given coordinates.
+func (lv *Level) GetBlockLight(x, y, z int32) byte {
+	c := lv.GetChunk(x>>4, z>>4)
+	c.Mutex().RLock()
+	defer c.Mutex().RUnlock()
+	return c.GetBlockLight(byte(x&0xf), byte(y), byte(z&0xf))
+}
+
+// GetSkyLight returns sky light level on given coordinates.
+func (lv *Level) GetSkyLight(x, y, z int32) byte {
+	c := lv.GetChunk(x>>4, z>>4)
+	c.Mutex().RLock()
+	defer c.Mutex().RUnlock()
+	return c.GetBlockSkyLight(byte(x&0xf), byte(y), byte(z&0xf))
 }
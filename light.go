@@ -0,0 +1,309 @@
+package lav7
+
+import "github.com/L7-MCPE/lav7/types"
+
+// lightSides are offsets of neighbor blocks. The first side should be down, for sky light propagation.
+var lightSides = [6][3]int32{
+	{0, -1, 0},
+	{0, 1, 0},
+	{-1, 0, 0},
+	{1, 0, 0},
+	{0, 0, -1},
+	{0, 0, 1},
+}
+
+// lightNode is a block position queued for light updates.
+type lightNode struct {
+	x, y, z int32
+	level   byte // Light level before removal
+}
+
+// lightWorld is a 3*3 window of chunks around a chunk, where light updates happen.
+// Light changed on a block reaches at most 15 blocks, so updates never go beyond the window.
+// Blocks on unloaded chunks are skipped.
+type lightWorld struct {
+	cx, cz int32
+	chunks [9]*types.Chunk
+}
+
+// chunk returns the chunk containing given block coordinates, or nil if it is out of the window or unloaded.
+func (w *lightWorld) chunk(x, z int32) *types.Chunk {
+	dx, dz := x>>4-w.cx+1, z>>4-w.cz+1
+	if dx < 0 || dx > 2 || dz < 0 || dz > 2 {
+		return nil
+	}
+	return w.chunks[dz*3+dx]
+}
+
+func (w *lightWorld) unlock() {
+	for _, c := range w.chunks {
+		if c != nil {
+			c.Mutex().Unlock()
+		}
+	}
+}
+
+// blockIndex returns index of BlockData. Nibble arrays use the index shifted by 1.
+func blockIndex(x, y, z int32) uint16 {
+	return uint16(y)<<8 | uint16(z&0xf)<<4 | uint16(x&0xf)
+}
+
+func lightData(c *types.Chunk, sky bool) *[16 * 16 * 64]byte {
+	if sky {
+		return &c.SkyLightData
+	}
+	return &c.LightData
+}
+
+func getNibble(data *[16 * 16 * 64]byte, i uint16) byte {
+	if i&1 == 0 {
+		return data[i>>1] & 0x0f
+	}
+	return data[i>>1] >> 4
+}
+
+func setNibble(data *[16 * 16 * 64]byte, i uint16, v byte) {
+	b := data[i>>1]
+	if i&1 == 0 {
+		data[i>>1] = b&0xf0 | v&0x0f
+	} else {
+		data[i>>1] = v<<4 | b&0x0f
+	}
+}
+
+// propagate spreads light from queued blocks, raising light levels of neighbors.
+func (w *lightWorld) propagate(sky bool, queue []lightNode) {
+	for i := 0; i < len(queue); i++ {
+		n := queue[i]
+		c := w.chunk(n.x, n.z)
+		l := getNibble(lightData(c, sky), blockIndex(n.x, n.y, n.z))
+		if l <= 1 {
+			continue
+		}
+		for side, d := range lightSides {
+			x, y, z := n.x+d[0], n.y+d[1], n.z+d[2]
+			if y < 0 || y > 127 {
+				continue
+			}
+			nc := c
+			if d[0] != 0 || d[2] != 0 {
+				if nc = w.chunk(x, z); nc == nil {
+					continue
+				}
+			}
+			idx := blockIndex(x, y, z)
+			opacity := lightOpacity[nc.BlockData[idx]]
+			dec := opacity + 1
+			if sky && side == 0 && l == MaxLight && opacity == 0 {
+				dec = 0 // Sky light goes down without decreasing
+			}
+			if l <= dec {
+				continue
+			}
+			data := lightData(nc, sky)
+			if nl := l - dec; nl > getNibble(data, idx) {
+				setNibble(data, idx, nl)
+				queue = append(queue, lightNode{x: x, y: y, z: z})
+			}
+		}
+	}
+}
+
+// remove clears light coming from given block, and returns neighbor blocks with light from other sources,
+// to propagate again.
+func (w *lightWorld) remove(sky bool, x, y, z int32) (refill []lightNode) {
+	c := w.chunk(x, z)
+	idx := blockIndex(x, y, z)
+	queue := []lightNode{{x: x, y: y, z: z, level: getNibble(lightData(c, sky), idx)}}
+	setNibble(lightData(c, sky), idx, 0)
+	for i := 0; i < len(queue); i++ {
+		n := queue[i]
+		for side, d := range lightSides {
+			x, y, z := n.x+d[0], n.y+d[1], n.z+d[2]
+			if y < 0 || y > 127 {
+				continue
+			}
+			nc := w.chunk(x, z)
+			if nc == nil {
+				continue
+			}
+			data := lightData(nc, sky)
+			idx := blockIndex(x, y, z)
+			nl := getNibble(data, idx)
+			if nl == 0 {
+				continue
+			}
+			if nl < n.level || sky && side == 0 && n.level == MaxLight && nl == MaxLight {
+				setNibble(data, idx, 0)
+				queue = append(queue, lightNode{x: x, y: y, z: z, level: nl})
+				if e := lightEmission[nc.BlockData[idx]]; !sky && e > 0 {
+					// The block is a light source itself, so it keeps its own light.
+					setNibble(data, idx, e)
+					refill = append(refill, lightNode{x: x, y: y, z: z})
+				}
+			} else {
+				refill = append(refill, lightNode{x: x, y: y, z: z})
+			}
+		}
+	}
+	return
+}
+
+// update recomputes block light and sky light after the block on given coordinates is changed.
+func (w *lightWorld) update(x, y, z int32) {
+	c := w.chunk(x, z)
+	idx := blockIndex(x, y, z)
+	id := c.BlockData[idx]
+	for _, sky := range []bool{false, true} {
+		queue := w.remove(sky, x, y, z)
+		var source byte
+		if !sky {
+			source = lightEmission[id]
+		} else if y == 127 && lightOpacity[id] == 0 {
+			source = MaxLight
+		}
+		if source > 0 {
+			setNibble(lightData(c, sky), idx, source)
+			queue = append(queue, lightNode{x: x, y: y, z: z})
+		}
+		w.propagate(sky, queue)
+	}
+}
+
+// populateLight computes light of a new chunk without neighbors.
+// Sky light comes down from the top of each column, and both sky light and block light spread in the chunk.
+func populateLight(c *types.Chunk) {
+	w := &lightWorld{chunks: [9]*types.Chunk{4: c}}
+	c.LightData = [16 * 16 * 64]byte{}
+	c.SkyLightData = [16 * 16 * 64]byte{}
+
+	// Height of the highest block which reduces sky light, or -1
+	var tops [16 * 16]int32
+	var sky, block []lightNode
+	for x := int32(0); x < 16; x++ {
+		for z := int32(0); z < 16; z++ {
+			top := int32(-1)
+			l := byte(MaxLight)
+			for y := int32(127); y >= 0 && l > 0; y-- {
+				idx := blockIndex(x, y, z)
+				opacity := lightOpacity[c.BlockData[idx]]
+				if opacity > 0 && top < 0 {
+					top = y
+				}
+				if l < MaxLight || opacity > 0 {
+					if l <= opacity+1 {
+						l = 0
+					} else {
+						l -= opacity + 1
+					}
+				}
+				setNibble(&c.SkyLightData, idx, l)
+			}
+			tops[z<<4|x] = top
+		}
+	}
+	for x := int32(0); x < 16; x++ {
+		for z := int32(0); z < 16; z++ {
+			// Sky light spreads sideways only below the highest neighbor column
+			limit := tops[z<<4|x]
+			for _, d := range lightSides[2:] {
+				if nx, nz := x+d[0], z+d[2]; nx >= 0 && nx < 16 && nz >= 0 && nz < 16 && tops[nz<<4|nx] > limit {
+					limit = tops[nz<<4|nx]
+				}
+			}
+			for y := int32(0); y <= limit; y++ {
+				if getNibble(&c.SkyLightData, blockIndex(x, y, z)) > 1 {
+					sky = append(sky, lightNode{x: x, y: y, z: z})
+				}
+			}
+			for y := int32(0); y < 128; y++ {
+				idx := blockIndex(x, y, z)
+				if e := lightEmission[c.BlockData[idx]]; e > 0 {
+					setNibble(&c.LightData, idx, e)
+					block = append(block, lightNode{x: x, y: y, z: z})
+				}
+			}
+		}
+	}
+	w.propagate(true, sky)
+	w.propagate(false, block)
+}
+
+// lightWindow returns locked light window around given chunk. Callers should hold lightLock, and unlock the window.
+func (lv *Level) lightWindow(cx, cz int32) *lightWorld {
+	w := &lightWorld{cx: cx, cz: cz}
+	// Chunks are looked up before locking them: holding a chunk lock while waiting for ChunkMutex
+	// deadlocks with chunk saves, which lock chunks while holding ChunkMutex.
+	lv.ChunkMutex.Lock()
+	for i := range w.chunks {
+		w.chunks[i] = lv.ChunkMap[[2]int32{cx + int32(i%3) - 1, cz + int32(i/3) - 1}]
+	}
+	lv.ChunkMutex.Unlock()
+	for _, c := range w.chunks {
+		if c != nil {
+			c.Mutex().Lock()
+		}
+	}
+	return w
+}
+
+// spreadLight exchanges light between a newly loaded chunk and its loaded neighbors.
+func (lv *Level) spreadLight(cx, cz int32) {
+	lv.lightLock.Lock()
+	defer lv.lightLock.Unlock()
+	w := lv.lightWindow(cx, cz)
+	defer w.unlock()
+	if w.chunks[4] == nil {
+		return
+	}
+	bx, bz := cx<<4, cz<<4
+	for _, sky := range []bool{false, true} {
+		var queue []lightNode
+		add := func(x, z int32) {
+			c := w.chunk(x, z)
+			if c == nil {
+				return
+			}
+			data := lightData(c, sky)
+			for y := int32(0); y < 128; y++ {
+				if getNibble(data, blockIndex(x, y, z)) > 1 {
+					queue = append(queue, lightNode{x: x, y: y, z: z})
+				}
+			}
+		}
+		for i := int32(0); i < 16; i++ {
+			add(bx+i, bz)
+			add(bx+i, bz-1)
+			add(bx+i, bz+15)
+			add(bx+i, bz+16)
+			add(bx, bz+i)
+			add(bx-1, bz+i)
+			add(bx+15, bz+i)
+			add(bx+16, bz+i)
+		}
+		w.propagate(sky, queue)
+	}
+}
+
+// UpdateLight recomputes block light and sky light around given coordinates.
+// Set and SetBlock call this when light emission or opacity of the block changes.
+func (lv *Level) UpdateLight(x, y, z int32) {
+	if y < 0 || y > 127 {
+		return
+	}
+	lv.lightLock.Lock()
+	defer lv.lightLock.Unlock()
+	w := lv.lightWindow(x>>4, z>>4)
+	defer w.unlock()
+	if w.chunks[4] == nil {
+		return
+	}
+	w.update(x, y, z)
+}
+
+// blockChanged updates light if the block change affects light.
+func (lv *Level) blockChanged(x, y, z int32, from, to byte) {
+	if lightOpacity[from] != lightOpacity[to] || lightEmission[from] != lightEmission[to] {
+		lv.UpdateLight(x, y, z)
+	}
+}
@@ -0,0 +1,146 @@
+package lav7
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// lightTestLevel returns a level with 3*3 flat chunks around chunk 0, 0. Ground is at y 5.
+func lightTestLevel(t testing.TB) *Level {
+	lv := &Level{Name: "lighttest"}
+	lv.Init(memoryProvider{})
+	lv.Ticker.Stop()
+	ground := new(types.Chunk)
+	for x := byte(0); x < 16; x++ {
+		for z := byte(0); z < 16; z++ {
+			for y := byte(0); y < 5; y++ {
+				ground.SetBlock(x, y, z, byte(types.Dirt))
+			}
+			ground.SetBlock(x, 5, z, byte(types.Grass))
+		}
+	}
+	ground.PopulateHeight()
+	for cx := int32(-1); cx <= 1; cx++ {
+		for cz := int32(-1); cz <= 1; cz++ {
+			c := new(types.Chunk)
+			c.CopyFrom(*ground)
+			populateLight(c)
+			lv.ChunkMutex.Lock()
+			lv.SetChunk(cx, cz, c)
+			lv.ChunkMutex.Unlock()
+			lv.spreadLight(cx, cz)
+		}
+	}
+	return lv
+}
+
+func TestLight(t *testing.T) {
+	lv := lightTestLevel(t)
+	expect := func(what string, x, y, z int32, block, sky byte) {
+		if b, s := lv.GetBlockLight(x, y, z), lv.GetSkyLight(x, y, z); b != block || s != sky {
+			t.Errorf("%s: light on %d, %d, %d is %d/%d, expected %d/%d", what, x, y, z, b, s, block, sky)
+		}
+	}
+	expect("open sky", 0, 6, 0, 0, 15)
+	expect("ground", 0, 5, 0, 0, 0)
+
+	// Torch light goes across chunk borders
+	lv.Set(15, 6, 0, types.Block{ID: byte(types.Torch)})
+	expect("torch", 15, 6, 0, 14, 15)
+	expect("next chunk", 16, 6, 0, 13, 15)
+	expect("far", 20, 8, 1, 6, 15)
+	lv.Set(15, 6, 0, types.Block{})
+	expect("removed torch", 16, 6, 0, 0, 15)
+
+	// A roof shades blocks below
+	for x := int32(-3); x <= 3; x++ {
+		for z := int32(-3); z <= 3; z++ {
+			lv.SetBlock(x, 9, z, byte(types.Stone))
+		}
+	}
+	if h := lv.GetChunk(0, 0).GetHeightMap(0, 0); h != 9 {
+		t.Error("Height map is not updated:", h)
+	}
+	expect("under roof", 0, 6, 0, 0, 11)
+	expect("edge of roof", 3, 8, 0, 0, 14)
+	lv.SetBlock(0, 9, 0, 0)
+	expect("hole in roof", 0, 6, 0, 0, 15)
+	if h := lv.GetChunk(0, 0).GetHeightMap(0, 0); h != 5 {
+		t.Error("Height map is not updated:", h)
+	}
+}
+
+// TestLightIncremental checks incremental updates give same light as computing from scratch.
+func TestLightIncremental(t *testing.T) {
+	lv := lightTestLevel(t)
+	r := rand.New(rand.NewSource(1))
+	ids := []types.ID{types.Air, types.Stone, types.Torch, types.Glowstone, types.Leaves, types.Water, types.Glass}
+	for i := 0; i < 300; i++ {
+		x, y, z := int32(r.Intn(24)-8), int32(r.Intn(12)+2), int32(r.Intn(24)-8)
+		lv.Set(x, y, z, types.Block{ID: byte(ids[r.Intn(len(ids))])})
+	}
+
+	fresh := &Level{Name: "lighttest"}
+	fresh.Init(memoryProvider{})
+	fresh.Ticker.Stop()
+	for k, c := range lv.ChunkMap {
+		nc := new(types.Chunk)
+		nc.CopyFrom(*c)
+		populateLight(nc)
+		fresh.ChunkMap[k] = nc
+	}
+	for k := range fresh.ChunkMap {
+		fresh.spreadLight(k[0], k[1])
+	}
+	for k, c := range lv.ChunkMap {
+		fc := fresh.ChunkMap[k]
+		if c.LightData != fc.LightData {
+			t.Errorf("Block light of chunk %v mismatches", k)
+		}
+		if c.SkyLightData != fc.SkyLightData {
+			t.Errorf("Sky light of chunk %v mismatches", k)
+		}
+	}
+}
+
+func TestLightRemoveNextToSource(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.Set(4, 8, 4, types.Block{ID: byte(types.Torch)})
+	lv.Set(5, 8, 4, types.Block{ID: byte(types.Fire)})
+	lv.Set(5, 8, 4, types.Block{})
+	if l := lv.GetBlockLight(4, 8, 4); l != lightEmission[types.Torch] {
+		t.Errorf("Torch next to removed fire should keep its light: expected %d, got %d", lightEmission[types.Torch], l)
+	}
+	if l := lv.GetBlockLight(6, 8, 4); l != lightEmission[types.Torch]-2 {
+		t.Errorf("Light of the torch should spread again: expected %d, got %d", lightEmission[types.Torch]-2, l)
+	}
+}
+
+// Run on ARM devices with a cross-compiled test binary, e.g.
+// GOARCH=arm GOARM=7 go test -c && ./lav7.test -test.run NONE -test.bench Light (see lav7-bench-arm).
+func BenchmarkPopulateLight(b *testing.B) {
+	lv := lightTestLevel(b)
+	c := new(types.Chunk)
+	c.CopyFrom(*lv.GetChunk(0, 0))
+	for i := 0; i < 16; i++ {
+		c.SetBlock(byte(i), 10, byte(i), byte(types.Torch))
+		c.SetBlock(byte(i), 20, 8, byte(types.Stone))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		populateLight(c)
+	}
+}
+
+func BenchmarkUpdateLight(b *testing.B) {
+	lv := lightTestLevel(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lv.Set(8, 6, 8, types.Block{ID: byte(types.Torch)})
+		lv.Set(8, 6, 8, types.Block{})
+		lv.Set(8, 10, 8, types.Block{ID: byte(types.Stone)})
+		lv.Set(8, 10, 8, types.Block{})
+	}
+}
@@ -0,0 +1,476 @@
+package lav7
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PermissionDefault decides if a player has a permission node, when no groups of the player set the node.
+type PermissionDefault int
+
+// Permission defaults
+const (
+	PermissionOp    PermissionDefault = iota // Only operators have the permission
+	PermissionTrue                           // Everyone has the permission
+	PermissionFalse                          // No one has the permission, unless groups grant it
+)
+
+// Built-in permission nodes
+const (
+	PermissionPlace = "lav7.build.place"
+	PermissionBreak = "lav7.build.break"
+)
+
+// Group is a permission group. Groups inherit permissions from parent groups, and override them.
+type Group struct {
+	Inherits    []string        `json:"inherits,omitempty"`
+	Permissions map[string]bool `json:"permissions,omitempty"`
+}
+
+// OpsFile and GroupsFile are paths to permission files. If empty, the data is not persisted.
+var (
+	OpsFile    = "ops.json"
+	GroupsFile = "groups.json"
+)
+
+// DefaultGroup is a group which every players belong to, if it exists.
+const DefaultGroup = "default"
+
+// maxInheritDepth limits group inheritance depth, for inheritance loops.
+const maxInheritDepth = 16
+
+var permissionDefaults = map[string]PermissionDefault{
+	PermissionPlace: PermissionTrue,
+	PermissionBreak: PermissionTrue,
+}
+
+var permissions = struct {
+	sync.RWMutex
+	ops     map[string]struct{}
+	groups  map[string]*Group
+	members map[string][]string // Lowercase username -> group names
+}{
+	ops:     make(map[string]struct{}),
+	groups:  make(map[string]*Group),
+	members: make(map[string][]string),
+}
+
+// groupsFile is a JSON structure of GroupsFile.
+type groupsFile struct {
+	Groups  map[string]*Group   `json:"groups"`
+	Players map[string][]string `json:"players"`
+}
+
+// RegisterPermission sets default value for given permission node.
+// Unregistered nodes default to PermissionOp.
+func RegisterPermission(node string, def PermissionDefault) {
+	permissions.Lock()
+	defer permissions.Unlock()
+	permissionDefaults[node] = def
+}
+
+// LoadPermissions loads ops list and groups from OpsFile and GroupsFile. Missing files are ignored.
+func LoadPermissions() error {
+	var ops []string
+	if err := readJSON(OpsFile, &ops); err != nil {
+		return err
+	}
+	gf := groupsFile{}
+	if err := readJSON(GroupsFile, &gf); err != nil {
+		return err
+	}
+	permissions.Lock()
+	defer permissions.Unlock()
+	permissions.ops = make(map[string]struct{})
+	for _, name := range ops {
+		permissions.ops[strings.ToLower(name)] = struct{}{}
+	}
+	permissions.groups = make(map[string]*Group)
+	for name, g := range gf.Groups {
+		if g.Permissions == nil {
+			g.Permissions = make(map[string]bool)
+		}
+		permissions.groups[strings.ToLower(name)] = g
+	}
+	permissions.members = make(map[string][]string)
+	for name, groups := range gf.Players {
+		permissions.members[strings.ToLower(name)] = groups
+	}
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// savePermissions writes permission files. Callers should hold permissions lock.
+func savePermissions() error {
+	ops := make([]string, 0, len(permissions.ops))
+	for name := range permissions.ops {
+		ops = append(ops, name)
+	}
+	sort.Strings(ops)
+	if err := writeJSON(OpsFile, ops); err != nil {
+		return err
+	}
+	return writeJSON(GroupsFile, groupsFile{Groups: permissions.groups, Players: permissions.members})
+}
+
+// IsOp checks if the player with given name is an operator.
+func IsOp(name string) bool {
+	permissions.RLock()
+	defer permissions.RUnlock()
+	_, ok := permissions.ops[strings.ToLower(name)]
+	return ok
+}
+
+// SetOp adds or removes the player with given name to ops list, and saves the list.
+func SetOp(name string, op bool) error {
+	permissions.Lock()
+	defer permissions.Unlock()
+	if op {
+		permissions.ops[strings.ToLower(name)] = struct{}{}
+	} else {
+		delete(permissions.ops, strings.ToLower(name))
+	}
+	return savePermissions()
+}
+
+// CreateGroup creates new permission group inheriting given groups.
+func CreateGroup(name string, inherits ...string) error {
+	permissions.Lock()
+	defer permissions.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := permissions.groups[name]; ok {
+		return fmt.Errorf("group %s already exists", name)
+	}
+	for _, parent := range inherits {
+		if _, ok := permissions.groups[strings.ToLower(parent)]; !ok {
+			return fmt.Errorf("group %s does not exist", parent)
+		}
+	}
+	permissions.groups[name] = &Group{Inherits: inherits, Permissions: make(map[string]bool)}
+	return savePermissions()
+}
+
+// DeleteGroup removes permission group, and removes players from the group.
+func DeleteGroup(name string) error {
+	permissions.Lock()
+	defer permissions.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := permissions.groups[name]; !ok {
+		return fmt.Errorf("group %s does not exist", name)
+	}
+	delete(permissions.groups, name)
+	for player := range permissions.members {
+		removeMember(player, name)
+	}
+	return savePermissions()
+}
+
+// SetGroupPermission sets permission node of the group. If value is nil, the node is unset.
+func SetGroupPermission(group, node string, value *bool) error {
+	permissions.Lock()
+	defer permissions.Unlock()
+	g, ok := permissions.groups[strings.ToLower(group)]
+	if !ok {
+		return fmt.Errorf("group %s does not exist", group)
+	}
+	if value == nil {
+		delete(g.Permissions, node)
+	} else {
+		g.Permissions[node] = *value
+	}
+	return savePermissions()
+}
+
+// AddToGroup adds the player with given name to the group.
+func AddToGroup(player, group string) error {
+	permissions.Lock()
+	defer permissions.Unlock()
+	player, group = strings.ToLower(player), strings.ToLower(group)
+	if _, ok := permissions.groups[group]; !ok {
+		return fmt.Errorf("group %s does not exist", group)
+	}
+	for _, g := range permissions.members[player] {
+		if g == group {
+			return nil
+		}
+	}
+	permissions.members[player] = append(permissions.members[player], group)
+	return savePermissions()
+}
+
+// RemoveFromGroup removes the player with given name from the group.
+func RemoveFromGroup(player, group string) error {
+	permissions.Lock()
+	defer permissions.Unlock()
+	removeMember(strings.ToLower(player), strings.ToLower(group))
+	return savePermissions()
+}
+
+func removeMember(player, group string) {
+	groups := permissions.members[player][:0]
+	for _, g := range permissions.members[player] {
+		if g != group {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == 0 {
+		delete(permissions.members, player)
+	} else {
+		permissions.members[player] = groups
+	}
+}
+
+// GetGroups returns group names of the player.
+func GetGroups(player string) []string {
+	permissions.RLock()
+	defer permissions.RUnlock()
+	return append([]string(nil), permissions.members[strings.ToLower(player)]...)
+}
+
+// ListGroups returns every group names, sorted.
+func ListGroups() []string {
+	permissions.RLock()
+	defer permissions.RUnlock()
+	names := make([]string, 0, len(permissions.groups))
+	for name := range permissions.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasPermission checks if the player with given name has the permission node.
+//
+// Groups of the player are searched first, in order of membership and then parents. The default group is searched last.
+// Exact nodes take precedence over wildcards, e.g. "lav7.command.stop" over "lav7.command.*" over "*".
+// If no groups set the node, the registered default is used.
+func HasPermission(player, node string) bool {
+	permissions.RLock()
+	defer permissions.RUnlock()
+	player = strings.ToLower(player)
+	var groups []*Group
+	visited := make(map[string]bool)
+	var walk func(name string, depth int)
+	walk = func(name string, depth int) {
+		name = strings.ToLower(name)
+		g, ok := permissions.groups[name]
+		if !ok || visited[name] || depth > maxInheritDepth {
+			return
+		}
+		visited[name] = true
+		groups = append(groups, g)
+		for _, parent := range g.Inherits {
+			walk(parent, depth+1)
+		}
+	}
+	for _, name := range permissions.members[player] {
+		walk(name, 0)
+	}
+	walk(DefaultGroup, 0)
+
+	for _, key := range permissionKeys(node) {
+		for _, g := range groups {
+			if v, ok := g.Permissions[key]; ok {
+				return v
+			}
+		}
+	}
+	_, op := permissions.ops[player]
+	switch permissionDefaults[node] {
+	case PermissionTrue:
+		return true
+	case PermissionFalse:
+		return false
+	default:
+		return op
+	}
+}
+
+// permissionKeys returns given node and its wildcard parents, most specific first.
+func permissionKeys(node string) []string {
+	keys := []string{node}
+	parts := strings.Split(node, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		keys = append(keys, strings.Join(parts[:i], ".")+".*")
+	}
+	return append(keys, "*")
+}
+
+func init() {
+	if err := RegisterCommand(&Command{
+		Name:        "op",
+		Usage:       "<player>",
+		Description: "Makes the player an operator.",
+		Permission:  "lav7.command.op",
+		Execute: func(sender CommandSender, args *CommandArgs) error {
+			return commandSetOp(sender, args, true)
+		},
+	}); err != nil {
+		panic(err)
+	}
+	if err := RegisterCommand(&Command{
+		Name:        "deop",
+		Usage:       "<player>",
+		Description: "Removes operator status from the player.",
+		Permission:  "lav7.command.op",
+		Execute: func(sender CommandSender, args *CommandArgs) error {
+			return commandSetOp(sender, args, false)
+		},
+	}); err != nil {
+		panic(err)
+	}
+	if err := RegisterCommand(&Command{
+		Name: "group",
+		Usage: "add <player> <group> | remove <player> <group> | list [player] | create <group> [parents...] | " +
+			"delete <group> | set <group> <node> <true|false> | unset <group> <node>",
+		Description: "Manages permission groups.",
+		Permission:  "lav7.command.group",
+		Execute:     commandGroup,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func commandSetOp(sender CommandSender, args *CommandArgs, op bool) error {
+	name, err := args.String()
+	if err != nil {
+		return err
+	}
+	if err := SetOp(name, op); err != nil {
+		return err
+	}
+	if op {
+		sender.SendMessage(name + " is now an operator.")
+	} else {
+		sender.SendMessage(name + " is no longer an operator.")
+	}
+	if p := FindPlayer(name); p != nil && strings.EqualFold(p.Username, name) && p != sender {
+		if op {
+			p.SendMessage("You are now an operator.")
+		} else {
+			p.SendMessage("You are no longer an operator.")
+		}
+	}
+	return nil
+}
+
+func commandGroup(sender CommandSender, args *CommandArgs) error {
+	sub, err := args.String()
+	if err != nil {
+		return err
+	}
+	sub = strings.ToLower(sub)
+	switch sub {
+	case "add", "remove":
+		player, err := args.String()
+		if err != nil {
+			return err
+		}
+		group, err := args.String()
+		if err != nil {
+			return err
+		}
+		if sub == "add" {
+			err = AddToGroup(player, group)
+		} else {
+			err = RemoveFromGroup(player, group)
+		}
+		if err != nil {
+			return err
+		}
+		sender.SendMessage(fmt.Sprintf("Groups of %s: %s", player, strings.Join(GetGroups(player), ", ")))
+	case "list":
+		if args.Len() > 0 {
+			player, _ := args.String()
+			sender.SendMessage(fmt.Sprintf("Groups of %s: %s", player, strings.Join(GetGroups(player), ", ")))
+		} else {
+			sender.SendMessage("Groups: " + strings.Join(ListGroups(), ", "))
+		}
+	case "create":
+		group, err := args.String()
+		if err != nil {
+			return err
+		}
+		var parents []string
+		for args.Len() > 0 {
+			parent, _ := args.String()
+			parents = append(parents, parent)
+		}
+		if err := CreateGroup(group, parents...); err != nil {
+			return err
+		}
+		sender.SendMessage("Created group " + group)
+	case "delete":
+		group, err := args.String()
+		if err != nil {
+			return err
+		}
+		if err := DeleteGroup(group); err != nil {
+			return err
+		}
+		sender.SendMessage("Deleted group " + group)
+	case "set", "unset":
+		group, err := args.String()
+		if err != nil {
+			return err
+		}
+		node, err := args.String()
+		if err != nil {
+			return err
+		}
+		var value *bool
+		if sub == "set" {
+			v, err := args.String()
+			if err != nil {
+				return err
+			}
+			b := v == "true"
+			if !b && v != "false" {
+				return usageError("Permission value should be true or false")
+			}
+			value = &b
+		}
+		if err := SetGroupPermission(group, node, value); err != nil {
+			return err
+		}
+		sender.SendMessage("Updated permission " + node + " of group " + group)
+	default:
+		return ErrUsage
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package lav7
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withPermissionFiles loads empty permissions with files in a temporary directory.
+// Returned function removes the directory and resets permissions.
+func withPermissionFiles(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "lav7-permission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	OpsFile, GroupsFile = filepath.Join(dir, "ops.json"), filepath.Join(dir, "groups.json")
+	if err := LoadPermissions(); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		OpsFile, GroupsFile = "", ""
+		LoadPermissions()
+		OpsFile, GroupsFile = "ops.json", "groups.json"
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPermissionDefaults(t *testing.T) {
+	defer withPermissionFiles(t)()
+	if !HasPermission("steve", PermissionPlace) {
+		t.Error("Build permission should default to true")
+	}
+	if HasPermission("steve", "lav7.command.stop") {
+		t.Error("Unregistered node should be op-only")
+	}
+	if err := SetOp("Steve", true); err != nil {
+		t.Fatal(err)
+	}
+	if !IsOp("steve") || !HasPermission("STEVE", "lav7.command.stop") {
+		t.Error("Op should have op-only nodes")
+	}
+	RegisterPermission("lav7.test.never", PermissionFalse)
+	if HasPermission("steve", "lav7.test.never") {
+		t.Error("PermissionFalse node should not be granted to ops")
+	}
+	if err := SetOp("steve", false); err != nil {
+		t.Fatal(err)
+	}
+	if HasPermission("steve", "lav7.command.stop") {
+		t.Error("Deopped player should not have op-only nodes")
+	}
+}
+
+func TestPermissionGroups(t *testing.T) {
+	yes, no := true, false
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		groups []string
+		node   string
+		want   bool
+	}{
+		{nil, PermissionPlace, false},
+		{nil, "lav7.command.say", false},
+		{[]string{"builder"}, PermissionPlace, true},
+		{[]string{"builder"}, PermissionBreak, false},
+		{[]string{"admin"}, PermissionPlace, true},
+		{[]string{"admin"}, PermissionBreak, false}, // "lav7.build.*" of default group is more specific than "*"
+		{[]string{"admin"}, "lav7.command.say", true},
+		{[]string{"admin"}, "lav7.command.stop", false},
+		{[]string{"builder", "admin"}, "lav7.command.say", true},
+	} {
+		reset := withPermissionFiles(t)
+		must(CreateGroup(DefaultGroup))
+		must(SetGroupPermission(DefaultGroup, "lav7.build.*", &no))
+		must(CreateGroup("builder", DefaultGroup))
+		must(SetGroupPermission("builder", PermissionPlace, &yes))
+		must(CreateGroup("admin", "builder"))
+		must(SetGroupPermission("admin", "*", &yes))
+		must(SetGroupPermission("admin", "lav7.command.stop", &no))
+		if err := CreateGroup("broken", "nonexistent"); err == nil {
+			t.Error("Group with unknown parent should not be created")
+		}
+		for _, g := range c.groups {
+			must(AddToGroup("alex", g))
+		}
+		if got := HasPermission("alex", c.node); got != c.want {
+			t.Errorf("groups %v, node %s: got %v, want %v", c.groups, c.node, got, c.want)
+		}
+		reset()
+	}
+}
+
+func TestPermissionPersistence(t *testing.T) {
+	defer withPermissionFiles(t)()
+	yes := true
+	if err := SetOp("Notch", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateGroup("mod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetGroupPermission("mod", "lav7.command.tp", &yes); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToGroup("Alex", "mod"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{OpsFile, GroupsFile} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ops, groups := OpsFile, GroupsFile
+	OpsFile, GroupsFile = "", ""
+	LoadPermissions() // Reset
+	if IsOp("notch") || HasPermission("alex", "lav7.command.tp") {
+		t.Fatal("Permissions are not reset")
+	}
+	OpsFile, GroupsFile = ops, groups
+	if err := LoadPermissions(); err != nil {
+		t.Fatal(err)
+	}
+	if !IsOp("notch") || !HasPermission("alex", "lav7.command.tp") {
+		t.Error("Permissions are not loaded from files")
+	}
+	if err := DeleteGroup("mod"); err != nil {
+		t.Fatal(err)
+	}
+	if len(GetGroups("alex")) != 0 {
+		t.Error("Deleted group should be removed from players")
+	}
+}
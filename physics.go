@@ -0,0 +1,164 @@
+package lav7
+
+import "github.com/L7-MCPE/lav7/types"
+
+func init() {
+	RegisterBlockUpdater(types.Sand, fallingBlock{})
+	RegisterBlockUpdater(types.Gravel, fallingBlock{})
+	water := &liquid{flowing: types.Water, still: types.StillWater, step: 1, delay: 5}
+	RegisterBlockUpdater(types.Water, water)
+	RegisterBlockUpdater(types.StillWater, water)
+	lava := &liquid{flowing: types.Lava, still: types.StillLava, step: 2, delay: 30}
+	RegisterBlockUpdater(types.Lava, lava)
+	RegisterBlockUpdater(types.StillLava, lava)
+}
+
+// horizontalSides are offsets of horizontal neighbor blocks.
+var horizontalSides = [4][2]int32{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+
+func isLiquid(id byte) bool {
+	switch types.ID(id) {
+	case types.Water, types.StillWater, types.Lava, types.StillLava:
+		return true
+	}
+	return false
+}
+
+// fallingBlock is a block falling down when there is nothing below, e.g. sand and gravel.
+// The block moves down by a block on each update.
+type fallingBlock struct{}
+
+func (fallingBlock) UpdateDelay() int { return 2 }
+
+func (fallingBlock) Update(lv *Level, x, y, z int32) {
+	below, ok := lv.LoadedBlock(x, y-1, z)
+	if !ok || !GetBlockType(below.ID).Replaceable {
+		return
+	}
+	b, _ := lv.LoadedBlock(x, y, z)
+	lv.ChangeBlock(x, y, z, types.Block{})
+	lv.ChangeBlock(x, y-1, z, b)
+}
+
+// liquid is a flowing liquid. Metadata of liquid blocks is a flow level: 0 for sources, and higher for
+// blocks farther from sources. Bit 8 is set on falling liquids.
+type liquid struct {
+	flowing, still types.ID
+	step           byte // Flow level increase per block
+	delay          int
+}
+
+func (l *liquid) UpdateDelay() int { return l.delay }
+
+func (l *liquid) is(id byte) bool {
+	return id == byte(l.flowing) || id == byte(l.still)
+}
+
+// level returns flow level of the block for spreading, or -1 if the block is not the liquid.
+// Falling liquids spread like sources.
+func (l *liquid) level(b types.Block) int {
+	if !l.is(b.ID) {
+		return -1
+	}
+	if b.Meta&8 != 0 {
+		return 0
+	}
+	return int(b.Meta & 7)
+}
+
+func (l *liquid) canFlowInto(lv *Level, x, y, z int32) bool {
+	b, ok := lv.LoadedBlock(x, y, z)
+	return ok && GetBlockType(b.ID).Replaceable && !isLiquid(b.ID)
+}
+
+func (l *liquid) Update(lv *Level, x, y, z int32) {
+	b, ok := lv.LoadedBlock(x, y, z)
+	if !ok || !l.is(b.ID) || l.harden(lv, x, y, z, b) {
+		return
+	}
+	meta := int(b.Meta)
+	if meta != 0 {
+		next := l.flowLevel(lv, x, y, z)
+		if next != meta {
+			if next < 0 {
+				lv.ChangeBlock(x, y, z, types.Block{})
+				return
+			}
+			lv.ChangeBlock(x, y, z, types.Block{ID: byte(l.flowing), Meta: byte(next)})
+			meta = next
+		}
+	}
+
+	if l.canFlowInto(lv, x, y-1, z) {
+		lv.ChangeBlock(x, y-1, z, types.Block{ID: byte(l.flowing), Meta: byte(meta&7 | 8)})
+		if meta != 0 {
+			return
+		}
+	} else if below, ok := lv.LoadedBlock(x, y-1, z); ok && l.is(below.ID) && meta != 0 {
+		return // Flowing liquids on the same liquid only fall
+	}
+	spread := int(l.step)
+	if meta&8 == 0 {
+		spread += meta
+	}
+	if spread >= 8 {
+		return
+	}
+	for _, d := range horizontalSides {
+		if l.canFlowInto(lv, x+d[0], y, z+d[1]) {
+			lv.ChangeBlock(x+d[0], y, z+d[1], types.Block{ID: byte(l.flowing), Meta: byte(spread)})
+		}
+	}
+}
+
+// flowLevel computes flow level of flowing liquid from neighbors, or -1 if the liquid should dry up.
+func (l *liquid) flowLevel(lv *Level, x, y, z int32) int {
+	if above, ok := lv.LoadedBlock(x, y+1, z); ok && l.is(above.ID) {
+		return 8
+	}
+	min, sources := -1, 0
+	for _, d := range horizontalSides {
+		n, ok := lv.LoadedBlock(x+d[0], y, z+d[1])
+		lvl := l.level(n)
+		if !ok || lvl < 0 {
+			continue
+		}
+		if n.Meta == 0 {
+			sources++
+		}
+		if min < 0 || lvl < min {
+			min = lvl
+		}
+	}
+	if l.flowing == types.Water && sources >= 2 {
+		// Water between two sources becomes a source, if it does not fall
+		if below, ok := lv.LoadedBlock(x, y-1, z); ok && (l.is(below.ID) && below.Meta == 0 || !GetBlockType(below.ID).Replaceable || isLiquid(below.ID) && !l.is(below.ID)) {
+			return 0
+		}
+	}
+	if min < 0 || min+int(l.step) >= 8 {
+		return -1
+	}
+	return min + int(l.step)
+}
+
+// harden turns lava touching water into obsidian or cobblestone. It returns true if the lava is hardened.
+func (l *liquid) harden(lv *Level, x, y, z int32, b types.Block) bool {
+	if l.flowing != types.Lava {
+		return false
+	}
+	for _, d := range [5][3]int32{{0, 1, 0}, {-1, 0, 0}, {1, 0, 0}, {0, 0, -1}, {0, 0, 1}} {
+		n, ok := lv.LoadedBlock(x+d[0], y+d[1], z+d[2])
+		if !ok || n.ID != byte(types.Water) && n.ID != byte(types.StillWater) {
+			continue
+		}
+		if b.Meta == 0 {
+			lv.ChangeBlock(x, y, z, types.Block{ID: byte(types.Obsidian)})
+			return true
+		} else if b.Meta&7 <= 4 {
+			lv.ChangeBlock(x, y, z, types.Block{ID: byte(types.Cobblestone)})
+			return true
+		}
+	}
+	return false
+}
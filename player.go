@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -55,15 +56,20 @@ type Player struct {
 	pending        map[[2]int32]time.Time
 
 	inventory *PlayerInventory
+	windows   windowManager
 
+	identifier   string          // Key on server players and router sessions map
+	server       *Server         // Server the player is registered on
+	session      *raknet.Session // Raknet session of the player
 	recvChan     chan *bytes.Buffer
 	raknetChan   chan<- *raknet.EncapsulatedPacket
 	callbackChan chan PlayerCallback
 	updateTicker *time.Ticker
 
-	loggedIn bool
-	spawned  bool
-	closed   bool
+	loggedIn    bool
+	spawned     bool
+	closed      bool
+	joinMessage string // Broadcasted on first spawn
 }
 
 func (p *Player) process() {
@@ -200,6 +206,14 @@ func (p *Player) updateChunk() {
 	}
 }
 
+// HasChunk returns if the chunk is loaded on the player.
+func (p *Player) HasChunk(cx, cz int32) bool {
+	p.fastChunkMutex.Lock()
+	defer p.fastChunkMutex.Unlock()
+	_, ok := p.fastChunks[[2]int32{cx, cz}]
+	return ok
+}
+
 // NOTE: Do NOT execute outside updateChunk goroutine. It could make data races.
 func (p *Player) getFastChunk(cx, cz int32) *types.Chunk {
 	p.fastChunkMutex.Lock()
@@ -228,14 +242,26 @@ func (p *Player) handleDataPacket(pk proto.Packet) (err error) {
 		if p.loggedIn {
 			return
 		}
-		iteratorLock.Lock()
-		if len(Players) >= int(atomic.LoadInt32(&raknet.MaxPlayers)) {
-			iteratorLock.Unlock()
+		if p.Server().PlayerCount() >= int(atomic.LoadInt32(&raknet.MaxPlayers)) {
 			p.disconnect("Server is full!")
 			return
 		}
-		iteratorLock.Unlock()
 		p.Username = pk.Username
+		if msg := checkLogin(p.Username, p.Address.IP); msg != "" {
+			log.Printf("%s(%s) is refused to join: %s", p.Username, p.Address, msg)
+			p.disconnect(msg)
+			return
+		}
+		join := &PlayerJoinEvent{
+			Player:      p,
+			Message:     p.Username + " joined",
+			KickMessage: "You are not allowed to join",
+		}
+		if !FireEvent(join) {
+			p.disconnect(join.KickMessage)
+			return
+		}
+		p.joinMessage = join.Message
 
 		ret := &proto.PlayStatus{}
 		if pk.Proto1 > raknet.MinecraftProtocol {
@@ -276,7 +302,8 @@ func (p *Player) handleDataPacket(pk proto.Packet) (err error) {
 
 		p.inventory.Holder = p
 		p.inventory.Init()
-		// TODO: Send SetTime/SpawnPosition/Health/Difficulty packets
+		p.Level.sendEnvironment(p)
+		// TODO: Send SpawnPosition/Health/Difficulty packets
 		p.firstSpawn()
 
 	case *proto.Batch:
@@ -292,7 +319,14 @@ func (p *Player) handleDataPacket(pk proto.Packet) (err error) {
 		if pk.TextType == proto.TextTypeTranslation {
 			return
 		}
-		Message(fmt.Sprintf("<%s> %s", p.Username, pk.Message))
+		if strings.HasPrefix(pk.Message, "/") {
+			ExecuteCommand(p, pk.Message)
+			return
+		}
+		chat := &PlayerChatEvent{Player: p, Message: pk.Message, Format: "<%s> %s"}
+		if FireEvent(chat) {
+			p.Server().Message(fmt.Sprintf(chat.Format, p.Username, chat.Message))
+		}
 
 	case *proto.MovePlayer:
 		pk := pk.(*proto.MovePlayer)
@@ -301,7 +335,26 @@ func (p *Player) handleDataPacket(pk proto.Packet) (err error) {
 
 	case *proto.RemoveBlock:
 		pk := pk.(*proto.RemoveBlock)
-		p.Level.SetBlock(int32(pk.X), int32(pk.Y), int32(pk.Z), 0) // Air
+		x, y, z := int32(pk.X), int32(pk.Y), int32(pk.Z)
+		if !p.HasPermission(PermissionBreak) {
+			p.SendMessage("You don't have permission to break blocks.")
+			p.Level.SendBlock(p, x, y, z)
+			break
+		}
+		block := p.Level.Get(x, y, z)
+		if !GetBlockType(block.ID).Breakable() {
+			p.Level.SendBlock(p, x, y, z)
+			break
+		}
+		if !FireEvent(&BlockBreakEvent{Player: p, Level: p.Level, X: x, Y: y, Z: z, Block: block}) {
+			p.Level.SendBlock(p, x, y, z)
+			break
+		}
+		p.Level.SetBlock(x, y, z, 0) // Air
+		if bt := GetBlockType(block.ID); bt.OnBreak != nil {
+			bt.OnBreak(p.Level, p, x, y, z, block)
+		}
+		p.Level.NotifyNeighbors(x, y, z)
 		p.BroadcastOthers(&proto.UpdateBlock{
 			BlockRecords: []proto.BlockRecord{
 				{
@@ -313,18 +366,22 @@ func (p *Player) handleDataPacket(pk proto.Packet) (err error) {
 			},
 		})
 
+	case *proto.BlockEntityData:
+		p.Level.OnBlockEntityData(p, pk.(*proto.BlockEntityData))
+
 	case *proto.UseItem:
 		pk := pk.(*proto.UseItem)
-		px, py, pz := int32(pk.X), int32(pk.Y), int32(pk.Z)
-		p.Level.OnUseItem(p, px, py, pz, pk.Face, pk.Item)
+		p.Level.OnUseItem(p, pk)
 		//spew.Dump(pk)
 
 	case *proto.RequestChunkRadius:
 		p.chunkRadius = int32(pk.(*proto.RequestChunkRadius).Radius)
 
 	case *proto.ContainerSetSlot:
-		//pk := pk.(*proto.ContainerSetSlot)
-		//spew.Dump(pk)
+		p.onContainerSetSlot(pk.(*proto.ContainerSetSlot))
+
+	case *proto.ContainerClose:
+		p.CloseWindow(pk.(*proto.ContainerClose).WindowID)
 
 	case *proto.Animate:
 		pk := pk.(*proto.Animate)
@@ -346,7 +403,7 @@ func (p *Player) SendMessage(msg string) {
 	})
 }
 
-//NOTE: This function is NOT goroutine-safe. Only for internal use.
+// NOTE: This function is NOT goroutine-safe. Only for internal use.
 func (p *Player) sendChunk(c types.ChunkDelivery) {
 	c.Chunk.Mutex().RLock()
 	i := &proto.FullChunkData{
@@ -405,10 +462,45 @@ func (p *Player) IsSelf(player *Player) bool {
 }
 
 func (p *Player) updateMove(pk *proto.MovePlayer) {
+	ev := &PlayerMoveEvent{
+		Player:  p,
+		From:    p.Position,
+		To:      vector.Vector3{X: pk.X, Y: pk.Y, Z: pk.Z},
+		Yaw:     pk.Yaw,
+		BodyYaw: pk.BodyYaw,
+		Pitch:   pk.Pitch,
+	}
+	if !FireEvent(ev) {
+		p.SendPacket(&proto.MovePlayer{
+			EntityID: 0, // Player self
+			X:        p.Position.X,
+			Y:        p.Position.Y,
+			Z:        p.Position.Z,
+			Yaw:      p.Yaw,
+			BodyYaw:  p.BodyYaw,
+			Pitch:    p.Pitch,
+			Mode:     proto.ModeReset,
+		})
+		return
+	}
+	if ev.To.X != pk.X || ev.To.Y != pk.Y || ev.To.Z != pk.Z ||
+		ev.Yaw != pk.Yaw || ev.BodyYaw != pk.BodyYaw || ev.Pitch != pk.Pitch {
+		pk = &proto.MovePlayer{
+			EntityID: 0,
+			X:        ev.To.X,
+			Y:        ev.To.Y,
+			Z:        ev.To.Z,
+			Yaw:      ev.Yaw,
+			BodyYaw:  ev.BodyYaw,
+			Pitch:    ev.Pitch,
+			Mode:     proto.ModeReset,
+		}
+		p.SendPacket(pk)
+	}
 	p.Position.X, p.Position.Y, p.Position.Z = pk.X, pk.Y, pk.Z
 	p.Yaw, p.BodyYaw, p.Pitch = pk.Yaw, pk.BodyYaw, pk.Pitch
 
-	go BroadcastCallback(PlayerCallback{
+	go p.Server().BroadcastCallback(PlayerCallback{
 		Call: func(pl *Player, arg interface{}) {
 			if pl.IsVisible(p) {
 				pl.SendPacket(&proto.MoveEntity{
@@ -427,12 +519,32 @@ func (p *Player) updateMove(pk *proto.MovePlayer) {
 	})
 }
 
+// Teleport moves the player to given position.
+func (p *Player) Teleport(pos vector.Vector3) {
+	p.RunAs(PlayerCallback{
+		Call: func(p *Player, arg interface{}) {
+			pk := &proto.MovePlayer{
+				EntityID: 0, // Player self
+				X:        pos.X,
+				Y:        pos.Y,
+				Z:        pos.Z,
+				Yaw:      p.Yaw,
+				BodyYaw:  p.BodyYaw,
+				Pitch:    p.Pitch,
+				Mode:     proto.ModeReset,
+			}
+			p.SendPacket(pk)
+			p.updateMove(pk)
+		},
+	})
+}
+
 func (p *Player) firstSpawn() {
 	if p.spawned {
 		return
 	}
 
-	BroadcastCallback(PlayerCallback{
+	p.Server().BroadcastCallback(PlayerCallback{
 		Call: func(player *Player, arg interface{}) {
 			player.ShowPlayer(p)
 			player.SendPacket(&proto.PlayerList{
@@ -448,7 +560,7 @@ func (p *Player) firstSpawn() {
 	})
 
 	var entries []proto.PlayerListEntry
-	AsPlayers(func(pl *Player) {
+	p.Server().AsPlayers(func(pl *Player) {
 		p.ShowPlayer(pl)
 		entries = append(entries, proto.PlayerListEntry{
 			RawUUID:  pl.UUID,
@@ -468,11 +580,13 @@ func (p *Player) firstSpawn() {
 			Status: proto.PlayerSpawn,
 		})
 
-		SpawnPlayer(p)
+		p.Server().SpawnPlayer(p)
 		p.RunAs(PlayerCallback{
 			Call: func(pl *Player, arg interface{}) {
 				p.spawned = true
-				Message(p.Username + " joined")
+				if p.joinMessage != "" {
+					p.Server().Message(p.joinMessage)
+				}
 				log.Println(p.Username + " joined the game")
 				p.SendMessage("Hello, this is lav7 test server!")
 			},
@@ -495,27 +609,44 @@ func (p *Player) disconnect(msg string) {
 		Message: msg,
 	})
 
-	raknet.SessionLock.Lock()
-	s, ok := raknet.Sessions[p.Address.String()]
-	raknet.SessionLock.Unlock()
-	if ok {
-		s.Close(msg)
+	if p.session != nil {
+		p.session.Close(msg)
 	}
 }
 
+// Server returns the server the player is registered on. Players not registered yet are on DefaultServer.
+func (p *Player) Server() *Server {
+	if p.server == nil {
+		return DefaultServer
+	}
+	return p.server
+}
+
 // BroadcastOthers broadcasts packet except player self.
 func (p *Player) BroadcastOthers(pk proto.Packet) {
-	AsPlayers(func(pl *Player) {
+	p.Server().AsPlayers(func(pl *Player) {
 		if !pl.IsSelf(p) {
 			pl.SendPacket(pk)
 		}
 	})
 }
 
+// immediatePackets is a set of latency-sensitive packet IDs.
+// These packets are sent without waiting for raknet send queue flush.
+var immediatePackets = map[byte]bool{
+	proto.MovePlayerHead:      true,
+	proto.MoveEntityHead:      true,
+	proto.SetEntityMotionHead: true,
+}
+
 // SendPacket sends given packet to client.
 func (p *Player) SendPacket(pk proto.Packet) {
 	buf := bytes.NewBuffer([]byte{0x8e, pk.Pid()})
 	buffer.Write(buf, pk.Write().Bytes())
+	if immediatePackets[pk.Pid()] {
+		p.sendPriority(buf, raknet.PriorityImmediate)
+		return
+	}
 	p.Send(buf)
 }
 
@@ -526,14 +657,11 @@ func (p *Player) SendDirect(pk proto.Packet) {
 
 	ep := new(raknet.EncapsulatedPacket)
 	ep.Reliability = 2
+	ep.Priority = raknet.PriorityImmediate
 	ep.Buffer = buf
 
-	raknet.SessionLock.Lock()
-	s, ok := raknet.Sessions[p.Address.String()]
-	raknet.SessionLock.Unlock()
-
-	if ok {
-		s.SendEncapsulated(ep)
+	if p.session != nil {
+		p.session.SendEncapsulated(ep)
 	}
 }
 
@@ -557,8 +685,13 @@ func (p *Player) RunAs(callback PlayerCallback) {
 // Send sends bytes buffer to client.
 // Do not use this method for sending packet to client, this is an internal function.
 func (p *Player) Send(buf *bytes.Buffer) {
+	p.sendPriority(buf, raknet.PriorityNormal)
+}
+
+func (p *Player) sendPriority(buf *bytes.Buffer, priority byte) {
 	ep := new(raknet.EncapsulatedPacket)
 	ep.Reliability = 2
+	ep.Priority = priority
 	ep.Buffer = buf
 	p.raknetChan <- ep
 }
@@ -0,0 +1,158 @@
+package lav7
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Plugin is an interface for server extensions. Plugins are built in with the server:
+// plugin packages call RegisterPlugin on init, and are imported from the server main package.
+//
+// Plugins register commands and event handlers on Enable, and should remove them on Disable.
+type Plugin interface {
+	Name() string
+	Version() string
+	Enable() error
+	Disable()
+}
+
+var plugins = struct {
+	sync.Mutex
+	registered map[string]Plugin
+	enabled    []Plugin // In order of enabling
+}{
+	registered: make(map[string]Plugin),
+}
+
+// RegisterPlugin adds the plugin to the server. Plugins with duplicate names are ignored.
+func RegisterPlugin(pl Plugin) {
+	plugins.Lock()
+	defer plugins.Unlock()
+	name := strings.ToLower(pl.Name())
+	if _, ok := plugins.registered[name]; !ok {
+		plugins.registered[name] = pl
+	}
+}
+
+// GetPlugin finds the plugin with given name.
+// If it doesn't present, returns nil.
+func GetPlugin(name string) Plugin {
+	plugins.Lock()
+	defer plugins.Unlock()
+	return plugins.registered[strings.ToLower(name)]
+}
+
+// GetPlugins returns every registered plugins, sorted by name.
+func GetPlugins() []Plugin {
+	plugins.Lock()
+	defer plugins.Unlock()
+	names := make([]string, 0, len(plugins.registered))
+	for name := range plugins.registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Plugin, len(names))
+	for i, name := range names {
+		list[i] = plugins.registered[name]
+	}
+	return list
+}
+
+// PluginEnabled returns if the plugin is enabled.
+func PluginEnabled(pl Plugin) bool {
+	plugins.Lock()
+	defer plugins.Unlock()
+	for _, p := range plugins.enabled {
+		if p == pl {
+			return true
+		}
+	}
+	return false
+}
+
+// EnablePlugins enables every registered plugins in order of names.
+// Plugins failed to enable are logged and skipped.
+func EnablePlugins() {
+	for _, pl := range GetPlugins() {
+		if PluginEnabled(pl) {
+			continue
+		}
+		if err := enablePlugin(pl); err != nil {
+			log.Printf("Error while enabling plugin %s: %v", pl.Name(), err)
+			continue
+		}
+		log.Printf("Enabled plugin %s %s", pl.Name(), pl.Version())
+	}
+}
+
+func enablePlugin(pl Plugin) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err := pl.Enable(); err != nil {
+		return err
+	}
+	plugins.Lock()
+	plugins.enabled = append(plugins.enabled, pl)
+	plugins.Unlock()
+	return nil
+}
+
+// DisablePlugins disables every enabled plugins in reverse order of enabling.
+func DisablePlugins() {
+	plugins.Lock()
+	enabled := plugins.enabled
+	plugins.enabled = nil
+	plugins.Unlock()
+	for i := len(enabled) - 1; i >= 0; i-- {
+		disablePlugin(enabled[i])
+	}
+}
+
+func disablePlugin(pl Plugin) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while disabling plugin %s: %v", pl.Name(), r)
+		}
+	}()
+	pl.Disable()
+	log.Printf("Disabled plugin %s", pl.Name())
+}
+
+// pluginList returns enabled plugins for query responses, e.g. "plugin1 1.0; plugin2 1.0"
+func pluginList() string {
+	plugins.Lock()
+	defer plugins.Unlock()
+	list := make([]string, len(plugins.enabled))
+	for i, pl := range plugins.enabled {
+		list[i] = pl.Name() + " " + pl.Version()
+	}
+	return strings.Join(list, "; ")
+}
+
+func init() {
+	if err := RegisterCommand(&Command{
+		Name:        "plugins",
+		Aliases:     []string{"pl"},
+		Description: "Shows the plugin list.",
+		Execute: func(sender CommandSender, args *CommandArgs) error {
+			list := GetPlugins()
+			sender.SendMessage(fmt.Sprintf("Plugins (%d):", len(list)))
+			for _, pl := range list {
+				state := "enabled"
+				if !PluginEnabled(pl) {
+					state = "disabled"
+				}
+				sender.SendMessage(fmt.Sprintf(" - %s %s (%s)", pl.Name(), pl.Version(), state))
+			}
+			return nil
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,53 @@
+package lav7
+
+import (
+	"errors"
+	"testing"
+)
+
+type testPlugin struct {
+	name    string
+	fail    bool
+	enabled bool
+}
+
+func (p *testPlugin) Name() string    { return p.name }
+func (p *testPlugin) Version() string { return "1.0" }
+func (p *testPlugin) Disable()        { p.enabled = false }
+func (p *testPlugin) Enable() error {
+	if p.fail {
+		return errors.New("test failure")
+	}
+	p.enabled = true
+	return nil
+}
+
+func TestPlugins(t *testing.T) {
+	a, b, broken := &testPlugin{name: "Alpha"}, &testPlugin{name: "beta"}, &testPlugin{name: "broken", fail: true}
+	RegisterPlugin(b)
+	RegisterPlugin(a)
+	RegisterPlugin(broken)
+	RegisterPlugin(&testPlugin{name: "alpha"}) // Duplicate
+	defer func() {
+		plugins.Lock()
+		for _, pl := range []*testPlugin{a, b, broken} {
+			delete(plugins.registered, pl.name)
+		}
+		plugins.Unlock()
+	}()
+
+	if GetPlugin("ALPHA") != a {
+		t.Error("Duplicate plugin should be ignored")
+	}
+	EnablePlugins()
+	if !a.enabled || !b.enabled || PluginEnabled(broken) {
+		t.Error("Plugins are not enabled correctly")
+	}
+	if list := pluginList(); list != "Alpha 1.0; beta 1.0" {
+		t.Errorf("Plugin list mismatch: %q", list)
+	}
+	DisablePlugins()
+	if a.enabled || b.enabled || pluginList() != "" {
+		t.Error("Plugins should be disabled")
+	}
+}
@@ -4,6 +4,7 @@ package proto
 import (
 	"bytes"
 	"log"
+	"reflect"
 
 	"github.com/L7-MCPE/lav7/raknet"
 	"github.com/L7-MCPE/lav7/types"
@@ -145,6 +146,16 @@ func GetPacket(pid byte) Packet {
 	return pk
 }
 
+// NewPacket returns new empty Packet struct with given pid, or nil if the pid is unknown.
+// Unlike GetPacket, returned struct is not shared with other callers.
+func NewPacket(pid byte) Packet {
+	pk, ok := packets[pid]
+	if !ok {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(pk).Elem()).Interface().(Packet)
+}
+
 // Login needs to be documented.
 type Login struct {
 	Username       string
@@ -1446,8 +1457,8 @@ func (i ContainerClose) Write() *bytes.Buffer {
 }
 
 // ContainerSetSlot needs to be documented.
-type ContainerSetSlot struct { // TODO: implement this after slots
-	Windowid   byte
+type ContainerSetSlot struct {
+	WindowID   byte
 	Slot       uint16
 	HotbarSlot uint16
 	Item       *types.Item
@@ -1458,7 +1469,7 @@ func (i ContainerSetSlot) Pid() byte { return ContainerSetSlotHead }
 
 // Read implements proto.Packet interface.
 func (i *ContainerSetSlot) Read(buf *bytes.Buffer) {
-	i.Windowid = buffer.ReadByte(buf)
+	i.WindowID = buffer.ReadByte(buf)
 	i.Slot = buffer.ReadShort(buf)
 	i.HotbarSlot = buffer.ReadShort(buf)
 	i.Item = new(types.Item)
@@ -1468,7 +1479,7 @@ func (i *ContainerSetSlot) Read(buf *bytes.Buffer) {
 // Write implements proto.Packet interface.
 func (i ContainerSetSlot) Write() *bytes.Buffer {
 	buf := new(bytes.Buffer)
-	buffer.WriteByte(buf, i.Windowid)
+	buffer.WriteByte(buf, i.WindowID)
 	buffer.WriteShort(buf, i.Slot)
 	buffer.WriteShort(buf, i.HotbarSlot)
 	buf.Write(i.Item.Write())
@@ -1508,6 +1519,13 @@ const (
 	CreativeWindow  byte = 0x79
 )
 
+// Window types of ContainerOpen packet
+const (
+	ChestWindowType     byte = 0
+	WorkbenchWindowType byte = 1
+	FurnaceWindowType   byte = 2
+)
+
 // ContainerSetContent needs to be documented.
 type ContainerSetContent struct {
 	WindowID byte
@@ -1,9 +1,10 @@
 package raknet
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
-	"strconv"
+	"reflect"
 	"testing"
 
 	"github.com/L7-MCPE/lav7/util"
@@ -16,45 +17,45 @@ func TestFormatParse(t *testing.T) {
 		expect []util.FmtElement
 	}{
 		{"B", []util.FmtElement{
-			{[]byte("B")[0], 1},
+			{T: 'B', C: 1},
 		}},
 		{"S", []util.FmtElement{
-			{[]byte("S")[0], 1},
+			{T: 'S', C: 1},
 		}},
 		{"D32", []util.FmtElement{
-			{[]byte("D")[0], 32},
+			{T: 'D', C: 32},
 		}},
 		{"BBS", []util.FmtElement{
-			{[]byte("B")[0], 1},
-			{[]byte("B")[0], 1},
-			{[]byte("S")[0], 1},
+			{T: 'B', C: 1},
+			{T: 'B', C: 1},
+			{T: 'S', C: 1},
 		}},
 		{"B13", []util.FmtElement{
-			{[]byte("B")[0], 13},
+			{T: 'B', C: 13},
 		}},
 		{"S4D1B", []util.FmtElement{
-			{[]byte("S")[0], 4},
-			{[]byte("D")[0], 1},
-			{[]byte("B")[0], 1},
+			{T: 'S', C: 4},
+			{T: 'D', C: 1},
+			{T: 'B', C: 1},
 		}},
 		{"L7D90B32", []util.FmtElement{
-			{[]byte("L")[0], 7},
-			{[]byte("D")[0], 90},
-			{[]byte("B")[0], 32},
+			{T: 'L', C: 7},
+			{T: 'D', C: 90},
+			{T: 'B', C: 32},
 		}},
 		{"SSSS", []util.FmtElement{
-			{[]byte("S")[0], 1},
-			{[]byte("S")[0], 1},
-			{[]byte("S")[0], 1},
-			{[]byte("S")[0], 1},
+			{T: 'S', C: 1},
+			{T: 'S', C: 1},
+			{T: 'S', C: 1},
+			{T: 'S', C: 1},
 		}},
 		{"D1000", []util.FmtElement{
-			{[]byte("D")[0], 1000},
+			{T: 'D', C: 1000},
 		}},
 		{"TTS", []util.FmtElement{
-			{[]byte("T")[0], 1},
-			{[]byte("T")[0], 1},
-			{[]byte("S")[0], 1},
+			{T: 'T', C: 1},
+			{T: 'T', C: 1},
+			{T: 'S', C: 1},
 		}},
 	}
 	for _, test := range tests {
@@ -75,49 +76,29 @@ func TestFormatParse(t *testing.T) {
 func TestBatchRead(t *testing.T) {
 	var tests = []struct {
 		init   []byte
-		fmt    string
+		p      []interface{}
 		expect []interface{}
 	}{
-		{[]byte{7, 4, 1, 2}, "BBBB", []interface{}{7, 4, 1, 2}},
-		{[]byte{0, 3, 6, 3}, "BBS", []interface{}{0, 3, 1539}},
-		{[]byte{0, 0, 0, 0}, "SS", []interface{}{0, 0}},
-		{[]byte{0, 0, 0, 0}, "I", []interface{}{0}},
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 1}, "L", []interface{}{1}},
-		{[]byte{0, 6, 0, 2, 0, 0, 3, 0}, "SSI", []interface{}{6, 2, 768}},
-		{[]byte{0, 32, 1, 4}, "SS", []interface{}{32, 260}},
+		{[]byte{7, 4, 1, 2}, []interface{}{new(byte), new(byte), new(byte), new(byte)}, []interface{}{7, 4, 1, 2}},
+		{[]byte{0, 3, 6, 3}, []interface{}{new(byte), new(byte), new(uint16)}, []interface{}{0, 3, 1539}},
+		{[]byte{0, 0, 0, 0}, []interface{}{new(uint16), new(uint16)}, []interface{}{0, 0}},
+		{[]byte{0, 0, 0, 0}, []interface{}{new(uint32)}, []interface{}{0}},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 1}, []interface{}{new(uint64)}, []interface{}{1}},
+		{[]byte{0, 6, 0, 2, 0, 0, 3, 0}, []interface{}{new(uint16), new(uint16), new(uint32)}, []interface{}{6, 2, 768}},
+		{[]byte{0, 32, 1, 4}, []interface{}{new(uint16), new(uint16)}, []interface{}{32, 260}},
 	}
 	for _, test := range tests {
-		b := bytes.NewBuffer(test.init)
-		r, err := b.BatchRead(test.fmt)
-		if err != nil {
-			t.Error(
-				"Got error while BatchRead:",
-				err,
-				"\nInit:\n"+hex.Dump(test.init)+
-					"Format:", test.fmt+
-					"\nExpected:", test.expect,
-			)
-			return
-		}
-		if len(r) != len(test.expect) {
-			t.Error(
-				"BatchRead return value length mismatch: Got",
-				strconv.Itoa(len(r))+",",
-				"expected", len(test.expect),
-				"\nInit:\n"+hex.Dump(test.init)+
-					"Format:", test.fmt+
-					"\nExpected:", test.expect,
-			)
-			return
+		buffer.BatchRead(bytes.NewBuffer(test.init), test.p...)
+		r := make([]interface{}, len(test.p))
+		for i, p := range test.p {
+			r[i] = reflect.ValueOf(p).Elem().Interface()
 		}
 		if fmt.Sprint(r) != fmt.Sprint(test.expect) {
 			t.Error(
 				"BatchRead return value mismatch: Got",
 				r,
 				"expected", test.expect,
-				"\nInit:\n"+hex.Dump(test.init)+
-					"Format:", test.fmt+
-					"\nExpected:", test.expect,
+				"\nInit:\n"+hex.Dump(test.init),
 			)
 		}
 	}
@@ -0,0 +1,451 @@
+package raknet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureDir is a directory to write per-session capture files on.
+// If empty, sessions are not captured.
+var CaptureDir string
+
+// Capture files are pcapng files with raw IP link type, so they can be opened with Wireshark too.
+// Each datagram is written as an Enhanced Packet Block, with synthesized IPv4/IPv6 and UDP headers.
+// Direction is stored on epb_flags option: inbound(client to server) or outbound(server to client).
+const (
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterface      = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+
+	linkTypeRaw  = 101
+	linkTypeIPv4 = 228
+	linkTypeIPv6 = 229
+
+	optEndOfOpt = 0
+	optTSResol  = 9
+	optFlags    = 2
+
+	flagInbound  = 1
+	flagOutbound = 2
+
+	captureSnapLen = 65535
+)
+
+// CaptureRecord is a datagram recorded on capture file.
+type CaptureRecord struct {
+	Time    time.Time
+	Inbound bool // True if the datagram is sent from client to server
+	Client  *net.UDPAddr
+	Server  *net.UDPAddr
+	Payload []byte
+}
+
+// CaptureWriter writes datagrams to pcapng capture file. It is safe for concurrent use.
+type CaptureWriter struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+// NewCaptureWriter writes pcapng headers to given writer, and returns new CaptureWriter.
+// If the writer implements io.Closer, it is closed with the CaptureWriter.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	shb := make([]byte, 28)
+	le := binary.LittleEndian
+	le.PutUint32(shb[0:], pcapngSectionHeader)
+	le.PutUint32(shb[4:], 28)
+	le.PutUint32(shb[8:], pcapngByteOrderMagic)
+	le.PutUint16(shb[12:], 1) // Major version
+	le.PutUint16(shb[14:], 0) // Minor version
+	le.PutUint64(shb[16:], 1<<64-1)
+	le.PutUint32(shb[24:], 28)
+
+	idb := make([]byte, 32)
+	le.PutUint32(idb[0:], pcapngInterface)
+	le.PutUint32(idb[4:], 32)
+	le.PutUint16(idb[8:], linkTypeRaw)
+	le.PutUint32(idb[12:], captureSnapLen)
+	le.PutUint16(idb[16:], optTSResol)
+	le.PutUint16(idb[18:], 1)
+	idb[20] = 6 // Microseconds
+	le.PutUint32(idb[28:], 32)
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+// Write writes a datagram record. Each record is written with single Write call, so capture files
+// are readable until the last record even if the server crashes.
+func (c *CaptureWriter) Write(rec *CaptureRecord) error {
+	var src, dst *net.UDPAddr
+	if rec.Inbound {
+		src, dst = rec.Client, rec.Server
+	} else {
+		src, dst = rec.Server, rec.Client
+	}
+	data := ipPacket(src, dst, rec.Payload)
+	padded := (len(data) + 3) &^ 3
+	size := 28 + padded + 12 + 4
+
+	b := make([]byte, size)
+	le := binary.LittleEndian
+	ts := uint64(rec.Time.UnixNano() / int64(time.Microsecond))
+	le.PutUint32(b[0:], pcapngEnhancedPacket)
+	le.PutUint32(b[4:], uint32(size))
+	le.PutUint32(b[8:], 0) // Interface ID
+	le.PutUint32(b[12:], uint32(ts>>32))
+	le.PutUint32(b[16:], uint32(ts))
+	le.PutUint32(b[20:], uint32(len(data)))
+	le.PutUint32(b[24:], uint32(len(data)))
+	copy(b[28:], data)
+	opt := b[28+padded:]
+	le.PutUint16(opt[0:], optFlags)
+	le.PutUint16(opt[2:], 4)
+	if rec.Inbound {
+		le.PutUint32(opt[4:], flagInbound)
+	} else {
+		le.PutUint32(opt[4:], flagOutbound)
+	}
+	le.PutUint32(b[size-4:], uint32(size))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return errors.New("capture is closed")
+	}
+	_, err := c.w.Write(b)
+	return err
+}
+
+// Close closes the capture. Records written after Close are ignored with error.
+func (c *CaptureWriter) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ipPacket wraps UDP payload with IP/UDP headers. IPv6 is used if either of addresses is IPv6.
+func ipPacket(src, dst *net.UDPAddr, payload []byte) []byte {
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	udpLen := 8 + len(payload)
+	var b, udp []byte
+	if srcIP != nil && dstIP != nil {
+		b = make([]byte, 20+udpLen)
+		b[0] = 0x45
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		b[8] = 64 // TTL
+		b[9] = 17 // UDP
+		copy(b[12:], srcIP)
+		copy(b[16:], dstIP)
+		binary.BigEndian.PutUint16(b[10:], ^checksum(0, b[:20]))
+		udp = b[20:]
+	} else {
+		b = make([]byte, 40+udpLen)
+		b[0] = 0x60
+		binary.BigEndian.PutUint16(b[4:], uint16(udpLen))
+		b[6] = 17 // UDP
+		b[7] = 64 // Hop limit
+		copy(b[8:], src.IP.To16())
+		copy(b[24:], dst.IP.To16())
+		udp = b[40:]
+	}
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[8:], payload)
+	if b[0]>>4 == 6 { // UDP checksum is mandatory on IPv6
+		var pseudo [40]byte
+		copy(pseudo[0:], b[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(udpLen))
+		pseudo[39] = 17
+		sum := ^checksum(checksum(0, pseudo[:]), udp)
+		if sum == 0 {
+			sum = 0xffff
+		}
+		binary.BigEndian.PutUint16(udp[6:], sum)
+	}
+	return b
+}
+
+// checksum adds given bytes to internet checksum.
+func checksum(sum uint16, b []byte) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return uint16(s)
+}
+
+// CaptureReader reads datagram records from pcapng capture file.
+// Files from other tools are also readable if they have raw IP link type.
+type CaptureReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []captureInterface
+	client     *net.UDPAddr // Source of the first datagram, for records without direction flags
+}
+
+type captureInterface struct {
+	linkType uint16
+	resol    time.Duration
+}
+
+// NewCaptureReader reads pcapng headers from given reader, and returns new CaptureReader.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	c := &CaptureReader{r: r}
+	typ, _, err := c.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if typ != pcapngSectionHeader {
+		return nil, errors.New("not a pcapng file")
+	}
+	return c, nil
+}
+
+// readBlock reads a pcapng block, and returns the block type and the body.
+func (c *CaptureReader) readBlock() (typ uint32, body []byte, err error) {
+	var head [12]byte
+	if _, err = io.ReadFull(c.r, head[:8]); err != nil {
+		return
+	}
+	if binary.LittleEndian.Uint32(head[:]) == pcapngSectionHeader {
+		if _, err = io.ReadFull(c.r, head[8:12]); err != nil {
+			return
+		}
+		switch uint32(pcapngByteOrderMagic) {
+		case binary.LittleEndian.Uint32(head[8:]):
+			c.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(head[8:]):
+			c.order = binary.BigEndian
+		default:
+			err = errors.New("invalid pcapng byte order magic")
+			return
+		}
+		c.interfaces = nil
+	} else if c.order == nil {
+		err = errors.New("pcapng section header is missing")
+		return
+	}
+	typ = c.order.Uint32(head[0:])
+	size := c.order.Uint32(head[4:])
+	read := uint32(8)
+	if typ == pcapngSectionHeader {
+		read = 12
+	}
+	if size < read+4 || size%4 != 0 || size > 1<<24 {
+		err = fmt.Errorf("invalid pcapng block length %d", size)
+		return
+	}
+	body = make([]byte, size-read)
+	if _, err = io.ReadFull(c.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	body = body[:len(body)-4] // Trailing block length
+	return
+}
+
+// options parses pcapng options, and calls given callback with each option.
+func (c *CaptureReader) options(b []byte, callback func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code, l := c.order.Uint16(b), int(c.order.Uint16(b[2:]))
+		if code == optEndOfOpt || 4+l > len(b) {
+			return
+		}
+		callback(code, b[4:4+l])
+		padded := 4 + (l+3)&^3
+		if padded > len(b) { // Last option without padding
+			return
+		}
+		b = b[padded:]
+	}
+}
+
+// Next returns next datagram record. It returns io.EOF at the end of capture.
+// Packets which are not UDP are skipped.
+func (c *CaptureReader) Next() (*CaptureRecord, error) {
+	for {
+		typ, body, err := c.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return nil, errors.New("invalid pcapng interface block")
+			}
+			iface := captureInterface{linkType: c.order.Uint16(body), resol: time.Microsecond}
+			c.options(body[8:], func(code uint16, value []byte) {
+				if code != optTSResol || len(value) < 1 {
+					return
+				}
+				if value[0]&0x80 == 0 {
+					iface.resol = time.Second
+					for i := byte(0); i < value[0] && iface.resol > 1; i++ {
+						iface.resol /= 10
+					}
+				} else if exp := value[0] &^ 0x80; exp < 31 {
+					iface.resol = time.Second >> exp
+				}
+			})
+			c.interfaces = append(c.interfaces, iface)
+		case pcapngEnhancedPacket:
+			if rec := c.enhancedPacket(body); rec != nil {
+				return rec, nil
+			}
+		}
+	}
+}
+
+func (c *CaptureReader) enhancedPacket(body []byte) *CaptureRecord {
+	if len(body) < 20 {
+		return nil
+	}
+	id := c.order.Uint32(body)
+	if id >= uint32(len(c.interfaces)) {
+		return nil
+	}
+	iface := c.interfaces[id]
+	ts := uint64(c.order.Uint32(body[4:]))<<32 | uint64(c.order.Uint32(body[8:]))
+	capLen := int(c.order.Uint32(body[12:]))
+	if 20+capLen > len(body) {
+		return nil
+	}
+	switch iface.linkType {
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil
+	}
+	src, dst, payload := parseIPPacket(body[20 : 20+capLen])
+	if payload == nil {
+		return nil
+	}
+	rec := &CaptureRecord{
+		Time:    time.Unix(0, 0).Add(time.Duration(ts) * iface.resol),
+		Payload: payload,
+	}
+	var flags uint32
+	c.options(body[20+(capLen+3)&^3:], func(code uint16, value []byte) {
+		if code == optFlags && len(value) == 4 {
+			flags = c.order.Uint32(value)
+		}
+	})
+	if c.client == nil {
+		c.client = src
+	}
+	switch flags & 3 {
+	case flagInbound:
+		rec.Inbound = true
+	case flagOutbound:
+	default:
+		rec.Inbound = src.String() == c.client.String()
+	}
+	if rec.Inbound {
+		rec.Client, rec.Server = src, dst
+	} else {
+		rec.Client, rec.Server = dst, src
+	}
+	return rec
+}
+
+// parseIPPacket parses IPv4/IPv6 UDP packet. It returns nil payload if the packet is not UDP.
+func parseIPPacket(b []byte) (src, dst *net.UDPAddr, payload []byte) {
+	if len(b) < 1 {
+		return
+	}
+	var udp []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < 20 || ihl < 20 || len(b) < ihl+8 || b[9] != 17 {
+			return
+		}
+		src = &net.UDPAddr{IP: net.IP(append([]byte(nil), b[12:16]...))}
+		dst = &net.UDPAddr{IP: net.IP(append([]byte(nil), b[16:20]...))}
+		udp = b[ihl:]
+	case 6:
+		if len(b) < 48 || b[6] != 17 { // Extension headers are not supported
+			return
+		}
+		src = &net.UDPAddr{IP: net.IP(append([]byte(nil), b[8:24]...))}
+		dst = &net.UDPAddr{IP: net.IP(append([]byte(nil), b[24:40]...))}
+		udp = b[40:]
+	default:
+		return
+	}
+	src.Port = int(binary.BigEndian.Uint16(udp[0:]))
+	dst.Port = int(binary.BigEndian.Uint16(udp[2:]))
+	l := int(binary.BigEndian.Uint16(udp[4:]))
+	if l < 8 || l > len(udp) {
+		l = len(udp)
+	}
+	payload = append([]byte{}, udp[8:l]...)
+	return
+}
+
+// startCapture opens capture file for the session on CaptureDir.
+func (s *Session) startCapture(dir string) {
+	name := strings.NewReplacer(":", "-", "[", "", "]", "").Replace(s.Address.String())
+	name = fmt.Sprintf("%s-%s.pcapng", name, time.Now().Format("20060102-150405.000"))
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		log.Println("Error while opening capture file:", err)
+		return
+	}
+	if s.capture, err = NewCaptureWriter(f); err != nil {
+		log.Println("Error while writing capture file:", err)
+		f.Close()
+		s.capture = nil
+		return
+	}
+	log.Println("Capturing session", s.Key, "to", f.Name())
+}
+
+// record writes given datagram to session capture, if capturing.
+func (s *Session) record(inbound bool, b []byte) {
+	if s.capture == nil {
+		return
+	}
+	var server *net.UDPAddr
+	if s.conn != nil {
+		server, _ = s.conn.LocalAddr().(*net.UDPAddr)
+	}
+	if server == nil {
+		server = &net.UDPAddr{IP: net.IPv4zero}
+	}
+	s.capture.Write(&CaptureRecord{
+		Time:    time.Now(),
+		Inbound: inbound,
+		Client:  s.Address,
+		Server:  server,
+		Payload: b,
+	})
+}
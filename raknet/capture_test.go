@@ -0,0 +1,97 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	records := []*CaptureRecord{
+		{
+			Inbound: true,
+			Client:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 54321},
+			Server:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132},
+			Payload: []byte{0x05, 1, 2, 3},
+		},
+		{
+			Client:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 54321},
+			Server:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 19132},
+			Payload: bytes.Repeat([]byte{0x84}, 1401),
+		},
+		{
+			Inbound: true,
+			Client:  &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 40000},
+			Server:  &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 19133},
+			Payload: []byte{0xc0},
+		},
+	}
+	base := time.Date(2016, 4, 1, 12, 0, 0, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	w, err := NewCaptureWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rec := range records {
+		rec.Time = base.Add(time.Duration(i) * time.Millisecond * 1500)
+		if err := w.Write(rec); err != nil {
+			t.Fatal("Error while writing record:", err)
+		}
+	}
+	w.Close()
+	if err := w.Write(records[0]); err == nil {
+		t.Error("Write after Close should fail")
+	}
+
+	r, err := NewCaptureReader(buf)
+	if err != nil {
+		t.Fatal("Error while reading capture:", err)
+	}
+	for i, expected := range records {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal("Error while reading record", i, err)
+		}
+		if !rec.Time.Equal(expected.Time) || rec.Inbound != expected.Inbound ||
+			rec.Client.String() != expected.Client.String() || rec.Server.String() != expected.Server.String() ||
+			!bytes.Equal(rec.Payload, expected.Payload) {
+			t.Errorf("Record #%d mismatch: expected %+v, got %+v", i, expected, rec)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Error("Expected EOF, got", err)
+	}
+}
+
+func TestCaptureChecksum(t *testing.T) {
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 19132}
+	dst := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 19133}
+	b := ipPacket(src, dst, []byte{1, 2, 3})
+	if checksum(0, b[:20]) != 0xffff {
+		t.Error("IPv4 header checksum mismatch")
+	}
+	if _, err := NewCaptureReader(bytes.NewReader(b)); err == nil {
+		t.Error("Reading non-pcapng data should fail")
+	}
+}
+
+func TestCaptureOptions(t *testing.T) {
+	c := &CaptureReader{order: binary.LittleEndian}
+	var values []string
+	record := func(code uint16, value []byte) {
+		values = append(values, string(value))
+	}
+	// Comment "abcde" padded to 8 bytes, then unpadded "xy" at the end of the block
+	c.options([]byte{1, 0, 5, 0, 'a', 'b', 'c', 'd', 'e', 0, 0, 0, 1, 0, 2, 0, 'x', 'y'}, record) // Should not panic
+	if len(values) != 2 || values[0] != "abcde" || values[1] != "xy" {
+		t.Errorf("Options mismatch: %q", values)
+	}
+	values = nil
+	c.options([]byte{1, 0, 9, 0, 'a'}, record)
+	if len(values) != 0 {
+		t.Errorf("Truncated option should be ignored: %q", values)
+	}
+}
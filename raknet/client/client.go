@@ -0,0 +1,431 @@
+// Package client implements a headless raknet/MCPE client, for bots and load tests.
+package client
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
+
+// MTU probe sizes, tried in order until the server replies.
+var mtuProbes = []int{1464, 1200, 576}
+
+// Timeouts for client connections.
+const (
+	ProbeTimeout   = time.Millisecond * 500 // Each OpenConnectionRequest
+	ConnectTimeout = time.Second * 5        // Whole raknet handshake
+	Timeout        = time.Second * 10       // No datagram from server
+	resendTimeout  = time.Second
+)
+
+// Client is a raknet client connection to MCPE server.
+type Client struct {
+	ClientID uint64
+	Server   *net.UDPAddr
+
+	// Handler is called with every received MCPE packet on client goroutine, if not nil.
+	// It should be set before Dial returns, and should not block. Use SetHandler to change it after that.
+	Handler func(proto.Packet)
+
+	conn    raknet.PacketConn
+	mtuSize uint16
+
+	recvChan  chan *bytes.Buffer
+	sendChan  chan *raknet.EncapsulatedPacket
+	connected chan struct{}
+	closeReq  chan string
+	closed    chan struct{}
+	lastRecv  time.Time
+
+	seqNumber    uint32
+	messageIndex uint32
+	splitID      uint16
+	recovery     map[uint32]*raknet.DataPacket
+
+	ackQueue  []uint32
+	received  map[uint32]struct{} // Received message indices above nextIndex
+	nextIndex uint32              // Lowest message index not received yet
+	splits    map[uint16][][]byte
+
+	state
+}
+
+// Dial connects to raknet server on given address, and completes raknet handshake.
+func Dial(address string) (*Client, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return DialConn(conn, addr)
+}
+
+// DialConn connects to raknet server on given address with given connection, and completes raknet handshake.
+// The connection is closed when the client is closed.
+func DialConn(conn raknet.PacketConn, addr *net.UDPAddr) (*Client, error) {
+	raknet.InitProtocol()
+	c := &Client{
+		ClientID:  uint64(rand.Int63()),
+		Server:    addr,
+		conn:      conn,
+		recvChan:  make(chan *bytes.Buffer, 256),
+		sendChan:  make(chan *raknet.EncapsulatedPacket, 256),
+		connected: make(chan struct{}),
+		closeReq:  make(chan string),
+		closed:    make(chan struct{}),
+		lastRecv:  time.Now(),
+		recovery:  make(map[uint32]*raknet.DataPacket),
+		received:  make(map[uint32]struct{}),
+		splits:    make(map[uint16][][]byte),
+	}
+	c.initState()
+	if err := c.openConnection(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.receive()
+	go c.work()
+	c.sendChan <- &raknet.EncapsulatedPacket{
+		Reliability: 2,
+		Buffer: raknet.GetDataHandler(0x09).Write(raknet.Fields{
+			"clientID":    c.ClientID,
+			"sendPing":    uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+			"useSecurity": false,
+		}),
+	}
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.closed:
+		return nil, errors.New("connection closed while handshaking")
+	case <-time.After(ConnectTimeout):
+		c.Close()
+		return nil, errors.New("raknet handshake timeout")
+	}
+}
+
+// openConnection negotiates MTU size with OpenConnectionRequest 1/2.
+func (c *Client) openConnection() error {
+	for _, mtu := range mtuProbes {
+		for try := 0; try < 2; try++ {
+			c.write(raknet.GetHandler(0x05).Write(raknet.Fields{
+				"protocol": byte(raknet.RaknetProtocol),
+				"mtuSize":  mtu,
+			}).Bytes())
+			if f, err := c.readUnconnected(0x06); err == nil {
+				c.mtuSize = f["mtuSize"].(uint16)
+				break
+			}
+		}
+		if c.mtuSize > 0 {
+			break
+		}
+	}
+	if c.mtuSize == 0 {
+		return errors.New("no reply for OpenConnectionRequest1")
+	}
+	for try := 0; try < 3; try++ {
+		c.write(raknet.GetHandler(0x07).Write(raknet.Fields{
+			"serverAddress": c.Server,
+			"mtuSize":       c.mtuSize,
+			"clientID":      c.ClientID,
+		}).Bytes())
+		if f, err := c.readUnconnected(0x08); err == nil {
+			c.mtuSize = f["mtuSize"].(uint16)
+			return nil
+		}
+	}
+	return errors.New("no reply for OpenConnectionRequest2")
+}
+
+func (c *Client) write(b []byte) {
+	c.conn.WriteToUDP(b, c.Server)
+}
+
+// fromServer returns whether given address is the server address.
+func (c *Client) fromServer(addr *net.UDPAddr) bool {
+	return addr.Port == c.Server.Port && (addr.IP.Equal(c.Server.IP) || c.Server.IP.IsUnspecified())
+}
+
+// readUnconnected waits for unconnected packet with given pid, and returns decoded fields.
+func (c *Client) readUnconnected(pid byte) (f raknet.Fields, err error) {
+	defer c.conn.SetReadDeadline(time.Time{})
+	c.conn.SetReadDeadline(time.Now().Add(ProbeTimeout))
+	b := make([]byte, 1024*64)
+	for {
+		var n int
+		var addr *net.UDPAddr
+		if n, addr, err = c.conn.ReadFromUDP(b); err != nil {
+			return
+		}
+		if n > 0 && b[0] == pid && c.fromServer(addr) {
+			return raknet.GetHandler(pid).Read(bytes.NewBuffer(b[1:n])), nil
+		}
+	}
+}
+
+func (c *Client) receive() {
+	b := make([]byte, 1024*64)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(b)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			continue
+		}
+		if !c.fromServer(addr) {
+			continue
+		}
+		buf := make([]byte, n)
+		copy(buf, b[:n])
+		select {
+		case c.recvChan <- bytes.NewBuffer(buf):
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *Client) work() {
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case reason := <-c.closeReq:
+			c.shutdown(reason)
+			return
+		case buf := <-c.recvChan:
+			c.lastRecv = time.Now()
+			c.handleDatagram(buf)
+		case ep := <-c.sendChan:
+			c.sendEncapsulated(ep)
+		case now := <-ticker.C:
+			c.update(now)
+		}
+	}
+}
+
+func (c *Client) update(now time.Time) {
+	if now.Sub(c.lastRecv) > Timeout {
+		c.shutdown("timeout")
+		return
+	}
+	if len(c.ackQueue) > 0 {
+		buf := bytes.NewBuffer([]byte{0xc0})
+		buffer.Write(buf, raknet.EncodeAck(c.ackQueue).Bytes())
+		c.write(buf.Bytes())
+		c.ackQueue = nil
+	}
+	for seq, dp := range c.recovery {
+		if now.Sub(dp.SendTime) > resendTimeout {
+			c.retransmit(seq)
+		}
+	}
+}
+
+func (c *Client) handleDatagram(buf *bytes.Buffer) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(buffer.Overflow); !ok {
+				panic(r)
+			}
+			log.Println("Recovering panic:", r)
+		}
+	}()
+	head := buffer.ReadByte(buf)
+	switch {
+	case head == 0xc0: // ACK
+		for _, seq := range raknet.DecodeAck(buf) {
+			delete(c.recovery, seq)
+		}
+	case head == 0xa0: // NACK
+		for _, seq := range raknet.DecodeAck(buf) {
+			c.retransmit(seq)
+		}
+	case head >= 0x80 && head < 0x90:
+		dp := &raknet.DataPacket{Buffer: buf, Head: head}
+		dp.Decode()
+		c.ackQueue = append(c.ackQueue, dp.SeqNumber)
+		for _, ep := range dp.Packets {
+			c.handleEncapsulated(ep)
+		}
+	}
+}
+
+func (c *Client) handleEncapsulated(ep *raknet.EncapsulatedPacket) {
+	if ep.Reliability >= 2 && ep.Reliability != 5 { // Drop duplicates
+		if _, ok := c.received[ep.MessageIndex]; ok || ep.MessageIndex < c.nextIndex {
+			return
+		}
+		c.received[ep.MessageIndex] = struct{}{}
+		for {
+			if _, ok := c.received[c.nextIndex]; !ok {
+				break
+			}
+			delete(c.received, c.nextIndex)
+			c.nextIndex++
+		}
+	}
+	if ep.HasSplit {
+		if ep.SplitCount == 0 || ep.SplitIndex >= ep.SplitCount {
+			return
+		}
+		parts, ok := c.splits[ep.SplitID]
+		if !ok || len(parts) != int(ep.SplitCount) {
+			parts = make([][]byte, ep.SplitCount)
+			c.splits[ep.SplitID] = parts
+		}
+		parts[ep.SplitIndex] = ep.Buffer.Bytes()
+		for _, part := range parts {
+			if part == nil {
+				return
+			}
+		}
+		delete(c.splits, ep.SplitID)
+		ep = &raknet.EncapsulatedPacket{Buffer: bytes.NewBuffer(bytes.Join(parts, nil))}
+	}
+	c.handleRaknet(ep.Buffer)
+}
+
+// handleRaknet handles connected raknet packets.
+func (c *Client) handleRaknet(buf *bytes.Buffer) {
+	head := buffer.ReadByte(buf)
+	switch head {
+	case 0x00: // Ping
+		f := raknet.GetDataHandler(0x00).Read(buf)
+		c.sendEncapsulated(&raknet.EncapsulatedPacket{
+			Buffer: raknet.GetDataHandler(0x03).Write(raknet.Fields{"pingID": f["pingID"]}),
+		})
+	case 0x10: // Server handshake
+		select {
+		case <-c.connected:
+			return
+		default:
+		}
+		f := raknet.GetDataHandler(0x10).Read(buf)
+		addrs := make([]*net.UDPAddr, 10)
+		addrs[0] = c.conn.LocalAddr().(*net.UDPAddr)
+		for i := 1; i < 10; i++ {
+			addrs[i] = &net.UDPAddr{IP: net.IPv4zero}
+		}
+		c.sendEncapsulated(&raknet.EncapsulatedPacket{
+			Reliability: 2,
+			Buffer: raknet.GetDataHandler(0x13).Write(raknet.Fields{
+				"address":         c.Server,
+				"systemAddresses": addrs,
+				"sendPing":        f["sendPong"],
+				"sendPong":        f["sendPong"].(uint64) + 1000,
+			}),
+		})
+		close(c.connected)
+	case 0x15: // Disconnect
+		c.shutdown("server disconnect")
+	case 0x8e:
+		c.handlePacket(buf)
+	}
+}
+
+// sendEncapsulated sends given packet, splitting it if it does not fit in a datagram.
+// It should be called only on work goroutine.
+func (c *Client) sendEncapsulated(ep *raknet.EncapsulatedPacket) {
+	reliable := ep.Reliability >= 2 && ep.Reliability != 5
+	if reliable {
+		ep.MessageIndex = c.messageIndex
+		c.messageIndex++
+	}
+	if 4+ep.TotalLen() <= int(c.mtuSize) {
+		c.transmit(&raknet.DataPacket{Head: 0x80, Packets: []*raknet.EncapsulatedPacket{ep}})
+		return
+	}
+	size := int(c.mtuSize) - 4 - (ep.TotalLen() - ep.Len()) - 10
+	count := uint32((ep.Len() + size - 1) / size)
+	splitID := c.splitID
+	c.splitID++
+	for i := uint32(0); i < count; i++ {
+		sp := &raknet.EncapsulatedPacket{
+			Buffer:       bytes.NewBuffer(ep.Next(size)),
+			Reliability:  ep.Reliability,
+			HasSplit:     true,
+			MessageIndex: ep.MessageIndex,
+			SplitCount:   count,
+			SplitID:      splitID,
+			SplitIndex:   i,
+		}
+		if i > 0 && reliable {
+			sp.MessageIndex = c.messageIndex
+			c.messageIndex++
+		}
+		c.transmit(&raknet.DataPacket{Head: 0x80, Packets: []*raknet.EncapsulatedPacket{sp}})
+	}
+}
+
+func (c *Client) transmit(dp *raknet.DataPacket) {
+	dp.SeqNumber = c.seqNumber
+	c.seqNumber++
+	dp.Encode()
+	dp.SendTime = time.Now()
+	c.write(dp.Bytes())
+	c.recovery[dp.SeqNumber] = dp
+}
+
+func (c *Client) retransmit(seq uint32) {
+	if dp, ok := c.recovery[seq]; ok {
+		delete(c.recovery, seq)
+		c.transmit(dp)
+	}
+}
+
+// SendRaw sends raw encapsulated payload to server, with reliable reliability.
+func (c *Client) SendRaw(buf *bytes.Buffer) {
+	select {
+	case c.sendChan <- &raknet.EncapsulatedPacket{Reliability: 2, Buffer: buf}:
+	case <-c.closed:
+	}
+}
+
+// Closed returns a channel which is closed when the connection is closed.
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Close disconnects from the server.
+func (c *Client) Close() {
+	select {
+	case c.closeReq <- "client disconnect":
+		<-c.closed
+	case <-c.closed:
+	}
+}
+
+// shutdown sends disconnect packet, and closes the connection.
+// It should be called only on work goroutine.
+func (c *Client) shutdown(reason string) {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	c.stateLock.Lock()
+	c.closeReason = reason
+	c.stateLock.Unlock()
+	c.transmit(&raknet.DataPacket{Head: 0x80, Packets: []*raknet.EncapsulatedPacket{
+		{Buffer: bytes.NewBuffer([]byte{0x15})},
+	}})
+	close(c.closed)
+	c.conn.Close()
+}
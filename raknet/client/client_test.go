@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
+
+// fakeServer starts raknet router which handles logins like lav7, and returns the router and received MCPE packets.
+func fakeServer(t *testing.T) (*raknet.Router, chan proto.Packet) {
+	received := make(chan proto.Packet, 64)
+	adder := func(s *raknet.Session) chan<- *bytes.Buffer {
+		ch := make(chan *bytes.Buffer, 64)
+		send := func(pk proto.Packet) {
+			buf := bytes.NewBuffer([]byte{0x8e, pk.Pid()})
+			buffer.Write(buf, pk.Write().Bytes())
+			s.PlayerChan <- &raknet.EncapsulatedPacket{Reliability: 2, Buffer: buf}
+		}
+		go func() {
+			for buf := range ch {
+				pk := proto.NewPacket(buffer.ReadByte(buf))
+				if pk == nil {
+					continue
+				}
+				pk.Read(buf)
+				received <- pk
+				if _, ok := pk.(*proto.Login); !ok {
+					continue
+				}
+				send(&proto.PlayStatus{Status: proto.LoginSuccess})
+				send(&proto.StartGame{EntityID: 1, X: 8, Y: 64, Z: 8})
+				chunk := &proto.FullChunkData{ChunkX: 0, ChunkZ: 0, Payload: make([]byte, 83200)}
+				send(&proto.Batch{Payloads: [][]byte{append([]byte{chunk.Pid()}, chunk.Write().Bytes()...)}})
+				send(&proto.PlayStatus{Status: proto.PlayerSpawn})
+			}
+		}()
+		return ch
+	}
+	remover := func(s *raknet.Session) error { return nil }
+	r, err := raknet.CreateRouter(adder, remover, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error while creating router:", err)
+	}
+	r.Start()
+	return r, received
+}
+
+func TestClientLogin(t *testing.T) {
+	r, received := fakeServer(t)
+	defer r.Close()
+	c, err := Dial(r.Addresses()[0].String())
+	if err != nil {
+		t.Fatal("Error while connecting:", err)
+	}
+	defer c.Close()
+	if err := c.Login("tester"); err != nil {
+		t.Fatal("Error while logging in:", err)
+	}
+	if err := c.WaitSpawn(time.Second * 5); err != nil {
+		t.Fatal("Error while waiting spawn:", err)
+	}
+	if c.EntityID() != 1 || c.Position().X != 8 || c.Chunks() != 1 {
+		t.Error("Client state mismatch: entity ID", c.EntityID(), "position", c.Position(), "chunks", c.Chunks())
+	}
+
+	select {
+	case pk := <-received:
+		if login, ok := pk.(*proto.Login); !ok || login.Username != "tester" || len(login.Skin) != 64*32*4 {
+			t.Error("Login packet mismatch:", pk)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Login packet is not received")
+	}
+	c.Move(10, 64, 12, 90, 0)
+	select {
+	case pk := <-received:
+		if mv, ok := pk.(*proto.MovePlayer); !ok || mv.X != 10 || mv.Z != 12 {
+			t.Error("MovePlayer packet mismatch:", pk)
+		}
+	case <-time.After(time.Second):
+		t.Error("MovePlayer packet is not received")
+	}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lav7-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	raknet.CaptureDir = dir
+	defer func() { raknet.CaptureDir = "" }()
+
+	r, _ := fakeServer(t)
+	defer r.Close()
+	c, err := Dial(r.Addresses()[0].String())
+	if err != nil {
+		t.Fatal("Error while connecting:", err)
+	}
+	if err := c.Login("replay"); err != nil {
+		t.Fatal("Error while logging in:", err)
+	}
+	if err := c.WaitSpawn(time.Second * 5); err != nil {
+		t.Fatal("Error while waiting spawn:", err)
+	}
+	c.Move(10, 64, 12, 90, 0)
+	c.Close()
+	time.Sleep(time.Millisecond * 100) // Wait for server session to be closed
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pcapng"))
+	if len(files) != 1 {
+		t.Fatal("Expected 1 capture file, got", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rd, err := raknet.NewCaptureReader(f)
+	if err != nil {
+		t.Fatal("Error while reading capture:", err)
+	}
+	var inbound, outbound []byte
+	replayer := raknet.NewReplayer(func(in bool, _ time.Time, buf *bytes.Buffer) {
+		if in {
+			inbound = append(inbound, buf.Bytes()[0])
+		} else {
+			outbound = append(outbound, buf.Bytes()[0])
+		}
+	})
+	for {
+		rec, err := rd.Next()
+		if err != nil {
+			break
+		}
+		replayer.Feed(rec)
+	}
+	replayer.Close()
+	if !bytes.Equal(inbound, []byte{proto.LoginHead, proto.MovePlayerHead}) {
+		t.Errorf("Inbound packets mismatch: %x", inbound)
+	}
+	if !bytes.Equal(outbound, []byte{proto.PlayStatusHead, proto.StartGameHead, proto.BatchHead, proto.PlayStatusHead}) {
+		t.Errorf("Outbound packets mismatch: %x", outbound)
+	}
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/util/buffer"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+// state contains MCPE-level client states, updated with received packets.
+type state struct {
+	stateLock   sync.Mutex
+	username    string
+	entityID    uint64
+	position    vector.Vector3
+	chunks      map[[2]int32]struct{}
+	packets     uint64 // Received MCPE packets, except Batch
+	closeReason string
+
+	loginResult chan uint32
+	spawned     chan struct{}
+}
+
+func (c *Client) initState() {
+	c.chunks = make(map[[2]int32]struct{})
+	c.loginResult = make(chan uint32, 1)
+	c.spawned = make(chan struct{})
+}
+
+// handlePacket decodes MCPE packet, and updates client states.
+func (c *Client) handlePacket(buf *bytes.Buffer) {
+	pk := proto.NewPacket(buffer.ReadByte(buf))
+	if pk == nil {
+		return
+	}
+	pk.Read(buf)
+	if pk, ok := pk.(*proto.Batch); ok {
+		for _, payload := range pk.Payloads {
+			c.handlePacket(bytes.NewBuffer(payload))
+		}
+		return
+	}
+	c.stateLock.Lock()
+	c.packets++
+	switch pk := pk.(type) {
+	case *proto.PlayStatus:
+		if pk.Status == proto.PlayerSpawn {
+			select {
+			case <-c.spawned:
+			default:
+				close(c.spawned)
+			}
+		} else {
+			select {
+			case c.loginResult <- pk.Status:
+			default:
+			}
+		}
+	case *proto.StartGame:
+		c.entityID = pk.EntityID
+		c.position = vector.Vector3{X: pk.X, Y: pk.Y, Z: pk.Z}
+	case *proto.MovePlayer:
+		if pk.EntityID == c.entityID {
+			c.position = vector.Vector3{X: pk.X, Y: pk.Y, Z: pk.Z}
+		}
+	case *proto.FullChunkData:
+		c.chunks[[2]int32{int32(pk.ChunkX), int32(pk.ChunkZ)}] = struct{}{}
+	case *proto.Disconnect:
+		c.closeReason = pk.Message
+	}
+	handler := c.Handler
+	c.stateLock.Unlock()
+	if handler != nil {
+		handler(pk)
+	}
+	if pk, ok := pk.(*proto.Disconnect); ok {
+		c.shutdown(pk.Message)
+	}
+}
+
+// SetHandler replaces Handler while the client is running.
+func (c *Client) SetHandler(handler func(proto.Packet)) {
+	c.stateLock.Lock()
+	c.Handler = handler
+	c.stateLock.Unlock()
+}
+
+// Login sends Login packet with given username, and waits for the result.
+func (c *Client) Login(username string) error {
+	var uuid [16]byte
+	for i := range uuid {
+		uuid[i] = byte(rand.Intn(256))
+	}
+	c.stateLock.Lock()
+	c.username = username
+	c.stateLock.Unlock()
+	c.SendPacket(&proto.Login{
+		Username:      username,
+		Proto1:        raknet.MinecraftProtocol,
+		Proto2:        raknet.MinecraftProtocol,
+		ClientID:      c.ClientID,
+		RawUUID:       uuid,
+		ServerAddress: c.Server.String(),
+		ClientSecret:  "",
+		SkinName:      "Standard_Steve",
+		Skin:          make([]byte, 64*32*4),
+	})
+	select {
+	case status := <-c.loginResult:
+		switch status {
+		case proto.LoginSuccess:
+			return nil
+		case proto.LoginFailedClient:
+			return errors.New("login failed: outdated client")
+		case proto.LoginFailedServer:
+			return errors.New("login failed: outdated server")
+		}
+		return errors.New("login failed: unknown status")
+	case <-c.closed:
+		return c.Err()
+	case <-time.After(ConnectTimeout):
+		return errors.New("login timeout")
+	}
+}
+
+// WaitSpawn waits until the server spawns the player.
+func (c *Client) WaitSpawn(timeout time.Duration) error {
+	select {
+	case <-c.spawned:
+		return nil
+	case <-c.closed:
+		return c.Err()
+	case <-time.After(timeout):
+		return errors.New("spawn timeout")
+	}
+}
+
+// SendPacket sends MCPE packet to server.
+func (c *Client) SendPacket(pk proto.Packet) {
+	buf := bytes.NewBuffer([]byte{0x8e, pk.Pid()})
+	buffer.Write(buf, pk.Write().Bytes())
+	c.SendRaw(buf)
+}
+
+// Move moves the player to given position.
+func (c *Client) Move(x, y, z, yaw, pitch float32) {
+	c.stateLock.Lock()
+	c.position = vector.Vector3{X: x, Y: y, Z: z}
+	eid := c.entityID
+	c.stateLock.Unlock()
+	c.SendPacket(&proto.MovePlayer{
+		EntityID: eid,
+		X:        x,
+		Y:        y,
+		Z:        z,
+		Yaw:      yaw,
+		BodyYaw:  yaw,
+		Pitch:    pitch,
+		Mode:     proto.ModeNormal,
+		OnGround: 1,
+	})
+}
+
+// Chat sends chat message to server.
+func (c *Client) Chat(msg string) {
+	c.stateLock.Lock()
+	username := c.username
+	c.stateLock.Unlock()
+	c.SendPacket(&proto.Text{
+		TextType: proto.TextTypeChat,
+		Source:   username,
+		Message:  msg,
+	})
+}
+
+// RequestChunkRadius requests chunk send radius to server.
+func (c *Client) RequestChunkRadius(radius uint32) {
+	c.SendPacket(&proto.RequestChunkRadius{Radius: radius})
+}
+
+// EntityID returns entity ID of the player, given by StartGame packet.
+func (c *Client) EntityID() uint64 {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.entityID
+}
+
+// Position returns current position of the player.
+func (c *Client) Position() vector.Vector3 {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.position
+}
+
+// Chunks returns count of received chunks.
+func (c *Client) Chunks() int {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return len(c.chunks)
+}
+
+// Received returns count of received MCPE packets. Packets in Batch are counted one by one.
+func (c *Client) Received() uint64 {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return c.packets
+}
+
+// Err returns the reason why the connection is closed, or nil if it is open.
+func (c *Client) Err() error {
+	select {
+	case <-c.closed:
+	default:
+		return nil
+	}
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	return errors.New("disconnected: " + c.closeReason)
+}
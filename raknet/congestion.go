@@ -0,0 +1,100 @@
+package raknet
+
+import "time"
+
+// Congestion control parameters. Window sizes are counted in datagrams.
+const (
+	initialWindow   = 16
+	minWindow       = 2
+	maxWindow       = windowSize
+	initialSsthresh = 512
+
+	initialRTO = time.Second
+	minRTO     = time.Millisecond * 200
+)
+
+// congestion implements a sliding congestion window with RTT estimation.
+// It follows TCP's scheme: slow start until ssthresh, then additive increase,
+// and multiplicative decrease on loss(NACK) events.
+type congestion struct {
+	cwnd     float64
+	ssthresh float64
+
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	lastLoss time.Time
+}
+
+func newCongestion() *congestion {
+	return &congestion{
+		cwnd:     initialWindow,
+		ssthresh: initialSsthresh,
+		rto:      initialRTO,
+	}
+}
+
+// window returns how many datagrams could be in flight at once.
+func (c *congestion) window() int {
+	return int(c.cwnd)
+}
+
+// onAck grows the window with a datagram acknowledgment, and updates RTT estimation with given sample.
+func (c *congestion) onAck(rtt time.Duration) {
+	if c.cwnd < c.ssthresh {
+		c.cwnd++ // Slow start
+	} else {
+		c.cwnd += 1 / c.cwnd // Congestion avoidance
+	}
+	if c.cwnd > maxWindow {
+		c.cwnd = maxWindow
+	}
+	if rtt <= 0 {
+		return
+	}
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		diff := c.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (c.rttvar*3 + diff) / 4
+		c.srtt = (c.srtt*7 + rtt) / 8
+	}
+	c.setRTO(c.srtt + c.rttvar*4)
+}
+
+// onNack halves the window. Multiple losses in one RTT are treated as a single loss event.
+func (c *congestion) onNack(now time.Time) {
+	if now.Sub(c.lastLoss) < c.srtt {
+		return
+	}
+	c.lastLoss = now
+	c.ssthresh = c.cwnd / 2
+	if c.ssthresh < minWindow {
+		c.ssthresh = minWindow
+	}
+	c.cwnd = c.ssthresh
+}
+
+// onTimeout collapses the window and backs off retransmission timeout.
+func (c *congestion) onTimeout(now time.Time) {
+	c.lastLoss = now
+	c.ssthresh = c.cwnd / 2
+	if c.ssthresh < minWindow {
+		c.ssthresh = minWindow
+	}
+	c.cwnd = minWindow
+	c.setRTO(c.rto * 2)
+}
+
+func (c *congestion) setRTO(rto time.Duration) {
+	if rto < minRTO {
+		rto = minRTO
+	} else if rto > RecoveryTimeout {
+		rto = RecoveryTimeout
+	}
+	c.rto = rto
+}
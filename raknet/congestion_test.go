@@ -0,0 +1,73 @@
+package raknet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCongestionSlowStart(t *testing.T) {
+	c := newCongestion()
+	for i := 0; i < 10; i++ {
+		c.onAck(time.Millisecond * 50)
+	}
+	if c.window() != initialWindow+10 {
+		t.Error("Slow start test failed: expected", initialWindow+10, "got", c.window())
+	}
+	c.cwnd, c.ssthresh = 20, 20
+	for i := 0; i < 20; i++ {
+		c.onAck(time.Millisecond * 50)
+	}
+	if c.cwnd < 20.9 || c.cwnd > 21 { // About one datagram per window
+		t.Error("Congestion avoidance test failed: expected about 21, got", c.cwnd)
+	}
+}
+
+func TestCongestionLoss(t *testing.T) {
+	c := newCongestion()
+	c.onAck(time.Millisecond * 100)
+	c.cwnd = 64
+	now := time.Now()
+	c.onNack(now)
+	if c.window() != 32 {
+		t.Error("Multiplicative decrease test failed: expected 32, got", c.window())
+	}
+	c.onNack(now.Add(time.Millisecond * 10)) // Same RTT: should be ignored
+	if c.window() != 32 {
+		t.Error("Loss event in same RTT should be ignored: expected 32, got", c.window())
+	}
+	c.onNack(now.Add(time.Second))
+	if c.window() != 16 {
+		t.Error("Multiplicative decrease test failed: expected 16, got", c.window())
+	}
+	rto := c.rto
+	c.onTimeout(now.Add(time.Second * 2))
+	if c.window() != minWindow || c.ssthresh != 8 {
+		t.Error("Timeout test failed: window", c.window(), "ssthresh", c.ssthresh)
+	}
+	if c.rto != rto*2 {
+		t.Error("RTO backoff test failed: expected", rto*2, "got", c.rto)
+	}
+}
+
+func TestCongestionRTO(t *testing.T) {
+	c := newCongestion()
+	if c.rto != initialRTO {
+		t.Error("Initial RTO mismatch:", c.rto)
+	}
+	c.onAck(time.Millisecond * 100)
+	if c.srtt != time.Millisecond*100 || c.rto != time.Millisecond*300 {
+		t.Error("RTO estimation failed: srtt", c.srtt, "rto", c.rto)
+	}
+	for i := 0; i < 100; i++ {
+		c.onAck(time.Millisecond)
+	}
+	if c.rto != minRTO {
+		t.Error("RTO should be clamped to", minRTO, "got", c.rto)
+	}
+	for i := 0; i < 10; i++ {
+		c.onTimeout(time.Now())
+	}
+	if c.rto != RecoveryTimeout {
+		t.Error("RTO should be clamped to", RecoveryTimeout, "got", c.rto)
+	}
+}
@@ -0,0 +1,203 @@
+package raknet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// PacketConn is a datagram transport for router and sessions. *net.UDPConn implements this interface.
+type PacketConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	SetReadDeadline(t time.Time) error
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// MemoryNetwork is an in-memory datagram network, for tests.
+// It can simulate packet loss, duplication, reordering and latency. Probabilities are in [0, 1].
+// Network conditions should be set before sending packets.
+type MemoryNetwork struct {
+	Loss      float64
+	Duplicate float64
+	Reorder   float64       // Reordered packets are delivered after ReorderDelay
+	Latency   time.Duration // One-way latency
+	Jitter    time.Duration // Random delay added to latency, in [0, Jitter)
+
+	// ReorderDelay is an extra delay for reordered packets. If zero, Latency+Jitter+10ms is used.
+	ReorderDelay time.Duration
+
+	lock  sync.Mutex
+	rand  *rand.Rand
+	conns map[string]*MemoryConn
+}
+
+// NewMemoryNetwork creates new in-memory network. Random network conditions are decided with given seed.
+func NewMemoryNetwork(seed int64) *MemoryNetwork {
+	return &MemoryNetwork{
+		rand:  rand.New(rand.NewSource(seed)),
+		conns: make(map[string]*MemoryConn),
+	}
+}
+
+// memoryQueueSize is a receive queue size of MemoryConn. Packets are dropped if the queue is full, like UDP.
+const memoryQueueSize = 4096
+
+// errMemoryClosed is returned from closed MemoryConn.
+var errMemoryClosed = errors.New("use of closed memory connection")
+
+// memoryTimeout is returned if read deadline exceeds.
+type memoryTimeout struct{}
+
+func (memoryTimeout) Error() string   { return "i/o timeout" }
+func (memoryTimeout) Timeout() bool   { return true }
+func (memoryTimeout) Temporary() bool { return true }
+
+type memoryPacket struct {
+	b    []byte
+	from *net.UDPAddr
+}
+
+// Listen opens new connection on given address. If the port is 0, new port is allocated.
+func (n *MemoryNetwork) Listen(address string) (*MemoryConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if addr.Port == 0 {
+		for addr.Port = 40000; ; addr.Port++ {
+			if _, ok := n.conns[addr.String()]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := n.conns[addr.String()]; ok {
+		return nil, errors.New("address already in use: " + addr.String())
+	}
+	c := &MemoryConn{
+		network: n,
+		addr:    addr,
+		queue:   make(chan memoryPacket, memoryQueueSize),
+		closed:  make(chan struct{}),
+	}
+	n.conns[addr.String()] = c
+	return c, nil
+}
+
+// send delivers given datagram to destination, applying network conditions.
+func (n *MemoryNetwork) send(b []byte, from, to *net.UDPAddr) {
+	n.lock.Lock()
+	dst, ok := n.conns[to.String()]
+	if !ok || n.rand.Float64() < n.Loss {
+		n.lock.Unlock()
+		return
+	}
+	copies := 1
+	if n.rand.Float64() < n.Duplicate {
+		copies++
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = n.Latency
+		if n.Jitter > 0 {
+			delays[i] += time.Duration(n.rand.Int63n(int64(n.Jitter)))
+		}
+		if n.rand.Float64() < n.Reorder {
+			if n.ReorderDelay > 0 {
+				delays[i] += n.ReorderDelay
+			} else {
+				delays[i] += n.Latency + n.Jitter + time.Millisecond*10
+			}
+		}
+	}
+	n.lock.Unlock()
+	pk := memoryPacket{b: append([]byte(nil), b...), from: from}
+	for _, d := range delays {
+		if d <= 0 {
+			dst.deliver(pk)
+			continue
+		}
+		time.AfterFunc(d, func() { dst.deliver(pk) })
+	}
+}
+
+// MemoryConn is a connection on MemoryNetwork. It implements PacketConn interface.
+type MemoryConn struct {
+	network   *MemoryNetwork
+	addr      *net.UDPAddr
+	queue     chan memoryPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	lock     sync.Mutex
+	deadline time.Time
+}
+
+func (c *MemoryConn) deliver(pk memoryPacket) {
+	select {
+	case <-c.closed:
+	case c.queue <- pk:
+	default: // Queue is full
+	}
+}
+
+// ReadFromUDP implements PacketConn interface.
+func (c *MemoryConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	c.lock.Lock()
+	deadline := c.deadline
+	c.lock.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(deadline.Sub(time.Now()))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-c.closed:
+		return 0, nil, errMemoryClosed
+	case <-timeout:
+		return 0, nil, memoryTimeout{}
+	case pk := <-c.queue:
+		return copy(b, pk.b), pk.from, nil
+	}
+}
+
+// WriteToUDP implements PacketConn interface.
+func (c *MemoryConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, errMemoryClosed
+	default:
+	}
+	c.network.send(b, c.addr, addr)
+	return len(b), nil
+}
+
+// SetReadDeadline implements PacketConn interface.
+func (c *MemoryConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.deadline = t
+	c.lock.Unlock()
+	return nil
+}
+
+// LocalAddr implements PacketConn interface.
+func (c *MemoryConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// Close implements PacketConn interface.
+func (c *MemoryConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.lock.Lock()
+		delete(c.network.conns, c.addr.String())
+		c.network.lock.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,71 @@
+package raknet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryNetwork(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	a, err := network.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error while listening:", err)
+	}
+	defer a.Close()
+	b, err := network.Listen("127.0.0.1:19132")
+	if err != nil {
+		t.Fatal("Error while listening:", err)
+	}
+	defer b.Close()
+	if _, err := network.Listen("127.0.0.1:19132"); err == nil {
+		t.Error("Listening on used address should fail")
+	}
+
+	a.WriteToUDP([]byte("hello"), b.LocalAddr().(*net.UDPAddr))
+	buf := make([]byte, 16)
+	b.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := b.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "hello" || from.String() != a.LocalAddr().String() {
+		t.Error("Datagram mismatch:", string(buf[:n]), from, err)
+	}
+
+	b.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+	if _, _, err := b.ReadFromUDP(buf); err == nil {
+		t.Error("Read should time out")
+	} else if err, ok := err.(net.Error); !ok || !err.Timeout() {
+		t.Error("Timeout error expected, got", err)
+	}
+}
+
+func TestMemoryNetworkConditions(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	network.Loss, network.Duplicate = 0.2, 0.2
+	a, _ := network.Listen("127.0.0.1:0")
+	defer a.Close()
+	b, _ := network.Listen("127.0.0.1:0")
+	defer b.Close()
+
+	const count = 1000
+	for i := 0; i < count; i++ {
+		a.WriteToUDP([]byte{byte(i)}, b.LocalAddr().(*net.UDPAddr))
+	}
+	received := 0
+	buf := make([]byte, 16)
+	b.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	for {
+		if _, _, err := b.ReadFromUDP(buf); err != nil {
+			break
+		}
+		received++
+	}
+	// Expected: 1000 * 0.8 * 1.2 = 960
+	if received < count*8/10 || received > count*11/10 || received == count {
+		t.Error("Unexpected received datagram count:", received)
+	}
+
+	b.Close()
+	if _, _, err := b.ReadFromUDP(buf); err == nil {
+		t.Error("Read on closed connection should fail")
+	}
+}
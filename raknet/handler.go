@@ -4,27 +4,35 @@ import (
 	"bytes"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/L7-MCPE/lav7/util/buffer"
 )
 
 var handlers map[byte]Protocol
 var dataPacketHandlers map[byte]Protocol
-var addressTemplate = []*net.UDPAddr{
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "127.0.0.1:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-	func() (addr *net.UDPAddr) { addr, _ = net.ResolveUDPAddr("udp4", "0.0.0.0:0"); return addr }(),
-}
-
-// InitProtocol registers raknet packet handlers
+var addressTemplate = systemAddresses("127.0.0.1", "0.0.0.0")
+var addressTemplate6 = systemAddresses("::1", "::")
+
+// systemAddresses returns 10 system addresses for server handshake: a loopback address and 9 unspecified addresses.
+func systemAddresses(loopback, unspecified string) []*net.UDPAddr {
+	addrs := make([]*net.UDPAddr, 10)
+	addrs[0] = &net.UDPAddr{IP: net.ParseIP(loopback)}
+	for i := 1; i < 10; i++ {
+		addrs[i] = &net.UDPAddr{IP: net.ParseIP(unspecified)}
+	}
+	return addrs
+}
+
+var protocolOnce sync.Once
+
+// InitProtocol registers raknet packet handlers. It is safe to call multiple times.
 func InitProtocol() {
+	protocolOnce.Do(initProtocol)
+}
+
+func initProtocol() {
 	handlers = map[byte]Protocol{
 		0x05: new(openConnectionRequest1),
 		0x06: new(openConnectionReply1),
@@ -105,10 +113,17 @@ func (p *openConnectionRequest1) Handle(f Fields, session *Session) {
 	if session.Status > 1 {
 		return
 	}
+	mtu := clampMTU(f["mtuSize"].(int))
+	if mtu == 0 {
+		return // Too small probe
+	}
+	if mtu > session.mtuProbe {
+		session.mtuProbe = mtu
+	}
 	log.Println("Handling OCR1: protocol", f["protocol"], f)
 	buf := new(openConnectionReply1).Write(Fields{
-		"mtuSize":  f["mtuSize"],
-		"serverID": serverID,
+		"mtuSize":  int(session.mtuProbe),
+		"serverID": session.serverID(),
 	})
 	session.Status = 1
 	session.send(buf)
@@ -167,9 +182,16 @@ func (p *openConnectionRequest2) Handle(f Fields, session *Session) {
 	}
 	log.Println("Handling OCR2: clientID", f["clientID"])
 	session.ID = f["clientID"].(uint64)
-	session.mtuSize = f["mtuSize"].(uint16)
+	mtu := clampMTU(int(f["mtuSize"].(uint16)))
+	if mtu == 0 {
+		mtu = MinMTU
+	}
+	if session.mtuProbe > 0 && mtu > session.mtuProbe {
+		mtu = session.mtuProbe // Don't trust MTU larger than the probe received
+	}
+	session.mtuSize = mtu
 	buf := new(openConnectionReply2).Write(Fields{
-		"serverID":      serverID,
+		"serverID":      session.serverID(),
 		"clientAddress": session.Address,
 		"mtuSize":       session.mtuSize,
 	})
@@ -294,12 +316,16 @@ func (p *ack) Read(buf *bytes.Buffer) (f Fields) {
 
 func (p *ack) Handle(f Fields, session *Session) {
 	session.recoveryLock.Lock()
-	defer session.recoveryLock.Unlock()
+	now := time.Now()
 	for _, seq := range f["seqs"].([]uint32) {
-		if _, ok := session.recovery[seq]; ok {
+		if dp, ok := session.recovery[seq]; ok {
 			delete(session.recovery, seq)
+			session.congestion.onAck(now.Sub(dp.SendTime))
+			session.onDatagramAck(dp)
 		}
 	}
+	session.recoveryLock.Unlock()
+	session.flushQueue(false)
 }
 
 func (p *ack) Write(f Fields) (buf *bytes.Buffer) {
@@ -316,11 +342,20 @@ func (p *nack) Read(buf *bytes.Buffer) (f Fields) {
 }
 
 func (p *nack) Handle(f Fields, session *Session) {
+	session.recoveryLock.Lock()
+	lost := false
 	for _, seq := range f["seqs"].([]uint32) {
-		if _, ok := session.nackQueue[seq]; ok {
-			delete(session.nackQueue, seq)
+		if dp, ok := session.recovery[seq]; ok {
+			if !lost {
+				session.congestion.onNack(time.Now())
+				lost = true
+			}
+			session.onDatagramNack(dp)
+			session.retransmit(seq)
 		}
 	}
+	session.recoveryLock.Unlock()
+	session.sendPending()
 }
 
 func (p *nack) Write(f Fields) (buf *bytes.Buffer) {
@@ -346,9 +381,13 @@ func (p *clientConnect) Handle(f Fields, session *Session) {
 	if session.Status != 2 {
 		return
 	}
+	template := addressTemplate
+	if session.Address.IP.To4() == nil {
+		template = addressTemplate6
+	}
 	buf := new(serverHandshake).Write(Fields{
 		"address":         session.Address,
-		"systemAddresses": addressTemplate,
+		"systemAddresses": template,
 		"sendPing":        f["sendPing"],
 		"sendPong":        f["sendPing"].(uint64) + 1000,
 	})
@@ -404,6 +443,7 @@ func (p *clientHandshake) Read(buf *bytes.Buffer) (f Fields) {
 func (p *clientHandshake) Handle(f Fields, session *Session) {
 	log.Println("Client connected successfully!")
 	session.Status = 3
+	session.leaveHalfOpen()
 	session.connComplete()
 	return
 }
@@ -430,7 +470,7 @@ func (p *serverHandshake) Read(buf *bytes.Buffer) (f Fields) {
 	buf.Next(1) // Unknown
 	addrs := make([]*net.UDPAddr, 10)
 	for i := 0; i < 10; i++ {
-		addrs[0] = buffer.ReadAddress(buf)
+		addrs[i] = buffer.ReadAddress(buf)
 	}
 	f["systemAddresses"] = addrs
 	f["sendPing"] = buffer.ReadLong(buf)
@@ -0,0 +1,169 @@
+package raknet
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Rate limit settings. Rates are per second for each IP address, and bursts are twice as rates.
+// Zero rate disables the limit, and zero BanDuration disables bans. These should be set before creating router.
+var (
+	PacketRate    = 500         // Received datagrams
+	ByteRate      = 1024 * 1024 // Received bytes
+	HandshakeRate = 10          // Unconnected pings and open connection requests
+	BanDuration   = time.Second * 10
+)
+
+// AddressFilter decides if packets from given IP address are accepted. If nil, every addresses are accepted.
+// It is called for every received datagrams, so it should be fast.
+var AddressFilter func(ip net.IP) bool
+
+// MaxHalfOpen is a maximum count of sessions which are not connected yet(Status < 3), on each router.
+// Packets creating new sessions over this limit are dropped.
+var MaxHalfOpen int32 = 32
+
+// MaxBanDuration is an upper bound of escalated temporary bans.
+const MaxBanDuration = time.Hour
+
+// limiterExpire defines how long idle, unbanned client records are kept.
+const limiterExpire = time.Minute * 10
+
+// tokenBucket is a simple token bucket, refilled by rate per second up to burst.
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+func newBucket(rate int, now time.Time) tokenBucket {
+	return tokenBucket{
+		tokens: float64(rate * 2),
+		rate:   float64(rate),
+		burst:  float64(rate * 2),
+		last:   now,
+	}
+}
+
+// take consumes n tokens from bucket. If the bucket doesn't have enough tokens, it returns false.
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// clientLimit holds rate limit states for an IP address.
+type clientLimit struct {
+	packets     tokenBucket
+	bytes       tokenBucket
+	handshakes  tokenBucket
+	offenses    uint
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// limiter applies per-IP rate limits, and bans offenders temporarily.
+// Ban duration is doubled for each offense, up to MaxBanDuration.
+type limiter struct {
+	lock    sync.Mutex
+	clients map[string]*clientLimit
+
+	packetRate, byteRate, handshakeRate int
+	banDuration                         time.Duration
+	onBan                               func(net.IP, time.Duration)
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		clients:       make(map[string]*clientLimit),
+		packetRate:    PacketRate,
+		byteRate:      ByteRate,
+		handshakeRate: HandshakeRate,
+		banDuration:   BanDuration,
+	}
+}
+
+// allow checks if a datagram with given length from given IP could be handled.
+// handshake should be true if the datagram is an unconnected ping or open connection request.
+func (l *limiter) allow(ip net.IP, n int, handshake bool, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	key := ip.String()
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimit{
+			packets:    newBucket(l.packetRate, now),
+			bytes:      newBucket(l.byteRate, now),
+			handshakes: newBucket(l.handshakeRate, now),
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	if c.bannedUntil.After(now) {
+		return false
+	}
+	if c.packets.take(1, now) && c.bytes.take(float64(n), now) && (!handshake || c.handshakes.take(1, now)) {
+		return true
+	}
+	if l.banDuration <= 0 {
+		return false
+	}
+	c.offenses++
+	d := MaxBanDuration
+	if c.offenses <= 16 && l.banDuration<<(c.offenses-1) < MaxBanDuration {
+		d = l.banDuration << (c.offenses - 1)
+	}
+	c.bannedUntil = now.Add(d)
+	log.Printf("Banned %s for %v: rate limit exceeded (offense #%d)", key, d, c.offenses)
+	if l.onBan != nil {
+		go l.onBan(ip, d) // Session.Close is idempotent, so sessions closed meanwhile are fine
+	}
+	return false
+}
+
+// banned returns whether given IP is banned now.
+func (l *limiter) banned(ip net.IP, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	c, ok := l.clients[ip.String()]
+	return ok && c.bannedUntil.After(now)
+}
+
+// cleanup removes idle client records. Records of banned clients are kept until the ban expires.
+func (l *limiter) cleanup(now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for k, c := range l.clients {
+		if now.Sub(c.lastSeen) > limiterExpire && c.bannedUntil.Before(now) {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// CloseAddress closes every sessions on the router from given IP address, e.g. when the address is banned.
+// Sessions already being closed are skipped.
+func (r *Router) CloseAddress(ip net.IP, reason string) {
+	var sessions []*Session
+	r.sessionLock.Lock()
+	for _, s := range r.sessions {
+		if s.Address.IP.Equal(ip) && !s.Closed() {
+			sessions = append(sessions, s)
+		}
+	}
+	r.sessionLock.Unlock()
+	for _, s := range sessions {
+		s.Close(reason)
+	}
+}
@@ -0,0 +1,226 @@
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newBucket(10, now)
+	for i := 0; i < 20; i++ {
+		if !b.take(1, now) {
+			t.Fatal("Bucket should allow burst of 20, failed at", i)
+		}
+	}
+	if b.take(1, now) {
+		t.Error("Bucket should be empty after burst")
+	}
+	if !b.take(5, now.Add(time.Millisecond*500)) || b.take(1, now.Add(time.Millisecond*500)) {
+		t.Error("Bucket should be refilled by rate")
+	}
+	if !b.take(20, now.Add(time.Hour)) || b.take(1, now.Add(time.Hour)) {
+		t.Error("Bucket should not be refilled over burst")
+	}
+	b = newBucket(0, now)
+	if !b.take(1e9, now) {
+		t.Error("Zero rate bucket should not limit")
+	}
+}
+
+func testLimiter() *limiter {
+	l := newLimiter()
+	l.packetRate, l.byteRate, l.handshakeRate = 100, 10000, 5
+	l.banDuration = time.Second * 10
+	return l
+}
+
+func TestLimiterFlood(t *testing.T) {
+	l := testLimiter()
+	flooder, normal := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	now := time.Now()
+	allowed := 0
+	for i := 0; i < 1000; i++ {
+		if l.allow(flooder, 10, false, now) {
+			allowed++
+		}
+	}
+	if allowed != 200 {
+		t.Error("Flooder should be limited to burst: expected 200, got", allowed)
+	}
+	if !l.banned(flooder, now) || l.banned(normal, now) {
+		t.Error("Only flooder should be banned")
+	}
+	if !l.allow(normal, 10, false, now) {
+		t.Error("Other addresses should not be affected by flooder")
+	}
+	if l.allow(flooder, 10, false, now.Add(time.Second*9)) {
+		t.Error("Banned address should be dropped")
+	}
+
+	now = now.Add(time.Second * 11)
+	if !l.allow(flooder, 10, false, now) {
+		t.Error("Ban should expire after ban duration")
+	}
+	for i := 0; i < 1000; i++ {
+		l.allow(flooder, 10, false, now)
+	}
+	if !l.banned(flooder, now.Add(time.Second*19)) || l.banned(flooder, now.Add(time.Second*21)) {
+		t.Error("Second offense should be banned for doubled duration")
+	}
+}
+
+func TestLimiterBytes(t *testing.T) {
+	l := testLimiter()
+	ip := net.IPv4(10, 0, 0, 3)
+	now := time.Now()
+	if !l.allow(ip, 15000, false, now) {
+		t.Error("Datagram within byte burst should be allowed")
+	}
+	if l.allow(ip, 6000, false, now) || !l.banned(ip, now) {
+		t.Error("Byte rate offender should be banned")
+	}
+}
+
+func TestLimiterHandshake(t *testing.T) {
+	l := testLimiter()
+	ip := net.IPv4(10, 0, 0, 4)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if !l.allow(ip, 30, true, now) {
+			t.Fatal("Handshake should be allowed within burst, failed at", i)
+		}
+	}
+	if l.allow(ip, 30, true, now) || !l.banned(ip, now) {
+		t.Error("Handshake flooder should be banned")
+	}
+}
+
+func TestLimiterEscalation(t *testing.T) {
+	l := testLimiter()
+	l.packetRate = 1
+	ip := net.IPv4(10, 0, 0, 5)
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		for l.allow(ip, 1, false, now) {
+		}
+		c := l.clients[ip.String()]
+		if d := c.bannedUntil.Sub(now); d > MaxBanDuration {
+			t.Fatal("Ban duration should be capped to", MaxBanDuration, "got", d)
+		}
+		now = c.bannedUntil.Add(time.Hour)
+	}
+	l.cleanup(now.Add(limiterExpire * 2))
+	if len(l.clients) != 0 {
+		t.Error("Idle clients should be cleaned up")
+	}
+}
+
+func TestHalfOpenLimit(t *testing.T) {
+	r, err := CreateRouter(nil, nil)
+	if err != nil {
+		t.Fatal("Error while creating router:", err)
+	}
+	defer r.Close()
+	oldMax := MaxHalfOpen
+	defer func() { MaxHalfOpen = oldMax }()
+	MaxHalfOpen = 4
+
+	ocr1 := func(i byte) {
+		r.handlePacket(Packet{
+			Buffer:  new(openConnectionRequest1).Write(Fields{"protocol": byte(6), "mtuSize": 500}),
+			Address: &net.UDPAddr{IP: net.IPv4(10, 0, 1, i), Port: 19132},
+		})
+	}
+	replies := func() (n int) {
+		for {
+			select {
+			case <-r.sendChan:
+				n++
+			case <-time.After(time.Millisecond * 200):
+				return
+			}
+		}
+	}
+	for i := 0; i < 10; i++ {
+		ocr1(byte(i))
+	}
+	if n := r.SessionCount(); n != 4 {
+		t.Error("Half-open sessions should be capped: expected 4, got", n)
+	}
+	if n := replies(); n != 4 {
+		t.Error("Only accepted sessions should reply: expected 4, got", n)
+	}
+	ocr1(0) // Packets from existing sessions should be passed even if the cap is reached.
+	if n := replies(); n != 1 {
+		t.Error("Existing session should reply: expected 1, got", n)
+	}
+
+	other := NewRouter(nil, nil)
+	other.GetSession(&net.UDPAddr{IP: net.IPv4(10, 0, 1, 100), Port: 19132}, nil)
+	if n := other.HalfOpenSessions(); n != 1 {
+		t.Error("Half-open sessions should be counted on each router: expected 1, got", n)
+	}
+	r.CloseAddress(net.IPv4(10, 0, 1, 0), "test")
+	r.CloseAddress(net.IPv4(10, 0, 1, 0), "test")
+	if n := r.HalfOpenSessions(); n != 3 {
+		t.Error("Closed session should be removed from half-open count once: expected 3, got", n)
+	}
+}
+
+func TestAddressFilter(t *testing.T) {
+	r, err := CreateRouter(nil, nil)
+	if err != nil {
+		t.Fatal("Error while creating router:", err)
+	}
+	defer r.Close()
+	blocked := net.IPv4(10, 0, 2, 1)
+	AddressFilter = func(ip net.IP) bool { return !ip.Equal(blocked) }
+	defer func() { AddressFilter = nil }()
+
+	network := NewMemoryNetwork(1)
+	server, err := network.Listen("10.0.2.100:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	ping := func(ip net.IP) bool {
+		client, err := network.Listen(ip.String() + ":19132")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		buf := new(bytes.Buffer)
+		buffer.WriteByte(buf, 0x01)
+		buffer.WriteLong(buf, 1234)
+		buf.Write([]byte(RaknetMagic))
+		r.handlePacket(Packet{Buffer: buf, Address: client.LocalAddr().(*net.UDPAddr), Conn: server})
+		client.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		_, _, err = client.ReadFromUDP(make([]byte, 1500))
+		return err == nil
+	}
+	if ping(blocked) {
+		t.Error("Filtered address should be dropped")
+	}
+	if !ping(net.IPv4(10, 0, 2, 2)) {
+		t.Error("Other addresses should be answered")
+	}
+}
+
+func TestCloseAddressClosed(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 3, 1), Port: 19132}
+	r := NewRouter(nil, nil)
+	s := r.GetSession(addr, nil)
+	s.Status = 3
+	s.packetChan = make(chan *bytes.Buffer)
+	s.Close("kicked")
+	if !s.Closed() {
+		t.Error("Session should be closed")
+	}
+	s.Close("kicked again") // Should not panic on closing packetChan twice
+	r.CloseAddress(addr.IP, "banned")
+}
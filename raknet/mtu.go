@@ -0,0 +1,67 @@
+package raknet
+
+import "log"
+
+// MTU limits for sessions. MTU sizes are counted as raknet datagram length, excluding IP/UDP headers.
+// Clients probe MTU with padded OpenConnectionRequest1 packets, and the server accepts the largest probe
+// within [MinMTU, MaxMTU]. Probes smaller than MinMTU are ignored.
+// These should be set before creating router.
+var (
+	MaxMTU uint16 = 1464 // 1492(PPPoE) - IPv4/UDP headers
+	MinMTU uint16 = 400
+)
+
+// mtuNackLimit is a count of consecutive NACKed large datagrams before lowering session MTU.
+const mtuNackLimit = 3
+
+// splitHeaderSize is a length of split fields in encapsulated packet header.
+const splitHeaderSize = 10
+
+// clampMTU limits given MTU size to [MinMTU, MaxMTU]. Zero is returned if the size is smaller than MinMTU.
+func clampMTU(size int) uint16 {
+	if size < int(MinMTU) {
+		return 0
+	}
+	if size > int(MaxMTU) {
+		return MaxMTU
+	}
+	return uint16(size)
+}
+
+// fallbackMTU returns lowered MTU size for given size. Datagrams larger than this size are treated as large.
+func fallbackMTU(size uint16) uint16 {
+	size = size / 4 * 3
+	if size < MinMTU {
+		return MinMTU
+	}
+	return size
+}
+
+// onDatagramAck resets NACK counter of large datagrams if acknowledged datagram is large.
+// Callers should lock recoveryLock before call.
+func (s *Session) onDatagramAck(dp *DataPacket) {
+	if dp.TotalLen() > int(fallbackMTU(s.mtuSize)) {
+		s.mtuNacks = 0
+	}
+}
+
+// onDatagramNack counts NACKed large datagrams, and lowers session MTU if they are lost repeatedly.
+// Callers should lock recoveryLock before call.
+func (s *Session) onDatagramNack(dp *DataPacket) {
+	lower := fallbackMTU(s.mtuSize)
+	if lower >= s.mtuSize || dp.TotalLen() <= int(lower) {
+		return
+	}
+	s.mtuNacks++
+	if s.mtuNacks < mtuNackLimit {
+		return
+	}
+	log.Println("Lowering MTU size:", s.Key, s.mtuSize, "->", lower)
+	s.mtuSize = lower
+	s.mtuNacks = 0
+}
+
+// splitSize returns maximum payload length of each split part for given packet.
+func (s *Session) splitSize(ep *EncapsulatedPacket) int {
+	return s.maxDatagramSize() - datagramHeaderSize - (ep.TotalLen() - ep.Len()) - splitHeaderSize
+}
@@ -0,0 +1,191 @@
+package raknet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// negotiatedMTU sends OpenConnectionRequest1 probes and OpenConnectionRequest2 with given MTU sizes to new session.
+func negotiatedMTU(probes []int, request uint16) (replies []uint16, mtu uint16) {
+	s := newTestSession()
+	s.mtuSize = 0
+	for _, probe := range probes {
+		new(openConnectionRequest1).Handle(Fields{"protocol": byte(6), "mtuSize": probe}, s)
+	}
+	for {
+		select {
+		case pk := <-s.SendChan:
+			pk.ReadByte()
+			replies = append(replies, new(openConnectionReply1).Read(pk.Buffer)["mtuSize"].(uint16))
+			continue
+		default:
+		}
+		break
+	}
+	new(openConnectionRequest2).Handle(Fields{"mtuSize": request, "clientID": uint64(1)}, s)
+	return replies, s.mtuSize
+}
+
+func TestMTUNegotiation(t *testing.T) {
+	tests := []struct {
+		Probes  []int
+		Request uint16
+		Replies []uint16
+		MTU     uint16
+	}{
+		{[]int{1464}, 1464, []uint16{1464}, 1464},
+		{[]int{2000}, 2000, []uint16{MaxMTU}, MaxMTU},
+		{[]int{300, 1200}, 1464, []uint16{1200}, 1200}, // Probe under MinMTU is ignored, and MTU is limited to the probe
+		{[]int{1000, 1200}, 1200, []uint16{1000, 1200}, 1200},
+		{[]int{1464}, 200, []uint16{1464}, MinMTU},
+	}
+	for _, test := range tests {
+		replies, mtu := negotiatedMTU(test.Probes, test.Request)
+		if len(replies) != len(test.Replies) {
+			t.Error("Reply count mismatch for probes", test.Probes, ": got", replies)
+			continue
+		}
+		for i := range replies {
+			if replies[i] != test.Replies[i] {
+				t.Error("Reply MTU mismatch for probes", test.Probes, ": got", replies)
+			}
+		}
+		if mtu != test.MTU {
+			t.Error("Negotiated MTU mismatch for probes", test.Probes, ": expected", test.MTU, "got", mtu)
+		}
+	}
+}
+
+func TestSplitSize(t *testing.T) {
+	s := newTestSession()
+	s.mtuSize = 576
+	s.SendEncapsulated(&EncapsulatedPacket{Reliability: 3, Buffer: bytes.NewBuffer(make([]byte, 5000))})
+	s.flushQueue(true)
+	total := 0
+	indices := make(map[uint32]bool)
+	for _, dp := range sentDatagrams(s) {
+		if dp.TotalLen() > 576 {
+			t.Error("Datagram overflows MTU:", dp.TotalLen())
+		}
+		for _, ep := range dp.Packets {
+			if !ep.HasSplit || ep.SplitCount != 10 {
+				t.Error("Split count mismatch: expected 10, got", ep.SplitCount)
+			}
+			if indices[ep.MessageIndex] {
+				t.Error("Duplicated message index:", ep.MessageIndex)
+			}
+			indices[ep.MessageIndex] = true
+			total += ep.Len()
+		}
+	}
+	if total != 5000 || len(indices) != 10 {
+		t.Error("Split payload mismatch: length", total, "parts", len(indices))
+	}
+}
+
+func TestMTUFallback(t *testing.T) {
+	s := newTestSession()
+	for i := 0; i < mtuNackLimit*2; i++ {
+		s.SendEncapsulated(&EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 600))})
+	}
+	s.flushQueue(true)
+	sentDatagrams(s)
+	var seqs []uint32
+	for seq := range s.recovery {
+		seqs = append(seqs, seq)
+	}
+	if len(seqs) != mtuNackLimit {
+		t.Fatal("Expected", mtuNackLimit, "datagrams, got", len(seqs))
+	}
+	new(nack).Handle(Fields{"seqs": seqs[:mtuNackLimit-1]}, s)
+	if s.mtuSize != 1400 {
+		t.Error("MTU should not be lowered before", mtuNackLimit, "NACKs")
+	}
+	sentDatagrams(s)
+	new(nack).Handle(Fields{"seqs": seqs[mtuNackLimit-1:]}, s)
+	if s.mtuSize != 1050 {
+		t.Error("MTU should be lowered to 1050, got", s.mtuSize)
+	}
+	dps := sentDatagrams(s)
+	if len(dps) != 2 {
+		t.Fatal("Retransmitted datagram should be divided into 2 datagrams, got", len(dps))
+	}
+	for _, dp := range dps {
+		if dp.TotalLen() > int(s.mtuSize) {
+			t.Error("Retransmitted datagram overflows lowered MTU:", dp.TotalLen())
+		}
+	}
+}
+
+func TestRetransmitSplit(t *testing.T) {
+	s := newTestSession()
+	s.SendEncapsulated(&EncapsulatedPacket{Reliability: 3, Buffer: bytes.NewBuffer(make([]byte, 1300))})
+	s.flushQueue(true)
+	if dps := sentDatagrams(s); len(dps) != 1 || len(dps[0].Packets) != 1 || dps[0].Packets[0].HasSplit {
+		t.Fatal("Packet should be sent in a datagram without split")
+	}
+	var seq uint32
+	for seq = range s.recovery {
+	}
+	s.mtuSize = 1050
+	s.recoveryLock.Lock()
+	s.retransmit(seq)
+	s.recoveryLock.Unlock()
+	s.sendPending()
+	dps := sentDatagrams(s)
+	if len(dps) != 2 {
+		t.Fatal("Packet should be split again into 2 datagrams, got", len(dps))
+	}
+	total := 0
+	for i, dp := range dps {
+		if dp.TotalLen() > int(s.mtuSize) {
+			t.Error("Retransmitted datagram overflows lowered MTU:", dp.TotalLen())
+		}
+		ep := dp.Packets[0]
+		if !ep.HasSplit || ep.SplitCount != 2 || ep.SplitIndex != uint32(i) || ep.OrderIndex != 0 {
+			t.Errorf("Split part %d mismatch: %+v", i, ep)
+		}
+		total += ep.Len()
+	}
+	if total != 1300 {
+		t.Error("Split payload length mismatch: expected 1300, got", total)
+	}
+
+	// Parts don't fit after another fallback, so the packet is split again with new split ID.
+	oldID := dps[0].Packets[0].SplitID
+	first := ^uint32(0)
+	for seq := range s.recovery {
+		if seq < first {
+			first = seq
+		}
+	}
+	s.mtuSize = 600
+	s.recoveryLock.Lock()
+	s.retransmit(first)     // The first part doesn't fit, so the packet is split again
+	s.retransmit(first + 1) // The other old part is dropped
+	s.recoveryLock.Unlock()
+	s.sendPending()
+	dps = sentDatagrams(s)
+	if len(dps) != 3 {
+		t.Fatal("Packet should be split again into 3 datagrams, got", len(dps))
+	}
+	total = 0
+	indices := make(map[uint32]bool)
+	for i, dp := range dps {
+		if dp.TotalLen() > int(s.mtuSize) {
+			t.Error("Retransmitted datagram overflows lowered MTU:", dp.TotalLen())
+		}
+		ep := dp.Packets[0]
+		if !ep.HasSplit || ep.SplitID == oldID || ep.SplitCount != 3 || ep.SplitIndex != uint32(i) || ep.OrderIndex != 0 {
+			t.Errorf("Split part %d mismatch: %+v", i, ep)
+		}
+		if ep.MessageIndex < 2 || indices[ep.MessageIndex] {
+			t.Error("Split part should get new message index:", ep.MessageIndex)
+		}
+		indices[ep.MessageIndex] = true
+		total += ep.Len()
+	}
+	if total != 1300 {
+		t.Error("Split payload length mismatch: expected 1300, got", total)
+	}
+}
@@ -8,20 +8,31 @@ import (
 	"github.com/L7-MCPE/lav7/util/buffer"
 )
 
-// Packet is a struct which contains binary buffer, address, and send time.
+// Packet is a struct which contains binary buffer, address, and the socket packet is received from/sent to.
 type Packet struct {
 	*bytes.Buffer
 	Address *net.UDPAddr
+	Conn    PacketConn
 }
 
 // NewPacket creates new packet with given packet id.
 func NewPacket(pid byte) Packet {
-	return Packet{bytes.NewBuffer([]byte{pid}), new(net.UDPAddr)}
+	return Packet{bytes.NewBuffer([]byte{pid}), new(net.UDPAddr), nil}
 }
 
+// Send priorities for EncapsulatedPacket.
+const (
+	PriorityNormal    byte = iota // Waits on session send queue to be packed with other packets
+	PriorityImmediate             // Flushes session send queue immediately, for latency-sensitive packets
+)
+
+// datagramHeaderSize is a length of DataPacket header: head byte and sequence number triad.
+const datagramHeaderSize = 4
+
 // EncapsulatedPacket is a struct, containing more values for decoding/encoding encapsualted packets.
 type EncapsulatedPacket struct {
 	*bytes.Buffer
+	Priority     byte // Not encoded: used for sending only
 	Reliability  byte
 	HasSplit     bool
 	MessageIndex uint32 // LE Triad
@@ -30,6 +41,8 @@ type EncapsulatedPacket struct {
 	SplitCount   uint32
 	SplitID      uint16
 	SplitIndex   uint32
+
+	source *splitSource // Packet which this part is split from, for sending only
 }
 
 // NewEncapsulated returns decoded EncapsulatedPacket struct from given binary.
@@ -143,7 +156,7 @@ func (dp *DataPacket) Decode() {
 
 // TotalLen returns total buffer length of data packet.
 func (dp *DataPacket) TotalLen() int {
-	length := 4
+	length := datagramHeaderSize
 	for _, d := range dp.Packets {
 		length += d.TotalLen()
 	}
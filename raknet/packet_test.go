@@ -3,21 +3,19 @@ package raknet
 import (
 	"bytes"
 	"encoding/base64"
-	"fmt"
 	"net"
 	"testing"
-	"time"
-)
 
-type ReadCase struct {
-	Total      uint64
-	ReadBefore uint64
-	ReadAfter  uint64
-	ShouldErr  bool
-}
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
 
 func TestRead(t *testing.T) {
-	cases := []ReadCase{
+	cases := []struct {
+		Total      int
+		ReadBefore int
+		ReadAfter  int
+		ShouldErr  bool
+	}{
 		{
 			Total:     32,
 			ReadAfter: 32,
@@ -36,70 +34,72 @@ func TestRead(t *testing.T) {
 	}
 	for _, c := range cases {
 		pk := bytes.NewBuffer(make([]byte, c.Total))
-		if pk.Head() != 0 {
-			t.Error("Head test failed: Expected 0, got", pk.Head())
-			return
-		}
-		pk.Read(c.ReadBefore)
-		if _, err := pk.Read(c.ReadAfter); (err != nil) != c.ShouldErr {
+		buffer.Read(pk, c.ReadBefore)
+		if _, err := buffer.Read(pk, c.ReadAfter); (err != nil) != c.ShouldErr {
 			t.Error("Read test failed:", c, "err exists:", !c.ShouldErr)
-			return
 		}
 	}
 	pk := bytes.NewBuffer([]byte("\xe8\x0f\x0d\xfd\x3f\xdd\xdd\x00\x0a\x00\xfd\xff\xfd\x00\x00\x64\x01\x04"))
-	if n, err := pk.ReadByte(); err != nil || n != 232 {
-		t.Error("ReadByte test failed:", pk, "Result:", n, "Expected: 232", "Error exists: ", err != nil)
+	if n := buffer.ReadByte(pk); n != 232 {
+		t.Error("ReadByte test failed: Result:", n, "Expected: 232")
 	}
-	if n, err := pk.ReadShort(); err != nil || n != 3853 {
-		t.Error("ReadShort test failed:", pk, "Result:", n, "Expected: 3853", "Error exists: ", err != nil)
+	if n := buffer.ReadShort(pk); n != 3853 {
+		t.Error("ReadShort test failed: Result:", n, "Expected: 3853")
 	}
-	if n, err := pk.ReadInt(); err != nil || n != 4248821213 {
-		t.Error("ReadInt test failed:", pk, "Result:", n, "Expected: 4248821213", "Error exists: ", err != nil)
+	if n := buffer.ReadInt(pk); n != 4248821213 {
+		t.Error("ReadInt test failed: Result:", n, "Expected: 4248821213")
 	}
-	if n, err := pk.ReadLong(); err != nil || n != 2815840688603136 {
-		t.Error("ReadLong test failed:", pk, "Result:", n, "Expected: 2815840688603136", "Error exists: ", err != nil)
+	if n := buffer.ReadLong(pk); n != 2815840688603136 {
+		t.Error("ReadLong test failed: Result:", n, "Expected: 2815840688603136")
 	}
-	if n, err := pk.ReadLTriad(); err != nil || n != 262500 {
-		t.Error("ReadLTriad test failed:", pk, "Result:", n, "Expected: 262500", "Error exists: ", err != nil)
+	if n := buffer.ReadLTriad(pk); n != 262500 {
+		t.Error("ReadLTriad test failed: Result:", n, "Expected: 262500")
 	}
 	pk = bytes.NewBuffer(append([]byte{0x00, 0x0d}, []byte("Hello, 世界")...))
-	if s, err := pk.ReadString(); err != nil || s != "Hello, 世界" {
-		t.Error("ReadString tets failed:", pk, "Result:", s, "Expected: Hello, 世界", "Error exists: ", err != nil)
+	if s := buffer.ReadString(pk); s != "Hello, 世界" {
+		t.Error("ReadString test failed: Result:", s, "Expected: Hello, 世界")
 	}
 }
 
 func TestWrite(t *testing.T) {
 	pk := new(bytes.Buffer)
-	pk.WriteByte(4)
-	pk.WriteShort(523)
-	pk.WriteInt(153925)
-	pk.WriteLong(539528483653)
-	pk.WriteString("Hello, 世界")
-	pk.WriteAddress(&net.UDPAddr{
+	buffer.WriteByte(pk, 4)
+	buffer.WriteShort(pk, 523)
+	buffer.WriteInt(pk, 153925)
+	buffer.WriteLong(pk, 539528483653)
+	buffer.WriteString(pk, "Hello, 世界")
+	buffer.WriteAddress(pk, &net.UDPAddr{
 		IP:   []byte{0x7f, 0x00, 0x00, 0x01},
 		Port: 19132,
 	})
-	pk.WriteLTriad(564365)
-	if b, err := pk.ReadByte(); err != nil || b != 4 {
-		t.Error("Test failed: expected 4, got", b, "Error exists:", err != nil)
+	buffer.WriteAddress(pk, &net.UDPAddr{
+		IP:   net.ParseIP("2001:db8::1"),
+		Port: 19133,
+	})
+	buffer.WriteLTriad(pk, 564365)
+	if b := buffer.ReadByte(pk); b != 4 {
+		t.Error("Test failed: expected 4, got", b)
 	}
-	if b, err := pk.ReadShort(); err != nil || b != 523 {
-		t.Error("Test failed: expected 523, got", b, "Error exists:", err != nil)
+	if b := buffer.ReadShort(pk); b != 523 {
+		t.Error("Test failed: expected 523, got", b)
 	}
-	if b, err := pk.ReadInt(); err != nil || b != 153925 {
-		t.Error("Test failed: expected 153925, got", b, "Error exists:", err != nil)
+	if b := buffer.ReadInt(pk); b != 153925 {
+		t.Error("Test failed: expected 153925, got", b)
 	}
-	if b, err := pk.ReadLong(); err != nil || b != 539528483653 {
-		t.Error("Test failed: expected 539528483653, got", b, "Error exists:", err != nil)
+	if b := buffer.ReadLong(pk); b != 539528483653 {
+		t.Error("Test failed: expected 539528483653, got", b)
 	}
-	if s, err := pk.ReadString(); err != nil || s != "Hello, 世界" {
-		t.Error("Test failed: expected Hello, 世界, got", s, "Error exists:", err != nil)
+	if s := buffer.ReadString(pk); s != "Hello, 世界" {
+		t.Error("Test failed: expected Hello, 世界, got", s)
 	}
-	if a, err := pk.ReadAddress(); err != nil || a.String() != "127.0.0.1:19132" {
-		t.Error("Test failed: expected 127.0.0.1:19132, got", a.String(), "Error exists:", err != nil)
+	if a := buffer.ReadAddress(pk); a.String() != "127.0.0.1:19132" {
+		t.Error("Test failed: expected 127.0.0.1:19132, got", a.String())
 	}
-	if b, err := pk.ReadLTriad(); err != nil || b != 564365 {
-		t.Error("Test failed: expected 564365, got", b, "Error exists:", err != nil)
+	if a := buffer.ReadAddress(pk); a.String() != "[2001:db8::1]:19133" {
+		t.Error("Test failed: expected [2001:db8::1]:19133, got", a.String())
+	}
+	if b := buffer.ReadLTriad(pk); b != 564365 {
+		t.Error("Test failed: expected 564365, got", b)
 	}
 }
 
@@ -112,61 +112,46 @@ func TestEncapsulated(t *testing.T) {
 		{"MACQIAAAAgAAAAMACAAAAAE1MTUxNTE1MTUxNTE1MTUxNTE=", 35},
 	}
 	for _, test := range tests {
-		var ep *EncapsulatedPacket
-		var err error
 		b, err := base64.StdEncoding.DecodeString(test.Base64)
 		if err != nil {
-			panic(fmt.Sprint("Error while decoding base64 payload:", err))
-		}
-		if ep, err = NewEncapsulated(bytes.NewBuffer(b)); err != nil {
-			t.Error("Error while creating new EncapsulatedPacket:", err)
-			return
+			t.Fatal("Error while decoding base64 payload:", err)
 		}
+		ep := NewEncapsulated(bytes.NewBuffer(b))
 		if ep.TotalLen() != test.Length {
 			t.Error("EncapsulatedPacket length test failed:", ep.TotalLen(), "!=", test.Length, ep)
-			return
-		}
-		var buf *bytes.Buffer
-		if buf, err = ep.Bytes(); err != nil {
-			t.Error("Error while encoding EncapsulatedPacket:", err)
-			return
+			continue
 		}
-		if !bytes.Equal(buf.Payload, b) {
+		if !bytes.Equal(ep.Bytes().Bytes(), b) {
 			t.Error("EncapsulatedPacket test failed: mismatch after encode/decode")
-			return
 		}
 	}
 }
 
 func TestDataPacket(t *testing.T) {
-	dp := new(DataPacket)
-	dp.Head = 4
-	dp.SeqNumber = 3
-	dp.SendTime = *new(time.Time)
-	dp.Packets = make([]*EncapsulatedPacket, 0)
-	b, _ := base64.StdEncoding.DecodeString("kACQBAAAIAAAAgAAAAMACAAAAAE1MTUxNTE1MTUxNTE1MTUxNTE=")
-	ep, _ := NewEncapsulated(bytes.NewBuffer(b))
-	dp.Packets = append(dp.Packets, ep)
-	b, _ = base64.StdEncoding.DecodeString("MACQIAAAAgAAAAMACAAAAAE1MTUxNTE1MTUxNTE1MTUxNTE=")
-	ep, _ = NewEncapsulated(bytes.NewBuffer(b))
-	dp.Packets = append(dp.Packets, ep)
-	if err := dp.Encode(); err != nil {
-		t.Error("Error while encoding DataPacket:", err)
-		return
-	}
-	dp = &DataPacket{
-		Buffer:    bytes.NewBuffer(dp.Done()),
-		SeqNumber: 0,
-		SendTime:  *new(time.Time),
-		Packets:   make([]*EncapsulatedPacket, 0),
-	}
-	if err := dp.Decode(); err != nil {
-		t.Error("Error while decoding DataPacket:", err)
-		return
-	}
-	bb, _ := dp.Packets[1].Bytes()
-	if !bytes.Equal(bb.Done(), b) {
-		t.Error("DataPacket encode/decode mismatch!")
-		return
+	dp := &DataPacket{Head: 0x84, SeqNumber: 3}
+	var payloads [][]byte
+	for _, s := range []string{
+		"kACQBAAAIAAAAgAAAAMACAAAAAE1MTUxNTE1MTUxNTE1MTUxNTE=",
+		"MACQIAAAAgAAAAMACAAAAAE1MTUxNTE1MTUxNTE1MTUxNTE=",
+	} {
+		b, _ := base64.StdEncoding.DecodeString(s)
+		payloads = append(payloads, b)
+		dp.Packets = append(dp.Packets, NewEncapsulated(bytes.NewBuffer(b)))
+	}
+	dp.Encode()
+	if dp.Len() != dp.TotalLen() {
+		t.Error("DataPacket length mismatch:", dp.Len(), dp.TotalLen())
+	}
+
+	b := dp.Bytes()
+	decoded := &DataPacket{Buffer: bytes.NewBuffer(b[1:])}
+	decoded.Decode()
+	if decoded.SeqNumber != 3 || len(decoded.Packets) != 2 {
+		t.Fatal("DataPacket decode mismatch:", decoded.SeqNumber, len(decoded.Packets))
+	}
+	for i, ep := range decoded.Packets {
+		if !bytes.Equal(ep.Bytes().Bytes(), payloads[i]) {
+			t.Error("DataPacket encode/decode mismatch on packet", i)
+		}
 	}
 }
@@ -5,10 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"net"
 	"testing"
-
-	"github.com/L7-MCPE/lav7/util/buffer"
 )
 
 func TestFieldChecks(t *testing.T) {
@@ -59,7 +56,6 @@ func TestFieldChecks(t *testing.T) {
 	for _, test := range tests {
 		if test.Expect != checkFields(test.Map, test.Fields...) {
 			t.Error("checkFields test failed:", test)
-			return
 		}
 	}
 }
@@ -79,37 +75,26 @@ func TestACK(t *testing.T) {
 	for _, v := range cases {
 		b, err := base64.StdEncoding.DecodeString(v.Base64)
 		if err != nil {
-			t.Error("Error while decoding base64 payload:", err)
-			return
+			t.Fatal("Error while decoding base64 payload:", err)
 		}
 		b = b[1:]
-		var result []uint32
-		result, err = DecodeAck(bytes.NewBuffer(b))
-		if err != nil {
-			t.Error("Error while decoding ACK:", err)
-			return
-		}
+		result := DecodeAck(bytes.NewBuffer(b))
 		if fmt.Sprint(v.Expect) != fmt.Sprint(result) {
 			t.Errorf("ACK decoding result mismatch: \n%v\n%v\ninput: %v", v.Expect, result, b)
 		}
-		var rb *bytes.Buffer
-		rb, err = EncodeAck(v.Expect)
-		if err != nil {
-			t.Error("Error while encoding ACK:", err)
-			return
-		}
-		if !bytes.Equal(rb.Payload, b) {
-			t.Errorf("ACK encoding result mismatch: \n%v\n%v\ninput: %v", b, rb.Payload, v.Expect)
+		if rb := EncodeAck(v.Expect); !bytes.Equal(rb.Bytes(), b) {
+			t.Errorf("ACK encoding result mismatch: \n%v\n%v\ninput: %v", b, rb.Bytes(), v.Expect)
 		}
 	}
 }
 
-func packetB64(b64 string) Packet {
+// packetB64 decodes base64 packet, and returns its buffer without packet ID.
+func packetB64(t *testing.T, b64 string) *bytes.Buffer {
 	b, err := base64.StdEncoding.DecodeString(b64)
 	if err != nil {
-		panic(fmt.Sprint("Error while decoding base64 payload:", err))
+		t.Fatal("Error while decoding base64 payload:", err)
 	}
-	return Packet{bytes.NewBuffer(b[1:]), new(net.UDPAddr)}
+	return bytes.NewBuffer(b[1:])
 }
 
 func TestOCR1(t *testing.T) {
@@ -125,25 +110,17 @@ func TestOCR1(t *testing.T) {
 	handler := new(openConnectionRequest1)
 
 	for _, test := range tests {
-		f, err := handler.Read(packetB64(test.Base64).Buffer)
-		if err != nil {
-			t.Error("Error while reading packet:", err)
-			return
-		}
+		f := handler.Read(packetB64(t, test.Base64))
 		if f["mtuSize"].(int) != test.MtuSize {
 			t.Error("MtuSize mismatch:", f["mtuSize"].(int), test.MtuSize)
-			return
+			continue
 		}
 		if f["protocol"].(byte) != test.Protocol {
 			t.Error("Protocol mismatch:", f["protocol"].(byte), test.Protocol)
-			return
+			continue
 		}
-		if pk, err := handler.Write(f); err != nil {
-			t.Error("Error while writing packet:", err)
-			return
-		} else if !bytes.Equal(pk.Done()[1:], packetB64(test.Base64).Done()) {
-			t.Errorf("Write test failed:\n%s\n\n%s", hex.Dump(pk.Done()[1:]), hex.Dump(packetB64(test.Base64).Done()))
-			return
+		if pk, expect := handler.Write(f), packetB64(t, test.Base64); !bytes.Equal(pk.Bytes()[1:], expect.Bytes()) {
+			t.Errorf("Write test failed:\n%s\n\n%s", hex.Dump(pk.Bytes()[1:]), hex.Dump(expect.Bytes()))
 		}
 	}
 }
@@ -0,0 +1,168 @@
+package raknet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
+
+// QueryMagic is a header of GameSpy4 query packets.
+const QueryMagic = "\xfe\xfd"
+
+// Query packet types
+const (
+	queryHandshake byte = 0x09
+	queryStat      byte = 0x00
+)
+
+// TokenRotation is an interval of query challenge token rotation.
+// Tokens issued in previous interval are also accepted.
+const TokenRotation = time.Second * 30
+
+// QueryInfo contains server status for query responses.
+type QueryInfo struct {
+	ServerName string
+	GameType   string // Defaults to "SMP"
+	Map        string // Level name
+	MaxPlayers int
+	Players    []string
+	Whitelist  bool
+	Plugins    string // Server software and plugin list, e.g. "lav7 1.1.0: plugin1 1.0; plugin2 1.0"
+}
+
+// QueryHandler returns current server status for query responses. If nil, query packets are ignored.
+var QueryHandler func() QueryInfo
+
+// queryTokens issues challenge tokens for query clients.
+// Tokens are derived from client IP and a random secret, so the server does not need to keep per-client states.
+type queryTokens struct {
+	lock     sync.Mutex
+	secrets  [2]uint64 // Current, previous
+	rotation time.Time
+}
+
+func newQueryTokens() *queryTokens {
+	return &queryTokens{
+		secrets:  [2]uint64{uint64(rand.Int63()), uint64(rand.Int63())},
+		rotation: time.Now(),
+	}
+}
+
+func (q *queryTokens) rotate(now time.Time) {
+	if now.Sub(q.rotation) < TokenRotation {
+		return
+	}
+	q.secrets[1], q.secrets[0] = q.secrets[0], uint64(rand.Int63())
+	q.rotation = now
+}
+
+func tokenFor(secret uint64, ip net.IP) int32 {
+	h := fnv.New32a()
+	binary.Write(h, binary.BigEndian, secret)
+	h.Write(ip.To16())
+	return int32(h.Sum32() & 0x7fffffff)
+}
+
+// issue returns challenge token for given address.
+func (q *queryTokens) issue(ip net.IP, now time.Time) int32 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.rotate(now)
+	return tokenFor(q.secrets[0], ip)
+}
+
+// verify checks if the token is issued for given address in current or previous rotation.
+func (q *queryTokens) verify(ip net.IP, token int32, now time.Time) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.rotate(now)
+	return token == tokenFor(q.secrets[0], ip) || token == tokenFor(q.secrets[1], ip)
+}
+
+// handleQuery handles GameSpy4 query packets. The query magic should be read from the buffer.
+// It returns response buffer, or nil if the packet should be ignored.
+func (r *Router) handleQuery(buf *bytes.Buffer, addr *net.UDPAddr, local net.Addr) *bytes.Buffer {
+	if QueryHandler == nil || buf.Len() < 5 {
+		return nil
+	}
+	typ := buffer.ReadByte(buf)
+	sessionID := buffer.ReadInt(buf)
+	now := time.Now()
+	res := new(bytes.Buffer)
+	buffer.WriteByte(res, typ)
+	buffer.WriteInt(res, sessionID)
+	switch typ {
+	case queryHandshake:
+		writeQueryString(res, strconv.Itoa(int(r.queryTokens.issue(addr.IP, now))))
+	case queryStat:
+		if buf.Len() < 4 || !r.queryTokens.verify(addr.IP, int32(buffer.ReadInt(buf)), now) {
+			return nil
+		}
+		info := QueryHandler()
+		if info.GameType == "" {
+			info.GameType = "SMP"
+		}
+		hostIP, hostPort := "0.0.0.0", 0
+		if local, ok := local.(*net.UDPAddr); ok {
+			hostIP, hostPort = local.IP.String(), local.Port
+		}
+		if buf.Len() >= 4 { // Full stat request has 4 bytes of padding
+			writeFullStat(res, info, hostIP, hostPort)
+		} else {
+			writeQueryString(res, info.ServerName)
+			writeQueryString(res, info.GameType)
+			writeQueryString(res, info.Map)
+			writeQueryString(res, strconv.Itoa(len(info.Players)))
+			writeQueryString(res, strconv.Itoa(info.MaxPlayers))
+			buffer.WriteLShort(res, uint16(hostPort))
+			writeQueryString(res, hostIP)
+		}
+	default:
+		return nil
+	}
+	return res
+}
+
+func writeFullStat(res *bytes.Buffer, info QueryInfo, hostIP string, hostPort int) {
+	whitelist := "off"
+	if info.Whitelist {
+		whitelist = "on"
+	}
+	res.WriteString("splitnum\x00\x80\x00")
+	for _, kv := range [][2]string{
+		{"hostname", info.ServerName},
+		{"gametype", info.GameType},
+		{"game_id", "MINECRAFTPE"},
+		{"version", MinecraftVersion},
+		{"server_engine", "lav7"},
+		{"plugins", info.Plugins},
+		{"map", info.Map},
+		{"numplayers", strconv.Itoa(len(info.Players))},
+		{"maxplayers", strconv.Itoa(info.MaxPlayers)},
+		{"whitelist", whitelist},
+		{"hostip", hostIP},
+		{"hostport", strconv.Itoa(hostPort)},
+	} {
+		writeQueryString(res, kv[0])
+		writeQueryString(res, kv[1])
+	}
+	res.WriteString("\x00\x01player_\x00\x00")
+	for _, name := range info.Players {
+		writeQueryString(res, name)
+	}
+	res.WriteByte(0)
+}
+
+// writeQueryString writes null-terminated string. Null characters in the string are removed.
+func writeQueryString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strings.Replace(s, "\x00", "", -1))
+	buf.WriteByte(0)
+}
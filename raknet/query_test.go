@@ -0,0 +1,113 @@
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/util/buffer"
+)
+
+// queryClient sends query packet to the router, and returns the response.
+func queryClient(t *testing.T, conn *MemoryConn, server *net.UDPAddr) func(typ byte, payload []byte) []byte {
+	return func(typ byte, payload []byte) []byte {
+		buf := bytes.NewBuffer([]byte(QueryMagic))
+		buffer.WriteByte(buf, typ)
+		buffer.WriteInt(buf, 0x01020304)
+		buf.Write(payload)
+		conn.WriteToUDP(buf.Bytes(), server)
+		b := make([]byte, 1500)
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		n, _, err := conn.ReadFromUDP(b)
+		if err != nil {
+			return nil
+		}
+		if n < 5 || b[0] != typ || !bytes.Equal(b[1:5], []byte{1, 2, 3, 4}) {
+			t.Fatalf("Invalid query response header: % x", b[:n])
+		}
+		return b[5:n]
+	}
+}
+
+func TestQuery(t *testing.T) {
+	oldHandler := QueryHandler
+	defer func() { QueryHandler = oldHandler }()
+	QueryHandler = func() QueryInfo {
+		return QueryInfo{
+			ServerName: "test server",
+			Map:        "world",
+			MaxPlayers: 20,
+			Players:    []string{"alice", "bob"},
+			Plugins:    "lav7",
+		}
+	}
+
+	network := NewMemoryNetwork(1)
+	serverConn, _ := network.Listen("127.0.0.1:19132")
+	r := NewRouter(nil, nil, serverConn)
+	r.Start()
+	defer r.Close()
+	conn, _ := network.Listen("127.0.0.1:0")
+	defer conn.Close()
+	query := queryClient(t, conn, serverConn.LocalAddr().(*net.UDPAddr))
+
+	res := query(queryHandshake, nil)
+	if len(res) < 2 || res[len(res)-1] != 0 {
+		t.Fatal("Invalid handshake response:", res)
+	}
+	token, err := strconv.Atoi(string(res[:len(res)-1]))
+	if err != nil {
+		t.Fatal("Invalid challenge token:", string(res))
+	}
+	tokenBuf := new(bytes.Buffer)
+	buffer.WriteInt(tokenBuf, uint32(token))
+
+	if res := query(queryStat, []byte{0, 0, 0, 0}); res != nil {
+		t.Error("Stat with invalid token should be ignored")
+	}
+
+	res = query(queryStat, tokenBuf.Bytes())
+	if string(res) != "test server\x00SMP\x00world\x002\x0020\x00\xbc\x4a127.0.0.1\x00" {
+		t.Errorf("Basic stat mismatch: %q", res)
+	}
+
+	res = query(queryStat, append(tokenBuf.Bytes(), 0, 0, 0, 0))
+	parts := strings.SplitN(string(res), "\x00\x01player_\x00\x00", 2)
+	if len(parts) != 2 {
+		t.Fatalf("Full stat has no player section: %q", res)
+	}
+	kv := strings.Split(strings.TrimPrefix(parts[0], "splitnum\x00\x80\x00"), "\x00")
+	values := make(map[string]string)
+	for i := 0; i+1 < len(kv); i += 2 {
+		values[kv[i]] = kv[i+1]
+	}
+	if values["hostname"] != "test server" || values["numplayers"] != "2" || values["hostport"] != "19132" ||
+		values["plugins"] != "lav7" || values["map"] != "world" {
+		t.Errorf("Full stat mismatch: %v", values)
+	}
+	if parts[1] != "alice\x00bob\x00\x00" {
+		t.Errorf("Player list mismatch: %q", parts[1])
+	}
+}
+
+func TestQueryTokenRotation(t *testing.T) {
+	q := newQueryTokens()
+	ip := net.IPv4(10, 0, 0, 1)
+	now := time.Now()
+	token := q.issue(ip, now)
+	if !q.verify(ip, token, now) {
+		t.Error("Issued token should be valid")
+	}
+	if q.verify(net.IPv4(10, 0, 0, 2), token, now) {
+		t.Error("Token should not be valid for other address")
+	}
+	if !q.verify(ip, token, now.Add(TokenRotation)) {
+		t.Error("Token should be valid for one more rotation")
+	}
+	if q.verify(ip, token, now.Add(TokenRotation*2)) {
+		t.Error("Token should expire after two rotations")
+	}
+}
@@ -0,0 +1,125 @@
+package raknet
+
+import (
+	"bytes"
+	"time"
+)
+
+// Replayer feeds captured datagrams to offline sessions, and reports MCPE packets(after 0x8e header) decoded from them.
+// Inbound datagrams go through the same session code as live server, including raknet handlers.
+// Outbound datagrams are decoded with a mirror session, which only reassembles MCPE packets.
+type Replayer struct {
+	// Handler is called with every MCPE packet, in the order of capture.
+	Handler func(inbound bool, t time.Time, buf *bytes.Buffer)
+
+	inbound, outbound *Session
+	inChan, outChan   chan *bytes.Buffer
+	done              chan struct{}
+	now               time.Time
+}
+
+// NewReplayer returns new Replayer which calls given handler with each MCPE packet.
+func NewReplayer(handler func(inbound bool, t time.Time, buf *bytes.Buffer)) *Replayer {
+	InitProtocol()
+	r := &Replayer{
+		Handler: handler,
+		inChan:  make(chan *bytes.Buffer, chanBufsize),
+		outChan: make(chan *bytes.Buffer, chanBufsize),
+		done:    make(chan struct{}),
+	}
+	go r.deliver(r.inChan, true)
+	go r.deliver(r.outChan, false)
+	return r
+}
+
+// newReplaySession creates a session which is not registered on any routers, and discards sent packets.
+func (r *Replayer) newReplaySession(rec *CaptureRecord) *Session {
+	s := new(Session)
+	s.Init(rec.Client)
+	s.updateTicker.Stop()
+	s.timeout.Stop()
+	s.mtuSize = MaxMTU
+	s.SendChan = make(chan Packet, chanBufsize)
+	go func(ch chan Packet) {
+		for range ch {
+		}
+	}(s.SendChan)
+	return s
+}
+
+// Feed processes a captured datagram. MCPE packets decoded from the datagram are passed to Handler before Feed returns.
+func (r *Replayer) Feed(rec *CaptureRecord) {
+	if len(rec.Payload) == 0 {
+		return
+	}
+	r.now = rec.Time
+	pk := Packet{Buffer: bytes.NewBuffer(append([]byte(nil), rec.Payload...)), Address: rec.Client}
+	if rec.Inbound {
+		if r.inbound == nil {
+			r.inbound = r.newReplaySession(rec)
+			r.inbound.playerAdder = func(*Session) chan<- *bytes.Buffer {
+				return r.inChan
+			}
+		}
+		s := r.inbound
+		s.handlePacket(pk)
+		select {
+		case <-s.closed: // Closed by client disconnect: next datagrams start new session
+			r.inbound = nil
+			close(s.SendChan)
+			if s.Status < 3 {
+				r.sync(r.inChan)
+				break
+			}
+			<-r.done // Packet channel is closed with the session
+			r.inChan = make(chan *bytes.Buffer, chanBufsize)
+			go r.deliver(r.inChan, true)
+		default:
+			r.sync(r.inChan)
+		}
+		return
+	}
+	if rec.Payload[0] < 0x80 || rec.Payload[0] > 0x8f { // Only data packets are decoded
+		return
+	}
+	if r.outbound == nil {
+		r.outbound = r.newReplaySession(rec)
+		r.outbound.mirror = true
+		r.outbound.Status = 3
+		r.outbound.packetChan = r.outChan
+	}
+	r.outbound.handlePacket(pk)
+	r.sync(r.outChan)
+}
+
+// sync waits until every packet on given channel is passed to Handler.
+func (r *Replayer) sync(ch chan *bytes.Buffer) {
+	ch <- nil
+	<-r.done
+}
+
+func (r *Replayer) deliver(ch chan *bytes.Buffer, inbound bool) {
+	for buf := range ch {
+		if buf == nil {
+			r.done <- struct{}{}
+			continue
+		}
+		if r.Handler != nil {
+			r.Handler(inbound, r.now, buf)
+		}
+	}
+	r.done <- struct{}{}
+}
+
+// Close stops replay sessions.
+func (r *Replayer) Close() {
+	for _, s := range []*Session{r.inbound, r.outbound} {
+		if s != nil {
+			close(s.SendChan)
+		}
+	}
+	close(r.inChan)
+	<-r.done
+	close(r.outChan)
+	<-r.done
+}
@@ -3,6 +3,7 @@ package raknet
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"math/rand"
 	"net"
 	"sync"
@@ -12,100 +13,326 @@ import (
 	"github.com/L7-MCPE/lav7/util/buffer"
 )
 
-var serverID uint64
-var blockList = make(map[string]time.Time)
-var blockLock = new(sync.Mutex)
-
 // GotBytes is a sum of received packet size.
 var GotBytes uint64
 
 // Router handles packets from network, and manages sessions.
 type Router struct {
-	sessions      []Session
-	conn          *net.UDPConn
+	id            uint64 // Server ID sent with pings and connection replies
+	sessions      map[string]*Session
+	sessionLock   sync.Mutex
+	halfOpen      int32                // Count of sessions not connected yet. Use sync/atomic to access it.
+	blockList     map[string]time.Time // Closed sessions by key, answered with disconnect until the time
+	blockLock     sync.Mutex
+	conns         []PacketConn
 	sendChan      chan Packet
-	playerAdder   func(*net.UDPAddr) chan<- *bytes.Buffer
-	playerRemover func(*net.UDPAddr) error
+	playerAdder   func(*Session) chan<- *bytes.Buffer
+	playerRemover func(*Session) error
+	limiter       *limiter
+	queryTokens   *queryTokens
+	closed        chan struct{}
 }
 
-// CreateRouter create/opens new raknet router with given port.
-func CreateRouter(playerAdder func(*net.UDPAddr) chan<- *bytes.Buffer,
-	playerRemover func(*net.UDPAddr) error, port uint16) (r *Router, err error) {
+// CreateRouter create/opens new raknet router, listening on every given addresses.
+// Addresses should be host:port form, e.g. "0.0.0.0:19132" or "[::]:19133".
+func CreateRouter(playerAdder func(*Session) chan<- *bytes.Buffer,
+	playerRemover func(*Session) error, addresses ...string) (r *Router, err error) {
+	var conns []PacketConn
+	for _, address := range addresses {
+		var conn *net.UDPConn
+		if conn, err = listen(address); err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return
+		}
+		conns = append(conns, conn)
+	}
+	r = NewRouter(playerAdder, playerRemover, conns...)
+	return
+}
+
+// NewRouter creates new raknet router on given connections.
+// Use this with MemoryNetwork connections to run servers without real sockets.
+func NewRouter(playerAdder func(*Session) chan<- *bytes.Buffer,
+	playerRemover func(*Session) error, conns ...PacketConn) (r *Router) {
 	InitProtocol()
-	Sessions = make(map[string]*Session)
 	r = new(Router)
-	serverID = uint64(rand.Int63())
-	r.sessions = make([]Session, 0)
+	r.id = uint64(rand.Int63())
+	r.sessions = make(map[string]*Session)
+	r.blockList = make(map[string]time.Time)
 	r.sendChan = make(chan Packet, chanBufsize)
-	r.conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	r.closed = make(chan struct{})
+	r.conns = conns
+	r.limiter = newLimiter()
+	r.queryTokens = newQueryTokens()
+	r.limiter.onBan = func(ip net.IP, d time.Duration) {
+		r.CloseAddress(ip, "rate limit exceeded")
+	}
 	r.playerAdder = playerAdder
 	r.playerRemover = playerRemover
 	return
 }
 
+// GetSession returns session with given address/socket if exists, or creates new one.
+func (r *Router) GetSession(address *net.UDPAddr, conn PacketConn) *Session {
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	identifier := SessionKey(address, conn)
+	if s, ok := r.sessions[identifier]; ok {
+		return s
+	}
+	log.Println("New session:", identifier)
+	sess := new(Session)
+	sess.Init(address)
+	sess.Key = identifier
+	sess.conn = conn
+	sess.router = r
+	sess.halfOpen = 1
+	atomic.AddInt32(&r.halfOpen, 1)
+	sess.SendChan = r.sendChan
+	sess.playerAdder = r.playerAdder
+	sess.playerRemover = r.playerRemover
+	if CaptureDir != "" {
+		sess.startCapture(CaptureDir)
+	}
+	go sess.work()
+	r.sessions[identifier] = sess
+	return sess
+}
+
+// Session returns session with given key, or nil if the session does not exist.
+func (r *Router) Session(key string) *Session {
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	return r.sessions[key]
+}
+
+// SessionCount returns a count of sessions on the router.
+func (r *Router) SessionCount() int {
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	return len(r.sessions)
+}
+
+// block answers packets to the closed session with disconnect for a while, instead of creating new session.
+func (r *Router) block(s *Session) {
+	r.blockLock.Lock()
+	defer r.blockLock.Unlock()
+	r.blockList[s.Key] = time.Now().Add(time.Second + time.Millisecond*500)
+}
+
+// cleanBlockList removes block list entries expired before given time.
+func (r *Router) cleanBlockList(now time.Time) {
+	r.blockLock.Lock()
+	defer r.blockLock.Unlock()
+	for key, until := range r.blockList {
+		if !until.After(now) {
+			delete(r.blockList, key)
+		}
+	}
+}
+
+// HalfOpenSessions returns a count of sessions on the router which are not connected yet.
+func (r *Router) HalfOpenSessions() int32 {
+	return atomic.LoadInt32(&r.halfOpen)
+}
+
+// removeSession removes closed session from the router.
+func (r *Router) removeSession(s *Session) {
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	if r.sessions[s.Key] == s {
+		delete(r.sessions, s.Key)
+	}
+}
+
+// listen opens UDP socket on given address.
+// IPv6 addresses are listened with "udp6", so that "[::]" does not collide with IPv4 sockets.
+func listen(address string) (*net.UDPConn, error) {
+	network := "udp"
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+			network = "udp4"
+		} else if ip != nil {
+			network = "udp6"
+		}
+	}
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP(network, addr)
+}
+
 // Start makes router process network I/O operations.
 func (r *Router) Start() {
 	go r.sendAsync()
-	go r.receivePacket()
+	go r.cleanLimiter()
+	for _, conn := range r.conns {
+		go r.receivePacket(conn)
+	}
+}
+
+// Close closes every sockets of the router.
+func (r *Router) Close() {
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	for _, conn := range r.conns {
+		conn.Close()
+	}
 }
 
-func (r *Router) receivePacket() {
-	var recvbuf []byte
-	defer r.conn.Close()
+// Addresses returns local addresses the router is listening on.
+func (r *Router) Addresses() (addrs []*net.UDPAddr) {
+	for _, conn := range r.conns {
+		addrs = append(addrs, conn.LocalAddr().(*net.UDPAddr))
+	}
+	return
+}
+
+// recvBufsize is a size of receive buffer, which is larger than any UDP datagram.
+const recvBufsize = 1024 * 64
+
+func (r *Router) receivePacket(conn PacketConn) {
+	recvbuf := make([]byte, recvBufsize)
+	defer conn.Close()
 	for {
-		var n int
-		var addr *net.UDPAddr
-		var err error
-		recvbuf = make([]byte, 1024*1024)
-		if n, addr, err = r.conn.ReadFromUDP(recvbuf); err != nil {
+		n, addr, err := conn.ReadFromUDP(recvbuf)
+		if err != nil {
+			select {
+			case <-r.closed:
+				return
+			default:
+			}
 			fmt.Println("Error while reading packet:", err)
 			continue
 		} else if n > 0 {
 			atomic.AddUint64(&GotBytes, uint64(n))
-			buf := bytes.NewBuffer(recvbuf[0:n])
-			pk := Packet{
-				Buffer:  buf,
+			b := make([]byte, n)
+			copy(b, recvbuf[:n])
+			r.handlePacket(Packet{
+				Buffer:  bytes.NewBuffer(b),
 				Address: addr,
-			}
-			if c, err := buf.ReadByte(); err == nil && c == 0x01 { // Unconnected ping: no need to create session
-				buf.UnreadByte()
-				pingID := buffer.ReadLong(buf)
-				buf := new(bytes.Buffer)
-				buffer.WriteByte(buf, 0x1c)
-				buffer.WriteLong(buf, pingID)
-				buffer.WriteLong(buf, serverID)
-				buf.Write([]byte(RaknetMagic))
-				buffer.WriteString(buf, GetServerString())
-				pk := Packet{
-					Buffer:  buf,
-					Address: addr,
-				}
-				r.sendPacket(pk)
-				continue
-			}
-			buf.UnreadByte()
-			func() {
-				blockLock.Lock()
-				defer blockLock.Unlock()
-				if blockList[addr.String()].After(time.Now()) {
-					r.conn.WriteToUDP([]byte("\x80\x00\x00\x00\x00\x00\x08\x15"), pk.Address)
-				} else {
-					delete(blockList, addr.String())
-					go func() {
-						GetSession(addr, r.sendChan, r.playerAdder, r.playerRemover).ReceivedChan <- pk
-					}()
-				}
-			}()
+				Conn:    conn,
+			})
 		}
 	}
 }
 
+// isHandshake returns whether given packet ID is an unconnected ping or open connection request.
+func isHandshake(pid byte) bool {
+	return pid == 0x01 || pid == 0x02 || pid == 0x05 || pid == 0x07
+}
+
+// handlePacket applies rate limits to received packet, and passes it to the session.
+func (r *Router) handlePacket(pk Packet) {
+	buf, addr := pk.Buffer, pk.Address
+	c, err := buf.ReadByte()
+	if err != nil {
+		return
+	}
+	if AddressFilter != nil && !AddressFilter(addr.IP) {
+		return
+	}
+	if !r.limiter.allow(addr.IP, buf.Len()+1, isHandshake(c), time.Now()) {
+		return
+	}
+	if c == 0x01 { // Unconnected ping: no need to create session
+		buf.UnreadByte()
+		if buf.Len() < 8 {
+			return
+		}
+		pingID := buffer.ReadLong(buf)
+		buf := new(bytes.Buffer)
+		buffer.WriteByte(buf, 0x1c)
+		buffer.WriteLong(buf, pingID)
+		buffer.WriteLong(buf, r.id)
+		buf.Write([]byte(RaknetMagic))
+		buffer.WriteString(buf, GetServerString())
+		r.sendPacket(Packet{
+			Buffer:  buf,
+			Address: addr,
+			Conn:    pk.Conn,
+		})
+		return
+	}
+	if c == QueryMagic[0] { // GameSpy4 query
+		if next, err := buf.ReadByte(); err != nil || next != QueryMagic[1] {
+			return
+		}
+		var local net.Addr
+		if pk.Conn != nil {
+			local = pk.Conn.LocalAddr()
+		}
+		if res := r.handleQuery(buf, addr, local); res != nil {
+			r.sendPacket(Packet{
+				Buffer:  res,
+				Address: addr,
+				Conn:    pk.Conn,
+			})
+		}
+		return
+	}
+	buf.UnreadByte()
+	key := SessionKey(addr, pk.Conn)
+	r.blockLock.Lock()
+	if r.blockList[key].After(time.Now()) {
+		r.blockLock.Unlock()
+		r.sendPacket(Packet{
+			Buffer:  bytes.NewBuffer([]byte("\x80\x00\x00\x00\x00\x00\x08\x15")),
+			Address: addr,
+			Conn:    pk.Conn,
+		})
+		return
+	}
+	delete(r.blockList, key)
+	r.blockLock.Unlock()
+	r.sessionLock.Lock()
+	_, ok := r.sessions[key]
+	r.sessionLock.Unlock()
+	if !ok && MaxHalfOpen > 0 && r.HalfOpenSessions() >= MaxHalfOpen {
+		return
+	}
+	sess := r.GetSession(addr, pk.Conn)
+	select {
+	case sess.ReceivedChan <- pk:
+	default: // Session is flooded: drop the packet
+	}
+}
+
+// cleanLimiter periodically removes idle rate limit records and expired block list entries.
+func (r *Router) cleanLimiter() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closed:
+			return
+		case now := <-ticker.C:
+			r.limiter.cleanup(now)
+			r.cleanBlockList(now)
+		}
+	}
+}
+
+// sendAsync sends packets from sessions until the router is closed.
 func (r *Router) sendAsync() {
-	for pk := range r.sendChan {
-		r.sendPacket(pk)
+	for {
+		select {
+		case pk := <-r.sendChan:
+			r.sendPacket(pk)
+		case <-r.closed:
+			return
+		}
 	}
 }
 
 func (r *Router) sendPacket(pk Packet) {
-	r.conn.WriteToUDP(pk.Buffer.Bytes(), pk.Address)
+	if pk.Conn != nil {
+		pk.Conn.WriteToUDP(pk.Buffer.Bytes(), pk.Address)
+	}
 }
@@ -1,14 +1,138 @@
 package raknet
 
 import (
+	"bytes"
+	"net"
+	"runtime"
 	"testing"
 	"time"
 )
 
 func TestCreateRouter(t *testing.T) {
-	_, err := CreateRouter(19132)
+	r, err := CreateRouter(nil, nil, "127.0.0.1:0", "[::1]:0")
 	time.Sleep(time.Millisecond * 250)
 	if err != nil {
 		t.Error("Test failed: error occured while creating router:", err.Error())
+		return
+	}
+	defer r.Close()
+	if addrs := r.Addresses(); len(addrs) != 2 || addrs[0].IP.To4() == nil || addrs[1].IP.To4() != nil {
+		t.Error("Test failed: router should listen on both IPv4 and IPv6 addresses:", addrs)
+	}
+}
+
+func TestRouterSessions(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 4, 1), Port: 19132}
+	r1, r2 := NewRouter(nil, nil), NewRouter(nil, nil)
+	s1, s2 := r1.GetSession(addr, nil), r2.GetSession(addr, nil)
+	if s1 == s2 || r1.GetSession(addr, nil) != s1 {
+		t.Fatal("Routers should keep their own sessions")
+	}
+	if s1.serverID() == s2.serverID() {
+		t.Error("Routers should have their own server IDs")
+	}
+	r1.CloseAddress(addr.IP, "banned")
+	if !s1.Closed() || s2.Closed() {
+		t.Error("CloseAddress should close sessions only on the router")
+	}
+	for i := 0; i < 100 && r1.Session(s1.Key) != nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if r1.Session(s1.Key) != nil || r2.Session(s2.Key) != s2 {
+		t.Error("Closed session should be removed from its router only")
+	}
+	s2.Close("done")
+}
+
+func TestRouterClose(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	conn, err := network.Listen("10.0.6.100:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	r := NewRouter(nil, nil, conn)
+	r.Start()
+	s := r.GetSession(&net.UDPAddr{IP: net.IPv4(10, 0, 6, 1), Port: 19132}, conn)
+	r.Close()
+	s.Close("done")
+
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < chanBufsize*2; i++ {
+			s.send(bytes.NewBuffer([]byte{0x15}))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Sending on closed router blocks")
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Closed router leaks goroutines: %d before, %d after", before, n)
+	}
+}
+
+func TestBlockListKey(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	conn1, err := network.Listen("10.0.5.100:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+	conn2, err := network.Listen("10.0.5.100:19133")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	r := NewRouter(nil, nil, conn1, conn2)
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 5, 1), Port: 19132}
+	s := r.GetSession(addr, conn1)
+	s.Close("test")
+	for i := 0; i < 100 && r.Session(s.Key) != nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	ocr1 := func(conn PacketConn) *Session {
+		r.handlePacket(Packet{
+			Buffer:  new(openConnectionRequest1).Write(Fields{"protocol": byte(6), "mtuSize": 500}),
+			Address: addr,
+			Conn:    conn,
+		})
+		return r.Session(SessionKey(addr, conn))
+	}
+	if ocr1(conn1) != nil {
+		t.Error("Closed session should be blocked")
+	}
+	if s := ocr1(conn2); s == nil {
+		t.Error("Same address on other socket should not be blocked")
+	} else {
+		s.Close("done")
+	}
+
+	r.cleanBlockList(time.Now())
+	r.blockLock.Lock()
+	n := len(r.blockList)
+	r.blockLock.Unlock()
+	if n == 0 {
+		t.Error("Block list entries are removed before expiry")
+	}
+	r.cleanBlockList(time.Now().Add(time.Second * 2))
+	if len(r.blockList) != 0 {
+		t.Error("Expired block list entries are not removed:", r.blockList)
+	}
+}
+
+func TestShortPing(t *testing.T) {
+	r := NewRouter(nil, nil)
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 5, 2), Port: 19132}
+	for _, b := range [][]byte{{0x01}, {0x01, 0x00}, {0x01, 0, 0, 0, 0, 0, 0, 0}} {
+		r.handlePacket(Packet{Buffer: bytes.NewBuffer(b), Address: addr}) // Should not panic
+	}
+	if r.SessionCount() != 0 {
+		t.Error("Ping should not create session")
 	}
 }
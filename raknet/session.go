@@ -3,11 +3,9 @@ package raknet
 import (
 	"bytes"
 	"log"
-	"math"
 	"math/rand"
 	"net"
 	"runtime/debug"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -18,40 +16,51 @@ import (
 const windowSize = 2048
 const chanBufsize = 256
 
+// orderChannels is a number of ordering channels on raknet sessions.
+const orderChannels = 32
+
+// indexMask masks order indexes, which are 24-bit on the wire.
+const indexMask = 1<<24 - 1
+
+// indexDiff returns a - b on 24-bit index space, in range of [-2^23, 2^23), so that indexes wrap around.
+func indexDiff(a, b uint32) int32 {
+	return int32((a-b)<<8) >> 8
+}
+
 // MaxPingTries defines max retry count on ping timeout.
 // If ping timeouts MaxPingTries + 1 times, session will be closed.
 const MaxPingTries uint64 = 3
 
-// RecoveryTimeout defines how long packets can live on recoery queue.
-// Once the packet is sent, the packet will be on recoery queue in RecoveryTimeout duration.
+// RecoveryTimeout defines the upper bound of retransmission timeout.
+// Once the packet is sent, the packet will be on recovery queue until acknowledged,
+// and will be retransmitted if it is not acknowledged in estimated RTO(at most RecoveryTimeout).
 const RecoveryTimeout = time.Second * 8
 
-// Sessions contains each raknet client sessions.
-var Sessions map[string]*Session
+// Limits for split packet reassembly.
+// Split packets exceeding these limits are dropped, and counted on SplitDrop* counters.
+const (
+	MaxSplits     = 16              // Maximum count of concurrent split IDs per session
+	MaxSplitCount = 512             // Maximum count of parts for a split packet
+	MaxSplitSize  = 1024 * 1024 * 2 // Maximum length of reassembled packet
+	SplitTimeout  = time.Second * 10
+)
+
+// Split packet drop counters for monitoring. Use sync/atomic to read them.
+var (
+	SplitDropOverflow uint64 // Split IDs over MaxSplits
+	SplitDropInvalid  uint64 // Invalid split count/index, or reassembled packet over MaxSplitSize
+	SplitDropTimeout  uint64 // Partial packets evicted after SplitTimeout
+)
 
-// SessionLock is a explicit locker for Sessions map.
-var SessionLock = new(sync.Mutex)
 var timeout = time.Millisecond * 2000
 
-// GetSession returns session with given identifier if exists, or creates new one.
-func GetSession(address *net.UDPAddr, sendChannel chan Packet,
-	playerAdder func(*net.UDPAddr) chan<- *bytes.Buffer,
-	playerRemover func(*net.UDPAddr) error) *Session {
-	SessionLock.Lock()
-	defer SessionLock.Unlock()
-	identifier := address.String()
-	if s, ok := Sessions[identifier]; ok {
-		return s
-	}
-	log.Println("New session:", identifier)
-	sess := new(Session)
-	sess.Init(address)
-	sess.SendChan = sendChannel
-	sess.playerAdder = playerAdder
-	sess.playerRemover = playerRemover
-	go sess.work()
-	Sessions[identifier] = sess
-	return sess
+// SessionKey returns session key on routers for given client address and the socket connected to.
+// Sessions from same client address on different sockets are separated.
+func SessionKey(address *net.UDPAddr, conn PacketConn) string {
+	if conn == nil {
+		return address.String()
+	}
+	return address.String() + "@" + conn.LocalAddr().String()
 }
 
 // Session contains player specific values for raknet-level communication.
@@ -63,15 +72,23 @@ type Session struct {
 	packetChan   chan<- *bytes.Buffer     // Packet delivery to player
 
 	ID           uint64
+	Key          string // Key on the router sessions map
 	Address      *net.UDPAddr
+	conn         PacketConn
 	updateTicker *time.Ticker
 	timeout      *time.Timer
 	mtuSize      uint16
+	mtuProbe     uint16 // Largest MTU probe received with OpenConnectionRequest1
+	mtuNacks     int    // Consecutive NACKed large datagrams
 
 	ackQueue     map[uint32]bool
 	nackQueue    map[uint32]bool
 	recovery     map[uint32]*DataPacket
 	recoveryLock util.Locker
+	congestion   *congestion
+	sendQueue    []*EncapsulatedPacket // Packets waiting for being packed into datagrams
+	queueBytes   int
+	pending      []*bytes.Buffer // Datagrams built by transmit, sent by sendPending
 
 	packetWindow   map[uint32]bool
 	windowBorder   [2]uint32 // Window range: [windowBorder[0], windowBorder[1])
@@ -82,19 +99,30 @@ type Session struct {
 	lastSeq      uint32 // Recv
 	lastMsgIndex uint32
 	splitID      uint16
-	splitTable   map[uint16]map[uint32][]byte
+	splitTable   map[uint16]*splitEntry
 	messageIndex uint32
-	channelIndex [8]uint32
+	channelIndex [orderChannels]uint32
 
-	playerAdder   func(*net.UDPAddr) chan<- *bytes.Buffer
-	playerRemover func(*net.UDPAddr) error
+	orderIndex    [orderChannels]uint32 // Next OrderIndex to be delivered, for reliable ordered packets. Masked by indexMask.
+	orderWindow   [orderChannels]map[uint32]*EncapsulatedPacket
+	sequenceIndex [orderChannels]uint32 // Lowest acceptable OrderIndex, for sequenced packets. Masked by indexMask.
+
+	router        *Router // Router which created the session, or nil for offline sessions
+	playerAdder   func(*Session) chan<- *bytes.Buffer
+	playerRemover func(*Session) error
 	pingTries     uint64
+	halfOpen      int32 // 1 if the session is counted on half-open sessions of the router
+	closing       int32 // 1 if Close is called
 	closed        chan struct{}
+
+	capture *CaptureWriter // Capture file, if CaptureDir is set
+	mirror  bool           // Decodes packets sent by server for replay, without running raknet handlers
 }
 
 // Init sets initial value for session.
 func (s *Session) Init(address *net.UDPAddr) {
 	s.Address = address
+	s.Key = address.String()
 	s.ReceivedChan = make(chan Packet, chanBufsize)
 	s.PlayerChan = make(chan *EncapsulatedPacket, chanBufsize)
 	s.closed = make(chan struct{}, 1)
@@ -105,22 +133,33 @@ func (s *Session) Init(address *net.UDPAddr) {
 	s.nackQueue = make(map[uint32]bool)
 	s.recovery = make(map[uint32]*DataPacket)
 	s.recoveryLock = util.NewMutex()
+	s.congestion = newCongestion()
 	s.packetWindow = make(map[uint32]bool)
 	s.reliableWindow = make(map[uint32]*EncapsulatedPacket)
-	s.splitTable = make(map[uint16]map[uint32][]byte)
+	s.splitTable = make(map[uint16]*splitEntry)
 	s.windowBorder = [2]uint32{0, windowSize}
 	s.reliableBorder = [2]uint32{0, windowSize}
 	s.lastSeq = 1<<32 - 1
 	s.lastMsgIndex = 1<<32 - 1
 }
 
+// leaveHalfOpen removes the session from half-open sessions count of the router. It is safe to call multiple times.
+func (s *Session) leaveHalfOpen() {
+	if atomic.CompareAndSwapInt32(&s.halfOpen, 1, 0) {
+		atomic.AddInt32(&s.router.halfOpen, -1)
+	}
+}
+
 func (s *Session) work() {
 	for {
 		select {
 		case <-s.closed:
-			SessionLock.Lock()
-			delete(Sessions, s.Address.String())
-			SessionLock.Unlock()
+			if s.router != nil {
+				s.router.removeSession(s)
+			}
+			if s.capture != nil {
+				s.capture.Close()
+			}
 			return
 		case pk := <-s.ReceivedChan:
 			s.handlePacket(pk)
@@ -170,15 +209,23 @@ func (s *Session) update() {
 		s.nackQueue = make(map[uint32]bool)
 	}
 	s.recoveryLock.Lock()
-	for seq, pk := range s.recovery {
-		if pk.SendTime.Add(RecoveryTimeout).Before(time.Now()) {
-			s.send(pk.Buffer)
-			delete(s.recovery, seq)
-		} else {
-			break
+	now := time.Now()
+	var lost []uint32
+	for seq, dp := range s.recovery {
+		if now.Sub(dp.SendTime) > s.congestion.rto {
+			lost = append(lost, seq)
+		}
+	}
+	if len(lost) > 0 {
+		s.congestion.onTimeout(now)
+		for _, seq := range lost {
+			s.retransmit(seq)
 		}
 	}
 	s.recoveryLock.Unlock()
+	s.sendPending()
+	s.flushQueue(true)
+	s.evictSplits(now)
 	for seq := range s.packetWindow {
 		if seq < s.windowBorder[0] {
 			delete(s.packetWindow, seq)
@@ -186,7 +233,6 @@ func (s *Session) update() {
 			break
 		}
 	}
-	// TODO: Send datapackets from queue
 }
 
 func (s *Session) handlePacket(pk Packet) {
@@ -201,6 +247,7 @@ func (s *Session) handlePacket(pk Packet) {
 			debug.PrintStack()
 		}
 	}()
+	s.record(true, pk.Bytes())
 	head := buffer.ReadByte(pk.Buffer)
 	if head != 0xa0 && head != 0xc0 {
 		s.timeout.Reset(func() time.Duration {
@@ -246,29 +293,72 @@ func (s *Session) preEncapsulated(ep *EncapsulatedPacket) {
 	}
 }
 
+// splitEntry holds received parts of a split packet.
+type splitEntry struct {
+	parts    [][]byte
+	received uint32
+	size     int
+	created  time.Time
+}
+
 func (s *Session) joinSplits(ep *EncapsulatedPacket) {
 	if s.Status < 3 {
 		return
 	}
+	if ep.SplitCount == 0 || ep.SplitCount > MaxSplitCount || ep.SplitIndex >= ep.SplitCount {
+		atomic.AddUint64(&SplitDropInvalid, 1)
+		return
+	}
 	tab, ok := s.splitTable[ep.SplitID]
 	if !ok {
-		s.splitTable[ep.SplitID] = make(map[uint32][]byte)
-		tab = s.splitTable[ep.SplitID]
+		if len(s.splitTable) >= MaxSplits {
+			atomic.AddUint64(&SplitDropOverflow, 1)
+			return
+		}
+		tab = &splitEntry{
+			parts:   make([][]byte, ep.SplitCount),
+			created: time.Now(),
+		}
+		s.splitTable[ep.SplitID] = tab
+	} else if len(tab.parts) != int(ep.SplitCount) {
+		delete(s.splitTable, ep.SplitID)
+		atomic.AddUint64(&SplitDropInvalid, 1)
+		return
 	}
-	if _, ok := tab[ep.SplitIndex]; !ok {
-		tab[ep.SplitIndex] = ep.Buffer.Bytes()
+	if tab.parts[ep.SplitIndex] == nil {
+		tab.size += ep.Buffer.Len()
+		if tab.size > MaxSplitSize {
+			delete(s.splitTable, ep.SplitID)
+			atomic.AddUint64(&SplitDropInvalid, 1)
+			return
+		}
+		tab.parts[ep.SplitIndex] = ep.Buffer.Bytes()
+		tab.received++
 	}
-	if len(tab) == int(ep.SplitCount) {
+	if tab.received == ep.SplitCount {
 		sep := new(EncapsulatedPacket)
-		sep.Buffer = new(bytes.Buffer)
-		for i := uint32(0); i < ep.SplitCount; i++ {
-			sep.Write(tab[i])
+		sep.Reliability = ep.Reliability
+		sep.OrderIndex = ep.OrderIndex
+		sep.OrderChannel = ep.OrderChannel
+		sep.Buffer = bytes.NewBuffer(make([]byte, 0, tab.size))
+		for _, part := range tab.parts {
+			sep.Write(part)
 		}
 		delete(s.splitTable, ep.SplitID)
 		s.handleEncapsulated(sep)
 	}
 }
 
+// evictSplits removes partial split packets older than SplitTimeout.
+func (s *Session) evictSplits(now time.Time) {
+	for id, tab := range s.splitTable {
+		if now.Sub(tab.created) > SplitTimeout {
+			delete(s.splitTable, id)
+			atomic.AddUint64(&SplitDropTimeout, 1)
+		}
+	}
+}
+
 func (s *Session) handleEncapsulated(ep *EncapsulatedPacket) {
 	if ep.HasSplit {
 		if s.Status > 2 {
@@ -276,11 +366,60 @@ func (s *Session) handleEncapsulated(ep *EncapsulatedPacket) {
 		}
 		return
 	}
+	switch ep.Reliability {
+	case 3: // Reliable ordered
+		s.orderEncapsulated(ep)
+	case 1, 4: // Unreliable/reliable sequenced
+		if ep.OrderChannel >= orderChannels || indexDiff(ep.OrderIndex, s.sequenceIndex[ep.OrderChannel]) < 0 {
+			return
+		}
+		s.sequenceIndex[ep.OrderChannel] = (ep.OrderIndex + 1) & indexMask
+		s.deliverEncapsulated(ep)
+	default:
+		s.deliverEncapsulated(ep)
+	}
+}
+
+// orderEncapsulated delivers reliable ordered packets in OrderIndex order for each channel.
+// Packets arrived earlier than their turn wait on the channel's order window.
+func (s *Session) orderEncapsulated(ep *EncapsulatedPacket) {
+	ch := ep.OrderChannel
+	if ch >= orderChannels {
+		return
+	}
+	if d := indexDiff(ep.OrderIndex, s.orderIndex[ch]); d < 0 || d >= windowSize { // Duplicated or outside of window
+		return
+	}
+	if ep.OrderIndex != s.orderIndex[ch] {
+		if s.orderWindow[ch] == nil {
+			s.orderWindow[ch] = make(map[uint32]*EncapsulatedPacket)
+		}
+		s.orderWindow[ch][ep.OrderIndex] = ep
+		return
+	}
+	s.orderIndex[ch] = (s.orderIndex[ch] + 1) & indexMask
+	s.deliverEncapsulated(ep)
+	for {
+		next, ok := s.orderWindow[ch][s.orderIndex[ch]]
+		if !ok {
+			break
+		}
+		delete(s.orderWindow[ch], s.orderIndex[ch])
+		s.orderIndex[ch] = (s.orderIndex[ch] + 1) & indexMask
+		s.deliverEncapsulated(next)
+	}
+}
+
+// deliverEncapsulated passes complete EncapsulatedPacket to player or raknet data packet handlers.
+func (s *Session) deliverEncapsulated(ep *EncapsulatedPacket) {
 	head := buffer.ReadByte(ep.Buffer)
 
 	if s.Status > 2 && head == 0x8e {
 		s.packetChan <- ep.Buffer
 	}
+	if s.mirror {
+		return
+	}
 
 	if handler := GetDataHandler(head); handler != nil {
 		handler.Handle(handler.Read(ep.Buffer), s)
@@ -291,7 +430,7 @@ func (s *Session) connComplete() {
 	if s.Status != 3 {
 		return
 	}
-	s.packetChan = s.playerAdder(s.Address)
+	s.packetChan = s.playerAdder(s)
 }
 
 // SendEncapsulated processes EncapsulatedPacket informations before sending.
@@ -304,72 +443,248 @@ func (s *Session) SendEncapsulated(ep *EncapsulatedPacket) {
 		ep.OrderIndex = s.channelIndex[ep.OrderChannel]
 		s.channelIndex[ep.OrderChannel]++
 	}
-	if ep.TotalLen()+4 > int(s.mtuSize) { // Need split
-		splitID := s.splitID
-		s.splitID++
-		splitIndex := uint32(0)
-		toSend := ep.Buffer.Len()
-		for ep.Buffer.Len() > 0 {
-			buf := ep.Next(int(s.mtuSize) - 34)
-			sp := new(EncapsulatedPacket)
-			sp.SplitID = splitID
-			sp.HasSplit = true
-			sp.SplitCount = uint32(math.Ceil(float64(ep.Len()) / float64(s.mtuSize-34)))
-			sp.Reliability = ep.Reliability
-			sp.SplitIndex = splitIndex
-			sp.Buffer = bytes.NewBuffer(buf)
-			toSend -= sp.Buffer.Len()
-			if splitIndex > 0 {
-				sp.MessageIndex = s.messageIndex
-				s.messageIndex++
-			} else {
-				sp.MessageIndex = s.messageIndex
-			}
-			if sp.Reliability == 3 {
-				sp.OrderChannel = ep.OrderChannel
-				sp.OrderIndex = ep.OrderIndex
-			}
-			splitIndex++
-			s.sendEncapsulatedDirect(sp)
-		}
-		if toSend != 0 {
-			log.Fatalln("ERROR: toSend assert 0 failed:", toSend)
+	if datagramHeaderSize+ep.TotalLen() > s.maxDatagramSize() { // Need split
+		for _, sp := range s.split(ep) {
+			s.queueEncapsulated(sp)
 		}
 	} else {
-		s.sendEncapsulatedDirect(ep)
+		s.queueEncapsulated(ep)
+	}
+}
+
+// split divides EncapsulatedPacket into parts fitting in current MTU, with new split ID.
+// The first part keeps MessageIndex of the packet, and other parts get new message indexes.
+func (s *Session) split(ep *EncapsulatedPacket) []*EncapsulatedPacket {
+	splitID := s.splitID
+	s.splitID++
+	src := &splitSource{
+		packet: EncapsulatedPacket{
+			Priority:     ep.Priority,
+			Reliability:  ep.Reliability,
+			MessageIndex: ep.MessageIndex,
+			OrderIndex:   ep.OrderIndex,
+			OrderChannel: ep.OrderChannel,
+		},
+		payload: ep.Buffer.Bytes(),
+	}
+	size := s.splitSize(ep)
+	count := uint32((ep.Len() + size - 1) / size)
+	parts := make([]*EncapsulatedPacket, count)
+	for i := uint32(0); i < count; i++ {
+		sp := &EncapsulatedPacket{
+			Buffer:       bytes.NewBuffer(ep.Next(size)),
+			Priority:     ep.Priority,
+			Reliability:  ep.Reliability,
+			HasSplit:     true,
+			MessageIndex: ep.MessageIndex,
+			OrderIndex:   ep.OrderIndex,
+			OrderChannel: ep.OrderChannel,
+			SplitCount:   count,
+			SplitID:      splitID,
+			SplitIndex:   i,
+			source:       src,
+		}
+		if i > 0 && sp.Reliability >= 2 && sp.Reliability != 5 {
+			sp.MessageIndex = s.messageIndex
+			s.messageIndex++
+		}
+		parts[i] = sp
+	}
+	return parts
+}
+
+// splitSource is shared by parts of a split packet, so that the packet can be split again when MTU is lowered.
+type splitSource struct {
+	packet  EncapsulatedPacket // Fields of the packet, without payload
+	payload []byte
+	resplit bool // Set when the packet is split again with new split ID. Old parts are not resent.
+}
+
+// resplit splits the packet of given source again with new split ID, for parts which don't fit in lowered MTU.
+// The receiver may have old parts, so every new part gets new message index.
+// Callers should lock recoveryLock before call.
+func (s *Session) resplit(src *splitSource) []*EncapsulatedPacket {
+	src.resplit = true
+	ep := src.packet
+	ep.Buffer = bytes.NewBuffer(src.payload)
+	if ep.Reliability >= 2 && ep.Reliability != 5 {
+		ep.MessageIndex = s.messageIndex
+		s.messageIndex++
 	}
+	return s.split(&ep)
+}
+
+// queueEncapsulated puts EncapsulatedPacket to send queue.
+// The queue is flushed on update ticks, or when queued packets fill a datagram.
+// If the packet has PriorityImmediate, the queue is flushed right away.
+func (s *Session) queueEncapsulated(ep *EncapsulatedPacket) {
+	s.recoveryLock.Lock()
+	s.sendQueue = append(s.sendQueue, ep)
+	s.queueBytes += ep.TotalLen()
+	s.recoveryLock.Unlock()
+	s.flushQueue(ep.Priority == PriorityImmediate)
 }
 
+// flushQueue packs queued packets into datagrams and sends them, until in-flight datagrams fill congestion window.
+// If force is false, only datagrams filled up to MTU are sent and the remainder waits for the next flush.
+func (s *Session) flushQueue(force bool) {
+	s.recoveryLock.Lock()
+	max := s.maxDatagramSize()
+	for len(s.sendQueue) > 0 && len(s.recovery) < s.congestion.window() {
+		if !force && datagramHeaderSize+s.queueBytes <= max {
+			break
+		}
+		size, n := datagramHeaderSize, 0
+		for n < len(s.sendQueue) {
+			l := s.sendQueue[n].TotalLen()
+			if n > 0 && size+l > max {
+				break
+			}
+			size += l
+			n++
+		}
+		packets := make([]*EncapsulatedPacket, n)
+		copy(packets, s.sendQueue)
+		s.sendQueue = s.sendQueue[n:]
+		s.queueBytes -= size - datagramHeaderSize
+		s.transmit(&DataPacket{
+			Head:    0x80,
+			Packets: packets,
+		})
+	}
+	s.recoveryLock.Unlock()
+	s.sendPending()
+}
+
+// maxDatagramSize returns maximum length of datagrams for the session.
+func (s *Session) maxDatagramSize() int {
+	return int(s.mtuSize)
+}
+
+// sendEncapsulatedDirect sends EncapsulatedPacket immediately, bypassing congestion window.
+// This is used for raknet-level control packets.
 func (s *Session) sendEncapsulatedDirect(ep *EncapsulatedPacket) {
-	dp := new(DataPacket)
-	dp.Head = 0x80
+	s.recoveryLock.Lock()
+	s.transmit(&DataPacket{
+		Head:    0x80,
+		Packets: []*EncapsulatedPacket{ep},
+	})
+	s.recoveryLock.Unlock()
+	s.sendPending()
+}
+
+// transmit assigns new sequence number to DataPacket, puts it to recovery queue and pending datagrams.
+// Callers should lock recoveryLock before call, and call sendPending after unlock.
+func (s *Session) transmit(dp *DataPacket) {
 	dp.SeqNumber = atomic.AddUint32(&s.seqNumber, 1)
-	dp.Packets = []*EncapsulatedPacket{ep}
 	dp.Encode()
-	s.send(dp.Buffer)
 	dp.SendTime = time.Now()
-	s.recoveryLock.Lock()
-	defer s.recoveryLock.Unlock()
 	s.recovery[dp.SeqNumber] = dp
+	s.pending = append(s.pending, dp.Buffer)
+}
+
+// sendPending sends datagrams built by transmit.
+// It should be called without recoveryLock, as sending blocks while the router send channel is full.
+func (s *Session) sendPending() {
+	s.recoveryLock.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.recoveryLock.Unlock()
+	for _, buf := range pending {
+		s.send(buf)
+	}
+}
+
+// retransmit resends lost DataPacket on recovery queue with new sequence number.
+// Callers should lock recoveryLock before call, and call sendPending after unlock.
+// If MTU is lowered after the first transmission, packets in the datagram are resent one by one,
+// and packets larger than the MTU are split again. Split parts can't be divided, so if a part doesn't fit,
+// its whole packet is split again with new split ID, and the other old parts are dropped from retransmission.
+func (s *Session) retransmit(seq uint32) {
+	dp, ok := s.recovery[seq]
+	if !ok {
+		return
+	}
+	delete(s.recovery, seq)
+	packets := make([]*EncapsulatedPacket, 0, len(dp.Packets))
+	for _, ep := range dp.Packets {
+		if ep.source == nil || !ep.source.resplit {
+			packets = append(packets, ep)
+		}
+	}
+	if len(packets) == 0 {
+		return
+	}
+	dp.Packets = packets
+	if dp.TotalLen() <= s.maxDatagramSize() {
+		s.transmit(dp)
+		return
+	}
+	for _, ep := range dp.Packets {
+		if ep.source != nil && ep.source.resplit { // Another part in this datagram is split again
+			continue
+		}
+		parts := []*EncapsulatedPacket{ep}
+		if datagramHeaderSize+ep.TotalLen() > s.maxDatagramSize() {
+			if !ep.HasSplit {
+				parts = s.split(ep)
+			} else if ep.source != nil {
+				parts = s.resplit(ep.source)
+			}
+		}
+		for _, sp := range parts {
+			s.transmit(&DataPacket{
+				Head:    dp.Head,
+				Packets: []*EncapsulatedPacket{sp},
+			})
+		}
+	}
 }
 
 func (s *Session) send(pk *bytes.Buffer) {
-	s.SendChan <- Packet{pk, s.Address}
+	s.record(false, pk.Bytes())
+	var closed chan struct{}
+	if s.router != nil {
+		closed = s.router.closed
+	}
+	select {
+	case s.SendChan <- Packet{pk, s.Address, s.conn}:
+	case <-closed: // Nothing sends packets after the router is closed.
+	}
 }
 
-// Close stops current session.
+// serverID returns server ID of the router, or 0 if the session is not on a router.
+func (s *Session) serverID() uint64 {
+	if s.router == nil {
+		return 0
+	}
+	return s.router.id
+}
+
+// Closed returns whether Close is called on the session.
+func (s *Session) Closed() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+// Close stops current session. It is safe to call multiple times: only the first call closes the session.
 func (s *Session) Close(reason string) {
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		return
+	}
 	s.updateTicker.Stop()
 	s.timeout.Stop()
 	s.closed <- struct{}{}
-	s.playerRemover(s.Address)
+	s.leaveHalfOpen()
+	if s.playerRemover != nil {
+		s.playerRemover(s)
+	}
 	data := &EncapsulatedPacket{Buffer: bytes.NewBuffer([]byte{0x15})}
 	s.sendEncapsulatedDirect(data)
 	if s.Status >= 3 {
 		close(s.packetChan)
 	}
-	blockLock.Lock()
-	defer blockLock.Unlock()
-	blockList[s.Address.String()] = time.Now().Add(time.Second + time.Millisecond*500)
+	if s.router != nil {
+		s.router.block(s)
+	}
 	log.Println("Session closed:", reason)
 }
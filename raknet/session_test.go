@@ -0,0 +1,317 @@
+package raknet
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestSession() *Session {
+	s := new(Session)
+	s.Init(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 19132})
+	s.SendChan = make(chan Packet, chanBufsize)
+	s.mtuSize = 1400
+	return s
+}
+
+// sentDatagrams drains datagrams sent from session, and returns decoded DataPackets.
+func sentDatagrams(s *Session) (dps []*DataPacket) {
+	for {
+		select {
+		case pk := <-s.SendChan:
+			dp := &DataPacket{Buffer: bytes.NewBuffer(pk.Bytes())}
+			dp.Head, _ = dp.ReadByte()
+			dp.Decode()
+			dps = append(dps, dp)
+		default:
+			return
+		}
+	}
+}
+
+func TestSendQueuePacking(t *testing.T) {
+	s := newTestSession()
+	for i := 0; i < 10; i++ {
+		s.SendEncapsulated(&EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 100))})
+	}
+	if dps := sentDatagrams(s); len(dps) != 0 {
+		t.Error("Packets should wait for flush, but", len(dps), "datagrams are sent")
+	}
+	for i := 0; i < 5; i++ {
+		s.SendEncapsulated(&EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 100))})
+	}
+	dps := sentDatagrams(s)
+	if len(dps) != 1 {
+		t.Fatal("Expected 1 full datagram, got", len(dps))
+	}
+	if dps[0].TotalLen() > s.maxDatagramSize() {
+		t.Error("Datagram overflows MTU:", dps[0].TotalLen())
+	}
+	sent := len(dps[0].Packets)
+	s.flushQueue(true)
+	dps = sentDatagrams(s)
+	if len(dps) != 1 || sent+len(dps[0].Packets) != 15 {
+		t.Error("Forced flush should send every remaining packets")
+	}
+	for i, ep := range dps[0].Packets {
+		if ep.MessageIndex != uint32(sent+i) {
+			t.Error("Message index mismatch: expected", sent+i, "got", ep.MessageIndex)
+		}
+	}
+}
+
+func TestSendQueueImmediate(t *testing.T) {
+	s := newTestSession()
+	s.SendEncapsulated(&EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 100))})
+	s.SendEncapsulated(&EncapsulatedPacket{Reliability: 2, Priority: PriorityImmediate, Buffer: bytes.NewBuffer(make([]byte, 30))})
+	dps := sentDatagrams(s)
+	if len(dps) != 1 || len(dps[0].Packets) != 2 {
+		t.Error("Immediate packet should flush whole queue into a datagram")
+	}
+}
+
+func TestSendQueueSplit(t *testing.T) {
+	s := newTestSession()
+	s.SendEncapsulated(&EncapsulatedPacket{Reliability: 2, Buffer: bytes.NewBuffer(make([]byte, 5000))})
+	s.flushQueue(true)
+	total := 0
+	for _, dp := range sentDatagrams(s) {
+		if dp.TotalLen() > s.maxDatagramSize() {
+			t.Error("Datagram overflows MTU:", dp.TotalLen())
+		}
+		for _, ep := range dp.Packets {
+			total += ep.Len()
+		}
+	}
+	if total != 5000 {
+		t.Error("Split payload length mismatch: expected 5000, got", total)
+	}
+}
+
+func TestSendWithoutRecoveryLock(t *testing.T) {
+	s := newTestSession()
+	s.SendChan = make(chan Packet) // Router is not reading
+	go s.SendEncapsulated(&EncapsulatedPacket{Priority: PriorityImmediate, Buffer: bytes.NewBuffer(make([]byte, 10))})
+	time.Sleep(time.Millisecond * 50)
+	done := make(chan struct{})
+	go func() {
+		GetHandler(0xc0).Handle(Fields{"seqs": []uint32{1}}, s) // Locks recoveryLock
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("recoveryLock is held while sending")
+	}
+	<-s.SendChan
+}
+
+// connectedTestSession returns test session which delivers MCPE packets to returned channel.
+func connectedTestSession() (*Session, chan *bytes.Buffer) {
+	s := newTestSession()
+	s.Status = 3
+	ch := make(chan *bytes.Buffer, chanBufsize)
+	s.packetChan = ch
+	return s, ch
+}
+
+// deliveredPayloads drains delivered packets, and returns the first payload byte of each.
+func deliveredPayloads(ch chan *bytes.Buffer) (r []byte) {
+	for {
+		select {
+		case buf := <-ch:
+			b, _ := buf.ReadByte()
+			r = append(r, b)
+		default:
+			return
+		}
+	}
+}
+
+func orderedPacket(msgIndex, orderIndex uint32, channel, payload byte) *EncapsulatedPacket {
+	return &EncapsulatedPacket{
+		Reliability:  3,
+		MessageIndex: msgIndex,
+		OrderIndex:   orderIndex,
+		OrderChannel: channel,
+		Buffer:       bytes.NewBuffer([]byte{0x8e, payload}),
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	tests := []struct {
+		Order  []uint32
+		Expect string
+	}{
+		{[]uint32{0, 1, 2, 3}, "\x00\x01\x02\x03"},
+		{[]uint32{3, 2, 1, 0}, "\x00\x01\x02\x03"},
+		{[]uint32{1, 0, 3, 2}, "\x00\x01\x02\x03"},
+		{[]uint32{2, 0, 0, 1, 2, 3}, "\x00\x01\x02\x03"}, // Duplicates
+	}
+	for _, test := range tests {
+		s, ch := connectedTestSession()
+		for _, i := range test.Order {
+			// MessageIndex is in arrival order, so that reliable window does not reorder packets.
+			s.handleEncapsulated(orderedPacket(i, i, 0, byte(i)))
+		}
+		if r := deliveredPayloads(ch); string(r) != test.Expect {
+			t.Errorf("Ordered delivery mismatch for arrival %v: got %v", test.Order, r)
+		}
+	}
+}
+
+func TestOrderedChannels(t *testing.T) {
+	s, ch := connectedTestSession()
+	s.handleEncapsulated(orderedPacket(0, 1, 0, 1))
+	s.handleEncapsulated(orderedPacket(1, 0, 31, 10))
+	s.handleEncapsulated(orderedPacket(2, 1, 31, 11))
+	if r := deliveredPayloads(ch); string(r) != "\x0a\x0b" {
+		t.Error("Channel 31 should not wait for channel 0: got", r)
+	}
+	s.handleEncapsulated(orderedPacket(3, 0, 0, 0))
+	if r := deliveredPayloads(ch); string(r) != "\x00\x01" {
+		t.Error("Channel 0 delivery mismatch: got", r)
+	}
+	s.handleEncapsulated(orderedPacket(4, 0, 32, 0))
+	if r := deliveredPayloads(ch); len(r) != 0 {
+		t.Error("Packet on invalid channel should be dropped")
+	}
+}
+
+func TestReliableOrderedReorder(t *testing.T) {
+	s, ch := connectedTestSession()
+	// Message indices and order indices both arrive out of order.
+	for _, i := range []uint32{2, 0, 3, 1} {
+		s.preEncapsulated(orderedPacket(i, i, 0, byte(i)))
+	}
+	if r := deliveredPayloads(ch); string(r) != "\x00\x01\x02\x03" {
+		t.Error("Reliable ordered delivery mismatch: got", r)
+	}
+}
+
+func TestSequencedDrop(t *testing.T) {
+	s, ch := connectedTestSession()
+	for _, i := range []uint32{0, 2, 1, 3, 3, 5, 4} {
+		s.handleEncapsulated(&EncapsulatedPacket{
+			Reliability: 1,
+			OrderIndex:  i,
+			Buffer:      bytes.NewBuffer([]byte{0x8e, byte(i)}),
+		})
+	}
+	if r := deliveredPayloads(ch); string(r) != "\x00\x02\x03\x05" {
+		t.Error("Stale sequenced packets should be dropped: got", r)
+	}
+}
+
+func TestIndexWrap(t *testing.T) {
+	s, ch := connectedTestSession()
+	s.orderIndex[0] = indexMask - 1
+	for _, i := range []uint32{indexMask, 1, indexMask - 1, 0} {
+		s.handleEncapsulated(orderedPacket(0, i, 0, byte(i)))
+	}
+	if r := deliveredPayloads(ch); string(r) != "\xfe\xff\x00\x01" {
+		t.Error("Ordered delivery should continue over 24-bit wrap: got", r)
+	}
+	s.handleEncapsulated(orderedPacket(0, indexMask, 0, 0xff))
+	if r := deliveredPayloads(ch); len(r) != 0 {
+		t.Error("Duplicated packet before wrap should be dropped: got", r)
+	}
+
+	s.sequenceIndex[1] = indexMask
+	for _, i := range []uint32{indexMask, 1, 0, 2} {
+		s.handleEncapsulated(&EncapsulatedPacket{
+			Reliability:  1,
+			OrderIndex:   i,
+			OrderChannel: 1,
+			Buffer:       bytes.NewBuffer([]byte{0x8e, byte(i)}),
+		})
+	}
+	if r := deliveredPayloads(ch); string(r) != "\xff\x01\x02" {
+		t.Error("Sequenced delivery should continue over 24-bit wrap: got", r)
+	}
+}
+
+func TestSplitOrdered(t *testing.T) {
+	s, ch := connectedTestSession()
+	split := func(index uint32, payload []byte) *EncapsulatedPacket {
+		ep := orderedPacket(0, 1, 0, 0)
+		ep.HasSplit = true
+		ep.SplitID = 7
+		ep.SplitCount = 2
+		ep.SplitIndex = index
+		ep.Buffer = bytes.NewBuffer(payload)
+		return ep
+	}
+	s.handleEncapsulated(split(1, []byte{0xff}))
+	s.handleEncapsulated(split(0, []byte{0x8e, 1}))
+	if r := deliveredPayloads(ch); len(r) != 0 {
+		t.Error("Joined packet should wait for OrderIndex 0: got", r)
+	}
+	s.handleEncapsulated(orderedPacket(1, 0, 0, 0))
+	if r := deliveredPayloads(ch); string(r) != "\x00\x01" {
+		t.Error("Split ordered delivery mismatch: got", r)
+	}
+}
+
+func splitPacket(id uint16, count, index uint32, payload []byte) *EncapsulatedPacket {
+	return &EncapsulatedPacket{
+		HasSplit:   true,
+		SplitID:    id,
+		SplitCount: count,
+		SplitIndex: index,
+		Buffer:     bytes.NewBuffer(payload),
+	}
+}
+
+func TestSplitLimits(t *testing.T) {
+	s, ch := connectedTestSession()
+	invalid := atomic.LoadUint64(&SplitDropInvalid)
+	s.joinSplits(splitPacket(0, 0, 0, []byte{0x8e}))
+	s.joinSplits(splitPacket(0, MaxSplitCount+1, 0, []byte{0x8e}))
+	s.joinSplits(splitPacket(0, 2, 2, []byte{0x8e}))
+	if len(s.splitTable) != 0 || atomic.LoadUint64(&SplitDropInvalid)-invalid != 3 {
+		t.Error("Invalid split count/index should be dropped")
+	}
+
+	s.joinSplits(splitPacket(0, 2, 0, []byte{0x8e}))
+	s.joinSplits(splitPacket(0, 3, 1, []byte{0x01}))
+	if len(s.splitTable) != 0 {
+		t.Error("Split count mismatch should drop the split ID")
+	}
+
+	part := make([]byte, MaxSplitSize/4+1)
+	for i := uint32(0); i < 4; i++ {
+		s.joinSplits(splitPacket(1, 4, i, part))
+	}
+	if len(s.splitTable) != 0 || len(deliveredPayloads(ch)) != 0 {
+		t.Error("Reassembled packet over MaxSplitSize should be dropped")
+	}
+
+	overflow := atomic.LoadUint64(&SplitDropOverflow)
+	for i := uint16(0); i <= MaxSplits; i++ {
+		s.joinSplits(splitPacket(i, 2, 0, []byte{0x8e}))
+	}
+	if len(s.splitTable) != MaxSplits || atomic.LoadUint64(&SplitDropOverflow)-overflow != 1 {
+		t.Error("Split IDs over MaxSplits should be dropped")
+	}
+}
+
+func TestSplitTimeout(t *testing.T) {
+	s, ch := connectedTestSession()
+	s.joinSplits(splitPacket(0, 2, 0, []byte{0x8e}))
+	timeout := atomic.LoadUint64(&SplitDropTimeout)
+	s.evictSplits(time.Now())
+	if len(s.splitTable) != 1 {
+		t.Error("Fresh split packet should not be evicted")
+	}
+	s.evictSplits(time.Now().Add(SplitTimeout + time.Second))
+	if len(s.splitTable) != 0 || atomic.LoadUint64(&SplitDropTimeout)-timeout != 1 {
+		t.Error("Split packet should be evicted after SplitTimeout")
+	}
+	s.joinSplits(splitPacket(0, 2, 1, []byte{0x01}))
+	if len(deliveredPayloads(ch)) != 0 {
+		t.Error("Evicted split packet should not be joined")
+	}
+}
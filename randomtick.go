@@ -0,0 +1,102 @@
+package lav7
+
+import (
+	"log"
+	"math/rand"
+	"runtime/debug"
+
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// RandomTickSpeed is a count of random block ticks for each 16*16*16 chunk section on every tick.
+// 0 disables random ticks.
+var RandomTickSpeed = 3
+
+// RandomTicker handles random ticks of a block type, e.g. growing crops.
+// Random ticks run on the level goroutine.
+type RandomTicker interface {
+	RandomTick(lv *Level, x, y, z int32, r *rand.Rand)
+}
+
+var randomTickers = map[byte]RandomTicker{}
+
+// RegisterRandomTicker sets the random ticker for given block ID. A nil ticker removes the ticker.
+func RegisterRandomTicker(id types.ID, t RandomTicker) {
+	if t == nil {
+		delete(randomTickers, id.Block())
+		return
+	}
+	randomTickers[id.Block()] = t
+}
+
+// randomTick picks RandomTickSpeed random blocks on each section of loaded chunks, and runs their random tickers.
+// Only chunks held by players are ticked: chunks without references are waiting to be unloaded.
+func (lv *Level) randomTick() {
+	speed := RandomTickSpeed
+	if speed <= 0 || len(randomTickers) == 0 {
+		return
+	}
+	lv.ChunkMutex.Lock()
+	chunks := make(map[[2]int32]*types.Chunk, len(lv.ChunkMap))
+	for cc, c := range lv.ChunkMap {
+		if _, ok := lv.CleanQueue[cc]; !ok {
+			chunks[cc] = c
+		}
+	}
+	lv.ChunkMutex.Unlock()
+
+	var ticks [][3]int32
+	for cc, c := range chunks {
+		c.Mutex().RLock()
+		if c.Refs > 0 {
+			for section := int32(0); section < 8; section++ {
+				for i := 0; i < speed; i++ {
+					n := lv.random.Int31n(16 * 16 * 16)
+					x, y, z := n&0xf, section<<4|n>>8, n>>4&0xf
+					if randomTickers[c.BlockData[blockIndex(x, y, z)]] != nil {
+						ticks = append(ticks, [3]int32{cc[0]<<4 | x, y, cc[1]<<4 | z})
+					}
+				}
+			}
+		}
+		c.Mutex().RUnlock()
+	}
+	for _, pos := range ticks {
+		b, ok := lv.LoadedBlock(pos[0], pos[1], pos[2])
+		if !ok {
+			continue
+		}
+		if t := randomTickers[b.ID]; t != nil {
+			lv.runRandomTick(t, pos)
+		}
+	}
+}
+
+func (lv *Level) runRandomTick(t RandomTicker, pos [3]int32) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while ticking block on %v: %v\n%s", pos, r, debug.Stack())
+		}
+	}()
+	t.RandomTick(lv, pos[0], pos[1], pos[2], lv.random)
+}
+
+// brightness returns the higher one of block light and sky light on given coordinates,
+// or 0 if the chunk is not loaded.
+func (lv *Level) brightness(x, y, z int32) byte {
+	if y < 0 || y > 127 {
+		return MaxLight
+	}
+	c := lv.loadedChunk(x>>4, z>>4)
+	if c == nil {
+		return 0
+	}
+	c.Mutex().RLock()
+	defer c.Mutex().RUnlock()
+	idx := blockIndex(x, y, z)
+	b, s := getNibble(&c.LightData, idx), getNibble(&c.SkyLightData, idx)
+	if b > s {
+		return b
+	}
+	return s
+}
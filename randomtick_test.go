@@ -0,0 +1,109 @@
+package lav7
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// countTicker counts random ticks.
+type countTicker struct {
+	count int
+}
+
+func (c *countTicker) RandomTick(lv *Level, x, y, z int32, r *rand.Rand) {
+	if y < 16 || y >= 32 {
+		panic("random tick out of the section")
+	}
+	c.count++
+}
+
+func TestRandomTick(t *testing.T) {
+	old := randomTickers
+	randomTickers = map[byte]RandomTicker{}
+	defer func() { randomTickers = old }()
+	counter := new(countTicker)
+	RegisterRandomTicker(types.Sponge, counter)
+
+	lv := lightTestLevel(t)
+	held, free := lv.GetChunk(0, 0), lv.GetChunk(1, 0)
+	for _, c := range []*types.Chunk{held, free} {
+		for i := 16 * 16 * 16; i < 16*16*32; i++ {
+			c.BlockData[i] = byte(types.Sponge) // Fill the second section
+		}
+	}
+	held.Refs = 1
+	lv.randomTick()
+	if counter.count != RandomTickSpeed {
+		t.Errorf("Random ticks on the section: got %d, expected %d", counter.count, RandomTickSpeed)
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	lv := lightTestLevel(t)
+	r := rand.New(rand.NewSource(1))
+	tick := func(x, y, z int32, n int) {
+		for i := 0; i < n; i++ {
+			if b, ok := lv.LoadedBlock(x, y, z); ok && randomTickers[b.ID] != nil {
+				randomTickers[b.ID].RandomTick(lv, x, y, z, r)
+			}
+		}
+	}
+
+	// Grass spreads onto lit dirt, and covered grass dies
+	lv.ChangeBlock(2, 5, 2, types.Block{ID: byte(types.Dirt)})
+	tick(1, 5, 2, 200)
+	if b := lv.GetBlock(2, 5, 2); b != byte(types.Grass) {
+		t.Error("Grass should spread onto dirt:", b)
+	}
+	lv.ChangeBlock(4, 6, 4, types.Block{ID: byte(types.Stone)})
+	tick(4, 5, 4, 1)
+	if b := lv.GetBlock(4, 5, 4); b != byte(types.Dirt) {
+		t.Error("Grass under stone should die:", b)
+	}
+
+	// Crops grow on farmland up to the last stage
+	lv.ChangeBlock(6, 5, 6, types.Block{ID: byte(types.Farmland)})
+	lv.ChangeBlock(6, 6, 6, types.Block{ID: byte(types.WheatBlock)})
+	lv.ChangeBlock(7, 6, 6, types.Block{ID: byte(types.WheatBlock)})
+	tick(6, 6, 6, 200)
+	tick(7, 6, 6, 200)
+	if b := lv.Get(6, 6, 6); b.Meta != 7 {
+		t.Error("Wheat should be fully grown:", b)
+	}
+	if b := lv.Get(7, 6, 6); b.Meta != 0 {
+		t.Error("Wheat without farmland should not grow:", b)
+	}
+
+	// Saplings grow into trees, and leaves decay after logs are gone
+	lv.ChangeBlock(-6, 6, -6, types.Block{ID: byte(types.Sapling), Meta: 2})
+	tick(-6, 6, -6, 500)
+	if b := lv.Get(-6, 6, -6); b.ID != byte(types.Log) || b.Meta != 2 {
+		t.Fatal("Sapling should grow into a birch tree:", b)
+	}
+	top := int32(6)
+	for lv.GetBlock(-6, top+1, -6) == byte(types.Log) {
+		top++
+	}
+	leaf := [3]int32{-5, top, -6}
+	if b := lv.Get(leaf[0], leaf[1], leaf[2]); b.ID != byte(types.Leaves) {
+		t.Fatal("Tree should have leaves:", b)
+	}
+	tick(leaf[0], leaf[1], leaf[2], 10)
+	if b := lv.GetBlock(leaf[0], leaf[1], leaf[2]); b != byte(types.Leaves) {
+		t.Error("Leaves near logs should not decay")
+	}
+	for y := int32(6); y <= top; y++ {
+		lv.ChangeBlock(-6, y, -6, types.Block{})
+	}
+	tick(leaf[0], leaf[1], leaf[2], 1)
+	if b := lv.GetBlock(leaf[0], leaf[1], leaf[2]); b != 0 {
+		t.Error("Leaves without logs should decay:", b)
+	}
+	lv.ChangeBlock(0, 20, 0, types.Block{ID: byte(types.Leaves), Meta: leavesNoDecay})
+	tick(0, 20, 0, 1)
+	if b := lv.GetBlock(0, 20, 0); b != byte(types.Leaves) {
+		t.Error("Leaves placed by players should not decay")
+	}
+}
@@ -0,0 +1,225 @@
+// Package rcon implements Source RCON protocol server, for remote server consoles.
+//
+// Each packet is little-endian int32 length, request ID and type, followed by null-terminated body and a null byte.
+// Clients authenticate with SERVERDATA_AUTH packet, and run commands with SERVERDATA_EXECCOMMAND packets.
+// Long command outputs are split into multiple SERVERDATA_RESPONSE_VALUE packets.
+package rcon
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Packet types
+const (
+	TypeResponseValue int32 = 0
+	TypeExecCommand   int32 = 2
+	TypeAuthResponse  int32 = 2
+	TypeAuth          int32 = 3
+)
+
+// MaxBodySize is a maximum body length of response packets. Longer outputs are split.
+const MaxBodySize = 4096
+
+// maxPacketSize is a maximum length of request packets, excluding length field.
+const maxPacketSize = 4096 + 10
+
+// AuthTimeout is a time limit for clients to authenticate after connection.
+const AuthTimeout = time.Second * 10
+
+// IdleTimeout closes connections without any packets for the duration.
+const IdleTimeout = time.Minute * 10
+
+// Packet is a RCON packet.
+type Packet struct {
+	ID   int32
+	Type int32
+	Body string
+}
+
+// ReadPacket reads a RCON packet from given reader.
+func ReadPacket(rd io.Reader) (*Packet, error) {
+	var size int32
+	if err := binary.Read(rd, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 10 || size > maxPacketSize {
+		return nil, errors.New("invalid packet size")
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rd, b); err != nil {
+		return nil, err
+	}
+	if b[size-2] != 0 || b[size-1] != 0 {
+		return nil, errors.New("packet is not null-terminated")
+	}
+	return &Packet{
+		ID:   int32(binary.LittleEndian.Uint32(b)),
+		Type: int32(binary.LittleEndian.Uint32(b[4:])),
+		Body: string(b[8 : size-2]),
+	}, nil
+}
+
+// WritePacket writes a RCON packet to given writer.
+func WritePacket(wr io.Writer, pk *Packet) error {
+	b := make([]byte, 14+len(pk.Body))
+	binary.LittleEndian.PutUint32(b, uint32(10+len(pk.Body)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(pk.ID))
+	binary.LittleEndian.PutUint32(b[8:], uint32(pk.Type))
+	copy(b[12:], pk.Body)
+	_, err := wr.Write(b)
+	return err
+}
+
+// Server is a RCON server.
+type Server struct {
+	password string
+	handler  func(command string) string
+	listener net.Listener
+
+	lock   sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Listen opens RCON server on given TCP address. Commands from authenticated clients are passed to the handler,
+// and the returned string is sent back to the client. Empty password is not allowed.
+func Listen(address, password string, handler func(command string) string) (*Server, error) {
+	if password == "" {
+		return nil, errors.New("RCON password is empty")
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		password: password,
+		handler:  handler,
+		listener: l,
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Addr returns the address server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts connections until the server is closed.
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(time.Millisecond * 100)
+				continue
+			}
+			log.Println("Error while accepting RCON connection:", err)
+			return
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// Close stops the server, and closes every connections.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+	rd := bufio.NewReader(conn)
+	wr := bufio.NewWriter(conn)
+	authed := false
+	for {
+		if authed {
+			conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(AuthTimeout))
+		}
+		pk, err := ReadPacket(rd)
+		if err != nil {
+			return
+		}
+		switch {
+		case pk.Type == TypeAuth:
+			WritePacket(wr, &Packet{ID: pk.ID, Type: TypeResponseValue})
+			if subtle.ConstantTimeCompare([]byte(pk.Body), []byte(s.password)) != 1 {
+				log.Println("RCON authentication failed from", conn.RemoteAddr())
+				WritePacket(wr, &Packet{ID: -1, Type: TypeAuthResponse})
+				wr.Flush()
+				return
+			}
+			authed = true
+			log.Println("RCON client connected from", conn.RemoteAddr())
+			WritePacket(wr, &Packet{ID: pk.ID, Type: TypeAuthResponse})
+		case !authed:
+			return
+		case pk.Type == TypeExecCommand:
+			log.Println("RCON", conn.RemoteAddr(), "issued command:", pk.Body)
+			output := s.handler(pk.Body)
+			for {
+				body := output
+				if len(body) > MaxBodySize {
+					// Don't split multi-byte characters between packets
+					n := MaxBodySize
+					for n > 0 && !utf8.RuneStart(body[n]) {
+						n--
+					}
+					if n == 0 {
+						n = MaxBodySize
+					}
+					body = body[:n]
+				}
+				WritePacket(wr, &Packet{ID: pk.ID, Type: TypeResponseValue, Body: body})
+				output = output[len(body):]
+				if output == "" {
+					break
+				}
+			}
+		case pk.Type == TypeResponseValue:
+			// Clients may send empty SERVERDATA_RESPONSE_VALUE after command to find the end of multi-packet response.
+			// Mirror it, so the client knows every response packets for the command are received.
+			WritePacket(wr, &Packet{ID: pk.ID, Type: TypeResponseValue})
+		}
+		if err := wr.Flush(); err != nil {
+			return
+		}
+	}
+}
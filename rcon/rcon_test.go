@@ -0,0 +1,140 @@
+package rcon
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func startTestServer(t *testing.T) *Server {
+	s, err := Listen("127.0.0.1:0", "secret", func(cmd string) string {
+		if cmd == "long" {
+			return strings.Repeat("a", MaxBodySize*2+10)
+		}
+		if cmd == "unicode" {
+			return "ab" + strings.Repeat("\uD55C", MaxBodySize)
+		}
+		return "ran " + cmd
+	})
+	if err != nil {
+		t.Fatal("Error while listening:", err)
+	}
+	go s.Serve()
+	return s
+}
+
+type testClient struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func dialTest(t *testing.T, s *Server) *testClient {
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal("Error while connecting:", err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	return &testClient{conn: conn, rd: bufio.NewReader(conn)}
+}
+
+func (c *testClient) request(t *testing.T, pk *Packet) {
+	if err := WritePacket(c.conn, pk); err != nil {
+		t.Fatal("Error while writing packet:", err)
+	}
+}
+
+func (c *testClient) read(t *testing.T) *Packet {
+	pk, err := ReadPacket(c.rd)
+	if err != nil {
+		t.Fatal("Error while reading packet:", err)
+	}
+	return pk
+}
+
+func TestAuth(t *testing.T) {
+	s := startTestServer(t)
+	defer s.Close()
+
+	c := dialTest(t, s)
+	defer c.conn.Close()
+	c.request(t, &Packet{ID: 7, Type: TypeAuth, Body: "wrong"})
+	c.read(t)
+	if pk := c.read(t); pk.Type != TypeAuthResponse || pk.ID != -1 {
+		t.Error("Auth with wrong password should fail:", pk)
+	}
+	if _, err := ReadPacket(c.rd); err == nil {
+		t.Error("Connection should be closed after auth failure")
+	}
+
+	c = dialTest(t, s)
+	defer c.conn.Close()
+	c.request(t, &Packet{ID: 8, Type: TypeExecCommand, Body: "stop"})
+	if _, err := ReadPacket(c.rd); err == nil {
+		t.Error("Command without auth should close connection")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s := startTestServer(t)
+	defer s.Close()
+	c := dialTest(t, s)
+	defer c.conn.Close()
+
+	c.request(t, &Packet{ID: 1, Type: TypeAuth, Body: "secret"})
+	if pk := c.read(t); pk.Type != TypeResponseValue || pk.ID != 1 {
+		t.Error("Empty response value expected before auth response:", pk)
+	}
+	if pk := c.read(t); pk.Type != TypeAuthResponse || pk.ID != 1 {
+		t.Fatal("Auth failed:", pk)
+	}
+
+	c.request(t, &Packet{ID: 2, Type: TypeExecCommand, Body: "netstat"})
+	if pk := c.read(t); pk.ID != 2 || pk.Type != TypeResponseValue || pk.Body != "ran netstat" {
+		t.Error("Command response mismatch:", pk)
+	}
+
+	c.request(t, &Packet{ID: 3, Type: TypeExecCommand, Body: "long"})
+	c.request(t, &Packet{ID: 4, Type: TypeResponseValue})
+	var body string
+	packets := 0
+	for {
+		pk := c.read(t)
+		if pk.ID == 4 {
+			break
+		}
+		if pk.ID != 3 || len(pk.Body) > MaxBodySize {
+			t.Fatal("Invalid multi-packet response:", pk.ID, len(pk.Body))
+		}
+		body += pk.Body
+		packets++
+	}
+	if packets != 3 || len(body) != MaxBodySize*2+10 {
+		t.Error("Multi-packet response mismatch:", packets, "packets,", len(body), "bytes")
+	}
+
+	c.request(t, &Packet{ID: 5, Type: TypeExecCommand, Body: "unicode"})
+	c.request(t, &Packet{ID: 6, Type: TypeResponseValue})
+	body = ""
+	for {
+		pk := c.read(t)
+		if pk.ID == 6 {
+			break
+		}
+		if len(pk.Body) > MaxBodySize || !utf8.ValidString(pk.Body) {
+			t.Fatal("Response split in the middle of character:", len(pk.Body))
+		}
+		body += pk.Body
+	}
+	if body != "ab"+strings.Repeat("\uD55C", MaxBodySize) {
+		t.Error("Unicode response mismatch:", len(body), "bytes")
+	}
+}
+
+func TestEmptyPassword(t *testing.T) {
+	if _, err := Listen("127.0.0.1:0", "", nil); err == nil {
+		t.Error("Empty password should not be allowed")
+	}
+}
@@ -0,0 +1,137 @@
+package lav7
+
+import (
+	"container/heap"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// Task is a function scheduled on a level tick.
+type Task struct {
+	fn        func()
+	next      uint64 // Tick to run the task
+	period    uint64 // Interval of repeating tasks. Zero for one-shot tasks.
+	seq       uint64 // Order of scheduling, for tasks on the same tick
+	cancelled int32
+}
+
+// Cancel cancels the task. Tasks already running are not interrupted.
+func (t *Task) Cancel() {
+	atomic.StoreInt32(&t.cancelled, 1)
+}
+
+// Cancelled returns if the task is cancelled.
+func (t *Task) Cancelled() bool {
+	return atomic.LoadInt32(&t.cancelled) == 1
+}
+
+// taskQueue is a min-heap of tasks ordered by next tick.
+type taskQueue []*Task
+
+func (q taskQueue) Len() int { return len(q) }
+func (q taskQueue) Less(i, j int) bool {
+	if q[i].next != q[j].next {
+		return q[i].next < q[j].next
+	}
+	return q[i].seq < q[j].seq
+}
+func (q taskQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *taskQueue) Push(x interface{}) { *q = append(*q, x.(*Task)) }
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return t
+}
+
+// Scheduler runs tasks on given ticks. Tasks can be scheduled from any goroutines,
+// and run on the goroutine calling Run, usually the level goroutine.
+type Scheduler struct {
+	lock    sync.Mutex
+	queue   taskQueue
+	seq     uint64
+	current uint64 // Last tick passed to Run
+}
+
+// schedule adds task running after delay ticks. Delays less than 1 are treated as 1, so tasks scheduled
+// while running tasks do not run on the same tick.
+func (s *Scheduler) schedule(delay, period uint64, fn func()) *Task {
+	if delay < 1 {
+		delay = 1
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	t := &Task{
+		fn:     fn,
+		next:   s.current + delay,
+		period: period,
+		seq:    s.seq,
+	}
+	heap.Push(&s.queue, t)
+	return t
+}
+
+// RunLater schedules the function to run after given ticks.
+func (s *Scheduler) RunLater(delay int, fn func()) *Task {
+	if delay < 0 {
+		delay = 0
+	}
+	return s.schedule(uint64(delay), 0, fn)
+}
+
+// RunRepeating schedules the function to run after given ticks, and then every period ticks until cancelled.
+func (s *Scheduler) RunRepeating(delay, period int, fn func()) *Task {
+	if delay < 0 {
+		delay = 0
+	}
+	if period < 1 {
+		period = 1
+	}
+	return s.schedule(uint64(delay), uint64(period), fn)
+}
+
+// Pending returns count of scheduled tasks, including cancelled tasks not removed yet.
+func (s *Scheduler) Pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.queue)
+}
+
+// Run runs every tasks due on given tick. Panics in tasks are logged.
+func (s *Scheduler) Run(tick uint64) {
+	s.lock.Lock()
+	s.current = tick
+	s.lock.Unlock()
+	for {
+		s.lock.Lock()
+		if len(s.queue) == 0 || s.queue[0].next > tick {
+			s.lock.Unlock()
+			return
+		}
+		t := heap.Pop(&s.queue).(*Task)
+		if t.period > 0 && !t.Cancelled() {
+			t.next += t.period
+			if t.next <= tick { // Catch up without running the task several times in a tick
+				t.next = tick + 1
+			}
+			heap.Push(&s.queue, t)
+		}
+		s.lock.Unlock()
+		if !t.Cancelled() {
+			runTask(t)
+		}
+	}
+}
+
+func runTask(t *Task) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while running scheduled task: %v\n%s", r, debug.Stack())
+		}
+	}()
+	t.fn()
+}
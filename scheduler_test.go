@@ -0,0 +1,77 @@
+package lav7
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s := new(Scheduler)
+	var got []string
+	record := func(name string) func() {
+		return func() { got = append(got, name) }
+	}
+	s.RunLater(2, record("later2"))
+	s.RunLater(0, record("now"))
+	s.RunLater(2, record("later2b"))
+	repeat := s.RunRepeating(1, 2, record("repeat"))
+	s.RunLater(1, func() {
+		got = append(got, "nested")
+		s.RunLater(0, record("nested-next"))
+	})
+	cancelled := s.RunLater(1, record("cancelled"))
+	cancelled.Cancel()
+	s.RunLater(1, func() { panic("task panic should be recovered") })
+
+	expected := [][]string{
+		1: {"now", "repeat", "nested"},
+		2: {"later2", "later2b", "nested-next"},
+		3: {"repeat"},
+		4: nil,
+		5: {"repeat"},
+	}
+	for tick := uint64(1); tick <= 5; tick++ {
+		got = nil
+		s.Run(tick)
+		if !reflect.DeepEqual(got, expected[tick]) {
+			t.Errorf("Tick %d: got %v, expected %v", tick, got, expected[tick])
+		}
+	}
+	repeat.Cancel()
+	got = nil
+	s.Run(6)
+	s.Run(7)
+	if len(got) != 0 || s.Pending() != 0 {
+		t.Error("Cancelled repeating task should not run:", got, s.Pending())
+	}
+}
+
+func TestLevelTick(t *testing.T) {
+	lv := &Level{Name: "ticktest"}
+	lv.Init(memoryProvider{})
+	defer lv.Ticker.Stop()
+	go lv.Process()
+	defer func() { lv.Stop <- struct{}{} }()
+
+	done := make(chan uint64, 1)
+	count := 0
+	lv.Scheduler.RunRepeating(0, 1, func() {
+		count++
+		if count == 10 {
+			done <- lv.CurrentTick()
+		}
+	})
+	select {
+	case tick := <-done:
+		if tick < 10 {
+			t.Error("Task should run on each tick: tick", tick)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Scheduled task is not run by the tick loop")
+	}
+	s := lv.TickStats()
+	if s.TPS <= 0 || s.TPS > TicksPerSecond || s.Tick < 10 {
+		t.Errorf("Tick stats mismatch: %+v", s)
+	}
+}
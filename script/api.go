@@ -0,0 +1,437 @@
+package script
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/L7-MCPE/lav7"
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Script API:
+//
+//	server.command{name=, aliases={}, usage=, description=, permission=, run=function(sender, args) end}
+//	    Registers a command. args is a list of argument strings. Returning false prints the usage.
+//	server.on(event, function(ev) end[, priority])
+//	    Subscribes to an event: "join", "chat", "place", "break", "move" or "quit".
+//	    Priority is one of "lowest", "low", "normal"(default), "high", "highest" or "monitor".
+//	    Set ev.cancelled = true to cancel the event. Changes to other fields are applied, if the event allows.
+//	server.after(seconds, fn), server.every(seconds, fn)
+//	    Schedules delayed or repeating task on ticks of the default level, and returns task ID.
+//	server.cancel(id)
+//	    Cancels scheduled task.
+//	server.broadcast(message), server.players(), server.player(name), server.level([name])
+//
+//	player:name(), player:send_message(msg), player:has_permission(node), player:position(), player:level(),
+//	player:kick(reason)
+//	level:name(), level:get(x, y, z) -> id, meta, level:set(x, y, z, id[, meta])
+//
+// Command senders which are not players, e.g. console, have name, send_message and has_permission methods.
+// player:kick does not wait for the player to be disconnected, so quit handlers run after the calling function.
+
+const (
+	playerType = "lav7.player"
+	senderType = "lav7.sender"
+	levelType  = "lav7.level"
+)
+
+// minInterval is a minimum interval of repeating tasks.
+const minInterval = time.Millisecond * 50
+
+var priorities = map[string]lav7.EventPriority{
+	"lowest":  lav7.PriorityLowest,
+	"low":     lav7.PriorityLow,
+	"normal":  lav7.PriorityNormal,
+	"high":    lav7.PriorityHigh,
+	"highest": lav7.PriorityHighest,
+	"monitor": lav7.PriorityMonitor,
+}
+
+// task is a scheduled function of the script.
+type task struct {
+	task *lav7.Task
+}
+
+func (s *Script) serverTable() *lua.LTable {
+	L := s.state
+	senderMethods := map[string]lua.LGFunction{
+		"name": func(L *lua.LState) int {
+			L.Push(lua.LString(checkSender(L).Name()))
+			return 1
+		},
+		"send_message": func(L *lua.LState) int {
+			checkSender(L).SendMessage(L.CheckString(2))
+			return 0
+		},
+		"has_permission": func(L *lua.LState) int {
+			L.Push(lua.LBool(checkSender(L).HasPermission(L.CheckString(2))))
+			return 1
+		},
+	}
+	mt := L.NewTypeMetatable(senderType)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), senderMethods))
+
+	playerMethods := map[string]lua.LGFunction{
+		"position": func(L *lua.LState) int {
+			pos := checkPlayer(L).Position
+			L.Push(lua.LNumber(pos.X))
+			L.Push(lua.LNumber(pos.Y))
+			L.Push(lua.LNumber(pos.Z))
+			return 3
+		},
+		"level": func(L *lua.LState) int {
+			L.Push(s.levelValue(checkPlayer(L).Level))
+			return 1
+		},
+		"kick": func(L *lua.LState) int {
+			// Kick fires quit event, which needs the script lock held by the caller.
+			go checkPlayer(L).Kick(L.OptString(2, "Kicked by script"))
+			return 0
+		},
+	}
+	for name, fn := range senderMethods {
+		playerMethods[name] = fn
+	}
+	mt = L.NewTypeMetatable(playerType)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), playerMethods))
+
+	mt = L.NewTypeMetatable(levelType)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"name": func(L *lua.LState) int {
+			L.Push(lua.LString(checkLevel(L).Name))
+			return 1
+		},
+		"get": func(L *lua.LState) int {
+			b := checkLevel(L).Get(checkPosition(L, 2))
+			L.Push(lua.LNumber(b.ID))
+			L.Push(lua.LNumber(b.Meta))
+			return 2
+		},
+		"set": func(L *lua.LState) int {
+			lv := checkLevel(L)
+			x, y, z := checkPosition(L, 2)
+			b := types.Block{ID: byte(L.CheckInt(5)), Meta: byte(L.OptInt(6, 0))}
+			lv.Set(x, y, z, b)
+			lv.BroadcastPacket(&proto.UpdateBlock{
+				BlockRecords: []proto.BlockRecord{{
+					X:     uint32(x),
+					Y:     byte(y),
+					Z:     uint32(z),
+					Block: b,
+					Flags: proto.UpdateAllPriority,
+				}},
+			})
+			return 0
+		},
+	}))
+
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"command":   s.registerCommand,
+		"on":        s.subscribe,
+		"after":     func(L *lua.LState) int { return s.schedule(L, false) },
+		"every":     func(L *lua.LState) int { return s.schedule(L, true) },
+		"cancel":    s.cancelTask,
+		"broadcast": func(L *lua.LState) int { lav7.Message(L.CheckString(1)); return 0 },
+		"players": func(L *lua.LState) int {
+			t := L.NewTable()
+			lav7.AsPlayers(func(p *lav7.Player) {
+				if p.Username != "" {
+					t.Append(s.playerValue(p))
+				}
+			})
+			L.Push(t)
+			return 1
+		},
+		"player": func(L *lua.LState) int {
+			L.Push(s.playerValue(lav7.FindPlayer(L.CheckString(1))))
+			return 1
+		},
+		"level": func(L *lua.LState) int {
+			if L.GetTop() == 0 {
+				L.Push(s.levelValue(lav7.GetDefaultLevel()))
+			} else {
+				L.Push(s.levelValue(lav7.GetLevel(L.CheckString(1))))
+			}
+			return 1
+		},
+	})
+}
+
+func checkSender(L *lua.LState) lav7.CommandSender {
+	ud := L.CheckUserData(1)
+	if sender, ok := ud.Value.(lav7.CommandSender); ok {
+		return sender
+	}
+	L.ArgError(1, "command sender expected")
+	return nil
+}
+
+func checkPlayer(L *lua.LState) *lav7.Player {
+	ud := L.CheckUserData(1)
+	if p, ok := ud.Value.(*lav7.Player); ok {
+		return p
+	}
+	L.ArgError(1, "player expected")
+	return nil
+}
+
+func checkLevel(L *lua.LState) *lav7.Level {
+	ud := L.CheckUserData(1)
+	if lv, ok := ud.Value.(*lav7.Level); ok {
+		return lv
+	}
+	L.ArgError(1, "level expected")
+	return nil
+}
+
+func checkPosition(L *lua.LState, n int) (x, y, z int32) {
+	x, y, z = int32(L.CheckInt(n)), int32(L.CheckInt(n+1)), int32(L.CheckInt(n+2))
+	if y < 0 || y > 127 {
+		L.ArgError(n+1, "y should be in 0-127")
+	}
+	return
+}
+
+func (s *Script) userData(v interface{}, typ string) lua.LValue {
+	ud := s.state.NewUserData()
+	ud.Value = v
+	s.state.SetMetatable(ud, s.state.GetTypeMetatable(typ))
+	return ud
+}
+
+func (s *Script) playerValue(p *lav7.Player) lua.LValue {
+	if p == nil {
+		return lua.LNil
+	}
+	return s.userData(p, playerType)
+}
+
+func (s *Script) senderValue(sender lav7.CommandSender) lua.LValue {
+	if p, ok := sender.(*lav7.Player); ok {
+		return s.playerValue(p)
+	}
+	return s.userData(sender, senderType)
+}
+
+func (s *Script) levelValue(lv *lav7.Level) lua.LValue {
+	if lv == nil {
+		return lua.LNil
+	}
+	return s.userData(lv, levelType)
+}
+
+func (s *Script) registerCommand(L *lua.LState) int {
+	t := L.CheckTable(1)
+	name := lua.LVAsString(t.RawGetString("name"))
+	run, ok := t.RawGetString("run").(*lua.LFunction)
+	if name == "" || !ok {
+		L.ArgError(1, "command name and run function are required")
+	}
+	cmd := &lav7.Command{
+		Name:        name,
+		Usage:       lua.LVAsString(t.RawGetString("usage")),
+		Description: lua.LVAsString(t.RawGetString("description")),
+		Permission:  lua.LVAsString(t.RawGetString("permission")),
+		Execute: func(sender lav7.CommandSender, args *lav7.CommandArgs) error {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if s.closed {
+				return errClosed
+			}
+			list := s.state.NewTable()
+			for args.Len() > 0 {
+				arg, _ := args.String()
+				list.Append(lua.LString(arg))
+			}
+			ret, err := s.call(run, 1, s.senderValue(sender), list)
+			if err != nil {
+				return err
+			}
+			if ret[0] == lua.LFalse {
+				return lav7.ErrUsage
+			}
+			return nil
+		},
+	}
+	if aliases, ok := t.RawGetString("aliases").(*lua.LTable); ok {
+		aliases.ForEach(func(_, v lua.LValue) {
+			cmd.Aliases = append(cmd.Aliases, lua.LVAsString(v))
+		})
+	}
+	if err := lav7.RegisterCommand(cmd); err != nil {
+		L.RaiseError("%v", err)
+	}
+	s.commands = append(s.commands, name)
+	return 0
+}
+
+func (s *Script) subscribe(L *lua.LState) int {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	priority, ok := priorities[strings.ToLower(L.OptString(3, "normal"))]
+	if !ok {
+		L.ArgError(3, "invalid priority")
+	}
+	handler := s.eventHandler(name, fn)
+	if handler == nil {
+		L.ArgError(1, "unknown event: "+name)
+	}
+	sub, err := lav7.Subscribe(handler, priority)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	s.subs = append(s.subs, sub)
+	return 0
+}
+
+// fireEvent calls Lua event handler with event table. fill sets fields of the table before the call,
+// and apply reads modified fields after the call. Cancellation is handled with "cancelled" field.
+func (s *Script) fireEvent(fn *lua.LFunction, c *lav7.Cancellable, fill func(t *lua.LTable), apply func(t *lua.LTable)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	t := s.state.NewTable()
+	fill(t)
+	if c != nil {
+		t.RawSetString("cancelled", lua.LBool(c.Cancelled()))
+	}
+	if _, err := s.call(fn, 0, t); err != nil {
+		log.Printf("[%s] %v", s.Name, err)
+		return
+	}
+	if c != nil {
+		c.SetCancelled(lua.LVAsBool(t.RawGetString("cancelled")))
+	}
+	if apply != nil {
+		apply(t)
+	}
+}
+
+// eventHandler returns typed event handler for lav7.Subscribe, or nil if the event name is unknown.
+func (s *Script) eventHandler(name string, fn *lua.LFunction) interface{} {
+	switch name {
+	case "join":
+		return func(ev *lav7.PlayerJoinEvent) {
+			s.fireEvent(fn, &ev.Cancellable, func(t *lua.LTable) {
+				t.RawSetString("player", s.playerValue(ev.Player))
+				t.RawSetString("message", lua.LString(ev.Message))
+				t.RawSetString("kick_message", lua.LString(ev.KickMessage))
+			}, func(t *lua.LTable) {
+				ev.Message = lua.LVAsString(t.RawGetString("message"))
+				ev.KickMessage = lua.LVAsString(t.RawGetString("kick_message"))
+			})
+		}
+	case "chat":
+		return func(ev *lav7.PlayerChatEvent) {
+			s.fireEvent(fn, &ev.Cancellable, func(t *lua.LTable) {
+				t.RawSetString("player", s.playerValue(ev.Player))
+				t.RawSetString("message", lua.LString(ev.Message))
+			}, func(t *lua.LTable) {
+				ev.Message = lua.LVAsString(t.RawGetString("message"))
+			})
+		}
+	case "place":
+		return func(ev *lav7.BlockPlaceEvent) {
+			s.fireEvent(fn, &ev.Cancellable, func(t *lua.LTable) {
+				s.blockFields(t, ev.Player, ev.Level, ev.X, ev.Y, ev.Z, ev.Block)
+			}, func(t *lua.LTable) {
+				ev.Block.ID = byte(lua.LVAsNumber(t.RawGetString("id")))
+				ev.Block.Meta = byte(lua.LVAsNumber(t.RawGetString("meta")))
+			})
+		}
+	case "break":
+		return func(ev *lav7.BlockBreakEvent) {
+			s.fireEvent(fn, &ev.Cancellable, func(t *lua.LTable) {
+				s.blockFields(t, ev.Player, ev.Level, ev.X, ev.Y, ev.Z, ev.Block)
+			}, nil)
+		}
+	case "move":
+		return func(ev *lav7.PlayerMoveEvent) {
+			s.fireEvent(fn, &ev.Cancellable, func(t *lua.LTable) {
+				t.RawSetString("player", s.playerValue(ev.Player))
+				t.RawSetString("from_x", lua.LNumber(ev.From.X))
+				t.RawSetString("from_y", lua.LNumber(ev.From.Y))
+				t.RawSetString("from_z", lua.LNumber(ev.From.Z))
+				t.RawSetString("x", lua.LNumber(ev.To.X))
+				t.RawSetString("y", lua.LNumber(ev.To.Y))
+				t.RawSetString("z", lua.LNumber(ev.To.Z))
+			}, func(t *lua.LTable) {
+				ev.To = vector.Vector3{
+					X: float32(lua.LVAsNumber(t.RawGetString("x"))),
+					Y: float32(lua.LVAsNumber(t.RawGetString("y"))),
+					Z: float32(lua.LVAsNumber(t.RawGetString("z"))),
+				}
+			})
+		}
+	case "quit":
+		return func(ev *lav7.PlayerQuitEvent) {
+			s.fireEvent(fn, nil, func(t *lua.LTable) {
+				t.RawSetString("player", s.playerValue(ev.Player))
+				t.RawSetString("message", lua.LString(ev.Message))
+			}, func(t *lua.LTable) {
+				ev.Message = lua.LVAsString(t.RawGetString("message"))
+			})
+		}
+	}
+	return nil
+}
+
+func (s *Script) blockFields(t *lua.LTable, p *lav7.Player, lv *lav7.Level, x, y, z int32, b types.Block) {
+	t.RawSetString("player", s.playerValue(p))
+	t.RawSetString("level", s.levelValue(lv))
+	t.RawSetString("x", lua.LNumber(x))
+	t.RawSetString("y", lua.LNumber(y))
+	t.RawSetString("z", lua.LNumber(z))
+	t.RawSetString("id", lua.LNumber(b.ID))
+	t.RawSetString("meta", lua.LNumber(b.Meta))
+}
+
+// schedule handles server.after and server.every. Callers hold the script lock, as it is called from Lua.
+// Tasks run on the default level goroutine, like other level tasks.
+func (s *Script) schedule(L *lua.LState, repeat bool) int {
+	d := time.Duration(float64(L.CheckNumber(1)) * float64(time.Second))
+	fn := L.CheckFunction(2)
+	if repeat && d < minInterval {
+		d = minInterval
+	}
+	ticks := int(d * lav7.TicksPerSecond / time.Second)
+	s.lastTask++
+	id := s.lastTask
+	t := new(task)
+	run := func() {
+		s.lock.Lock()
+		if s.closed || s.tasks[id] != t {
+			s.lock.Unlock()
+			return
+		}
+		if !repeat {
+			delete(s.tasks, id)
+		}
+		s.lock.Unlock()
+		s.run(fn)
+	}
+	scheduler := lav7.GetDefaultLevel().Scheduler
+	if repeat {
+		t.task = scheduler.RunRepeating(ticks, ticks, run)
+	} else {
+		t.task = scheduler.RunLater(ticks, run)
+	}
+	s.tasks[id] = t
+	L.Push(lua.LNumber(id))
+	return 1
+}
+
+func (s *Script) cancelTask(L *lua.LState) int {
+	id := L.CheckInt(1)
+	if t, ok := s.tasks[id]; ok {
+		t.task.Cancel()
+		delete(s.tasks, id)
+	}
+	return 0
+}
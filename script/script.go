@@ -0,0 +1,307 @@
+// Package script runs server-side Lua scripts with an embedded, pure-Go interpreter.
+//
+// Each *.lua file in the script directory is loaded into its own sandboxed Lua state. Scripts can not access
+// files or network: only base, table, string, math and coroutine libraries are available, without dofile,
+// loadfile and require. Server APIs are provided with the global "server" table; see api.go for the list.
+//
+// Scripts are reloaded when their files change. Commands, event handlers and tasks of the old script are
+// removed before the new script is loaded.
+package script
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/L7-MCPE/lav7"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// CallTimeout is a time limit for each call into scripts, including loading.
+// Scripts running longer are interrupted with an error, so infinite loops do not block the server.
+var CallTimeout = time.Second
+
+// Script is a loaded Lua script file.
+type Script struct {
+	Name    string // File name without directory
+	path    string
+	modTime time.Time
+
+	lock     sync.Mutex // Lua states are not goroutine-safe: every calls should hold it.
+	state    *lua.LState
+	closed   bool
+	commands []string
+	subs     []*lav7.Subscription
+	tasks    map[int]*task
+	lastTask int
+}
+
+// errClosed is returned when calling into unloaded scripts.
+var errClosed = errors.New("script is unloaded")
+
+func newScript(path string, modTime time.Time) *Script {
+	s := &Script{
+		Name:    filepath.Base(path),
+		path:    path,
+		modTime: modTime,
+		tasks:   make(map[int]*task),
+	}
+	s.state = lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   200,
+		RegistrySize:    1024 * 4,
+		RegistryMaxSize: 1024 * 256,
+	})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+	} {
+		s.state.Push(s.state.NewFunction(lib.fn))
+		s.state.Push(lua.LString(lib.name))
+		s.state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		s.state.SetGlobal(name, lua.LNil)
+	}
+	s.state.SetGlobal("print", s.state.NewFunction(s.print))
+	s.state.SetGlobal("server", s.serverTable())
+	return s
+}
+
+// load runs the script source.
+func (s *Script) load(source []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn, err := s.state.Load(bytes.NewReader(source), s.Name)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(fn, 0)
+	return err
+}
+
+// call calls Lua function with time limit, and returns nret results. Callers should hold the lock.
+func (s *Script) call(fn *lua.LFunction, nret int, args ...lua.LValue) ([]lua.LValue, error) {
+	if s.closed {
+		return nil, errClosed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CallTimeout)
+	defer cancel()
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	if err := s.state.CallByParam(lua.P{Fn: fn, NRet: nret, Protect: true}, args...); err != nil {
+		return nil, err
+	}
+	ret := make([]lua.LValue, nret)
+	for i := nret - 1; i >= 0; i-- {
+		ret[i] = s.state.Get(-1)
+		s.state.Pop(1)
+	}
+	return ret, nil
+}
+
+// run calls Lua function from outside of the script, e.g. event handlers or tasks, and logs errors.
+func (s *Script) run(fn *lua.LFunction, args ...lua.LValue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if _, err := s.call(fn, 0, args...); err != nil {
+		log.Printf("[%s] %v", s.Name, err)
+	}
+}
+
+// unload removes every commands, event handlers and tasks of the script, and closes Lua state.
+func (s *Script) unload() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, name := range s.commands {
+		lav7.UnregisterCommand(name)
+	}
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+	for _, t := range s.tasks {
+		t.task.Cancel()
+	}
+	s.state.Close()
+}
+
+func (s *Script) print(L *lua.LState) int {
+	texts := make([]string, L.GetTop())
+	for i := range texts {
+		texts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	log.Printf("[%s] %s", s.Name, strings.Join(texts, "\t"))
+	return 0
+}
+
+// Manager loads scripts from a directory, and reloads them on file changes.
+type Manager struct {
+	Dir string
+
+	lock    sync.Mutex
+	scripts map[string]*Script // Path -> script
+	stop    chan struct{}
+}
+
+// NewManager creates script manager for given directory. Scripts are loaded on Poll.
+func NewManager(dir string) *Manager {
+	return &Manager{
+		Dir:     dir,
+		scripts: make(map[string]*Script),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Poll loads new or changed scripts, and unloads removed scripts.
+func (m *Manager) Poll() {
+	files, err := filepath.Glob(filepath.Join(m.Dir, "*.lua"))
+	if err != nil {
+		log.Println("Error while listing scripts:", err)
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	found := make(map[string]struct{})
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		found[path] = struct{}{}
+		old, ok := m.scripts[path]
+		if ok && old.modTime.Equal(info.ModTime()) {
+			continue
+		}
+		if ok {
+			old.unload()
+			delete(m.scripts, path)
+		}
+		s, err := m.load(path, info.ModTime())
+		if err != nil {
+			log.Printf("Error while loading script %s: %v", filepath.Base(path), err)
+		}
+		// Keep failed scripts too, so they are not loaded again until the file changes.
+		m.scripts[path] = s
+	}
+	for path, s := range m.scripts {
+		if _, ok := found[path]; !ok {
+			s.unload()
+			delete(m.scripts, path)
+			log.Printf("Unloaded script %s", s.Name)
+		}
+	}
+}
+
+func (m *Manager) load(path string, modTime time.Time) (*Script, error) {
+	s := newScript(path, modTime)
+	source, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = s.load(source)
+	}
+	if err != nil {
+		s.unload()
+		return s, err
+	}
+	log.Printf("Loaded script %s", s.Name)
+	return s, nil
+}
+
+// Reload unloads and loads every scripts again.
+func (m *Manager) Reload() {
+	m.Close()
+	m.Poll()
+}
+
+// Scripts returns names of loaded scripts, sorted.
+func (m *Manager) Scripts() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var names []string
+	for _, s := range m.scripts {
+		s.lock.Lock()
+		if !s.closed {
+			names = append(names, s.Name)
+		}
+		s.lock.Unlock()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Watch polls the script directory with given interval, until Stop is called.
+func (m *Manager) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Poll()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Stop stops watching, and unloads every scripts.
+func (m *Manager) Stop() {
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	m.Close()
+}
+
+// Close unloads every scripts.
+func (m *Manager) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for path, s := range m.scripts {
+		s.unload()
+		delete(m.scripts, path)
+	}
+}
+
+// Register adds "scripts" command for the manager, which lists or reloads scripts.
+func (m *Manager) Register() error {
+	return lav7.RegisterCommand(&lav7.Command{
+		Name:        "scripts",
+		Usage:       "[reload]",
+		Description: "Shows loaded scripts, or reloads them.",
+		Permission:  "lav7.command.scripts",
+		Execute: func(sender lav7.CommandSender, args *lav7.CommandArgs) error {
+			switch args.Peek() {
+			case "":
+			case "reload":
+				m.Reload()
+			default:
+				return lav7.ErrUsage
+			}
+			names := m.Scripts()
+			sender.SendMessage(fmt.Sprintf("Scripts (%d): %s", len(names), strings.Join(names, ", ")))
+			return nil
+		},
+	})
+}
@@ -0,0 +1,340 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7"
+	"github.com/L7-MCPE/lav7/gen"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/raknet/client"
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// testSender is a CommandSender which records messages.
+type testSender struct {
+	messages []string
+}
+
+func (s *testSender) Name() string              { return "tester" }
+func (s *testSender) SendMessage(msg string)    { s.messages = append(s.messages, msg) }
+func (s *testSender) HasPermission(string) bool { return true }
+func (s *testSender) output() string            { return strings.Join(s.messages, "\n") }
+func (s *testSender) reset()                    { s.messages = nil }
+
+// memoryProvider is a level format which does not save anything.
+type memoryProvider struct{}
+
+func (memoryProvider) Init(string)                          {}
+func (memoryProvider) Loadable(int32, int32) (string, bool) { return "", false }
+func (memoryProvider) LoadChunk(int32, int32, string) (*types.Chunk, error) {
+	return nil, errors.New("memoryProvider does not load chunks")
+}
+func (memoryProvider) WriteChunk(int32, int32, *types.Chunk) error { return nil }
+func (memoryProvider) SaveAll(map[[2]int32]*types.Chunk) error     { return nil }
+
+var testLevelOnce sync.Once
+
+// startTestLevel initializes the default level with flat chunks, and runs its ticks for scheduled tasks.
+func startTestLevel() {
+	testLevelOnce.Do(func() {
+		lav7.LevelDir = ""
+		g := new(gen.FlatGenerator)
+		g.Init()
+		lv := lav7.GetDefaultLevel()
+		lv.Init(memoryProvider{})
+		lv.Gen = g.Gen
+		go lv.Process()
+	})
+}
+
+// loginTestClient starts lav7.DefaultServer on an in-memory network, and logs in a client with given name.
+// The returned function disconnects the client, and stops the server.
+func loginTestClient(t *testing.T, username string) func() {
+	startTestLevel()
+	atomic.StoreInt32(&raknet.MaxPlayers, 20)
+	network := raknet.NewMemoryNetwork(1)
+	conn, err := network.Listen("127.0.0.1:19132")
+	if err != nil {
+		t.Fatal("Error while listening:", err)
+	}
+	r := raknet.NewRouter(lav7.RegisterPlayer, lav7.UnregisterPlayer, conn)
+	lav7.DefaultServer.Router = r
+	r.Start()
+	stop := func() {
+		r.Close()
+		lav7.DefaultServer.Router = nil
+	}
+	if conn, err = network.Listen("127.0.0.1:40000"); err != nil {
+		stop()
+		t.Fatal("Error while listening:", err)
+	}
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:19132")
+	c, err := client.DialConn(conn, addr)
+	if err == nil {
+		if err = c.Login(username); err == nil {
+			err = c.WaitSpawn(time.Second * 30)
+		}
+		if err != nil {
+			c.Close()
+		}
+	}
+	if err != nil {
+		stop()
+		t.Fatal(username+": error while logging in:", err)
+	}
+	return func() {
+		c.Close()
+		stop()
+	}
+}
+
+func testManager(t *testing.T, files map[string]string) (*Manager, func()) {
+	startTestLevel()
+	dir, err := ioutil.TempDir("", "lav7-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, source := range files {
+		writeScript(t, filepath.Join(dir, name), source)
+	}
+	m := NewManager(dir)
+	m.Poll()
+	return m, func() {
+		m.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// scriptWrites counts script writes, to give each write different modification time.
+var scriptWrites int
+
+func writeScript(t *testing.T, path, source string) {
+	if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure modification time changes on file systems with coarse timestamps
+	scriptWrites++
+	mod := time.Now().Add(time.Duration(scriptWrites) * time.Second)
+	os.Chtimes(path, mod, mod)
+}
+
+func TestCommandAndEvents(t *testing.T) {
+	m, cleanup := testManager(t, map[string]string{"greet.lua": `
+		server.command{
+			name = "greet",
+			aliases = {"hi"},
+			usage = "<name>",
+			run = function(sender, args)
+				if #args == 0 then return false end
+				sender:send_message("Hello, " .. args[1] .. " from " .. sender:name())
+			end,
+		}
+		server.on("chat", function(ev)
+			if ev.message == "secret" then
+				ev.cancelled = true
+			end
+			ev.message = string.upper(ev.message)
+		end, "high")
+		server.on("place", function(ev)
+			if ev.id == 1 then ev.id = 4 end
+		end)
+	`})
+	defer cleanup()
+	if names := m.Scripts(); len(names) != 1 || names[0] != "greet.lua" {
+		t.Fatal("Script is not loaded:", names)
+	}
+
+	sender := new(testSender)
+	lav7.ExecuteCommand(sender, "hi Steve")
+	if out := sender.output(); out != "Hello, Steve from tester" {
+		t.Errorf("Command output mismatch: %q", out)
+	}
+	sender.reset()
+	lav7.ExecuteCommand(sender, "greet")
+	if out := sender.output(); out != "Usage: /greet <name>" {
+		t.Errorf("Usage output mismatch: %q", out)
+	}
+
+	chat := &lav7.PlayerChatEvent{Message: "hello"}
+	if !lav7.FireEvent(chat) || chat.Message != "HELLO" {
+		t.Error("Chat event should be modified:", chat.Message)
+	}
+	if lav7.FireEvent(&lav7.PlayerChatEvent{Message: "secret"}) {
+		t.Error("Chat event should be cancelled")
+	}
+	place := &lav7.BlockPlaceEvent{Block: types.Block{ID: byte(types.Stone)}}
+	if !lav7.FireEvent(place) || place.Block.ID != byte(types.Cobblestone) {
+		t.Error("Block should be modified:", place.Block)
+	}
+
+	m.Close()
+	sender.reset()
+	lav7.ExecuteCommand(sender, "greet Steve")
+	if !strings.HasPrefix(sender.output(), "Unknown command") {
+		t.Error("Command should be unregistered on unload:", sender.output())
+	}
+	chat = &lav7.PlayerChatEvent{Message: "hello"}
+	if lav7.FireEvent(chat); chat.Message != "hello" {
+		t.Error("Event handler should be removed on unload")
+	}
+}
+
+func TestTasks(t *testing.T) {
+	_, cleanup := testManager(t, map[string]string{"tasks.lua": `
+		count = 0
+		server.every(0.05, function() count = count + 1 end)
+		local cancelled = server.after(0.05, function() error("cancelled task should not run") end)
+		server.cancel(cancelled)
+		server.after(0.05, function() done = true end)
+		server.command{name = "taskstate", run = function(sender)
+			sender:send_message(tostring(done) .. " " .. tostring(count >= 3))
+		end}
+	`})
+	defer cleanup()
+	time.Sleep(time.Millisecond * 400)
+	sender := new(testSender)
+	lav7.ExecuteCommand(sender, "taskstate")
+	if out := sender.output(); out != "true true" {
+		t.Errorf("Task state mismatch: %q", out)
+	}
+}
+
+func TestKickFromScript(t *testing.T) {
+	_, cleanup := testManager(t, map[string]string{"kick.lua": `
+		server.on("quit", function(ev)
+			ev.message = ev.player:name() .. " is kicked by script"
+		end)
+		server.command{name = "kickme", run = function(sender, args)
+			server.player(args[1]):kick("bye")
+		end}
+	`})
+	deadlocked := false
+	defer func() {
+		if !deadlocked { // Unloading would wait for the script forever
+			cleanup()
+		}
+	}()
+	quits := make(chan string, 1)
+	sub, err := lav7.Subscribe(func(ev *lav7.PlayerQuitEvent) { quits <- ev.Message }, lav7.PriorityMonitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	disconnect := loginTestClient(t, "mallory")
+	defer disconnect()
+
+	// Kicking fires quit event, which calls into the script running the command.
+	done := make(chan struct{})
+	go func() {
+		lav7.ExecuteCommand(new(testSender), "kickme mallory")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		deadlocked = true
+		t.Fatal("Kicking from script should not deadlock")
+	}
+	select {
+	case msg := <-quits:
+		if msg != "mallory is kicked by script" {
+			t.Error("Quit message mismatch:", msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("Quit event is not fired")
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	old := CallTimeout
+	CallTimeout = time.Millisecond * 100
+	defer func() { CallTimeout = old }()
+	_, cleanup := testManager(t, map[string]string{"sandbox.lua": `
+		server.command{name = "sandbox", run = function(sender, args)
+			if args[1] == "loop" then
+				while true do end
+			end
+			local libs = {}
+			for _, name in ipairs({"io", "os", "package", "debug", "require", "dofile", "loadfile"}) do
+				if _G[name] ~= nil then table.insert(libs, name) end
+			end
+			sender:send_message("exposed: " .. table.concat(libs, ","))
+		end}
+	`})
+	defer cleanup()
+	sender := new(testSender)
+	lav7.ExecuteCommand(sender, "sandbox")
+	if out := sender.output(); out != "exposed: " {
+		t.Errorf("Unsafe libraries are exposed: %q", out)
+	}
+	sender.reset()
+	start := time.Now()
+	lav7.ExecuteCommand(sender, "sandbox loop")
+	if time.Since(start) > time.Second || !strings.HasPrefix(sender.output(), "Error:") {
+		t.Errorf("Infinite loop should be interrupted: %q", sender.output())
+	}
+}
+
+func TestHotReload(t *testing.T) {
+	m, cleanup := testManager(t, map[string]string{"reload.lua": `
+		server.command{name = "version", run = function(sender) sender:send_message("v1") end}
+	`})
+	defer cleanup()
+	run := func() string {
+		sender := new(testSender)
+		lav7.ExecuteCommand(sender, "version")
+		return sender.output()
+	}
+	if out := run(); out != "v1" {
+		t.Fatalf("Output mismatch: %q", out)
+	}
+	path := filepath.Join(m.Dir, "reload.lua")
+
+	writeScript(t, path, `server.command{name = "version", run = function(sender) sender:send_message("v2") end}`)
+	m.Poll()
+	if out := run(); out != "v2" {
+		t.Errorf("Script should be reloaded: %q", out)
+	}
+
+	// Syntax errors unload the old script, and the script is loaded again when fixed.
+	writeScript(t, path, `server.command{`)
+	m.Poll()
+	if out := run(); !strings.HasPrefix(out, "Unknown command") || len(m.Scripts()) != 0 {
+		t.Errorf("Broken script should be unloaded: %q", out)
+	}
+	writeScript(t, path, `server.command{name = "version", run = function(sender) sender:send_message("v3") end}`)
+	m.Poll()
+	if out := run(); out != "v3" {
+		t.Errorf("Fixed script should be loaded: %q", out)
+	}
+
+	os.Remove(path)
+	m.Poll()
+	if out := run(); !strings.HasPrefix(out, "Unknown command") {
+		t.Errorf("Removed script should be unloaded: %q", out)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s := newScript("load.lua", time.Now())
+	defer s.unload()
+	if err := s.load([]byte(`print("hello", 1, nil)`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.load([]byte(`error("oops")`)); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Error("Runtime error should be returned:", err)
+	}
+	if err := s.load(bytes.Repeat([]byte("("), 10)); err == nil {
+		t.Error("Syntax error should be returned")
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"log"
-	"net"
 	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,23 +17,49 @@ import (
 	"github.com/L7-MCPE/lav7/util"
 )
 
+// Server holds online players, and the raknet router which accepts them.
+// Package-level functions, e.g. AsPlayers, work on DefaultServer.
+type Server struct {
+	Router *raknet.Router // Router passing sessions to the server, used for closing sessions by address
+
+	players map[string]*Player // Players by raknet session key
+	lock    sync.Mutex
+}
+
+// NewServer creates a server without players.
+// Pass its RegisterPlayer and UnregisterPlayer methods to raknet router, and set Router field.
+func NewServer() *Server {
+	return &Server{
+		players: make(map[string]*Player),
+	}
+}
+
+// DefaultServer is a server used by package-level functions.
+var DefaultServer = NewServer()
+
 // RegisterPlayer registers player to the server and returns packet handler function for it.
-func RegisterPlayer(addr *net.UDPAddr) (handlerChan chan<- *bytes.Buffer) {
-	identifier := addr.String()
-	if _, ok := Players[identifier]; ok {
-		fmt.Println("Duplicate authentication from", addr)
-		Players[identifier].disconnect("Logged in from another location")
+func (sv *Server) RegisterPlayer(s *raknet.Session) (handlerChan chan<- *bytes.Buffer) {
+	identifier := s.Key
+	sv.lock.Lock()
+	old, ok := sv.players[identifier]
+	sv.lock.Unlock()
+	if ok {
+		fmt.Println("Duplicate authentication from", s.Address)
+		old.disconnect("Logged in from another location")
 	}
 
 	p := new(Player)
-	p.Address = addr
+	p.Address = s.Address
+	p.identifier = identifier
+	p.server = sv
+	p.session = s
 	p.Level = GetDefaultLevel()
 	p.EntityID = atomic.AddUint64(&lastEntityID, 1)
 	p.playerShown = make(map[uint64]struct{})
 
 	ch := make(chan *bytes.Buffer, 64)
 	p.recvChan = ch
-	p.raknetChan = raknet.Sessions[identifier].PlayerChan
+	p.raknetChan = s.PlayerChan
 	p.callbackChan = make(chan PlayerCallback, 128)
 	p.updateTicker = time.NewTicker(time.Millisecond * 500)
 
@@ -46,49 +72,64 @@ func RegisterPlayer(addr *net.UDPAddr) (handlerChan chan<- *bytes.Buffer) {
 
 	p.inventory = new(PlayerInventory)
 
-	iteratorLock.Lock()
-	Players[identifier] = p
-	iteratorLock.Unlock()
+	sv.lock.Lock()
+	sv.players[identifier] = p
+	sv.lock.Unlock()
+	p.Level.addPlayer(p)
 	atomic.AddInt32(&raknet.OnlinePlayers, 1)
 	go p.process()
 	return ch
 }
 
 // UnregisterPlayer removes player from server.
-func UnregisterPlayer(addr *net.UDPAddr) error {
-	identifier := addr.String()
-	iteratorLock.Lock()
-	if p, ok := Players[identifier]; ok {
-		iteratorLock.Unlock()
+func (sv *Server) UnregisterPlayer(s *raknet.Session) error {
+	identifier := s.Key
+	sv.lock.Lock()
+	if p, ok := sv.players[identifier]; ok {
+		sv.lock.Unlock()
 		p.updateTicker.Stop()
 		p.chunkStop <- struct{}{}
-		AsPlayers(func(pl *Player) {
+		p.closeWindows()
+		sv.AsPlayers(func(pl *Player) {
 			if p.EntityID == pl.EntityID {
 				return
 			}
-			pl.HidePlayer(p) //FIXME: semms not working
+			pl.RunAs(PlayerCallback{
+				Call: func(pl *Player, arg interface{}) {
+					pl.HidePlayer(p) //FIXME: semms not working
+				},
+			})
 		})
-		iteratorLock.Lock()
-		delete(Players, identifier)
-		iteratorLock.Unlock()
+		sv.lock.Lock()
+		delete(sv.players, identifier)
+		sv.lock.Unlock()
+		p.Level.removePlayer(p)
 		atomic.AddInt32(&raknet.OnlinePlayers, -1)
 		if p.loggedIn {
-			Message(p.Username + " disconnected")
+			quit := &PlayerQuitEvent{Player: p, Message: p.Username + " disconnected"}
+			FireEvent(quit)
+			if quit.Message != "" {
+				sv.Message(quit.Message)
+			}
 		}
 		return nil
 	}
-	iteratorLock.Unlock()
-	return fmt.Errorf("Tried to remove nonexistent player: %v", addr)
+	sv.lock.Unlock()
+	return fmt.Errorf("Tried to remove nonexistent player: %v", s.Address)
+}
+
+// PlayerCount returns a count of players on the server.
+func (sv *Server) PlayerCount() int {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+	return len(sv.players)
 }
 
 // AsPlayers executes given callback with every online players.
 //
-// Warning: callbacks are executed in separate, copied map of lav7.Players. Callbacks can run with disconnected player.
-func AsPlayers(callback func(*Player)) {
-	iteratorLock.Lock()
-	pm := getMapCopy()
-	iteratorLock.Unlock()
-	for _, p := range pm {
+// Warning: callbacks are executed in separate, copied map of players. Callbacks can run with disconnected player.
+func (sv *Server) AsPlayers(callback func(*Player)) {
+	for _, p := range sv.getMapCopy() {
 		callback(p)
 	}
 }
@@ -97,12 +138,9 @@ func AsPlayers(callback func(*Player)) {
 // It returns sync.WaitGroup struct to synchronize with callbacks.
 //
 // Warning: this could be a lot of overhead. Use with caution.
-func AsPlayersAsync(callback func(*Player)) *sync.WaitGroup {
-	iteratorLock.Lock()
-	pm := getMapCopy()
-	iteratorLock.Unlock()
+func (sv *Server) AsPlayersAsync(callback func(*Player)) *sync.WaitGroup {
 	wg := new(sync.WaitGroup)
-	for _, p := range pm {
+	for _, p := range sv.getMapCopy() {
 		wg.Add(1)
 		go func(pp *Player, w *sync.WaitGroup) {
 			callback(pp)
@@ -113,11 +151,8 @@ func AsPlayersAsync(callback func(*Player)) *sync.WaitGroup {
 }
 
 // AsPlayersError is similar to AsPlayers, but breaks iteration if callback returns error
-func AsPlayersError(callback func(*Player) error) error {
-	iteratorLock.Lock()
-	pm := getMapCopy()
-	iteratorLock.Unlock()
-	for _, p := range pm {
+func (sv *Server) AsPlayersError(callback func(*Player) error) error {
+	for _, p := range sv.getMapCopy() {
 		if err := callback(p); err != nil {
 			return err
 		}
@@ -126,42 +161,124 @@ func AsPlayersError(callback func(*Player) error) error {
 }
 
 // BroadcastCallback is same as AsPlayers(RunAs())
-func BroadcastCallback(callback PlayerCallback) {
-	AsPlayers(func(p *Player) {
+func (sv *Server) BroadcastCallback(callback PlayerCallback) {
+	sv.AsPlayers(func(p *Player) {
 		p.RunAs(callback)
 	})
 }
 
-func getMapCopy() map[string]*Player {
+func (sv *Server) getMapCopy() map[string]*Player {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
 	m := make(map[string]*Player)
-	for k := range Players {
-		m[k] = Players[k]
+	for k := range sv.players {
+		m[k] = sv.players[k]
 	}
 	return m
 }
 
 // Message broadcasts message, and logs to console.
-func Message(msg string) {
-	AsPlayers(func(pl *Player) {
+func (sv *Server) Message(msg string) {
+	sv.AsPlayers(func(pl *Player) {
 		pl.SendMessage(msg)
 	})
 	log.Println(msg)
 }
 
 // SpawnPlayer shows given player to all players, except given player itself.
-func SpawnPlayer(player *Player) {
-	AsPlayers(func(p *Player) {
-		if p.spawned && p.EntityID != player.EntityID {
-			p.ShowPlayer(player)
+// Players are shown on their own goroutines, as ShowPlayer changes visible players of the viewer.
+func (sv *Server) SpawnPlayer(player *Player) {
+	sv.AsPlayers(func(p *Player) {
+		if p.EntityID != player.EntityID {
+			p.RunAs(PlayerCallback{
+				Call: func(p *Player, arg interface{}) {
+					if p.spawned {
+						p.ShowPlayer(player)
+					}
+				},
+			})
 		}
 	})
 }
 
 // BroadcastPacket sends given packet to all online players.
-func BroadcastPacket(pk proto.Packet) {
-	for _, p := range Players {
+func (sv *Server) BroadcastPacket(pk proto.Packet) {
+	sv.AsPlayers(func(p *Player) {
 		p.SendPacket(pk)
+	})
+}
+
+// QueryInfo returns server status for raknet query responses.
+func (sv *Server) QueryInfo() raknet.QueryInfo {
+	var names []string
+	sv.AsPlayers(func(p *Player) {
+		if p.Username != "" {
+			names = append(names, p.Username)
+		}
+	})
+	sort.Strings(names)
+	plugins := "lav7 " + Version
+	if list := pluginList(); list != "" {
+		plugins += ": " + list
 	}
+	return raknet.QueryInfo{
+		ServerName: config.ServerName,
+		Map:        GetDefaultLevel().Name,
+		MaxPlayers: int(config.MaxPlayers),
+		Players:    names,
+		Whitelist:  WhitelistEnabled(),
+		Plugins:    plugins,
+	}
+}
+
+// RegisterPlayer registers player to DefaultServer. See Server.RegisterPlayer.
+func RegisterPlayer(s *raknet.Session) chan<- *bytes.Buffer {
+	return DefaultServer.RegisterPlayer(s)
+}
+
+// UnregisterPlayer removes player from DefaultServer. See Server.UnregisterPlayer.
+func UnregisterPlayer(s *raknet.Session) error {
+	return DefaultServer.UnregisterPlayer(s)
+}
+
+// AsPlayers executes given callback with every players on DefaultServer. See Server.AsPlayers.
+func AsPlayers(callback func(*Player)) {
+	DefaultServer.AsPlayers(callback)
+}
+
+// AsPlayersAsync is AsPlayers on DefaultServer, with a goroutine for each players. See Server.AsPlayersAsync.
+func AsPlayersAsync(callback func(*Player)) *sync.WaitGroup {
+	return DefaultServer.AsPlayersAsync(callback)
+}
+
+// AsPlayersError is AsPlayers on DefaultServer, which breaks iteration on error. See Server.AsPlayersError.
+func AsPlayersError(callback func(*Player) error) error {
+	return DefaultServer.AsPlayersError(callback)
+}
+
+// BroadcastCallback runs given callback with every players on DefaultServer.
+func BroadcastCallback(callback PlayerCallback) {
+	DefaultServer.BroadcastCallback(callback)
+}
+
+// Message broadcasts message to DefaultServer, and logs to console.
+func Message(msg string) {
+	DefaultServer.Message(msg)
+}
+
+// SpawnPlayer shows given player to all players on DefaultServer, except given player itself.
+func SpawnPlayer(player *Player) {
+	DefaultServer.SpawnPlayer(player)
+}
+
+// BroadcastPacket sends given packet to all players on DefaultServer.
+func BroadcastPacket(pk proto.Packet) {
+	DefaultServer.BroadcastPacket(pk)
+}
+
+// QueryInfo returns status of DefaultServer for raknet query responses.
+func QueryInfo() raknet.QueryInfo {
+	return DefaultServer.QueryInfo()
 }
 
 // GetLevel returns level reference with given name if exists, or nil.
@@ -184,6 +301,7 @@ func Stop(reason string) {
 	}
 	fmt.Println("Stopping server: " + reason)
 	AsPlayers(func(p *Player) { p.Kick("Server stop: " + reason) })
+	DisablePlugins()
 	for _, l := range levels {
 		l.Save()
 	}
@@ -0,0 +1,183 @@
+package lav7
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/gen"
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/raknet/client"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+// memoryProvider is a level format which does not save anything.
+type memoryProvider struct{}
+
+func (memoryProvider) Init(string)                          {}
+func (memoryProvider) Loadable(int32, int32) (string, bool) { return "", false }
+func (memoryProvider) LoadChunk(int32, int32, string) (*types.Chunk, error) {
+	return nil, errors.New("memoryProvider does not load chunks")
+}
+func (memoryProvider) WriteChunk(int32, int32, *types.Chunk) error { return nil }
+func (memoryProvider) SaveAll(map[[2]int32]*types.Chunk) error     { return nil }
+
+var testLevelOnce sync.Once
+
+const testServerAddress = "127.0.0.1:19132"
+
+// startTestServer starts lav7 server with flat level on given in-memory network.
+func startTestServer(t *testing.T, network *raknet.MemoryNetwork) *raknet.Router {
+	return startServer(t, network, DefaultServer, testServerAddress)
+}
+
+// startServer starts given server on given address of the in-memory network, with flat default level.
+func startServer(t *testing.T, network *raknet.MemoryNetwork, sv *Server, address string) *raknet.Router {
+	testLevelOnce.Do(func() {
+		g := new(gen.FlatGenerator)
+		g.Init()
+		GetDefaultLevel().Init(memoryProvider{})
+		GetDefaultLevel().Gen = g.Gen
+		atomic.StoreInt32(&raknet.MaxPlayers, 20)
+	})
+	conn, err := network.Listen(address)
+	if err != nil {
+		t.Fatal("Error while listening:", err)
+	}
+	r := raknet.NewRouter(sv.RegisterPlayer, sv.UnregisterPlayer, conn)
+	sv.Router = r
+	r.Start()
+	return r
+}
+
+// testClientPort is the last client port used by tests.
+// Each client gets unique address, so sessions left by previous tests do not collide.
+var testClientPort int32 = 40000
+
+// spawnTestClient connects to the test server, and waits for spawn.
+// Received UpdateBlock packets are sent to returned channel.
+func spawnTestClient(t *testing.T, network *raknet.MemoryNetwork, username string) (*client.Client, <-chan *proto.UpdateBlock) {
+	return spawnClient(t, network, testServerAddress, username)
+}
+
+// spawnClient connects to the server on given address, and waits for spawn.
+func spawnClient(t *testing.T, network *raknet.MemoryNetwork, address, username string) (*client.Client, <-chan *proto.UpdateBlock) {
+	conn, err := network.Listen(fmt.Sprintf("127.0.0.1:%d", atomic.AddInt32(&testClientPort, 1)))
+	if err != nil {
+		t.Fatal("Error while listening:", err)
+	}
+	addr, _ := net.ResolveUDPAddr("udp", address)
+	c, err := client.DialConn(conn, addr)
+	if err != nil {
+		t.Fatal(username+": error while connecting:", err)
+	}
+	updates := make(chan *proto.UpdateBlock, 16)
+	c.SetHandler(func(pk proto.Packet) {
+		if pk, ok := pk.(*proto.UpdateBlock); ok {
+			select {
+			case updates <- pk:
+			default:
+			}
+		}
+	})
+	if err := c.Login(username); err != nil {
+		t.Fatal(username+": error while logging in:", err)
+	}
+	if err := c.WaitSpawn(time.Second * 30); err != nil {
+		t.Fatal(username+": error while waiting spawn:", err)
+	}
+	return c, updates
+}
+
+// waitUpdateBlock waits for UpdateBlock packet with given block record.
+func waitUpdateBlock(updates <-chan *proto.UpdateBlock, x, y, z uint32, id byte) bool {
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case pk := <-updates:
+			for _, r := range pk.BlockRecords {
+				if r.X == x && r.Y == byte(y) && r.Z == z && r.Block.ID == id {
+					return true
+				}
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func TestUseItemBroadcast(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	network.Loss, network.Duplicate, network.Reorder = 0.05, 0.05, 0.1
+	network.Latency, network.Jitter = time.Millisecond*5, time.Millisecond*5
+	r := startTestServer(t, network)
+	defer r.Close()
+
+	alice, aliceUpdates := spawnTestClient(t, network, "alice")
+	defer alice.Close()
+	bob, bobUpdates := spawnTestClient(t, network, "bob")
+	defer bob.Close()
+
+	// Flat level has grass on y=5. Place stone on it.
+	alice.SendPacket(&proto.UseItem{
+		X: 1, Y: 5, Z: 1,
+		Face: vector.SideUp,
+		Item: &types.Item{ID: types.Stone, Amount: 1},
+	})
+	if !waitUpdateBlock(aliceUpdates, 1, 6, 1, byte(types.Stone)) {
+		t.Error("UpdateBlock is not sent to the player who placed block")
+	}
+	if !waitUpdateBlock(bobUpdates, 1, 6, 1, byte(types.Stone)) {
+		t.Error("UpdateBlock is not broadcasted to other player")
+	}
+	if b := GetDefaultLevel().GetBlock(1, 6, 1); b != byte(types.Stone) {
+		t.Error("Block is not set on level: got", b)
+	}
+}
+
+// playerNames returns sorted usernames of players on the server.
+func playerNames(sv *Server) (names []string) {
+	sv.AsPlayers(func(p *Player) {
+		names = append(names, p.Username)
+	})
+	sort.Strings(names)
+	return
+}
+
+func TestMultipleServers(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	sv1, sv2 := NewServer(), NewServer()
+	r1 := startServer(t, network, sv1, "127.0.0.1:19141")
+	defer r1.Close()
+	r2 := startServer(t, network, sv2, "127.0.0.1:19142")
+	defer r2.Close()
+
+	erin, _ := spawnClient(t, network, "127.0.0.1:19141", "erin")
+	defer erin.Close()
+	frank, _ := spawnClient(t, network, "127.0.0.1:19142", "frank")
+	defer frank.Close()
+	if names := playerNames(sv1); len(names) != 1 || names[0] != "erin" {
+		t.Error("First server should have only its player:", names)
+	}
+	if names := playerNames(sv2); len(names) != 1 || names[0] != "frank" {
+		t.Error("Second server should have only its player:", names)
+	}
+
+	erin.Close()
+	for i := 0; i < 100 && sv1.PlayerCount() > 0; i++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if n := sv1.PlayerCount(); n != 0 {
+		t.Error("Disconnected player should be removed from its server: got", n)
+	}
+	if names := playerNames(sv2); len(names) != 1 || names[0] != "frank" {
+		t.Error("Player on other server should be kept:", names)
+	}
+}
@@ -0,0 +1,282 @@
+package lav7
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/nbt"
+)
+
+// TileEntity is extra data of a block, e.g. sign text and chest items.
+// Tile entities are saved as NBT compounds in chunks, and sent to clients with chunks.
+type TileEntity interface {
+	TileID() string          // "id" field of the NBT, e.g. "Sign"
+	ReadNBT(c nbt.Compound)  // Loads fields from the NBT
+	WriteNBT(c nbt.Compound) // Saves fields to the NBT. Position and ID are written by the level.
+}
+
+var tileTypes = map[string]func() TileEntity{}
+
+// RegisterTileEntity sets the constructor of tile entities with given ID. A nil constructor removes the type.
+func RegisterTileEntity(id string, fn func() TileEntity) {
+	if fn == nil {
+		delete(tileTypes, id)
+		return
+	}
+	tileTypes[id] = fn
+}
+
+// NewTileEntity returns an empty tile entity with given ID, or nil if the ID is not registered.
+func NewTileEntity(id string) TileEntity {
+	if fn := tileTypes[id]; fn != nil {
+		return fn()
+	}
+	return nil
+}
+
+func init() {
+	RegisterTileEntity("Sign", func() TileEntity { return new(Sign) })
+	RegisterTileEntity("Chest", func() TileEntity { return &Chest{Items: make(Inventory, ChestSize)} })
+	RegisterTileEntity("Furnace", func() TileEntity { return &Furnace{Items: make(Inventory, FurnaceSize)} })
+}
+
+// Sign is a tile entity of sign posts and wall signs.
+type Sign struct {
+	Lines [4]string
+}
+
+// TileID implements TileEntity interface.
+func (*Sign) TileID() string { return "Sign" }
+
+// ReadNBT implements TileEntity interface.
+func (s *Sign) ReadNBT(c nbt.Compound) {
+	for i := range s.Lines {
+		s.Lines[i] = c.String(fmt.Sprintf("Text%d", i+1))
+	}
+}
+
+// WriteNBT implements TileEntity interface.
+func (s *Sign) WriteNBT(c nbt.Compound) {
+	for i, line := range s.Lines {
+		c[fmt.Sprintf("Text%d", i+1)] = line
+	}
+}
+
+// ChestSize is the number of slots of a chest.
+const ChestSize = 27
+
+// Chest is a tile entity of chests, storing items.
+type Chest struct {
+	Items Inventory
+}
+
+// TileID implements TileEntity interface.
+func (*Chest) TileID() string { return "Chest" }
+
+// ReadNBT implements TileEntity interface.
+func (ch *Chest) ReadNBT(c nbt.Compound) {
+	ch.Items = readItems(c.List("Items"), ChestSize)
+}
+
+// WriteNBT implements TileEntity interface.
+func (ch *Chest) WriteNBT(c nbt.Compound) {
+	c["Items"] = writeItems(ch.Items)
+}
+
+// Furnace slots
+const (
+	FurnaceInput = iota
+	FurnaceFuel
+	FurnaceResult
+	FurnaceSize
+)
+
+// Furnace is a tile entity of furnaces, storing items and smelting progress in ticks.
+type Furnace struct {
+	Items    Inventory
+	BurnTime int16 // Remaining ticks of the current fuel
+	MaxTime  int16 // Burn ticks of the current fuel
+	CookTime int16 // Ticks spent on smelting the input
+}
+
+// TileID implements TileEntity interface.
+func (*Furnace) TileID() string { return "Furnace" }
+
+// ReadNBT implements TileEntity interface.
+func (f *Furnace) ReadNBT(c nbt.Compound) {
+	f.Items = readItems(c.List("Items"), FurnaceSize)
+	f.BurnTime = c.Short("BurnTime")
+	f.MaxTime = c.Short("MaxTime")
+	f.CookTime = c.Short("CookTime")
+}
+
+// WriteNBT implements TileEntity interface.
+func (f *Furnace) WriteNBT(c nbt.Compound) {
+	c["Items"] = writeItems(f.Items)
+	c["BurnTime"] = f.BurnTime
+	c["MaxTime"] = f.MaxTime
+	c["CookTime"] = f.CookTime
+}
+
+// readItems reads container items from NBT list of slot compounds.
+func readItems(l nbt.List, size int) Inventory {
+	inv := make(Inventory, size)
+	for _, v := range l.Values {
+		c, ok := v.(nbt.Compound)
+		if !ok {
+			continue
+		}
+		slot := int(c.Byte("Slot"))
+		if slot >= size || c.Short("id") <= 0 || c.Byte("Count") == 0 {
+			continue
+		}
+		inv[slot] = types.Item{
+			ID:     types.ID(c.Short("id")),
+			Meta:   uint16(c.Short("Damage")),
+			Amount: c.Byte("Count"),
+		}
+	}
+	return inv
+}
+
+// writeItems returns NBT list of non-empty slots.
+func writeItems(inv Inventory) nbt.List {
+	l := nbt.List{Type: nbt.TagCompound}
+	for i, item := range inv {
+		if item.ID == 0 || item.Amount == 0 {
+			continue
+		}
+		l.Values = append(l.Values, nbt.Compound{
+			"Slot":   byte(i),
+			"id":     int16(item.ID),
+			"Damage": int16(item.Meta),
+			"Count":  item.Amount,
+		})
+	}
+	return l
+}
+
+// tileCompound returns NBT of the tile entity on given position.
+func tileCompound(x, y, z int32, te TileEntity) nbt.Compound {
+	c := nbt.Compound{}
+	te.WriteNBT(c)
+	c["id"] = te.TileID()
+	c["x"], c["y"], c["z"] = x, y, z
+	return c
+}
+
+// GetTile returns the tile entity on given coordinates, or nil if there is no tile entity.
+// The returned value is a copy: call SetTile to save changes.
+func (lv *Level) GetTile(x, y, z int32) TileEntity {
+	if y < 0 || y > 127 {
+		return nil
+	}
+	c := lv.GetChunk(x>>4, z>>4)
+	c.Mutex().RLock()
+	data := c.GetTile(byte(x&0xf), byte(y), byte(z&0xf))
+	c.Mutex().RUnlock()
+	if data == nil {
+		return nil
+	}
+	te := NewTileEntity(data.String("id"))
+	if te != nil {
+		te.ReadNBT(data)
+	}
+	return te
+}
+
+// SetTile saves the tile entity on given coordinates, and sends it to players who have the chunk.
+// A nil tile entity removes the tile entity.
+func (lv *Level) SetTile(x, y, z int32, te TileEntity) {
+	data := lv.storeTile(x, y, z, te)
+	if data == nil {
+		return
+	}
+	pk := tilePacket(x, y, z, data)
+	lv.AsPlayers(func(p *Player) {
+		if p.HasChunk(x>>4, z>>4) {
+			p.SendPacket(pk)
+		}
+	})
+}
+
+// storeTile saves the tile entity without sending it to players, and returns saved NBT.
+// Tile entities not used by the block are not saved, so removed blocks do not get their tile entities back.
+func (lv *Level) storeTile(x, y, z int32, te TileEntity) nbt.Compound {
+	if y < 0 || y > 127 {
+		return nil
+	}
+	var data nbt.Compound
+	if te != nil {
+		data = tileCompound(x, y, z, te)
+	}
+	c := lv.GetChunk(x>>4, z>>4)
+	c.Mutex().Lock()
+	defer c.Mutex().Unlock()
+	if te != nil && GetBlockType(c.GetBlock(byte(x&0xf), byte(y), byte(z&0xf))).Tile != te.TileID() {
+		return nil
+	}
+	c.SetTile(byte(x&0xf), byte(y), byte(z&0xf), data)
+	return data
+}
+
+func tilePacket(x, y, z int32, data nbt.Compound) *proto.BlockEntityData {
+	buf := new(bytes.Buffer)
+	if err := nbt.Write(buf, "", data); err != nil {
+		log.Println("Error while encoding tile entity:", err)
+	}
+	return &proto.BlockEntityData{
+		X:        uint32(x),
+		Y:        uint32(y),
+		Z:        uint32(z),
+		NamedTag: buf.Bytes(),
+	}
+}
+
+// removeTile removes the tile entity of the old block if the new block does not use it, and returns true if removed.
+// Callers should lock the chunk.
+func removeTile(c *types.Chunk, x, y, z int32, from, to byte) bool {
+	if tile := GetBlockType(from).Tile; tile != "" && tile != GetBlockType(to).Tile {
+		c.SetTile(byte(x&0xf), byte(y), byte(z&0xf), nil)
+		return true
+	}
+	return false
+}
+
+// SendTile sends the tile entity on given coordinates to the player, to revert client-side changes.
+func (lv *Level) SendTile(p *Player, x, y, z int32) {
+	if te := lv.GetTile(x, y, z); te != nil {
+		p.SendPacket(tilePacket(x, y, z, tileCompound(x, y, z, te)))
+	}
+}
+
+// OnBlockEntityData handles BlockEntityDataPacket, sent by clients after editing signs.
+func (lv *Level) OnBlockEntityData(p *Player, pk *proto.BlockEntityData) {
+	x, y, z := int32(pk.X), int32(pk.Y), int32(pk.Z)
+	sign, ok := lv.GetTile(x, y, z).(*Sign)
+	if !ok {
+		return
+	}
+	_, data, err := nbt.Read(bytes.NewReader(pk.NamedTag))
+	if err != nil || data.String("id") != sign.TileID() {
+		lv.SendTile(p, x, y, z)
+		return
+	}
+	if !p.HasPermission(PermissionPlace) {
+		p.SendMessage("You don't have permission to edit signs.")
+		lv.SendTile(p, x, y, z)
+		return
+	}
+	edited := new(Sign)
+	edited.ReadNBT(data)
+	ev := &SignChangeEvent{Player: p, Level: lv, X: x, Y: y, Z: z, Lines: edited.Lines}
+	if !FireEvent(ev) {
+		lv.SendTile(p, x, y, z)
+		return
+	}
+	sign.Lines = ev.Lines
+	lv.SetTile(x, y, z, sign)
+}
@@ -0,0 +1,100 @@
+package lav7
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/nbt"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+func TestTileEntities(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.Set(1, 6, 1, types.Block{ID: byte(types.Chest)})
+	chest := &Chest{Items: make(Inventory, ChestSize)}
+	chest.Items[3] = types.Item{ID: types.Diamond, Amount: 5}
+	chest.Items[26] = types.Item{ID: types.Wool, Meta: 14, Amount: 64}
+	lv.SetTile(1, 6, 1, chest)
+
+	got, ok := lv.GetTile(1, 6, 1).(*Chest)
+	if !ok {
+		t.Fatal("Chest tile entity is not saved")
+	}
+	if len(got.Items) != ChestSize || got.Items[3] != chest.Items[3] || got.Items[26] != chest.Items[26] {
+		t.Error("Chest items mismatch:", got.Items)
+	}
+
+	c := lv.GetChunk(0, 0)
+	payload := c.FullChunkData()
+	_, data, err := nbt.Read(bytes.NewReader(payload[83200+4:]))
+	if err != nil || data.String("id") != "Chest" || data.Int("x") != 1 || data.Int("y") != 6 {
+		t.Error("Tile entity is not included in full chunk data:", data, err)
+	}
+	copied := new(types.Chunk)
+	if err := copied.ReadTileData(c.TileData()); err != nil || copied.GetTile(1, 6, 1) == nil {
+		t.Error("Tile data is not read back:", err)
+	}
+
+	lv.Set(2, 6, 2, types.Block{ID: byte(types.Furnace)})
+	lv.SetTile(2, 6, 2, &Furnace{Items: make(Inventory, FurnaceSize), BurnTime: 100})
+	lv.Set(2, 6, 2, types.Block{ID: byte(types.BurningFurnace)})
+	if f, ok := lv.GetTile(2, 6, 2).(*Furnace); !ok || f.BurnTime != 100 {
+		t.Error("Furnace should keep its tile entity while burning")
+	}
+	lv.Set(1, 6, 1, types.Block{ID: byte(types.Stone)})
+	if te := lv.GetTile(1, 6, 1); te != nil {
+		t.Error("Tile entity should be removed with the block:", te)
+	}
+}
+
+func TestSignEdit(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	r := startTestServer(t, network)
+	defer r.Close()
+	c, updates := spawnTestClient(t, network, "writer")
+	defer c.Close()
+
+	c.SendPacket(&proto.UseItem{
+		X: 9, Y: 5, Z: 9,
+		Face: vector.SideUp,
+		Item: &types.Item{ID: types.Sign, Amount: 1},
+	})
+	if !waitUpdateBlock(updates, 9, 6, 9, byte(types.SignPost)) {
+		t.Fatal("Sign post is not placed")
+	}
+	tiles := make(chan *proto.BlockEntityData, 4)
+	c.SetHandler(func(pk proto.Packet) {
+		if pk, ok := pk.(*proto.BlockEntityData); ok {
+			select {
+			case tiles <- pk:
+			default:
+			}
+		}
+	})
+
+	buf := new(bytes.Buffer)
+	nbt.Write(buf, "", nbt.Compound{"id": "Sign", "x": int32(9), "y": int32(6), "z": int32(9), "Text1": "Hello", "Text3": "lav7"})
+	c.SendPacket(&proto.BlockEntityData{X: 9, Y: 6, Z: 9, NamedTag: buf.Bytes()})
+
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case pk := <-tiles:
+			_, data, err := nbt.Read(bytes.NewReader(pk.NamedTag))
+			if err != nil || data.String("Text1") != "Hello" {
+				continue
+			}
+			sign, ok := GetDefaultLevel().GetTile(9, 6, 9).(*Sign)
+			if !ok || sign.Lines != [4]string{"Hello", "", "lav7", ""} {
+				t.Error("Sign text is not saved:", sign)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Edited sign is not sent back")
+		}
+	}
+}
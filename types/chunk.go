@@ -2,9 +2,11 @@ package types
 
 import (
 	"bytes"
+	"sort"
 
 	"github.com/L7-MCPE/lav7/util"
 	"github.com/L7-MCPE/lav7/util/buffer"
+	"github.com/L7-MCPE/lav7/util/nbt"
 )
 
 // ChunkDelivery is a type for passing full chunk data to players.
@@ -25,6 +27,8 @@ type Chunk struct {
 	HeightMap    [16 * 16]byte
 	BiomeData    [16 * 16 * 4]byte // Uints
 
+	TileEntities map[uint16]nbt.Compound // Tile entity NBT by block index, y<<8|z<<4|x. Compounds are replaced, not modified.
+
 	Refs    uint64
 	RWMutex util.RWLocker
 }
@@ -43,6 +47,10 @@ func (c *Chunk) CopyFrom(chunk Chunk) {
 	copy(c.SkyLightData[:], chunk.SkyLightData[:])
 	copy(c.HeightMap[:], chunk.HeightMap[:])
 	copy(c.BiomeData[:], chunk.BiomeData[:])
+	c.TileEntities = nil
+	for i, t := range chunk.TileEntities {
+		c.setTile(i, t)
+	}
 }
 
 // GetBlock returns block ID at given coordinates.
@@ -155,6 +163,77 @@ func (c *Chunk) PopulateHeight() {
 	}
 }
 
+// UpdateHeight updates height map after the block on given coordinates is changed.
+func (c *Chunk) UpdateHeight(x, y, z byte) {
+	h := c.GetHeightMap(x, z)
+	if c.GetBlock(x, y, z) != 0 {
+		if y > h {
+			c.SetHeightMap(x, z, y)
+		}
+		return
+	}
+	if y != h {
+		return
+	}
+	for y > 0 && c.GetBlock(x, y, z) == 0 {
+		y--
+	}
+	c.SetHeightMap(x, z, y)
+}
+
+// GetTile returns NBT of the tile entity at given coordinates, or nil if there is no tile entity.
+func (c Chunk) GetTile(x, y, z byte) nbt.Compound {
+	return c.TileEntities[uint16(y)<<8|uint16(z)<<4|uint16(x)]
+}
+
+// SetTile sets NBT of the tile entity at given coordinates. A nil compound removes the tile entity.
+func (c *Chunk) SetTile(x, y, z byte, t nbt.Compound) {
+	c.setTile(uint16(y)<<8|uint16(z)<<4|uint16(x), t)
+}
+
+func (c *Chunk) setTile(i uint16, t nbt.Compound) {
+	if t == nil {
+		delete(c.TileEntities, i)
+		return
+	}
+	if c.TileEntities == nil {
+		c.TileEntities = make(map[uint16]nbt.Compound)
+	}
+	c.TileEntities[i] = t
+}
+
+// TileData returns NBT compounds of tile entities, ordered by block index.
+func (c Chunk) TileData() []byte {
+	keys := make([]int, 0, len(c.TileEntities))
+	for i := range c.TileEntities {
+		keys = append(keys, int(i))
+	}
+	sort.Ints(keys)
+	buf := new(bytes.Buffer)
+	for _, i := range keys {
+		nbt.Write(buf, "", c.TileEntities[uint16(i)])
+	}
+	return buf.Bytes()
+}
+
+// ReadTileData reads tile entities written by TileData, replacing current tile entities.
+func (c *Chunk) ReadTileData(b []byte) error {
+	c.TileEntities = nil
+	buf := bytes.NewBuffer(b)
+	for buf.Len() > 0 {
+		_, t, err := nbt.Read(buf)
+		if err != nil {
+			return err
+		}
+		x, y, z := t.Int("x"), t.Int("y"), t.Int("z")
+		if y < 0 || y > 127 {
+			continue
+		}
+		c.SetTile(byte(x&0xf), byte(y), byte(z&0xf), t)
+	}
+	return nil
+}
+
 // Mutex returns chunk's RW mutex.
 func (c *Chunk) Mutex() util.RWLocker {
 	if c.RWMutex == nil {
@@ -169,6 +248,6 @@ func (c Chunk) FullChunkData() []byte {
 	buffer.Write(buf, append(c.SkyLightData[:], c.LightData[:]...))  // SkyLight, Light
 	buffer.Write(buf, append(c.HeightMap[:], c.BiomeData[:]...))     // Height Map, Biome colors
 	buffer.Write(buf, []byte{0, 0, 0, 0})                            // Extra data: NBT length 0
-	// No tile entity NBT fields
+	buffer.Write(buf, c.TileData())                                  // Tile entities
 	return buf.Bytes()
 }
@@ -8,8 +8,13 @@ import (
 	"io"
 	"math"
 	"net"
+	"strconv"
 )
 
+// IPv6 address family value written on addresses.
+// Raknet copies sockaddr_in6 structure as-is, so this is AF_INET6 on Windows.
+const addressFamily6 = 23
+
 // Overflow is an error indicates the reader could not read as you requested.
 type Overflow struct {
 	Need int
@@ -204,20 +209,40 @@ func ReadString(rd io.Reader) (str string) {
 }
 
 // ReadAddress reads IP address/port from buffer.
+// IPv4 addresses are encoded as version byte 4, inverted address bytes and port.
+// IPv6 addresses are encoded as version byte 6 and sockaddr_in6 structure:
+// little-endian family, port, flow info, address bytes and scope ID.
 func ReadAddress(rd io.Reader) (addr *net.UDPAddr) {
-	v := ReadByte(rd)
-	if v != 4 {
+	switch v := ReadByte(rd); v {
+	case 4:
+		b, err := Read(rd, 4)
+		if err != nil {
+			panic(err)
+		}
+		p := ReadShort(rd)
+		return &net.UDPAddr{
+			IP:   append([]byte{b[0] ^ 0xff}, b[1]^0xff, b[2]^0xff, b[3]^0xff),
+			Port: int(p),
+		}
+	case 6:
+		ReadLShort(rd) // Family
+		p := ReadShort(rd)
+		ReadInt(rd) // Flow info
+		b, err := Read(rd, 16)
+		if err != nil {
+			panic(err)
+		}
+		addr = &net.UDPAddr{
+			IP:   net.IP(b),
+			Port: int(p),
+		}
+		if scope := ReadInt(rd); scope != 0 {
+			addr.Zone = strconv.Itoa(int(scope))
+		}
+		return
+	default:
 		panic(fmt.Sprintf("ReadAddress got unsupported IP version %d", v))
 	}
-	b, err := Read(rd, 4)
-	if err != nil {
-		panic(err)
-	}
-	p := ReadShort(rd)
-	return &net.UDPAddr{
-		IP:   append([]byte{b[0] ^ 0xff}, b[1]^0xff, b[2]^0xff, b[3]^0xff),
-		Port: int(p),
-	}
 }
 
 // Write writes given byte array to buffer.
@@ -386,12 +411,38 @@ func WriteString(wr io.Writer, s string) {
 }
 
 // WriteAddress writes net.UDPAddr address to buffer.
+// See ReadAddress for encoding details.
 func WriteAddress(wr io.Writer, i *net.UDPAddr) {
-	WriteByte(wr, 4)
-	for _, v := range i.IP.To4() {
-		WriteByte(wr, v^0xff)
+	if ip := i.IP.To4(); ip != nil || i.IP == nil {
+		if ip == nil {
+			ip = net.IPv4zero.To4()
+		}
+		WriteByte(wr, 4)
+		for _, v := range ip {
+			WriteByte(wr, v^0xff)
+		}
+		WriteShort(wr, uint16(i.Port))
+		return
 	}
+	WriteByte(wr, 6)
+	WriteLShort(wr, addressFamily6)
 	WriteShort(wr, uint16(i.Port))
+	WriteInt(wr, 0) // Flow info
+	Write(wr, i.IP.To16())
+	WriteInt(wr, scopeID(i.Zone))
+}
+
+func scopeID(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(zone); err == nil {
+		return uint32(n)
+	}
+	if ifi, err := net.InterfaceByName(zone); err == nil {
+		return uint32(ifi.Index)
+	}
+	return 0
 }
 
 // Dump prints hexdump for given buffer.
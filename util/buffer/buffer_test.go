@@ -0,0 +1,36 @@
+package buffer
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		Address string
+		Length  int
+	}{
+		{"127.0.0.1:19132", 7},
+		{"0.0.0.0:0", 7},
+		{"[::1]:19133", 29},
+		{"[fe80::1%3]:19132", 29},
+		{"[2001:db8::8a2e:370:7334]:65535", 29},
+	}
+	for _, test := range tests {
+		addr, err := net.ResolveUDPAddr("udp", test.Address)
+		if err != nil {
+			t.Error("Error while resolving address:", err)
+			return
+		}
+		buf := new(bytes.Buffer)
+		WriteAddress(buf, addr)
+		if buf.Len() != test.Length {
+			t.Error("Encoded address length mismatch:", test.Address, buf.Len(), "!=", test.Length)
+			continue
+		}
+		if r := ReadAddress(buf); r.String() != addr.String() {
+			t.Error("Address encode/decode mismatch: expected", addr, "got", r)
+		}
+	}
+}
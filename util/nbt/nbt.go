@@ -0,0 +1,394 @@
+// Package nbt implements little-endian NBT, used by MCPE for tile entities.
+//
+// Tag values are represented with Go types:
+// byte, int16, int32, int64, float32, float64, []byte, string, List, Compound and []int32.
+package nbt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Tag types
+const (
+	TagEnd byte = iota
+	TagByte
+	TagShort
+	TagInt
+	TagLong
+	TagFloat
+	TagDouble
+	TagByteArray
+	TagString
+	TagList
+	TagCompound
+	TagIntArray
+)
+
+// maxDepth limits nesting of lists and compounds, to reject malicious data from clients.
+const maxDepth = 64
+
+// maxLength limits length of arrays and lists.
+const maxLength = 1 << 20
+
+// ErrDepth is returned if the NBT data is nested too deeply.
+var ErrDepth = errors.New("nbt: too deeply nested")
+
+// Compound is a set of named tags.
+type Compound map[string]interface{}
+
+// List is a list of unnamed tags with the same type.
+type List struct {
+	Type   byte
+	Values []interface{}
+}
+
+// Byte returns byte value with given name, or 0 if it does not exist.
+func (c Compound) Byte(name string) byte {
+	v, _ := c[name].(byte)
+	return v
+}
+
+// Short returns short value with given name, or 0 if it does not exist.
+func (c Compound) Short(name string) int16 {
+	v, _ := c[name].(int16)
+	return v
+}
+
+// Int returns int value with given name, or 0 if it does not exist.
+func (c Compound) Int(name string) int32 {
+	v, _ := c[name].(int32)
+	return v
+}
+
+// String returns string value with given name, or an empty string if it does not exist.
+func (c Compound) String(name string) string {
+	v, _ := c[name].(string)
+	return v
+}
+
+// List returns list value with given name. If it does not exist, the list is empty.
+func (c Compound) List(name string) List {
+	v, _ := c[name].(List)
+	return v
+}
+
+// Compound returns compound value with given name, or nil if it does not exist.
+func (c Compound) Compound(name string) Compound {
+	v, _ := c[name].(Compound)
+	return v
+}
+
+// TagType returns tag type of given value, or TagEnd if the type is not supported.
+func TagType(v interface{}) byte {
+	switch v.(type) {
+	case byte:
+		return TagByte
+	case int16:
+		return TagShort
+	case int32:
+		return TagInt
+	case int64:
+		return TagLong
+	case float32:
+		return TagFloat
+	case float64:
+		return TagDouble
+	case []byte:
+		return TagByteArray
+	case string:
+		return TagString
+	case List:
+		return TagList
+	case Compound:
+		return TagCompound
+	case []int32:
+		return TagIntArray
+	}
+	return TagEnd
+}
+
+// Read reads a named compound tag. It returns io.EOF if there is no data.
+func Read(r io.Reader) (name string, c Compound, err error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r}
+	}
+	d := &decoder{r: r, br: br}
+	typ, err := d.byte()
+	if err != nil {
+		return
+	}
+	if typ != TagCompound {
+		return "", nil, fmt.Errorf("nbt: root tag type %d is not compound", typ)
+	}
+	if name, err = d.string(); err != nil {
+		return
+	}
+	v, err := d.value(TagCompound, 0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return name, v.(Compound), nil
+}
+
+// Write writes the compound as a named tag.
+func Write(w io.Writer, name string, c Compound) error {
+	e := &encoder{w: w}
+	e.byte(TagCompound)
+	e.string(name)
+	e.value(c)
+	return e.err
+}
+
+// byteReader reads a byte at once without buffering, so Read never consumes data after the tag.
+type byteReader struct {
+	r io.Reader
+	b [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.r, r.b[:])
+	return r.b[0], err
+}
+
+type decoder struct {
+	r   io.Reader
+	br  io.ByteReader
+	buf [8]byte
+}
+
+func (d *decoder) byte() (byte, error) {
+	return d.br.ReadByte()
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	_, err := io.ReadFull(d.r, d.buf[:n])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return d.buf[:n], err
+}
+
+func (d *decoder) length() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	n := int32(binary.LittleEndian.Uint32(b))
+	if n < 0 || n > maxLength {
+		return 0, fmt.Errorf("nbt: invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.read(2)
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, binary.LittleEndian.Uint16(b))
+	if _, err := io.ReadFull(d.r, s); err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(s), nil
+}
+
+func (d *decoder) value(typ byte, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrDepth
+	}
+	switch typ {
+	case TagByte:
+		return d.byte()
+	case TagShort:
+		b, err := d.read(2)
+		return int16(binary.LittleEndian.Uint16(b)), err
+	case TagInt:
+		b, err := d.read(4)
+		return int32(binary.LittleEndian.Uint32(b)), err
+	case TagLong:
+		b, err := d.read(8)
+		return int64(binary.LittleEndian.Uint64(b)), err
+	case TagFloat:
+		b, err := d.read(4)
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), err
+	case TagDouble:
+		b, err := d.read(8)
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), err
+	case TagByteArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return b, nil
+	case TagString:
+		return d.string()
+	case TagList:
+		elem, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		l := List{Type: elem}
+		for i := 0; i < n; i++ {
+			v, err := d.value(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			l.Values = append(l.Values, v)
+		}
+		return l, nil
+	case TagCompound:
+		c := make(Compound)
+		for {
+			t, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if t == TagEnd {
+				return c, nil
+			}
+			name, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if c[name], err = d.value(t, depth+1); err != nil {
+				return nil, err
+			}
+		}
+	case TagIntArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		a := make([]int32, n)
+		for i := range a {
+			b, err := d.read(4)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = int32(binary.LittleEndian.Uint32(b))
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("nbt: unknown tag type %d", typ)
+}
+
+type encoder struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func (e *encoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
+
+func (e *encoder) uint16(n uint16) {
+	binary.LittleEndian.PutUint16(e.buf[:2], n)
+	e.write(e.buf[:2])
+}
+
+func (e *encoder) uint32(n uint32) {
+	binary.LittleEndian.PutUint32(e.buf[:4], n)
+	e.write(e.buf[:4])
+}
+
+func (e *encoder) uint64(n uint64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], n)
+	e.write(e.buf[:8])
+}
+
+func (e *encoder) string(s string) {
+	if len(s) > math.MaxUint16 {
+		e.fail(fmt.Errorf("nbt: string too long: %d bytes", len(s)))
+		return
+	}
+	e.uint16(uint16(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *encoder) value(v interface{}) {
+	switch v := v.(type) {
+	case byte:
+		e.byte(v)
+	case int16:
+		e.uint16(uint16(v))
+	case int32:
+		e.uint32(uint32(v))
+	case int64:
+		e.uint64(uint64(v))
+	case float32:
+		e.uint32(math.Float32bits(v))
+	case float64:
+		e.uint64(math.Float64bits(v))
+	case []byte:
+		e.uint32(uint32(len(v)))
+		e.write(v)
+	case string:
+		e.string(v)
+	case List:
+		e.byte(v.Type)
+		e.uint32(uint32(len(v.Values)))
+		for _, x := range v.Values {
+			if TagType(x) != v.Type {
+				e.fail(fmt.Errorf("nbt: %T in list of tag type %d", x, v.Type))
+				return
+			}
+			e.value(x)
+		}
+	case Compound:
+		// Sorted for stable output
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			typ := TagType(v[name])
+			if typ == TagEnd {
+				e.fail(fmt.Errorf("nbt: unsupported type %T of %q", v[name], name))
+				return
+			}
+			e.byte(typ)
+			e.string(name)
+			e.value(v[name])
+		}
+		e.byte(TagEnd)
+	case []int32:
+		e.uint32(uint32(len(v)))
+		for _, n := range v {
+			e.uint32(uint32(n))
+		}
+	default:
+		e.fail(fmt.Errorf("nbt: unsupported type %T", v))
+	}
+}
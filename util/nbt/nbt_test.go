@@ -0,0 +1,78 @@
+package nbt
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := Compound{
+		"id":    "Chest",
+		"x":     int32(-12),
+		"Byte":  byte(200),
+		"Short": int16(-3),
+		"Long":  int64(1) << 40,
+		"Float": float32(0.5),
+		"Bytes": []byte{1, 2, 3},
+		"Ints":  []int32{1, -1},
+		"Items": List{Type: TagCompound, Values: []interface{}{
+			Compound{"Slot": byte(0), "id": int16(1), "Count": byte(64)},
+		}},
+		"Empty": List{Type: TagString},
+		"Sub":   Compound{"Double": float64(2.25)},
+	}
+	buf := new(bytes.Buffer)
+	if err := Write(buf, "root", c); err != nil {
+		t.Fatal("Error while writing NBT:", err)
+	}
+	Write(buf, "", Compound{"next": "tag"})
+
+	name, got, err := Read(buf)
+	if err != nil {
+		t.Fatal("Error while reading NBT:", err)
+	}
+	c["Empty"] = List{Type: TagString} // Values of empty lists are nil
+	if name != "root" || !reflect.DeepEqual(got, c) {
+		t.Errorf("Round trip mismatch: %q %v", name, got)
+	}
+	if _, next, err := Read(buf); err != nil || next.String("next") != "tag" {
+		t.Error("Second compound is not read:", next, err)
+	}
+	if _, _, err := Read(buf); err != io.EOF {
+		t.Error("Expected io.EOF after the last compound, got", err)
+	}
+}
+
+func TestLittleEndian(t *testing.T) {
+	buf := new(bytes.Buffer)
+	Write(buf, "", Compound{"a": int16(1)})
+	expect := []byte{TagCompound, 0, 0, TagShort, 1, 0, 'a', 1, 0, TagEnd}
+	if !bytes.Equal(buf.Bytes(), expect) {
+		t.Errorf("Expected % x, got % x", expect, buf.Bytes())
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		{TagCompound, 0, 0, TagShort, 1, 0, 'a', 1}, // Truncated
+		{TagString, 0, 0}, // Root is not a compound
+		{TagCompound, 0, 0, TagIntArray, 0, 0, 0xff, 0xff, 0xff, 0xff}, // Negative length
+		{TagCompound, 0, 0, 99, 0, 0},                                  // Unknown tag
+	} {
+		if _, _, err := Read(bytes.NewReader(b)); err == nil || err == io.EOF {
+			t.Errorf("Invalid data % x should fail, got %v", b, err)
+		}
+	}
+	deep := []byte{TagCompound, 0, 0}
+	for i := 0; i < maxDepth+1; i++ {
+		deep = append(deep, TagCompound, 0, 0)
+	}
+	if _, _, err := Read(bytes.NewReader(deep)); err != ErrDepth {
+		t.Error("Expected ErrDepth, got", err)
+	}
+	if err := Write(new(bytes.Buffer), "", Compound{"a": 1}); err == nil {
+		t.Error("Unsupported type int should fail")
+	}
+}
@@ -0,0 +1,375 @@
+package lav7
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/L7-MCPE/lav7/proto"
+)
+
+// Weather is a weather state of levels.
+type Weather int
+
+// Weathers
+const (
+	WeatherClear Weather = iota
+	WeatherRain
+	WeatherThunder
+)
+
+func (w Weather) String() string {
+	switch w {
+	case WeatherRain:
+		return "rain"
+	case WeatherThunder:
+		return "thunder"
+	default:
+		return "clear"
+	}
+}
+
+// ParseWeather returns weather with given name: clear, rain or thunder.
+func ParseWeather(name string) (Weather, bool) {
+	switch strings.ToLower(name) {
+	case "clear", "sun":
+		return WeatherClear, true
+	case "rain":
+		return WeatherRain, true
+	case "thunder", "storm":
+		return WeatherThunder, true
+	}
+	return WeatherClear, false
+}
+
+// timeSyncInterval is an interval of SetTime broadcasts in ticks. Clients advance time by themselves,
+// so the packet only corrects drifts.
+const timeSyncInterval = TicksPerSecond * 15
+
+// Durations of random weathers, in ticks
+const (
+	minClearTicks = TicksPerSecond * 60 * 10
+	maxClearTicks = TicksPerSecond * 60 * 150
+	minRainTicks  = TicksPerSecond * 60 * 5
+	maxRainTicks  = TicksPerSecond * 60 * 15
+)
+
+// rainIntensity is sent with EventStartRain and EventStartThunder.
+const rainIntensity = 65535
+
+// LevelDir is a directory containing level data. Each level saves its state to <LevelDir>/<name>/level.json.
+var LevelDir = "levels"
+
+// levelEnv is world time and weather of a level.
+type levelEnv struct {
+	sync.Mutex
+	Time        uint32  `json:"time"` // Time of day, 0 ~ proto.FullTime
+	TimeStopped bool    `json:"time_stopped,omitempty"`
+	Weather     Weather `json:"weather"`
+	WeatherTime int     `json:"weather_time"` // Remaining ticks until the weather changes
+}
+
+func newLevelEnv() *levelEnv {
+	return &levelEnv{WeatherTime: randomTicks(minClearTicks, maxClearTicks)}
+}
+
+func randomTicks(min, max int) int {
+	return min + rand.Intn(max-min)
+}
+
+func (lv *Level) statePath() string {
+	if LevelDir == "" {
+		return ""
+	}
+	return filepath.Join(LevelDir, lv.Name, "level.json")
+}
+
+// loadState reads saved time and weather of the level.
+func (lv *Level) loadState() {
+	lv.env.Lock()
+	defer lv.env.Unlock()
+	if err := readJSON(lv.statePath(), lv.env); err != nil {
+		log.Println("Error while loading level state:", err)
+	}
+	lv.env.Time %= proto.FullTime
+}
+
+// saveState writes time and weather of the level.
+func (lv *Level) saveState() error {
+	path := lv.statePath()
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lv.env.Lock()
+	defer lv.env.Unlock()
+	return writeJSON(path, lv.env)
+}
+
+// tickEnv advances time and weather of the level by a tick.
+func (lv *Level) tickEnv(tick uint64) {
+	env := lv.env
+	env.Lock()
+	if !env.TimeStopped {
+		env.Time = (env.Time + 1) % proto.FullTime
+	}
+	syncTime := tick%timeSyncInterval == 0
+	var change bool
+	if env.WeatherTime--; env.WeatherTime <= 0 {
+		change = true
+	}
+	weather := env.Weather
+	env.Unlock()
+
+	if change {
+		if weather == WeatherClear {
+			weather = WeatherRain
+			if rand.Intn(4) == 0 {
+				weather = WeatherThunder
+			}
+		} else {
+			weather = WeatherClear
+		}
+		lv.SetWeather(weather, 0)
+	}
+	if syncTime {
+		lv.BroadcastPacket(lv.timePacket())
+	}
+}
+
+// Time returns time of day of the level, from 0 to proto.FullTime.
+func (lv *Level) Time() uint32 {
+	lv.env.Lock()
+	defer lv.env.Unlock()
+	return lv.env.Time
+}
+
+// SetTime sets time of day of the level, and sends it to players on the level.
+func (lv *Level) SetTime(t uint32) {
+	lv.env.Lock()
+	lv.env.Time = t % proto.FullTime
+	lv.env.Unlock()
+	lv.BroadcastPacket(lv.timePacket())
+}
+
+// AddTime advances time of day of the level by given ticks.
+func (lv *Level) AddTime(ticks int) {
+	lv.env.Lock()
+	t := (int(lv.env.Time) + ticks) % proto.FullTime
+	if t < 0 {
+		t += proto.FullTime
+	}
+	lv.env.Time = uint32(t)
+	lv.env.Unlock()
+	lv.BroadcastPacket(lv.timePacket())
+}
+
+// TimeStopped returns if the day/night cycle of the level is stopped.
+func (lv *Level) TimeStopped() bool {
+	lv.env.Lock()
+	defer lv.env.Unlock()
+	return lv.env.TimeStopped
+}
+
+// StopTime stops or restarts the day/night cycle of the level.
+func (lv *Level) StopTime(stop bool) {
+	lv.env.Lock()
+	lv.env.TimeStopped = stop
+	lv.env.Unlock()
+	lv.BroadcastPacket(lv.timePacket())
+}
+
+// Weather returns current weather of the level.
+func (lv *Level) Weather() Weather {
+	lv.env.Lock()
+	defer lv.env.Unlock()
+	return lv.env.Weather
+}
+
+// SetWeather changes weather of the level for given ticks, and sends it to players on the level.
+// If duration is not positive, random duration is used.
+func (lv *Level) SetWeather(weather Weather, duration int) {
+	if duration <= 0 {
+		if weather == WeatherClear {
+			duration = randomTicks(minClearTicks, maxClearTicks)
+		} else {
+			duration = randomTicks(minRainTicks, maxRainTicks)
+		}
+	}
+	lv.env.Lock()
+	old := lv.env.Weather
+	lv.env.Weather = weather
+	lv.env.WeatherTime = duration
+	lv.env.Unlock()
+	if old == weather {
+		return
+	}
+	for _, pk := range weatherPackets(old, weather) {
+		lv.BroadcastPacket(pk)
+	}
+}
+
+func (lv *Level) timePacket() *proto.SetTime {
+	lv.env.Lock()
+	defer lv.env.Unlock()
+	return &proto.SetTime{
+		Time:    lv.env.Time,
+		Started: !lv.env.TimeStopped,
+	}
+}
+
+// weatherPackets returns LevelEvent packets changing weather between given weathers.
+func weatherPackets(from, to Weather) (pks []proto.Packet) {
+	event := func(id uint16, data uint32) {
+		pks = append(pks, &proto.LevelEvent{EventID: id, Data: data})
+	}
+	if from == WeatherThunder && to != WeatherThunder {
+		event(proto.EventStopThunder, 0)
+	}
+	if from != WeatherClear && to == WeatherClear {
+		event(proto.EventStopRain, 0)
+	}
+	if from == WeatherClear && to != WeatherClear {
+		event(proto.EventStartRain, rainIntensity)
+	}
+	if from != WeatherThunder && to == WeatherThunder {
+		event(proto.EventStartThunder, rainIntensity)
+	}
+	return
+}
+
+// sendEnvironment sends time and weather of the level to the player.
+func (lv *Level) sendEnvironment(p *Player) {
+	p.SendPacket(lv.timePacket())
+	for _, pk := range weatherPackets(WeatherClear, lv.Weather()) {
+		p.SendPacket(pk)
+	}
+}
+
+// BroadcastPacket sends given packet to players on the level.
+func (lv *Level) BroadcastPacket(pk proto.Packet) {
+	lv.AsPlayers(func(p *Player) {
+		p.SendPacket(pk)
+	})
+}
+
+// senderLevel returns level of the command sender: the player's level, or the default level for console.
+func senderLevel(sender CommandSender) *Level {
+	if p, ok := sender.(*Player); ok && p.Level != nil {
+		return p.Level
+	}
+	return GetDefaultLevel()
+}
+
+// parseTime parses time of day, in ticks or one of day, noon, sunset, night, midnight and sunrise.
+func parseTime(s string) (uint32, error) {
+	switch strings.ToLower(s) {
+	case "day":
+		return proto.DayTime + 1000, nil
+	case "noon":
+		return proto.DayTime + 6000, nil
+	case "sunset":
+		return proto.SunsetTime, nil
+	case "night":
+		return proto.NightTime, nil
+	case "midnight":
+		return 18000, nil
+	case "sunrise":
+		return proto.SunriseTime, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, usageError("Invalid time: " + s)
+	}
+	return uint32(n % proto.FullTime), nil
+}
+
+func init() {
+	for _, cmd := range []*Command{
+		{
+			Name:        "time",
+			Usage:       "[set <time>|add <ticks>|stop|start]",
+			Description: "Shows or changes time of the level.",
+			Permission:  "lav7.command.time",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				lv := senderLevel(sender)
+				sub := strings.ToLower(args.Peek())
+				args.String()
+				switch sub {
+				case "", "query":
+				case "set":
+					s, err := args.String()
+					if err != nil {
+						return err
+					}
+					t, err := parseTime(s)
+					if err != nil {
+						return err
+					}
+					lv.SetTime(t)
+				case "add":
+					n, err := args.Int()
+					if err != nil {
+						return err
+					}
+					lv.AddTime(n)
+				case "stop":
+					lv.StopTime(true)
+				case "start":
+					lv.StopTime(false)
+				default:
+					return ErrUsage
+				}
+				state := "running"
+				if lv.TimeStopped() {
+					state = "stopped"
+				}
+				sender.SendMessage(fmt.Sprintf("Time of %s: %d (%s)", lv.Name, lv.Time(), state))
+				return nil
+			},
+		},
+		{
+			Name:        "weather",
+			Usage:       "[clear|rain|thunder] [seconds]",
+			Description: "Shows or changes weather of the level.",
+			Permission:  "lav7.command.weather",
+			Execute: func(sender CommandSender, args *CommandArgs) error {
+				lv := senderLevel(sender)
+				if args.Len() == 0 {
+					sender.SendMessage(fmt.Sprintf("Weather of %s: %v", lv.Name, lv.Weather()))
+					return nil
+				}
+				s, _ := args.String()
+				weather, ok := ParseWeather(s)
+				if !ok {
+					return usageError("Unknown weather: " + s)
+				}
+				var duration int
+				if args.Len() > 0 {
+					seconds, err := args.Int()
+					if err != nil {
+						return err
+					}
+					if seconds <= 0 {
+						return usageError("Duration should be positive")
+					}
+					duration = seconds * TicksPerSecond
+				}
+				lv.SetWeather(weather, duration)
+				sender.SendMessage(fmt.Sprintf("Changed weather of %s to %v", lv.Name, weather))
+				return nil
+			},
+		},
+	} {
+		if err := RegisterCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package lav7
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+)
+
+func TestWeatherPackets(t *testing.T) {
+	ids := func(pks []proto.Packet) (ids []uint16) {
+		for _, pk := range pks {
+			ids = append(ids, pk.(*proto.LevelEvent).EventID)
+		}
+		return
+	}
+	for _, c := range []struct {
+		from, to Weather
+		expected []uint16
+	}{
+		{WeatherClear, WeatherRain, []uint16{proto.EventStartRain}},
+		{WeatherClear, WeatherThunder, []uint16{proto.EventStartRain, proto.EventStartThunder}},
+		{WeatherRain, WeatherThunder, []uint16{proto.EventStartThunder}},
+		{WeatherThunder, WeatherRain, []uint16{proto.EventStopThunder}},
+		{WeatherThunder, WeatherClear, []uint16{proto.EventStopThunder, proto.EventStopRain}},
+		{WeatherRain, WeatherRain, nil},
+	} {
+		if got := ids(weatherPackets(c.from, c.to)); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v -> %v: got %v, expected %v", c.from, c.to, got, c.expected)
+		}
+	}
+}
+
+func TestLevelTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lav7-level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := LevelDir
+	LevelDir = dir
+	defer func() { LevelDir = old }()
+
+	lv := &Level{Name: "timetest"}
+	lv.Init(memoryProvider{})
+	defer lv.Ticker.Stop()
+
+	lv.SetTime(proto.FullTime - 1)
+	lv.tick(time.Now())
+	if tm := lv.Time(); tm != 0 {
+		t.Error("Time should wrap around:", tm)
+	}
+	lv.AddTime(-100)
+	if tm := lv.Time(); tm != proto.FullTime-100 {
+		t.Error("Time mismatch:", tm)
+	}
+	lv.StopTime(true)
+	lv.tick(time.Now())
+	if tm := lv.Time(); tm != proto.FullTime-100 {
+		t.Error("Stopped time should not advance:", tm)
+	}
+
+	lv.SetWeather(WeatherRain, 2)
+	lv.tick(time.Now())
+	if w := lv.Weather(); w != WeatherRain {
+		t.Error("Weather should not change yet:", w)
+	}
+	lv.tick(time.Now())
+	if w := lv.Weather(); w != WeatherClear {
+		t.Error("Rain should stop after the duration:", w)
+	}
+	lv.SetWeather(WeatherThunder, 1000)
+	lv.Save()
+
+	loaded := &Level{Name: "timetest"}
+	loaded.Init(memoryProvider{})
+	defer loaded.Ticker.Stop()
+	if loaded.Time() != lv.Time() || !loaded.TimeStopped() || loaded.Weather() != WeatherThunder {
+		t.Errorf("Level state is not restored: time %d, stopped %v, weather %v",
+			loaded.Time(), loaded.TimeStopped(), loaded.Weather())
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for s, expected := range map[string]uint32{
+		"night":   proto.NightTime,
+		"Sunrise": proto.SunriseTime,
+		"6000":    6000,
+		"24001":   1,
+	} {
+		if tm, err := parseTime(s); err != nil || tm != expected {
+			t.Errorf("%s: got %d, %v, expected %d", s, tm, err, expected)
+		}
+	}
+	for _, s := range []string{"", "-1", "dusk"} {
+		if _, err := parseTime(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package lav7
+
+import (
+	"sync"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/types"
+)
+
+// maxStack is the maximum amount of items in a container slot.
+const maxStack = 64
+
+// Window IDs given to container windows. Other IDs are used by player inventory windows.
+const (
+	firstWindowID byte = 1
+	lastWindowID  byte = 99
+)
+
+// Container is a tile entity holding items, which players can open in windows.
+type Container interface {
+	TileEntity
+	Inventory() Inventory // Items of the container, modified in place
+	WindowType() byte     // Window type sent with ContainerOpen packet
+}
+
+// Inventory implements Container interface.
+func (ch *Chest) Inventory() Inventory { return ch.Items }
+
+// WindowType implements Container interface.
+func (*Chest) WindowType() byte { return proto.ChestWindowType }
+
+// Inventory implements Container interface.
+func (f *Furnace) Inventory() Inventory { return f.Items }
+
+// WindowType implements Container interface.
+func (*Furnace) WindowType() byte { return proto.FurnaceWindowType }
+
+// windowData returns window properties of the furnace: smelting progress, remaining and total burn time.
+func (f *Furnace) windowData() []int16 {
+	return []int16{f.CookTime, f.BurnTime, f.MaxTime}
+}
+
+// openContainer is a container block opened by players.
+// Players viewing the same block share it, so changes from a player are sent to the others.
+type openContainer struct {
+	sync.Mutex
+	lv      *Level
+	x, y, z int32
+	tile    Container
+	viewers map[*Player]byte // Window ID on each viewer
+}
+
+// openContainers holds containers opened on a level by position.
+// Lock it before locking containers in it.
+type openContainers struct {
+	sync.Mutex
+	m map[[3]int32]*openContainer
+}
+
+func newOpenContainers() *openContainers {
+	return &openContainers{
+		m: make(map[[3]int32]*openContainer),
+	}
+}
+
+// windowManager holds container windows opened by a player. A zero value is ready to use.
+type windowManager struct {
+	sync.Mutex
+	windows map[byte]*openContainer
+	last    byte
+}
+
+// add gives an unused window ID to the container. It returns 0 if every ID is in use.
+func (w *windowManager) add(oc *openContainer) byte {
+	w.Lock()
+	defer w.Unlock()
+	if w.windows == nil {
+		w.windows = make(map[byte]*openContainer)
+	}
+	for i := firstWindowID; i <= lastWindowID; i++ {
+		w.last++
+		if w.last < firstWindowID || w.last > lastWindowID {
+			w.last = firstWindowID
+		}
+		if _, ok := w.windows[w.last]; !ok {
+			w.windows[w.last] = oc
+			return w.last
+		}
+	}
+	return 0
+}
+
+// get returns the container opened with given window ID, or nil if the window is not opened.
+func (w *windowManager) get(id byte) *openContainer {
+	w.Lock()
+	defer w.Unlock()
+	return w.windows[id]
+}
+
+// remove forgets the window with given ID.
+func (w *windowManager) remove(id byte) {
+	w.Lock()
+	defer w.Unlock()
+	delete(w.windows, id)
+}
+
+// all returns every opened container.
+func (w *windowManager) all() []*openContainer {
+	w.Lock()
+	defer w.Unlock()
+	ocs := make([]*openContainer, 0, len(w.windows))
+	for _, oc := range w.windows {
+		ocs = append(ocs, oc)
+	}
+	return ocs
+}
+
+// OpenContainer opens the container block on given coordinates to the player, and sends its items.
+// It returns false if the block is not a container.
+func (lv *Level) OpenContainer(p *Player, x, y, z int32) bool {
+	pks := lv.addViewer(p, x, y, z)
+	if pks == nil {
+		return false
+	}
+	p.SendCompressed(pks...)
+	return true
+}
+
+// addViewer adds the player to viewers of the container, and returns packets opening the window.
+// It returns nil if the container can't be opened. Packets are sent by the caller after unlocking containers.
+func (lv *Level) addViewer(p *Player, x, y, z int32) []proto.Packet {
+	if y < 0 || y > 127 {
+		return nil
+	}
+	lv.containers.Lock()
+	defer lv.containers.Unlock()
+	pos := [3]int32{x, y, z}
+	oc, ok := lv.containers.m[pos]
+	if !ok {
+		tile, ok := lv.GetTile(x, y, z).(Container)
+		if !ok {
+			// Tile entity is not saved until the container is changed.
+			tile, ok = NewTileEntity(GetBlockType(lv.GetBlock(x, y, z)).Tile).(Container)
+			if !ok {
+				return nil
+			}
+		}
+		oc = &openContainer{
+			lv:      lv,
+			x:       x,
+			y:       y,
+			z:       z,
+			tile:    tile,
+			viewers: make(map[*Player]byte),
+		}
+		lv.containers.m[pos] = oc
+	}
+	oc.Lock()
+	defer oc.Unlock()
+	id, ok := oc.viewers[p]
+	if !ok {
+		if id = p.windows.add(oc); id == 0 {
+			if len(oc.viewers) == 0 {
+				delete(lv.containers.m, pos)
+			}
+			return nil
+		}
+		oc.viewers[p] = id
+	}
+	pks := []proto.Packet{&proto.ContainerOpen{
+		WindowID: id,
+		Type:     oc.tile.WindowType(),
+		Slots:    uint16(len(oc.tile.Inventory())),
+		X:        uint32(x),
+		Y:        uint32(y),
+		Z:        uint32(z),
+	}, oc.content(id)}
+	if f, ok := oc.tile.(*Furnace); ok {
+		for i, v := range f.windowData() {
+			pks = append(pks, &proto.ContainerSetData{WindowID: id, Property: uint16(i), Value: uint16(v)})
+		}
+	}
+	return pks
+}
+
+// closeContainer closes windows of the container on given coordinates, e.g. when the block is removed.
+func (lv *Level) closeContainer(x, y, z int32) {
+	lv.containers.Lock()
+	pos := [3]int32{x, y, z}
+	oc, ok := lv.containers.m[pos]
+	if !ok {
+		lv.containers.Unlock()
+		return
+	}
+	delete(lv.containers.m, pos)
+	oc.Lock()
+	viewers := oc.viewers
+	oc.viewers = make(map[*Player]byte)
+	for p, id := range viewers {
+		p.windows.remove(id)
+	}
+	oc.Unlock()
+	lv.containers.Unlock()
+
+	for p, id := range viewers {
+		p.SendPacket(&proto.ContainerClose{WindowID: id})
+	}
+}
+
+// removeViewer closes the window of the container on the player.
+// The container is removed from the level when no one views it.
+func (oc *openContainer) removeViewer(p *Player) {
+	oc.lv.containers.Lock()
+	defer oc.lv.containers.Unlock()
+	oc.Lock()
+	defer oc.Unlock()
+	if id, ok := oc.viewers[p]; ok {
+		p.windows.remove(id)
+		delete(oc.viewers, p)
+	}
+	pos := [3]int32{oc.x, oc.y, oc.z}
+	if len(oc.viewers) == 0 && oc.lv.containers.m[pos] == oc {
+		delete(oc.lv.containers.m, pos)
+	}
+}
+
+// content returns ContainerSetContent packet with all items of the container. Callers should lock the container.
+func (oc *openContainer) content(id byte) *proto.ContainerSetContent {
+	return &proto.ContainerSetContent{
+		WindowID: id,
+		Slots:    append([]types.Item(nil), oc.tile.Inventory()...),
+	}
+}
+
+// slot returns ContainerSetSlot packet with the item on given slot. Callers should lock the container.
+func (oc *openContainer) slot(id byte, slot int) *proto.ContainerSetSlot {
+	item := oc.tile.Inventory()[slot]
+	return &proto.ContainerSetSlot{
+		WindowID: id,
+		Slot:     uint16(slot),
+		Item:     &item,
+	}
+}
+
+// CloseWindow closes the container window with given ID.
+func (p *Player) CloseWindow(id byte) {
+	if oc := p.windows.get(id); oc != nil {
+		oc.removeViewer(p)
+	}
+}
+
+// closeWindows closes every container window of the player.
+func (p *Player) closeWindows() {
+	for _, oc := range p.windows.all() {
+		oc.removeViewer(p)
+	}
+}
+
+// checkItem returns the item to be saved in a container slot, or false if the item is not valid.
+// Empty items are normalized to a zero value, and NBT data of items are dropped.
+func checkItem(item *types.Item) (types.Item, bool) {
+	if item == nil || item.ID == 0 || item.Amount == 0 {
+		return types.Item{}, true
+	}
+	if item.ID.String() == "Unknown" || item.Amount > maxStack {
+		return types.Item{}, false
+	}
+	return types.Item{ID: item.ID, Meta: item.Meta, Amount: item.Amount}, true
+}
+
+// onContainerSetSlot handles ContainerSetSlot packet. The change is checked against the window and container
+// held by the server, and accepted changes are sent to other players viewing the container.
+// If the change is not accepted, the slot or the window is synchronized to the server-side state.
+// Packets are sent after unlocking the container.
+//
+// Players are in creative mode, so items are not taken from the player inventory: any valid item stack
+// is accepted, and items put in or taken out of the container are not checked against other windows.
+// Survival mode will need to validate the change as a transaction with the player inventory.
+func (p *Player) onContainerSetSlot(pk *proto.ContainerSetSlot) {
+	if pk.WindowID < firstWindowID || pk.WindowID > lastWindowID {
+		return // Player inventory windows are handled by creative clients.
+	}
+	oc := p.windows.get(pk.WindowID)
+	if oc == nil {
+		p.SendPacket(&proto.ContainerClose{WindowID: pk.WindowID})
+		return
+	}
+	slot := int(pk.Slot)
+	item, valid := checkItem(pk.Item)
+
+	oc.Lock()
+	if id, ok := oc.viewers[p]; !ok || id != pk.WindowID {
+		oc.Unlock()
+		p.SendPacket(&proto.ContainerClose{WindowID: pk.WindowID})
+		return
+	}
+	items := oc.tile.Inventory()
+	if slot >= len(items) {
+		content := oc.content(pk.WindowID)
+		oc.Unlock()
+		p.SendPacket(content)
+		return
+	}
+	if !valid {
+		revert := oc.slot(pk.WindowID, slot)
+		oc.Unlock()
+		p.SendPacket(revert)
+		return
+	}
+	if items[slot] == item {
+		oc.Unlock()
+		return
+	}
+	oc.Unlock()
+
+	// Event handlers may use containers, so the container is not locked while firing the event.
+	ev := &ContainerChangeEvent{Player: p, Level: oc.lv, X: oc.x, Y: oc.y, Z: oc.z, Slot: slot, Item: item}
+	cancelled := !FireEvent(ev)
+
+	oc.Lock()
+	if _, ok := oc.viewers[p]; !ok {
+		oc.Unlock()
+		return
+	}
+	if ev.Item, valid = checkItem(&ev.Item); cancelled || !valid {
+		revert := oc.slot(pk.WindowID, slot)
+		oc.Unlock()
+		p.SendPacket(revert)
+		return
+	}
+	items[slot] = ev.Item
+	oc.lv.storeTile(oc.x, oc.y, oc.z, oc.tile)
+	updates := make(map[*Player]*proto.ContainerSetSlot, len(oc.viewers))
+	for v, id := range oc.viewers {
+		if v != p || ev.Item != item {
+			updates[v] = oc.slot(id, slot)
+		}
+	}
+	oc.Unlock()
+
+	for v, pk := range updates {
+		v.SendPacket(pk)
+	}
+}
@@ -0,0 +1,185 @@
+package lav7
+
+import (
+	"testing"
+	"time"
+
+	"github.com/L7-MCPE/lav7/proto"
+	"github.com/L7-MCPE/lav7/raknet"
+	"github.com/L7-MCPE/lav7/raknet/client"
+	"github.com/L7-MCPE/lav7/types"
+	"github.com/L7-MCPE/lav7/util/vector"
+)
+
+// windowTestPlayer returns a player without session. Sent packets are kept on returned channel.
+func windowTestPlayer(lv *Level) (*Player, chan *raknet.EncapsulatedPacket) {
+	ch := make(chan *raknet.EncapsulatedPacket, 64)
+	return &Player{Level: lv, raknetChan: ch}, ch
+}
+
+// sentPids returns packet IDs sent to the player so far.
+func sentPids(ch chan *raknet.EncapsulatedPacket) (pids []byte) {
+	for {
+		select {
+		case ep := <-ch:
+			pids = append(pids, ep.Buffer.Bytes()[1])
+		default:
+			return
+		}
+	}
+}
+
+func TestContainerWindows(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.Set(1, 6, 1, types.Block{ID: byte(types.Chest)})
+	alice, aliceSent := windowTestPlayer(lv)
+	bob, bobSent := windowTestPlayer(lv)
+
+	if lv.OpenContainer(alice, 2, 6, 2) {
+		t.Error("Air should not be opened")
+	}
+	if !lv.OpenContainer(alice, 1, 6, 1) || !lv.OpenContainer(bob, 1, 6, 1) {
+		t.Fatal("Chest is not opened")
+	}
+	if len(lv.containers.m) != 1 {
+		t.Error("Players should share the chest:", lv.containers.m)
+	}
+	aid, bid := lv.containers.m[[3]int32{1, 6, 1}].viewers[alice], lv.containers.m[[3]int32{1, 6, 1}].viewers[bob]
+	sentPids(aliceSent)
+	sentPids(bobSent)
+
+	alice.onContainerSetSlot(&proto.ContainerSetSlot{WindowID: aid, Slot: 3, Item: &types.Item{ID: types.Diamond, Amount: 5}})
+	if chest, ok := lv.GetTile(1, 6, 1).(*Chest); !ok || chest.Items[3] != (types.Item{ID: types.Diamond, Amount: 5}) {
+		t.Error("Slot change is not saved:", chest)
+	}
+	if pids := sentPids(bobSent); len(pids) != 1 || pids[0] != proto.ContainerSetSlotHead {
+		t.Errorf("Slot change is not sent to other viewer: %x", pids)
+	}
+	if pids := sentPids(aliceSent); len(pids) != 0 {
+		t.Errorf("Accepted change should not be sent back: %x", pids)
+	}
+
+	invalid := []struct {
+		pk  *proto.ContainerSetSlot
+		pid byte
+	}{
+		{&proto.ContainerSetSlot{WindowID: aid, Slot: 3, Item: &types.Item{ID: types.Stone, Amount: 65}}, proto.ContainerSetSlotHead},
+		{&proto.ContainerSetSlot{WindowID: aid, Slot: 3, Item: &types.Item{ID: 9999, Amount: 1}}, proto.ContainerSetSlotHead},
+		{&proto.ContainerSetSlot{WindowID: aid, Slot: ChestSize, Item: &types.Item{ID: types.Stone, Amount: 1}}, proto.ContainerSetContentHead},
+		{&proto.ContainerSetSlot{WindowID: aid + 1, Slot: 3, Item: &types.Item{ID: types.Stone, Amount: 1}}, proto.ContainerCloseHead},
+	}
+	for i, c := range invalid {
+		alice.onContainerSetSlot(c.pk)
+		if pids := sentPids(aliceSent); len(pids) != 1 || pids[0] != c.pid {
+			t.Errorf("%d: client is not resynced: %x", i, pids)
+		}
+		if pids := sentPids(bobSent); len(pids) != 0 {
+			t.Errorf("%d: rejected change is sent to other viewer: %x", i, pids)
+		}
+	}
+	if chest := lv.GetTile(1, 6, 1).(*Chest); chest.Items[3] != (types.Item{ID: types.Diamond, Amount: 5}) {
+		t.Error("Rejected change is saved:", chest.Items[3])
+	}
+
+	alice.CloseWindow(aid)
+	if alice.windows.get(aid) != nil || len(lv.containers.m) != 1 {
+		t.Error("Window is not closed")
+	}
+	lv.Set(1, 6, 1, types.Block{ID: byte(types.Stone)})
+	if pids := sentPids(bobSent); len(pids) != 1 || pids[0] != proto.ContainerCloseHead {
+		t.Errorf("Window is not closed with the block: %x", pids)
+	}
+	if bob.windows.get(bid) != nil || len(lv.containers.m) != 0 {
+		t.Error("Removed chest is still opened")
+	}
+}
+
+func TestContainerSendUnlocked(t *testing.T) {
+	lv := lightTestLevel(t)
+	lv.Set(1, 6, 1, types.Block{ID: byte(types.Chest)})
+	stuck := &Player{Level: lv, raknetChan: make(chan *raknet.EncapsulatedPacket)} // Nothing receives packets
+	bob, _ := windowTestPlayer(lv)
+
+	go lv.OpenContainer(stuck, 1, 6, 1)
+	done := make(chan struct{})
+	go func() {
+		for stuck.windows.get(firstWindowID) == nil {
+			time.Sleep(time.Millisecond)
+		}
+		lv.OpenContainer(bob, 1, 6, 1)
+		bob.CloseWindow(firstWindowID)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Containers are locked while sending packets")
+	}
+}
+
+func TestContainerBroadcast(t *testing.T) {
+	network := raknet.NewMemoryNetwork(1)
+	r := startTestServer(t, network)
+	defer r.Close()
+	carol, updates := spawnTestClient(t, network, "carol")
+	defer carol.Close()
+	dave, _ := spawnTestClient(t, network, "dave")
+	defer dave.Close()
+
+	carol.SendPacket(&proto.UseItem{
+		X: 11, Y: 5, Z: 11,
+		Face: vector.SideUp,
+		Item: &types.Item{ID: types.Chest, Amount: 1},
+	})
+	if !waitUpdateBlock(updates, 11, 6, 11, byte(types.Chest)) {
+		t.Fatal("Chest is not placed")
+	}
+
+	carolOpens := make(chan *proto.ContainerOpen, 4)
+	daveOpens := make(chan *proto.ContainerOpen, 4)
+	daveSlots := make(chan *proto.ContainerSetSlot, 4)
+	carol.SetHandler(func(pk proto.Packet) {
+		if pk, ok := pk.(*proto.ContainerOpen); ok {
+			carolOpens <- pk
+		}
+	})
+	dave.SetHandler(func(pk proto.Packet) {
+		switch pk := pk.(type) {
+		case *proto.ContainerOpen:
+			daveOpens <- pk
+		case *proto.ContainerSetSlot:
+			daveSlots <- pk
+		}
+	})
+	open := func(c *client.Client, opens <-chan *proto.ContainerOpen) *proto.ContainerOpen {
+		c.SendPacket(&proto.UseItem{X: 11, Y: 6, Z: 11, Face: vector.SideUp, Item: &types.Item{}})
+		select {
+		case pk := <-opens:
+			if pk.Type != proto.ChestWindowType || pk.Slots != ChestSize || pk.X != 11 || pk.Y != 6 || pk.Z != 11 {
+				t.Error("ContainerOpen mismatch:", pk)
+			}
+			return pk
+		case <-time.After(time.Second * 10):
+			t.Fatal("Chest window is not opened")
+		}
+		return nil
+	}
+	carolWindow, daveWindow := open(carol, carolOpens), open(dave, daveOpens)
+
+	carol.SendPacket(&proto.ContainerSetSlot{
+		WindowID: carolWindow.WindowID,
+		Slot:     4,
+		Item:     &types.Item{ID: types.Diamond, Amount: 3},
+	})
+	select {
+	case pk := <-daveSlots:
+		if pk.WindowID != daveWindow.WindowID || pk.Slot != 4 || pk.Item.ID != types.Diamond || pk.Item.Amount != 3 {
+			t.Error("ContainerSetSlot mismatch:", pk, pk.Item)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("Slot change is not sent to other viewer")
+	}
+	if chest, ok := GetDefaultLevel().GetTile(11, 6, 11).(*Chest); !ok || chest.Items[4].ID != types.Diamond {
+		t.Error("Slot change is not saved:", chest)
+	}
+}